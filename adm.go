@@ -0,0 +1,764 @@
+/*
+
+Copyright © 2020 Andrey Kuvshinov. Contacts: <syslinux@protonmail.com>
+Copyright © 2020 Eltaline OU. Contacts: <eltaline.ou@gmail.com>
+All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+The wZD project contains unmodified/modified libraries imports too with
+separate copyright notices and license terms. Your use of the source code
+this libraries is subject to the terms and conditions of licenses these libraries.
+
+*/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/subtle"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/coocood/freecache"
+	"github.com/eltaline/mmutex"
+	"github.com/eltaline/nutsdb"
+	"github.com/kataras/iris/v12"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Admin Handlers
+
+// AllowGet : current get allow list, allow lists are replaced as a whole during reload
+func AllowGet() []Allow {
+
+	allowmu.RLock()
+	defer allowmu.RUnlock()
+
+	return getallow
+
+}
+
+// AllowPut : current put allow list
+func AllowPut() []Allow {
+
+	allowmu.RLock()
+	defer allowmu.RUnlock()
+
+	return putallow
+
+}
+
+// AllowDel : current delete allow list
+func AllowDel() []Allow {
+
+	allowmu.RLock()
+	defer allowmu.RUnlock()
+
+	return delallow
+
+}
+
+// AllowFile : read of allow file of virtual host with one CIDR per line
+func AllowFile(vhost string, file string) (Allow, error) {
+
+	allow := Allow{Vhost: vhost}
+
+	afile, err := os.OpenFile(filepath.Clean(file), os.O_RDONLY, os.ModePerm)
+	if err != nil {
+		return allow, err
+	}
+	defer afile.Close()
+
+	sallow := bufio.NewScanner(afile)
+	for sallow.Scan() {
+
+		line := sallow.Text()
+
+		_, _, err = net.ParseCIDR(line)
+		if err != nil {
+			return allow, fmt.Errorf("bad CIDR line format in file %s: %s", file, line)
+		}
+
+		allow.CIDR = append(allow.CIDR, struct{ Addr string }{line})
+
+	}
+
+	return allow, sallow.Err()
+
+}
+
+// AllowReload : read again get, put and delete allow files of all virtual hosts, current allow lists are kept on any error
+func AllowReload() error {
+
+	var nget, nput, ndel []Allow
+
+	for _, Server := range config.Server {
+
+		for _, af := range []struct {
+			file string
+			list *[]Allow
+		}{{Server.GETALLOW, &nget}, {Server.PUTALLOW, &nput}, {Server.DELALLOW, &ndel}} {
+
+			if af.file == "" {
+				continue
+			}
+
+			allow, err := AllowFile(Server.HOST, af.file)
+			if err != nil {
+				return err
+			}
+
+			*af.list = append(*af.list, allow)
+
+		}
+
+	}
+
+	allowmu.Lock()
+	getallow, putallow, delallow = nget, nput, ndel
+	allowmu.Unlock()
+
+	return nil
+
+}
+
+// ReadOnly : read-only state of virtual host set through admin listener
+func ReadOnly(vhost string) bool {
+
+	romutex.RLock()
+	defer romutex.RUnlock()
+
+	return rohosts[vhost]
+
+}
+
+// ReadOnlySet : set or clear read-only state of virtual host, state is not kept across restarts
+func ReadOnlySet(vhost string, ro bool) {
+
+	romutex.Lock()
+	defer romutex.Unlock()
+
+	if ro {
+		rohosts[vhost] = true
+		return
+	}
+
+	delete(rohosts, vhost)
+
+}
+
+// AdminTaskStart : register running background task
+func AdminTaskStart(name string, host string, path string) {
+
+	amutex.Lock()
+	defer amutex.Unlock()
+
+	atasks[name] = &AdminTask{Name: name, Host: host, Path: path, Started: time.Now().Unix(), Running: true}
+
+}
+
+// AdminTaskDone : register result of background task
+func AdminTaskDone(name string, result interface{}, err error) {
+
+	amutex.Lock()
+	defer amutex.Unlock()
+
+	task, ok := atasks[name]
+	if !ok {
+		return
+	}
+
+	task.Running = false
+	task.Finished = time.Now().Unix()
+	task.Result = result
+
+	if err != nil {
+		task.Error = err.Error()
+	}
+
+}
+
+// AdminTasks : copy of states of background tasks
+func AdminTasks(name string) []AdminTask {
+
+	amutex.Lock()
+	defer amutex.Unlock()
+
+	tasks := []AdminTask{}
+
+	for tname, task := range atasks {
+
+		if name != "" && tname != name {
+			continue
+		}
+
+		tasks = append(tasks, *task)
+
+	}
+
+	sort.Slice(tasks, func(i, j int) bool { return tasks[i].Name < tasks[j].Name })
+
+	return tasks
+
+}
+
+// AdminServer : configuration of virtual host
+func AdminServer(vhost string) (server, bool) {
+
+	for _, Server := range config.Server {
+
+		if Server.HOST == vhost {
+			return Server, true
+		}
+
+	}
+
+	return server{}, false
+
+}
+
+// AdminCompactions : compaction tasks from compaction db
+func AdminCompactions(cdb *nutsdb.DB) ([]Compact, error) {
+
+	tasks := []Compact{}
+
+	err := cdb.View(func(tx *nutsdb.Tx) error {
+
+		entries, err := tx.GetAll(cmpbucket)
+
+		if entries == nil {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		for _, entry := range entries {
+
+			var ev Compact
+
+			if gob.NewDecoder(bytes.NewReader(entry.Value)).Decode(&ev) == nil {
+				tasks = append(tasks, ev)
+			}
+
+		}
+
+		return nil
+
+	})
+
+	sort.Slice(tasks, func(i, j int) bool { return tasks[i].Time.Before(tasks[j].Time) })
+
+	return tasks, err
+
+}
+
+// AdminLower : keys of decoded JSON objects converted to lower case like keys of config file
+func AdminLower(v interface{}) interface{} {
+
+	switch t := v.(type) {
+	case map[string]interface{}:
+
+		m := make(map[string]interface{})
+
+		for k, val := range t {
+			m[strings.ToLower(k)] = AdminLower(val)
+		}
+
+		return m
+
+	case []interface{}:
+
+		for i, val := range t {
+			t[i] = AdminLower(val)
+		}
+
+	}
+
+	return v
+
+}
+
+// AdminConfig : effective config of global section and virtual hosts with secrets replaced, only requested virtual host if set
+func AdminConfig(vhost string) (interface{}, error) {
+
+	gcfg := config.Global
+
+	if gcfg.ADMINTOKEN != "" {
+		gcfg.ADMINTOKEN = "********"
+	}
+
+	scfg := make(map[string]server)
+
+	for name, Server := range config.Server {
+
+		if vhost != "" && Server.HOST != vhost {
+			continue
+		}
+
+		hooks := make([]webhook, len(Server.WEBHOOK))
+
+		for i, hook := range Server.WEBHOOK {
+
+			if hook.SECRET != "" {
+				hook.SECRET = "********"
+			}
+
+			hooks[i] = hook
+
+		}
+
+		Server.WEBHOOK = hooks
+
+		scfg[name] = Server
+
+	}
+
+	data, err := json.Marshal(struct {
+		Global global
+		Server map[string]server
+	}{gcfg, scfg})
+
+	if err != nil {
+		return nil, err
+	}
+
+	var dump interface{}
+
+	err = json.Unmarshal(data, &dump)
+	if err != nil {
+		return nil, err
+	}
+
+	return AdminLower(dump), nil
+
+}
+
+// Scrub : read-only check of all archives through directory with the same checks as fsck, interrupted by shutdown or admin request
+func Scrub(base string, abs string) (ScrubStats, error) {
+
+	// Loggers
+
+	appLogger, applogfile := AppLogger()
+	defer applogfile.Close()
+
+	ss := ScrubStats{Path: "/" + strings.TrimPrefix(strings.TrimPrefix(abs, base), "/")}
+
+	atomic.StoreInt32(&scrubstop, 0)
+
+	appLogger.Infof("| Scrub started | Path [%s]", abs)
+
+	report := func(dbf string, key string, msg string) {
+		appLogger.Errorf("| Scrub | DB [%s] | Key [%s] | %s", dbf, key, msg)
+	}
+
+	err := filepath.Walk(abs, func(path string, info os.FileInfo, err error) error {
+
+		if err != nil {
+
+			if os.IsNotExist(err) {
+				return nil
+			}
+
+			return err
+
+		}
+
+		if shutdown {
+			return errShutdown
+		}
+
+		if atomic.LoadInt32(&scrubstop) == 1 {
+			return errStopped
+		}
+
+		if info.IsDir() && path == backupdir {
+			return filepath.SkipDir
+		}
+
+		if !info.Mode().IsRegular() || !rgxbolt.MatchString(path) {
+			return nil
+		}
+
+		fst, err := FsckArchive(path, false, "lost+found", report)
+
+		ss.Archives++
+		ss.Keys += fst.Keys
+		ss.Errors += fst.Errors
+
+		if err != nil || fst.Errors > 0 {
+
+			if err != nil {
+				ss.Errors++
+				report(path, "", err.Error())
+			}
+
+			ss.Broken = append(ss.Broken, strings.TrimPrefix(path, base))
+
+		}
+
+		return nil
+
+	})
+
+	appLogger.Infof("| Scrub finished | Path [%s] | Archives [%d] | Keys [%d] | Errors [%d]", abs, ss.Archives, ss.Keys, ss.Errors)
+
+	return ss, err
+
+}
+
+// AdminReply : JSON response of admin listener
+func AdminReply(ctx iris.Context, status int, v interface{}) {
+
+	rbytes, err := json.Marshal(v)
+	if err != nil {
+		status = iris.StatusInternalServerError
+		rbytes = []byte(`{"error":"can't encode response"}`)
+	}
+
+	ctx.StatusCode(status)
+	ctx.Header("Content-Type", "application/json")
+	ctx.Header("Content-Length", fmt.Sprintf("%d", len(rbytes)))
+
+	_, _ = ctx.Write(rbytes)
+
+}
+
+// AdminError : JSON error response of admin listener
+func AdminError(ctx iris.Context, status int, err error) {
+	AdminReply(ctx, status, map[string]string{"error": err.Error()})
+}
+
+// AdminApp : admin listener application with JSON endpoints, every request is authorized by bearer token from admintoken
+func AdminApp(cache *freecache.Cache, keymutex *mmutex.Mutex, cdb *nutsdb.DB, wg *sync.WaitGroup) *iris.Application {
+
+	adm := iris.New()
+
+	adm.Use(func(ctx iris.Context) {
+
+		token := []byte("Bearer " + admintoken)
+
+		if subtle.ConstantTimeCompare([]byte(ctx.GetHeader("Authorization")), token) != 1 {
+
+			appLogger, applogfile := AppLogger()
+			appLogger.Errorf("| Admin | Client IP [%s] | 401 | Unauthorized | Path [%s]", ctx.RemoteAddr(), ctx.Path())
+			applogfile.Close()
+
+			AdminError(ctx, iris.StatusUnauthorized, errors.New("unauthorized"))
+			return
+
+		}
+
+		if ctx.Method() != "GET" {
+
+			appLogger, applogfile := AppLogger()
+			appLogger.Warnf("| Admin | Client IP [%s] | %s | Path [%s] | Query [%s]", ctx.RemoteAddr(), ctx.Method(), ctx.Path(), ctx.Request().URL.RawQuery)
+			applogfile.Close()
+
+		}
+
+		ctx.Next()
+
+	})
+
+	// Runtime Statistics
+
+	adm.Get("/stats", func(ctx iris.Context) {
+
+		var mem runtime.MemStats
+
+		runtime.ReadMemStats(&mem)
+
+		st := AdminStats{
+			Pid:          os.Getpid(),
+			Uptime:       int64(time.Since(starttime).Seconds()),
+			Goroutines:   runtime.NumGoroutine(),
+			Alloc:        mem.Alloc,
+			Sys:          mem.Sys,
+			NumGC:        mem.NumGC,
+			CacheEntries: cache.EntryCount(),
+			CacheHitRate: cache.HitRate(),
+			CacheEvicted: cache.EvacuateCount(),
+			Search:       []AdminSearch{},
+			Feed:         make(map[string]uint64),
+			ReadOnly:     []string{},
+			Tasks:        AdminTasks(""),
+		}
+
+		tasks, err := AdminCompactions(cdb)
+		if err != nil {
+			AdminError(ctx, iris.StatusInternalServerError, err)
+			return
+		}
+
+		st.Compactions = len(tasks)
+
+		for _, sdb := range sdbs {
+			st.Search = append(st.Search, AdminSearch{Dir: sdb.Dir, Index: sdb.Index, Hosts: sdb.Hosts, Broken: sdb.Broken})
+		}
+
+		for _, Server := range config.Server {
+
+			if flog := FeedHost(Server.HOST); flog != nil {
+				flog.Lock()
+				st.Feed[Server.HOST] = flog.Seq
+				flog.Unlock()
+			}
+
+			st.Replicas = append(st.Replicas, RepStatus(Server.HOST)...)
+
+			if ReadOnly(Server.HOST) {
+				st.ReadOnly = append(st.ReadOnly, Server.HOST)
+			}
+
+		}
+
+		sort.Strings(st.ReadOnly)
+
+		AdminReply(ctx, iris.StatusOK, st)
+
+	})
+
+	// Effective Config
+
+	adm.Get("/config", func(ctx iris.Context) {
+
+		dump, err := AdminConfig(ctx.URLParam("host"))
+		if err != nil {
+			AdminError(ctx, iris.StatusInternalServerError, err)
+			return
+		}
+
+		AdminReply(ctx, iris.StatusOK, dump)
+
+	})
+
+	// Compaction Tasks
+
+	adm.Get("/compact", func(ctx iris.Context) {
+
+		tasks, err := AdminCompactions(cdb)
+		if err != nil {
+			AdminError(ctx, iris.StatusInternalServerError, err)
+			return
+		}
+
+		type ctask struct {
+			Path string `json:"path"`
+			Time int64  `json:"time"`
+		}
+
+		ctasks := []ctask{}
+
+		for _, task := range tasks {
+			ctasks = append(ctasks, ctask{Path: task.Path, Time: task.Time.Unix()})
+		}
+
+		AdminReply(ctx, iris.StatusOK, ctasks)
+
+	})
+
+	adm.Post("/compact", func(ctx iris.Context) {
+
+		path := ctx.URLParam("path")
+
+		if path != "" {
+			path = filepath.Clean(path)
+		}
+
+		if ctx.URLParam("wait") != "1" {
+
+			wg.Add(1)
+
+			go func() {
+				defer wg.Done()
+				CMPRun(keymutex, cdb, time.Now(), path)
+			}()
+
+			AdminReply(ctx, iris.StatusAccepted, map[string]string{"path": path})
+			return
+
+		}
+
+		wg.Add(1)
+		defer wg.Done()
+
+		AdminReply(ctx, iris.StatusOK, map[string]interface{}{"path": path, "compacted": CMPRun(keymutex, cdb, time.Now(), path)})
+
+	})
+
+	// Reindex And Scrub
+
+	start := func(name string) iris.Handler {
+		return func(ctx iris.Context) {
+
+			vhost := ctx.URLParam("host")
+
+			Server, ok := AdminServer(vhost)
+			if !ok {
+				AdminError(ctx, iris.StatusNotFound, fmt.Errorf("not found configured virtual host %s", vhost))
+				return
+			}
+
+			ndb, search := SearchHost(vhost)
+			if name == "reindex" && !search {
+				AdminError(ctx, iris.StatusForbidden, fmt.Errorf("search is disabled for virtual host %s", vhost))
+				return
+			}
+
+			depth, err := strconv.Atoi(ctx.URLParamDefault("depth", "-1"))
+			if err != nil {
+				AdminError(ctx, iris.StatusBadRequest, fmt.Errorf("depth must be a number"))
+				return
+			}
+
+			base := filepath.Clean(Server.ROOT)
+			abs := filepath.Clean(base + "/" + ctx.URLParamDefault("path", "/"))
+
+			if !strings.HasPrefix(abs+"/", base+"/") || !DirExists(abs) {
+				AdminError(ctx, iris.StatusNotFound, fmt.Errorf("not found directory %s", abs))
+				return
+			}
+
+			if !keymutex.TryLock(name) {
+				AdminError(ctx, iris.StatusConflict, fmt.Errorf("%s is already in progress", name))
+				return
+			}
+
+			filemode := os.FileMode(0640)
+
+			cfilemode, err := strconv.ParseUint(fmt.Sprintf("%d", Server.FILEMODE), 8, 32)
+			if err == nil && cfilemode != 0 {
+				filemode = os.FileMode(cfilemode)
+			}
+
+			timeout := time.Duration(Server.LOCKTIMEOUT) * time.Second
+
+			AdminTaskStart(name, vhost, strings.TrimPrefix(abs, base))
+
+			wg.Add(1)
+
+			go func() {
+				defer wg.Done()
+				defer keymutex.UnLock(name)
+
+				switch name {
+				case "reindex":
+					rs, err := Reindex(cache, ndb, base, abs, depth, Server.REINDEXRATE, filemode, timeout, Server.OPENTRIES)
+					AdminTaskDone(name, rs, err)
+				case "scrub":
+					ss, err := Scrub(base, abs)
+					AdminTaskDone(name, ss, err)
+				}
+
+			}()
+
+			AdminReply(ctx, iris.StatusAccepted, AdminTasks(name))
+
+		}
+	}
+
+	stop := func(name string, flag *int32) iris.Handler {
+		return func(ctx iris.Context) {
+
+			atomic.StoreInt32(flag, 1)
+
+			AdminReply(ctx, iris.StatusAccepted, AdminTasks(name))
+
+		}
+	}
+
+	status := func(name string) iris.Handler {
+		return func(ctx iris.Context) {
+			AdminReply(ctx, iris.StatusOK, AdminTasks(name))
+		}
+	}
+
+	adm.Get("/reindex", status("reindex"))
+	adm.Post("/reindex", start("reindex"))
+	adm.Delete("/reindex", stop("reindex", &reindexstop))
+
+	adm.Get("/scrub", status("scrub"))
+	adm.Post("/scrub", start("scrub"))
+	adm.Delete("/scrub", stop("scrub", &scrubstop))
+
+	// Allow Lists
+
+	adm.Post("/allow/reload", func(ctx iris.Context) {
+
+		err := AllowReload()
+		if err != nil {
+			AdminError(ctx, iris.StatusUnprocessableEntity, err)
+			return
+		}
+
+		AdminReply(ctx, iris.StatusOK, map[string]int{"get": len(AllowGet()), "put": len(AllowPut()), "delete": len(AllowDel())})
+
+	})
+
+	// Read-Only Virtual Hosts
+
+	readonly := func(ro bool) iris.Handler {
+		return func(ctx iris.Context) {
+
+			vhost := ctx.URLParam("host")
+
+			if _, ok := AdminServer(vhost); !ok {
+				AdminError(ctx, iris.StatusNotFound, fmt.Errorf("not found configured virtual host %s", vhost))
+				return
+			}
+
+			ReadOnlySet(vhost, ro)
+
+			AdminReply(ctx, iris.StatusOK, map[string]interface{}{"host": vhost, "readonly": ro})
+
+		}
+	}
+
+	adm.Get("/readonly", func(ctx iris.Context) {
+
+		hosts := []string{}
+
+		for _, Server := range config.Server {
+
+			if ReadOnly(Server.HOST) {
+				hosts = append(hosts, Server.HOST)
+			}
+
+		}
+
+		sort.Strings(hosts)
+
+		AdminReply(ctx, iris.StatusOK, hosts)
+
+	})
+
+	adm.Put("/readonly", readonly(true))
+	adm.Delete("/readonly", readonly(false))
+
+	return adm
+
+}
@@ -0,0 +1,973 @@
+/*
+
+Copyright © 2020 Andrey Kuvshinov. Contacts: <syslinux@protonmail.com>
+Copyright © 2020 Eltaline OU. Contacts: <eltaline.ou@gmail.com>
+All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+The wZD project contains unmodified/modified libraries imports too with
+separate copyright notices and license terms. Your use of the source code
+this libraries is subject to the terms and conditions of licenses these libraries.
+
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/eltaline/bolt"
+	"github.com/eltaline/toml"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Archive Subcommands
+
+// Subcommand : run of fsck, pack, unpack, ls, cat, verify or stat subcommand and its exit code
+func Subcommand(cmd string, args []string) int {
+
+	switch cmd {
+	case "fsck":
+		return Fsck(args)
+	case "pack":
+		return ArchivePack(args)
+	case "unpack":
+		return ArchiveUnpack(args)
+	case "ls":
+		return ArchiveLs(args)
+	case "cat":
+		return ArchiveCat(args)
+	case "verify":
+		return ArchiveVerify(args)
+	case "stat":
+		return ArchiveStat(args)
+	}
+
+	return 16
+
+}
+
+// ArchiveFlags : flag set of archive subcommand with layout options, --config and --host take options of virtual host from config
+func ArchiveFlags(cmd string, usage string, opts *ArchiveOptions) (*flag.FlagSet, func() error) {
+
+	fs := flag.NewFlagSet(cmd, flag.ContinueOnError)
+
+	*opts = ArchiveOptions{Skeyscnt: 16384, Smaxsize: 536870912, Fmaxsize: 1048576, Integrity: true, Filemode: os.FileMode(0640), Timeout: time.Duration(5) * time.Second, Opentries: 5}
+
+	cfile := fs.String("config", "", "--config=/etc/wzd/wzd.conf - config file for options of --host")
+	chost := fs.String("host", "", "--host=localhost - virtual host for skeyscnt, smaxsize, fmaxsize, writeintegrity, filemode, opentries and locktimeout options")
+
+	fs.IntVar(&opts.Skeyscnt, "skeyscnt", opts.Skeyscnt, "--skeyscnt=16384 - maximum number of keys per archive")
+	fs.Int64Var(&opts.Smaxsize, "smaxsize", opts.Smaxsize, "--smaxsize=536870912 - maximum size of archive")
+	fs.Int64Var(&opts.Fmaxsize, "fmaxsize", opts.Fmaxsize, "--fmaxsize=1048576 - maximum size of file packed to archive")
+	fs.BoolVar(&opts.Integrity, "integrity", opts.Integrity, "--integrity=true - write crc of data to headers")
+	fs.StringVar(&freelist, "freelist", freelist, "--freelist=hashmap - freelist type of bolt archives, hashmap or array")
+
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: wzd %s [options] %s\n", cmd, usage)
+		fs.PrintDefaults()
+	}
+
+	load := func() error {
+
+		if *chost == "" {
+			return nil
+		}
+
+		var cfg Config
+
+		if *cfile == "" {
+			*cfile = configfile
+		}
+
+		_, err := toml.DecodeFile(*cfile, &cfg)
+		if err != nil {
+			return err
+		}
+
+		for _, Server := range cfg.Server {
+
+			if Server.HOST != *chost {
+				continue
+			}
+
+			opts.Skeyscnt = Server.SKEYSCNT
+			opts.Smaxsize = Server.SMAXSIZE
+			opts.Fmaxsize = Server.FMAXSIZE
+			opts.Integrity = Server.WRITEINTEGRITY
+			opts.Opentries = Server.OPENTRIES
+			opts.Timeout = time.Duration(Server.LOCKTIMEOUT) * time.Second
+
+			cfilemode, err := strconv.ParseUint(fmt.Sprintf("%d", Server.FILEMODE), 8, 32)
+			if err == nil && cfilemode != 0 {
+				opts.Filemode = os.FileMode(cfilemode)
+			}
+
+			if cfg.Global.FREELIST != "" {
+				freelist = cfg.Global.FREELIST
+			}
+
+			return nil
+
+		}
+
+		return fmt.Errorf("not found configured virtual host %s", *chost)
+
+	}
+
+	return fs, load
+
+}
+
+// ArchiveParse : parse of arguments of archive subcommand, false with printed usage on error
+func ArchiveParse(fs *flag.FlagSet, load func() error, opts *ArchiveOptions, args []string, nargs int) bool {
+
+	err := fs.Parse(args)
+	if err == nil {
+		err = load()
+	}
+
+	if err == nil && (opts.Skeyscnt < 1 || opts.Smaxsize < 1 || opts.Fmaxsize < 1) {
+		err = errors.New("skeyscnt, smaxsize and fmaxsize must be positive")
+	}
+
+	if err != nil || fs.NArg() < nargs {
+
+		if err != nil && err != flag.ErrHelp {
+			fmt.Fprintf(os.Stderr, "Can`t parse options error | %v\n", err)
+		}
+
+		fs.Usage()
+		return false
+
+	}
+
+	return true
+
+}
+
+// PerBucket : maximum number of keys per data bucket of archive by size of value
+func PerBucket(clength int64) int {
+
+	switch {
+	case clength >= 262144 && clength < 1048576:
+		return 512
+	case clength >= 1048576 && clength < 4194304:
+		return 256
+	case clength >= 4194304 && clength < 8388608:
+		return 128
+	case clength >= 8388608 && clength < 16777216:
+		return 64
+	case clength >= 16777216:
+		return 32
+	}
+
+	return 1024
+
+}
+
+// ArchiveShards : archives of directory in shard order, main archive and numbered archives up to the first hole
+func ArchiveShards(ddir string) []string {
+
+	var shards []string
+
+	dbn := filepath.Base(ddir)
+	dbf := filepath.Clean(ddir + "/" + dbn + ".bolt")
+
+	if FileExists(dbf) {
+		shards = append(shards, dbf)
+	}
+
+	for dcount := 1; ; dcount++ {
+
+		ndbf := fmt.Sprintf("%s/%s_%08d.bolt", ddir, dbn, dcount)
+		if !FileExists(ndbf) {
+			break
+		}
+
+		shards = append(shards, ndbf)
+
+	}
+
+	return shards
+
+}
+
+// ArchiveFind : archive and data bucket of key in archives of directory, empty archive if key does not exist
+func ArchiveFind(shards []string, name string, opts *ArchiveOptions) (string, string, error) {
+
+	for _, dbf := range shards {
+
+		db, err := BoltOpenRead(dbf, opts.Filemode, opts.Timeout, opts.Opentries, freelist)
+		if err != nil {
+			return "", "", err
+		}
+
+		bucket, err := KeyExists(db, "index", name)
+		db.Close()
+
+		if err != nil {
+			return "", "", err
+		}
+
+		if bucket != "" {
+			return dbf, bucket, nil
+		}
+
+	}
+
+	return "", "", nil
+
+}
+
+// ArchivePut : write of key to archives of directory with the same sharding and bucket layout as uploads, archive is locked by bolt for the time of one transaction, returns archive and data bucket of key
+func ArchivePut(ddir string, name string, data []byte, mode os.FileMode, date time.Time, opts *ArchiveOptions) (string, string, error) {
+
+	shards := ArchiveShards(ddir)
+
+	dbf, _, err := ArchiveFind(shards, name, opts)
+	if err != nil {
+		return "", "", err
+	}
+
+	if dbf == "" {
+
+		switch {
+		case len(shards) == 0:
+			dbf = filepath.Clean(ddir + "/" + filepath.Base(ddir) + ".bolt")
+		default:
+
+			dbf = shards[len(shards)-1]
+
+			info, err := os.Stat(dbf)
+			if err != nil {
+				return "", "", err
+			}
+
+			db, err := BoltOpenRead(dbf, opts.Filemode, opts.Timeout, opts.Opentries, freelist)
+			if err != nil {
+				return "", "", err
+			}
+
+			keyscnt, err := KeysCount(db, "index")
+			db.Close()
+
+			if err != nil {
+				return "", "", err
+			}
+
+			if info.Size() >= opts.Smaxsize || keyscnt >= opts.Skeyscnt {
+				dbf = fmt.Sprintf("%s/%s_%08d.bolt", ddir, filepath.Base(ddir), len(shards))
+			}
+
+		}
+
+	}
+
+	head := Header{
+		Size: uint64(len(data)), Date: uint64(date.Unix()), Mode: uint16(mode.Perm()), Uuid: uint16(Uid), Guid: uint16(Gid), Comp: uint8(0), Encr: uint8(0), Crcs: uint32(0), Rsvr: uint64(0),
+	}
+
+	if opts.Integrity {
+		head.Crcs = crc32.Checksum(data, ctbl32)
+	}
+
+	vbuffer := new(bytes.Buffer)
+
+	err = binary.Write(vbuffer, Endian, head)
+	if err != nil {
+		return "", "", err
+	}
+
+	_, err = vbuffer.Write(data)
+	if err != nil {
+		return "", "", err
+	}
+
+	sb := make([]byte, 8)
+	Endian.PutUint64(sb, head.Size)
+
+	tb := make([]byte, 8)
+	Endian.PutUint64(tb, head.Date)
+
+	db, err := BoltOpenWrite(dbf, opts.Filemode, opts.Timeout, opts.Opentries, freelist)
+	if err != nil {
+		return "", "", err
+	}
+
+	defer db.Close()
+
+	var bucket string
+
+	err = db.Update(func(tx *bolt.Tx) error {
+
+		var aux [4]*bolt.Bucket
+
+		for i, name := range []string{"index", "size", "time", "count"} {
+
+			b, err := tx.CreateBucketIfNotExists([]byte(name))
+			if err != nil {
+				return err
+			}
+
+			aux[i] = b
+
+		}
+
+		ib, sbk, tbk, cb := aux[0], aux[1], aux[2], aux[3]
+
+		bucket = string(ib.Get([]byte(name)))
+
+		if bucket == "" {
+
+			var counter uint64 = 1
+
+			if cv := cb.Get([]byte("counter")); len(cv) == 8 {
+				counter = Endian.Uint64(cv)
+			}
+
+			if lb := tx.Bucket([]byte(fmt.Sprintf("wzd%d", counter))); lb != nil {
+
+				sts := lb.Stats()
+
+				if sts.KeyN >= PerBucket(int64(len(data))) || sts.LeafInuse >= 536870912 {
+					counter++
+				}
+
+			}
+
+			nb := make([]byte, 8)
+			Endian.PutUint64(nb, counter)
+
+			err := cb.Put([]byte("counter"), nb)
+			if err != nil {
+				return err
+			}
+
+			bucket = fmt.Sprintf("wzd%d", counter)
+
+		}
+
+		b, err := tx.CreateBucketIfNotExists([]byte(bucket))
+		if err != nil {
+			return err
+		}
+
+		err = b.Put([]byte(name), vbuffer.Bytes())
+		if err != nil {
+			return err
+		}
+
+		err = ib.Put([]byte(name), []byte(bucket))
+		if err != nil {
+			return err
+		}
+
+		err = sbk.Put([]byte(name), sb)
+		if err != nil {
+			return err
+		}
+
+		return tbk.Put([]byte(name), tb)
+
+	})
+
+	return dbf, bucket, err
+
+}
+
+// ArchiveKeys : keys of archive with headers, sorted by name
+func ArchiveKeys(dbf string, opts *ArchiveOptions) ([]ArchiveKey, error) {
+
+	var keys []ArchiveKey
+
+	db, err := BoltOpenRead(dbf, opts.Filemode, opts.Timeout, opts.Opentries, freelist)
+	if err != nil {
+		return nil, err
+	}
+
+	defer db.Close()
+
+	err = db.View(func(tx *bolt.Tx) error {
+
+		ib := tx.Bucket([]byte("index"))
+		if ib == nil {
+			return errors.New("index bucket not exists")
+		}
+
+		return ib.ForEach(func(k, v []byte) error {
+
+			ak := ArchiveKey{Name: string(k), Archive: dbf, Bucket: string(v)}
+
+			b := tx.Bucket(v)
+			if b == nil {
+				return fmt.Errorf("data bucket %s of key %s not exists", string(v), string(k))
+			}
+
+			val, err := b.GetLimit(k, 36)
+			if err != nil || len(val) < 36 {
+				return fmt.Errorf("header of key %s is truncated", string(k))
+			}
+
+			err = binary.Read(bytes.NewReader(val), Endian, &ak.Head)
+			if err != nil {
+				return err
+			}
+
+			keys = append(keys, ak)
+
+			return nil
+
+		})
+
+	})
+
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Name < keys[j].Name })
+
+	return keys, err
+
+}
+
+// ArchiveGet : header and data of key from data bucket of archive with verification of header size and crc
+func ArchiveGet(dbf string, bucket string, name string, opts *ArchiveOptions) (Header, []byte, error) {
+
+	var head Header
+	var data []byte
+
+	db, err := BoltOpenRead(dbf, opts.Filemode, opts.Timeout, opts.Opentries, freelist)
+	if err != nil {
+		return head, nil, err
+	}
+
+	defer db.Close()
+
+	err = db.View(func(tx *bolt.Tx) error {
+
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return fmt.Errorf("data bucket %s not exists", bucket)
+		}
+
+		val := b.Get([]byte(name))
+		if len(val) < 36 {
+			return fmt.Errorf("header of key %s is truncated", name)
+		}
+
+		err := binary.Read(bytes.NewReader(val[:36]), Endian, &head)
+		if err != nil {
+			return err
+		}
+
+		data = append([]byte(nil), val[36:]...)
+
+		return nil
+
+	})
+
+	if err != nil {
+		return head, nil, err
+	}
+
+	if uint64(len(data)) != head.Size {
+		return head, nil, fmt.Errorf("header size %d does not match data length %d of key %s", head.Size, len(data), name)
+	}
+
+	if head.Crcs != 0 && crc32.Checksum(data, ctbl32) != head.Crcs {
+		return head, nil, fmt.Errorf("crc does not verify for key %s", name)
+	}
+
+	return head, data, nil
+
+}
+
+// ArchivePaths : archives of arguments, archive file as is, directory as its archives in shard order, recursively with walk
+func ArchivePaths(paths []string, walk bool) ([]string, error) {
+
+	var archives []string
+
+	for _, path := range paths {
+
+		path = filepath.Clean(path)
+
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case !info.IsDir():
+			archives = append(archives, path)
+		case !walk:
+			archives = append(archives, ArchiveShards(path)...)
+		default:
+
+			err = filepath.Walk(path, func(dir string, info os.FileInfo, err error) error {
+
+				if err != nil {
+					return err
+				}
+
+				if info.IsDir() {
+					archives = append(archives, ArchiveShards(dir)...)
+				}
+
+				return nil
+
+			})
+
+			if err != nil {
+				return nil, err
+			}
+
+		}
+
+	}
+
+	return archives, nil
+
+}
+
+// ArchivePack : pack regular files of directories recursively to archives of their directories, files bigger than fmaxsize are kept
+func ArchivePack(args []string) int {
+
+	var opts ArchiveOptions
+
+	fs, load := ArchiveFlags("pack", "dir ...", &opts)
+
+	remove := fs.Bool("delete", false, "--delete - delete packed files")
+
+	if !ArchiveParse(fs, load, &opts, args, 1) {
+		return 16
+	}
+
+	code := 0
+
+	var packed, skipped, size uint64
+
+	for _, root := range fs.Args() {
+
+		err := filepath.Walk(filepath.Clean(root), func(path string, info os.FileInfo, err error) error {
+
+			if err != nil {
+				return err
+			}
+
+			if !info.Mode().IsRegular() || rgxbolt.MatchString(path) || rgxcrcbolt.MatchString(path) {
+				return nil
+			}
+
+			if info.Size() > opts.Fmaxsize {
+				skipped++
+				return nil
+			}
+
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				return err
+			}
+
+			dbf, bucket, err := ArchivePut(filepath.Dir(path), info.Name(), data, info.Mode(), info.ModTime(), &opts)
+			if err != nil {
+				return fmt.Errorf("file %s: %v", path, err)
+			}
+
+			packed++
+			size += uint64(len(data))
+
+			if debugmode {
+				fmt.Printf("| File [%s] | DB [%s] | Packed\n", path, dbf)
+			}
+
+			if *remove {
+
+				_, vdata, err := ArchiveGet(dbf, bucket, info.Name(), &opts)
+				if err != nil || !bytes.Equal(vdata, data) {
+					return fmt.Errorf("file %s: packed key does not match file, file is kept: %v", path, err)
+				}
+
+				return os.Remove(path)
+
+			}
+
+			return nil
+
+		})
+
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "| Path [%s] | Can`t pack files error | %v\n", root, err)
+			code = 1
+		}
+
+	}
+
+	fmt.Printf("| Packed [%d] | Skipped [%d] | Bytes [%d]\n", packed, skipped, size)
+
+	return code
+
+}
+
+// ArchiveUnpack : unpack keys of archives to regular files of their directories, existing files are kept without --force
+func ArchiveUnpack(args []string) int {
+
+	var opts ArchiveOptions
+
+	fs, load := ArchiveFlags("unpack", "archive|dir ...", &opts)
+
+	remove := fs.Bool("delete", false, "--delete - delete archives of directory after all keys of all its archives are unpacked")
+	force := fs.Bool("force", false, "--force - overwrite existing files")
+
+	if !ArchiveParse(fs, load, &opts, args, 1) {
+		return 16
+	}
+
+	archives, err := ArchivePaths(fs.Args(), true)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Can`t find archives error | %v\n", err)
+		return 1
+	}
+
+	code := 0
+
+	var unpacked, skipped uint64
+
+	var dirs []string
+
+	done := make(map[string]bool)
+
+	for _, dbf := range archives {
+
+		ddir := filepath.Dir(dbf)
+
+		if len(dirs) == 0 || dirs[len(dirs)-1] != ddir {
+			dirs = append(dirs, ddir)
+		}
+
+		keys, err := ArchiveKeys(dbf, &opts)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "| DB [%s] | Can`t read archive error | %v\n", dbf, err)
+			code = 1
+			continue
+		}
+
+		complete := true
+
+		for _, ak := range keys {
+
+			if !ArchiveSafeName(ak.Name) {
+				fmt.Fprintf(os.Stderr, "| DB [%s] | Key [%q] | Unsafe key name, skipped\n", dbf, ak.Name)
+				complete = false
+				code = 1
+				continue
+			}
+
+			file := filepath.Join(ddir, ak.Name)
+
+			if FileOrLinkExists(file) && !*force {
+				fmt.Fprintf(os.Stderr, "| DB [%s] | File [%s] | File already exists, skipped\n", dbf, file)
+				skipped++
+				complete = false
+				continue
+			}
+
+			_, data, err := ArchiveGet(dbf, ak.Bucket, ak.Name, &opts)
+			if err == nil {
+				err = ArchiveWrite(file, data, ak.Head, &opts)
+			}
+
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "| DB [%s] | File [%s] | Can`t unpack key error | %v\n", dbf, file, err)
+				complete = false
+				code = 1
+				continue
+			}
+
+			unpacked++
+
+		}
+
+		done[dbf] = complete
+
+	}
+
+	// Shards of directory are deleted only when all of them are unpacked, from the last one, so a failure never leaves a gap in shard numbers
+
+	for _, ddir := range dirs {
+
+		if !*remove {
+			break
+		}
+
+		shards := ArchiveShards(ddir)
+
+		all := true
+
+		for _, dbf := range shards {
+
+			if !done[dbf] {
+				all = false
+				break
+			}
+
+		}
+
+		if !all {
+			fmt.Fprintf(os.Stderr, "| Directory [%s] | Not all archives of directory are unpacked, archives are kept\n", ddir)
+			continue
+		}
+
+		for i := len(shards) - 1; i >= 0; i-- {
+
+			err = os.Remove(shards[i])
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "| DB [%s] | Can`t delete archive error | %v\n", shards[i], err)
+				code = 1
+				break
+			}
+
+		}
+
+	}
+
+	fmt.Printf("| Unpacked [%d] | Skipped [%d]\n", unpacked, skipped)
+
+	return code
+
+}
+
+// ArchiveSafeName : check that key name read from archive is a plain file name inside directory of archive
+func ArchiveSafeName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, "/\x00")
+}
+
+// ArchiveWrite : write of key data to regular file through temporary file with mode and modification time from header
+func ArchiveWrite(file string, data []byte, head Header, opts *ArchiveOptions) error {
+
+	mode := opts.Filemode
+	if head.Mode != 0 && head.Mode <= 0777 {
+		mode = os.FileMode(head.Mode)
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(file), "."+filepath.Base(file)+".")
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Chmod(mode)
+	}
+
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		return err
+	}
+
+	mtime := time.Unix(int64(head.Date), 0)
+
+	err = os.Chtimes(tmp.Name(), mtime, mtime)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), file)
+
+}
+
+// ArchiveLs : list keys of archives, with --long size, date, data bucket and archive of every key
+func ArchiveLs(args []string) int {
+
+	var opts ArchiveOptions
+
+	fs, load := ArchiveFlags("ls", "archive|dir ...", &opts)
+
+	long := fs.Bool("long", false, "--long - print size, date, data bucket and archive of keys")
+
+	if !ArchiveParse(fs, load, &opts, args, 1) {
+		return 16
+	}
+
+	archives, err := ArchivePaths(fs.Args(), false)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Can`t find archives error | %v\n", err)
+		return 1
+	}
+
+	code := 0
+
+	for _, dbf := range archives {
+
+		keys, err := ArchiveKeys(dbf, &opts)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "| DB [%s] | Can`t read archive error | %v\n", dbf, err)
+			code = 1
+			continue
+		}
+
+		for _, ak := range keys {
+
+			if *long {
+				fmt.Printf("%12d %s %-8s %s %s\n", ak.Head.Size, time.Unix(int64(ak.Head.Date), 0).Format("2006-01-02 15:04:05"), ak.Bucket, filepath.Base(dbf), ak.Name)
+				continue
+			}
+
+			fmt.Println(ak.Name)
+
+		}
+
+	}
+
+	return code
+
+}
+
+// ArchiveCat : write data of key from archives of directory or from archive to stdout
+func ArchiveCat(args []string) int {
+
+	var opts ArchiveOptions
+
+	fs, load := ArchiveFlags("cat", "archive|dir key", &opts)
+
+	if !ArchiveParse(fs, load, &opts, args, 2) {
+		return 16
+	}
+
+	archives, err := ArchivePaths(fs.Args()[:1], false)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Can`t find archives error | %v\n", err)
+		return 1
+	}
+
+	name := fs.Arg(1)
+
+	dbf, bucket, err := ArchiveFind(archives, name, &opts)
+
+	switch {
+	case err != nil:
+		fmt.Fprintf(os.Stderr, "| Key [%s] | Can`t read archive error | %v\n", name, err)
+		return 1
+	case dbf == "":
+		fmt.Fprintf(os.Stderr, "| Key [%s] | Key not found\n", name)
+		return 1
+	}
+
+	_, data, err := ArchiveGet(dbf, bucket, name, &opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "| DB [%s] | Key [%s] | Can`t read key error | %v\n", dbf, name, err)
+		return 1
+	}
+
+	_, err = os.Stdout.Write(data)
+	if err != nil {
+		return 1
+	}
+
+	return 0
+
+}
+
+// ArchiveVerify : read-only check of archives with the same checks and exit codes as fsck without --repair
+func ArchiveVerify(args []string) int {
+
+	var opts ArchiveOptions
+
+	fs, load := ArchiveFlags("verify", "archive|dir ...", &opts)
+
+	if !ArchiveParse(fs, load, &opts, args, 1) {
+		return 16
+	}
+
+	return FsckWalk(fs.Args(), false, "lost+found")
+
+}
+
+// ArchiveStat : statistics of archives, keys, data buckets, counter, bytes of data, free bytes and size of file
+func ArchiveStat(args []string) int {
+
+	var opts ArchiveOptions
+
+	fs, load := ArchiveFlags("stat", "archive|dir ...", &opts)
+
+	if !ArchiveParse(fs, load, &opts, args, 1) {
+		return 16
+	}
+
+	archives, err := ArchivePaths(fs.Args(), false)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Can`t find archives error | %v\n", err)
+		return 1
+	}
+
+	code := 0
+
+	for _, dbf := range archives {
+
+		info, err := os.Stat(dbf)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "| DB [%s] | Can`t stat archive error | %v\n", dbf, err)
+			code = 1
+			continue
+		}
+
+		keys, err := ArchiveKeys(dbf, &opts)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "| DB [%s] | Can`t read archive error | %v\n", dbf, err)
+			code = 1
+			continue
+		}
+
+		var dbytes uint64
+
+		buckets := make(map[string]bool)
+
+		for _, ak := range keys {
+			dbytes += ak.Head.Size
+			buckets[ak.Bucket] = true
+		}
+
+		db, err := BoltOpenRead(dbf, opts.Filemode, opts.Timeout, opts.Opentries, freelist)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "| DB [%s] | Can`t open archive error | %v\n", dbf, err)
+			code = 1
+			continue
+		}
+
+		counter, _ := BucketCount(db, "count")
+
+		st := db.Stats()
+		free := int64(st.FreePageN+st.PendingPageN) * int64(db.Info().PageSize)
+
+		db.Close()
+
+		fmt.Printf("| DB [%s] | Keys [%d] | Buckets [%d] | Counter [%d] | Bytes [%d] | Free [%d] | Size [%d]\n", dbf, len(keys), len(buckets), counter, dbytes, free, info.Size())
+
+	}
+
+	return code
+
+}
@@ -0,0 +1,776 @@
+/*
+
+Copyright © 2020 Andrey Kuvshinov. Contacts: <syslinux@protonmail.com>
+Copyright © 2020 Eltaline OU. Contacts: <eltaline.ou@gmail.com>
+All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+The wZD project contains unmodified/modified libraries imports too with
+separate copyright notices and license terms. Your use of the source code
+this libraries is subject to the terms and conditions of licenses these libraries.
+
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/coocood/freecache"
+	"github.com/eltaline/bolt"
+	"github.com/eltaline/mmutex"
+	"github.com/eltaline/nutsdb"
+	"github.com/kataras/iris/v12"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Backup Handlers
+
+var rgxsnapshot = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,127}$`)
+
+// ZDBackup : POST method with Backup or Restore header, consistent snapshot of directory of virtual host to backup directory, incremental snapshot against base snapshot with Incremental header, restore of directory from snapshot
+func ZDBackup(cache *freecache.Cache, keymutex *mmutex.Mutex, cdb *nutsdb.DB, wg *sync.WaitGroup) iris.Handler {
+	return func(ctx iris.Context) {
+		defer wg.Done()
+
+		var err error
+
+		// Wait Group
+
+		wg.Add(1)
+
+		// Loggers
+
+		putLogger, putlogfile := PutLogger()
+		defer putlogfile.Close()
+
+		// Shutdown
+
+		if shutdown {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			return
+		}
+
+		// Headers
+
+		ip := ctx.RemoteAddr()
+		cip := net.ParseIP(ip)
+		vhost := strings.Split(ctx.Host(), ":")[0]
+
+		// Search DB of virtual host
+
+		ndb, search := SearchHost(vhost)
+
+		uri := ctx.Path()
+
+		hbackup := ctx.GetHeader("Backup")
+		hrestore := ctx.GetHeader("Restore")
+		hincremental := ctx.GetHeader("Incremental")
+		hwait := ctx.GetHeader("Wait")
+
+		badhost := true
+		badip := true
+
+		base := "/notfound"
+
+		backup := false
+
+		reindexrate := 0
+
+		trytimes := 5
+		opentries := 5
+		locktimeout := 5
+
+		filemode := os.FileMode(0640)
+		dirmode := os.FileMode(0750)
+
+		log4xx := true
+
+		for _, Server := range config.Server {
+
+			if vhost == Server.HOST {
+
+				badhost = false
+
+				base = filepath.Clean(Server.ROOT)
+
+				for _, Vhost := range AllowPut() {
+
+					if vhost == Vhost.Vhost {
+
+						for _, CIDR := range Vhost.CIDR {
+							_, ipnet, _ := net.ParseCIDR(CIDR.Addr)
+							if ipnet.Contains(cip) {
+								badip = false
+								break
+							}
+						}
+
+						break
+
+					}
+
+				}
+
+				backup = Server.BACKUP
+
+				reindexrate = Server.REINDEXRATE
+
+				trytimes = Server.TRYTIMES
+				opentries = Server.OPENTRIES
+				locktimeout = Server.LOCKTIMEOUT
+
+				cfilemode, err := strconv.ParseUint(fmt.Sprintf("%d", Server.FILEMODE), 8, 32)
+				switch {
+				case err != nil || cfilemode == 0:
+					filemode = os.FileMode(0640)
+				default:
+					filemode = os.FileMode(cfilemode)
+				}
+
+				cdirmode, err := strconv.ParseUint(fmt.Sprintf("%d", Server.DIRMODE), 8, 32)
+				switch {
+				case err != nil || cdirmode == 0:
+					dirmode = os.FileMode(0750)
+				default:
+					dirmode = os.FileMode(cdirmode)
+				}
+
+				log4xx = Server.LOG4XX
+
+				break
+
+			}
+
+		}
+
+		if badhost {
+
+			ctx.StatusCode(iris.StatusMisdirectedRequest)
+
+			if log4xx {
+				putLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 421 | Not found configured virtual host", vhost, ip)
+			}
+
+			if debugmode {
+
+				_, err = ctx.Writef("[ERRO] Not found configured virtual host | Virtual Host [%s]\n", vhost)
+				if err != nil {
+					putLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+				}
+
+			}
+
+			return
+
+		}
+
+		if badip {
+
+			ctx.StatusCode(iris.StatusForbidden)
+
+			if log4xx {
+				putLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 403 | Forbidden", vhost, ip)
+			}
+
+			if debugmode {
+
+				_, err = ctx.Writef("[ERRO] Not found allowed ip | Virtual Host [%s]\n", vhost)
+				if err != nil {
+					putLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+				}
+
+			}
+
+			return
+
+		}
+
+		if !backup {
+
+			ctx.StatusCode(iris.StatusForbidden)
+
+			if log4xx {
+				putLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 403 | The backup and restore requests are not allowed during POST request", vhost, ip)
+			}
+
+			if debugmode {
+
+				_, err = ctx.WriteString("[ERRO] The backup and restore requests are not allowed during POST request\n")
+				if err != nil {
+					putLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+				}
+
+			}
+
+			return
+
+		}
+
+		if hrestore != "" && ReadOnly(vhost) {
+
+			ctx.StatusCode(iris.StatusForbidden)
+
+			if log4xx {
+				putLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 403 | Virtual host is read-only", vhost, ip)
+			}
+
+			if debugmode {
+
+				_, err = ctx.Writef("[ERRO] Virtual host is read-only | Virtual Host [%s]\n", vhost)
+				if err != nil {
+					putLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+				}
+
+			}
+
+			return
+
+		}
+
+		name := hbackup
+		if hrestore != "" {
+			name = hrestore
+		}
+
+		if (hbackup != "" && hrestore != "") || !rgxsnapshot.MatchString(name) || (hincremental != "" && (hrestore != "" || !rgxsnapshot.MatchString(hincremental) || hincremental == name)) {
+
+			ctx.StatusCode(iris.StatusBadRequest)
+
+			if log4xx {
+				putLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 400 | Backup, Restore and Incremental headers must be snapshot names of letters, digits, dots, underscores and dashes during backup request | Backup [%s] | Restore [%s] | Incremental [%s]", vhost, ip, hbackup, hrestore, hincremental)
+			}
+
+			if debugmode {
+
+				_, err = ctx.WriteString("[ERRO] Backup, Restore and Incremental headers must be snapshot names of letters, digits, dots, underscores and dashes during backup request\n")
+				if err != nil {
+					putLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+				}
+
+			}
+
+			return
+
+		}
+
+		abs := filepath.Clean(base + uri)
+
+		snapdir := filepath.Join(backupdir, vhost, name)
+		basedir := ""
+
+		if hincremental != "" {
+			basedir = filepath.Join(backupdir, vhost, hincremental)
+		}
+
+		var sterr error
+
+		switch {
+		case hbackup != "" && !DirExists(abs):
+			sterr = fmt.Errorf("directory not exists %s", abs)
+		case hbackup != "" && FileOrLinkExists(snapdir):
+			sterr = fmt.Errorf("snapshot already exists %s", snapdir)
+		case basedir != "" && !FileExists(filepath.Join(basedir, "manifest.json")):
+			sterr = fmt.Errorf("base snapshot not exists %s", basedir)
+		case hrestore != "" && !FileExists(filepath.Join(snapdir, "manifest.json")):
+			sterr = fmt.Errorf("snapshot not exists %s", snapdir)
+		}
+
+		if sterr != nil {
+
+			ctx.StatusCode(iris.StatusNotFound)
+
+			if log4xx {
+				putLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 404 | Not found | %v", vhost, ip, sterr)
+			}
+
+			if debugmode {
+
+				_, err = ctx.Writef("[ERRO] Not found | %v\n", sterr)
+				if err != nil {
+					putLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+				}
+
+			}
+
+			return
+
+		}
+
+		if !keymutex.TryLock("backup") {
+
+			ctx.StatusCode(iris.StatusConflict)
+
+			if log4xx {
+				putLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 409 | Backup or restore is already in progress error | Path [%s]", vhost, ip, abs)
+			}
+
+			if debugmode {
+
+				_, err = ctx.WriteString("[ERRO] Backup or restore is already in progress error\n")
+				if err != nil {
+					putLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+				}
+
+			}
+
+			return
+
+		}
+
+		timeout := time.Duration(locktimeout) * time.Second
+
+		run := func() (BackupManifest, error) {
+
+			defer keymutex.UnLock("backup")
+
+			if hrestore != "" {
+				return Restore(cache, keymutex, ndb, search, base, abs, snapdir, reindexrate, filemode, dirmode, timeout, opentries, trytimes)
+			}
+
+			bm := BackupManifest{Host: vhost, Path: "/" + strings.TrimPrefix(strings.TrimPrefix(abs, base), "/"), Name: name, Base: hincremental, Time: time.Now().Unix()}
+
+			if flog := FeedHost(vhost); flog != nil {
+				flog.Lock()
+				bm.Feed = flog.Seq
+				flog.Unlock()
+			}
+
+			if search {
+				bm.Search = true
+			}
+
+			err := Backup(keymutex, ndb, cdb, &bm, base, abs, snapdir, basedir, filemode, dirmode, timeout, opentries, trytimes)
+			if err != nil {
+				_ = os.RemoveAll(snapdir)
+			}
+
+			return bm, err
+
+		}
+
+		if hwait != "1" {
+
+			wg.Add(1)
+
+			go func() {
+				defer wg.Done()
+
+				bm, err := run()
+				if err != nil {
+					putLogger, putlogfile := PutLogger()
+					putLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 500 | Can`t backup or restore directory error | Path [%s] | Snapshot [%s] | %v", vhost, ip, abs, snapdir, err)
+					putlogfile.Close()
+					return
+				}
+
+				appLogger, applogfile := AppLogger()
+				appLogger.Warnf("| Virtual Host [%s] | Backup or restore is completed | Path [%s] | Snapshot [%s] | Dirs [%d] | Files [%d] | Archives [%d] | Linked [%d] | Bytes [%d]", vhost, bm.Path, snapdir, bm.Dirs, bm.Files, bm.Archives, bm.Linked, bm.Bytes)
+				applogfile.Close()
+
+			}()
+
+			ctx.StatusCode(iris.StatusAccepted)
+			return
+
+		}
+
+		bm, err := run()
+		if err != nil {
+
+			ctx.StatusCode(iris.StatusInternalServerError)
+			putLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 500 | Can`t backup or restore directory error | Path [%s] | Snapshot [%s] | %v", vhost, ip, abs, snapdir, err)
+
+			if debugmode {
+
+				_, err = ctx.WriteString("[ERRO] Can`t backup or restore directory error\n")
+				if err != nil {
+					putLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+				}
+
+			}
+
+			return
+
+		}
+
+		rbytes, _ := json.Marshal(bm)
+
+		ctx.Header("Content-Type", "application/json")
+		ctx.Header("Content-Length", fmt.Sprintf("%d", len(rbytes)))
+
+		_, err = ctx.Write(rbytes)
+		if err != nil {
+
+			if log4xx {
+				putLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+			}
+
+		}
+
+	}
+
+}
+
+// Backup : consistent snapshot of directory to snapshot directory, archives are copied through bolt read transaction, files are copied under key lock, unchanged files and archives are hard linked from base snapshot
+func Backup(keymutex *mmutex.Mutex, ndb *nutsdb.DB, cdb *nutsdb.DB, bm *BackupManifest, base string, abs string, snapdir string, basedir string, filemode os.FileMode, dirmode os.FileMode, timeout time.Duration, opentries int, trytimes int) error {
+
+	var err error
+
+	var since int64
+
+	if basedir != "" {
+
+		bbm, err := BackupRead(basedir)
+		if err != nil {
+			return err
+		}
+
+		since = bbm.Time
+
+	}
+
+	datadir := filepath.Join(snapdir, "data")
+
+	err = os.MkdirAll(datadir, dirmode)
+	if err != nil {
+		return err
+	}
+
+	err = filepath.Walk(abs, func(path string, info os.FileInfo, err error) error {
+
+		if err != nil {
+			return err
+		}
+
+		if shutdown {
+			return errors.New("shutdown in progress")
+		}
+
+		rel := strings.TrimPrefix(path, base)
+		dst := filepath.Join(datadir, rel)
+
+		if info.IsDir() {
+
+			if path == backupdir {
+				return filepath.SkipDir
+			}
+
+			bm.Dirs++
+			return os.MkdirAll(dst, dirmode)
+
+		}
+
+		if !info.Mode().IsRegular() || rgxcrcbolt.MatchString(path) {
+			return nil
+		}
+
+		archive := rgxbolt.MatchString(path)
+
+		if since > 0 && info.ModTime().Unix() < since {
+
+			bfile := filepath.Join(basedir, "data", rel)
+
+			binfo, err := os.Stat(bfile)
+			if err == nil && (archive || binfo.Size() == info.Size()) && os.Link(bfile, dst) == nil {
+
+				bm.Linked++
+				bm.Bytes += uint64(binfo.Size())
+
+				if archive {
+					bm.Archives++
+				} else {
+					bm.Files++
+				}
+
+				return nil
+
+			}
+
+		}
+
+		size, err := BackupCopy(keymutex, path, dst, archive, filemode, timeout, opentries, trytimes)
+		if err != nil {
+			return err
+		}
+
+		bm.Bytes += uint64(size)
+
+		if archive {
+			bm.Archives++
+		} else {
+			bm.Files++
+		}
+
+		return nil
+
+	})
+
+	if err != nil {
+		return err
+	}
+
+	if bm.Search && ndb != nil {
+
+		err = ndb.Backup(filepath.Join(snapdir, "search"))
+		if err != nil {
+			return err
+		}
+
+	}
+
+	if cdb != nil {
+
+		err = cdb.Backup(filepath.Join(snapdir, "compact"))
+		if err != nil {
+			return err
+		}
+
+		bm.Compact = true
+
+	}
+
+	return BackupWrite(snapdir, bm, filemode)
+
+}
+
+// Restore : restore of directory from snapshot directory, files and archives are replaced under key lock, files absent in snapshot are kept, search db is reindexed through directory
+func Restore(cache *freecache.Cache, keymutex *mmutex.Mutex, ndb *nutsdb.DB, search bool, base string, abs string, snapdir string, rate int, filemode os.FileMode, dirmode os.FileMode, timeout time.Duration, opentries int, trytimes int) (BackupManifest, error) {
+
+	bm, err := BackupRead(snapdir)
+	if err != nil {
+		return bm, err
+	}
+
+	datadir := filepath.Join(snapdir, "data")
+	src := filepath.Join(datadir, strings.TrimPrefix(abs, base))
+
+	if !DirExists(src) {
+		return bm, fmt.Errorf("directory not exists in snapshot %s", src)
+	}
+
+	rm := BackupManifest{Host: bm.Host, Path: "/" + strings.TrimPrefix(strings.TrimPrefix(abs, base), "/"), Name: bm.Name, Base: bm.Base, Time: bm.Time, Feed: bm.Feed}
+
+	err = filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+
+		if err != nil {
+			return err
+		}
+
+		if shutdown {
+			return errors.New("shutdown in progress")
+		}
+
+		dst := filepath.Join(base, strings.TrimPrefix(path, datadir))
+
+		if info.IsDir() {
+
+			rm.Dirs++
+			return os.MkdirAll(dst, dirmode)
+
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		// Snapshot files are never changed, so plain copy is consistent, key lock of destination keeps writers away
+
+		size, err := BackupCopy(keymutex, path, dst, false, filemode, timeout, opentries, trytimes)
+		if err != nil {
+			return err
+		}
+
+		rm.Bytes += uint64(size)
+
+		if rgxbolt.MatchString(path) {
+			rm.Archives++
+		} else {
+			rm.Files++
+		}
+
+		return nil
+
+	})
+
+	if err != nil {
+		return rm, err
+	}
+
+	CacheInvalidate(cache, abs)
+
+	if search && ndb != nil {
+
+		_, err = Reindex(cache, ndb, base, abs, -1, rate, filemode, timeout, opentries)
+		if err != nil {
+			return rm, err
+		}
+
+		rm.Search = true
+
+	}
+
+	return rm, nil
+
+}
+
+// BackupCopy : copy of file or archive through temporary file under key lock of source or destination, archive is copied through bolt read transaction, modification time is preserved
+func BackupCopy(keymutex *mmutex.Mutex, src string, dst string, archive bool, filemode os.FileMode, timeout time.Duration, opentries int, trytimes int) (int64, error) {
+
+	lkey := src
+	if !strings.HasPrefix(dst, backupdir+"/") {
+		lkey = dst
+	}
+
+	key := false
+
+	for i := 0; i < trytimes; i++ {
+
+		if key = keymutex.TryLock(lkey); key {
+			break
+		}
+
+		time.Sleep(defsleep)
+
+	}
+
+	if !key {
+		return 0, fmt.Errorf("timeout of key lock %s", lkey)
+	}
+
+	defer keymutex.UnLock(lkey)
+
+	info, err := os.Stat(src)
+	if err != nil {
+		return 0, err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(dst), "."+filepath.Base(dst)+".")
+	if err != nil {
+		return 0, err
+	}
+
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	var size int64
+
+	switch {
+	case archive:
+
+		db, err := BoltOpenRead(src, filemode, timeout, opentries, freelist)
+		if err != nil {
+			return 0, err
+		}
+
+		err = db.View(func(tx *bolt.Tx) error {
+			size, err = tx.WriteTo(tmp)
+			return err
+		})
+
+		db.Close()
+
+		if err != nil {
+			return 0, err
+		}
+
+	default:
+
+		sf, err := os.Open(src)
+		if err != nil {
+			return 0, err
+		}
+
+		size, err = io.Copy(tmp, sf)
+		sf.Close()
+
+		if err != nil {
+			return 0, err
+		}
+
+	}
+
+	err = tmp.Sync()
+	if err != nil {
+		return 0, err
+	}
+
+	err = tmp.Chmod(filemode)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tmp.Close()
+	if err != nil {
+		return 0, err
+	}
+
+	err = os.Chtimes(tmp.Name(), info.ModTime(), info.ModTime())
+	if err != nil {
+		return 0, err
+	}
+
+	err = os.Rename(tmp.Name(), dst)
+	if err != nil {
+		return 0, err
+	}
+
+	return size, nil
+
+}
+
+// BackupRead : read of manifest of snapshot directory
+func BackupRead(snapdir string) (BackupManifest, error) {
+
+	var bm BackupManifest
+
+	data, err := ioutil.ReadFile(filepath.Join(snapdir, "manifest.json"))
+	if err != nil {
+		return bm, err
+	}
+
+	err = json.Unmarshal(data, &bm)
+
+	return bm, err
+
+}
+
+// BackupWrite : atomic write of manifest of snapshot directory, manifest is written last and marks snapshot as complete
+func BackupWrite(snapdir string, bm *BackupManifest, filemode os.FileMode) error {
+
+	data, err := json.Marshal(bm)
+	if err != nil {
+		return err
+	}
+
+	tmp := filepath.Join(snapdir, ".manifest.json")
+
+	err = ioutil.WriteFile(tmp, data, filemode)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, filepath.Join(snapdir, "manifest.json"))
+
+}
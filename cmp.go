@@ -35,9 +35,16 @@ import (
 
 // CMPScheduler : Compaction/Defragmentation scheduler
 func CMPScheduler(keymutex *mmutex.Mutex, cdb *nutsdb.DB) {
+	CMPRun(keymutex, cdb, time.Now().Add(time.Duration(-24*cmptime)*time.Hour), "")
+}
+
+// CMPRun : compaction of archives from compaction db with tasks older than past time, only of requested archive if path is set, returns number of compacted archives
+func CMPRun(keymutex *mmutex.Mutex, cdb *nutsdb.DB, past time.Time, path string) int {
 
 	// Variables
 
+	compacted := 0
+
 	opentries := 30
 	trytimes := 30
 	timeout := time.Duration(60) * time.Second
@@ -60,11 +67,9 @@ func CMPScheduler(keymutex *mmutex.Mutex, cdb *nutsdb.DB) {
 	// Shutdown
 
 	if shutdown {
-		return
+		return compacted
 	}
 
-	past := time.Now().Add(time.Duration(-24*cmptime) * time.Hour)
-
 	cerr := cdb.View(func(tx *nutsdb.Tx) error {
 
 		var err error
@@ -104,6 +109,10 @@ func CMPScheduler(keymutex *mmutex.Mutex, cdb *nutsdb.DB) {
 
 			}
 
+			if path != "" && ev.Path != path {
+				continue
+			}
+
 			diff := ev.Time.Sub(past)
 
 			if diff < 0 {
@@ -222,6 +231,11 @@ func CMPScheduler(keymutex *mmutex.Mutex, cdb *nutsdb.DB) {
 
 			}
 
+			err = FeedCompact(dbf.Path)
+			if err != nil {
+				appLogger.Errorf("| Write compaction event to change feed or webhooks queue db error | DB [%s] | %v", dbf.Path, err)
+			}
+
 			err = NDBDelete(cdb, cmpbucket, dbf.Key)
 			if err != nil {
 				appLogger.Errorf("| Delete compaction task error | DB Key [%s] | %v", sdbf, err)
@@ -233,6 +247,8 @@ func CMPScheduler(keymutex *mmutex.Mutex, cdb *nutsdb.DB) {
 			db.Close()
 			keymutex.UnLock(dbf.Path)
 
+			compacted++
+
 		} else {
 
 			appLogger.Errorf("| Timeout mmutex lock error | DB [%s]", dbf.Path)
@@ -241,4 +257,6 @@ func CMPScheduler(keymutex *mmutex.Mutex, cdb *nutsdb.DB) {
 
 	}
 
+	return compacted
+
 }
@@ -29,6 +29,7 @@ import (
 	"encoding/gob"
 	"errors"
 	"fmt"
+	"github.com/coocood/freecache"
 	"github.com/eltaline/bolt"
 	"github.com/eltaline/mmutex"
 	"github.com/eltaline/nutsdb"
@@ -46,8 +47,17 @@ import (
 // Delete
 
 // ZDDel : DELETE method
-func ZDDel(keymutex *mmutex.Mutex, cdb *nutsdb.DB, ndb *nutsdb.DB, wg *sync.WaitGroup) iris.Handler {
+func ZDDel(cache *freecache.Cache, keymutex *mmutex.Mutex, cdb *nutsdb.DB, wg *sync.WaitGroup) iris.Handler {
+
+	proxy := ZDProxy(wg)
+
 	return func(ctx iris.Context) {
+
+		if ProxyHost(strings.Split(ctx.Host(), ":")[0]) != nil {
+			proxy(ctx)
+			return
+		}
+
 		defer wg.Done()
 
 		var err error
@@ -67,6 +77,10 @@ func ZDDel(keymutex *mmutex.Mutex, cdb *nutsdb.DB, ndb *nutsdb.DB, wg *sync.Wait
 		cip := net.ParseIP(ip)
 		vhost := strings.Split(ctx.Host(), ":")[0]
 
+		// Search DB of virtual host
+
+		ndb, search := SearchHost(vhost)
+
 		// Shutdown
 
 		if shutdown {
@@ -118,7 +132,7 @@ func ZDDel(keymutex *mmutex.Mutex, cdb *nutsdb.DB, ndb *nutsdb.DB, wg *sync.Wait
 
 				base = filepath.Clean(Server.ROOT)
 
-				for _, Vhost := range delallow {
+				for _, Vhost := range AllowDel() {
 
 					if vhost == Vhost.Vhost {
 
@@ -226,6 +240,27 @@ func ZDDel(keymutex *mmutex.Mutex, cdb *nutsdb.DB, ndb *nutsdb.DB, wg *sync.Wait
 
 		}
 
+		if ReadOnly(vhost) {
+
+			ctx.StatusCode(iris.StatusForbidden)
+
+			if log4xx {
+				delLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 403 | Virtual host is read-only", vhost, ip)
+			}
+
+			if debugmode {
+
+				_, err = ctx.Writef("[ERRO] Virtual host is read-only | Virtual Host [%s]\n", vhost)
+				if err != nil {
+					delLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+				}
+
+			}
+
+			return
+
+		}
+
 		if len(params) != 0 {
 
 			ctx.StatusCode(iris.StatusForbidden)
@@ -436,6 +471,11 @@ func ZDDel(keymutex *mmutex.Mutex, cdb *nutsdb.DB, ndb *nutsdb.DB, wg *sync.Wait
 
 				}
 
+				err = FeedAppend(vhost, FeedEvent{Path: FeedPath(base, abs), Type: 0, Op: "delete"})
+				if err != nil {
+					delLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 599 | Write event to change feed or webhooks queue db error | File [%s] | Path [%s] | %v", vhost, ip, file, abs, err)
+				}
+
 				if search {
 
 					dcrc := crc64.Checksum([]byte(ddir), ctbl64)
@@ -448,15 +488,18 @@ func ZDDel(keymutex *mmutex.Mutex, cdb *nutsdb.DB, ndb *nutsdb.DB, wg *sync.Wait
 						delLogger.Errorf("| Delete file from search db error | File [%s] | Path [%s] | Bucket [%s] | %v", file, abs, nbucket, err)
 					}
 
+					CacheInvalidate(cache, ddir)
+
 					if deldir {
 
 						ed, _ := IsEmptyDir(ddir)
 
 						if ed {
 
-							radix.Lock()
-							tree, _, _ = tree.Delete([]byte(ddir))
-							radix.Unlock()
+							err = TreeDelete(ndb, ddir)
+							if err != nil {
+								delLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 599 | Delete directory from search tree db error | Path [%s] | %v", vhost, ip, ddir, err)
+							}
 
 							dcrc = crc64.Checksum([]byte(filepath.Dir(ddir)), ctbl64)
 							nbucket = strconv.FormatUint(dcrc, 16)
@@ -468,6 +511,8 @@ func ZDDel(keymutex *mmutex.Mutex, cdb *nutsdb.DB, ndb *nutsdb.DB, wg *sync.Wait
 								delLogger.Errorf("| Delete directory from search db error | Directory [%s] | Bucket [%s] | %v", dbn, nbucket, err)
 							}
 
+							CacheInvalidate(cache, filepath.Dir(ddir))
+
 							// Add delete bucket function to NutsDB
 
 						}
@@ -893,6 +938,11 @@ func ZDDel(keymutex *mmutex.Mutex, cdb *nutsdb.DB, ndb *nutsdb.DB, wg *sync.Wait
 
 				}
 
+				err = FeedAppend(vhost, FeedEvent{Path: FeedPath(base, ddir+"/"+file), Archive: FeedPath(base, dbf), Type: 1, Op: "delete"})
+				if err != nil {
+					delLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 599 | Write event to change feed or webhooks queue db error | File [%s] | DB [%s] | %v", vhost, ip, file, dbf, err)
+				}
+
 				if search {
 
 					dcrc := crc64.Checksum([]byte(ddir), ctbl64)
@@ -905,6 +955,18 @@ func ZDDel(keymutex *mmutex.Mutex, cdb *nutsdb.DB, ndb *nutsdb.DB, wg *sync.Wait
 						delLogger.Errorf("| Delete file from search db error | File [%s] | DB [%s] | Bucket [%s] | %v", file, dbf, nbucket, err)
 					}
 
+					err = FTDelete(ndb, nbucket, file)
+					if err != nil {
+						delLogger.Errorf("| Delete file words from full-text search db error | File [%s] | DB [%s] | Bucket [%s] | %v", file, dbf, nbucket, err)
+					}
+
+					err = JXDelete(ndb, nbucket, file)
+					if err != nil {
+						delLogger.Errorf("| Delete file JSON fields from search db error | File [%s] | DB [%s] | Bucket [%s] | %v", file, dbf, nbucket, err)
+					}
+
+					CacheInvalidate(cache, ddir)
+
 				}
 
 				keyscountbucket, err := KeysCountBucket(db, bucket)
@@ -1010,9 +1072,10 @@ func ZDDel(keymutex *mmutex.Mutex, cdb *nutsdb.DB, ndb *nutsdb.DB, wg *sync.Wait
 
 						if ed {
 
-							radix.Lock()
-							tree, _, _ = tree.Delete([]byte(ddir))
-							radix.Unlock()
+							err = TreeDelete(ndb, ddir)
+							if err != nil {
+								delLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 599 | Delete directory from search tree db error | Path [%s] | %v", vhost, ip, ddir, err)
+							}
 
 							dcrc := crc64.Checksum([]byte(filepath.Dir(ddir)), ctbl64)
 							nbucket := strconv.FormatUint(dcrc, 16)
@@ -1024,6 +1087,8 @@ func ZDDel(keymutex *mmutex.Mutex, cdb *nutsdb.DB, ndb *nutsdb.DB, wg *sync.Wait
 								delLogger.Errorf("| Delete directory from search db error | Directory [%s] | Bucket [%s] | %v", dbn, nbucket, err)
 							}
 
+							CacheInvalidate(cache, filepath.Dir(ddir))
+
 							// Add delete bucket function to NutsDB
 
 						}
@@ -1055,6 +1120,8 @@ func ZDDel(keymutex *mmutex.Mutex, cdb *nutsdb.DB, ndb *nutsdb.DB, wg *sync.Wait
 						err = db.CompactQuietly()
 						if err != nil {
 							delLogger.Errorf("| On the fly compaction error | DB [%s] | %v", dbf, err)
+						} else if ferr := FeedCompact(dbf); ferr != nil {
+							delLogger.Errorf("| Write compaction event to change feed or webhooks queue db error | DB [%s] | %v", dbf, ferr)
 						}
 
 						err = os.Chmod(dbf, filemode)
@@ -1099,6 +1166,8 @@ func ZDDel(keymutex *mmutex.Mutex, cdb *nutsdb.DB, ndb *nutsdb.DB, wg *sync.Wait
 						err = db.CompactQuietly()
 						if err != nil {
 							delLogger.Errorf("| On the fly compaction error | DB [%s] | %v", dbf, err)
+						} else if ferr := FeedCompact(dbf); ferr != nil {
+							delLogger.Errorf("| Write compaction event to change feed or webhooks queue db error | DB [%s] | %v", dbf, ferr)
 						}
 
 						err = os.Chmod(dbf, filemode)
@@ -0,0 +1,537 @@
+/*
+
+Copyright © 2020 Andrey Kuvshinov. Contacts: <syslinux@protonmail.com>
+Copyright © 2020 Eltaline OU. Contacts: <eltaline.ou@gmail.com>
+All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+The wZD project contains unmodified/modified libraries imports too with
+separate copyright notices and license terms. Your use of the source code
+this libraries is subject to the terms and conditions of licenses these libraries.
+
+*/
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/coocood/freecache"
+	"github.com/eltaline/bolt"
+	"github.com/eltaline/nutsdb"
+	"github.com/kataras/iris/v12"
+	"hash/crc64"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Digest Handlers
+
+// ZDDigest : GET method with Digest header, Merkle digest of directory with digests of own files and keys and of child directories for top-down comparison of two servers
+func ZDDigest(cache *freecache.Cache, wg *sync.WaitGroup) iris.Handler {
+	return func(ctx iris.Context) {
+		defer wg.Done()
+
+		var err error
+
+		// Wait Group
+
+		wg.Add(1)
+
+		// Loggers
+
+		getLogger, getlogfile := GetLogger()
+		defer getlogfile.Close()
+
+		// Shutdown
+
+		if shutdown {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			return
+		}
+
+		// Headers
+
+		ip := ctx.RemoteAddr()
+		cip := net.ParseIP(ip)
+		vhost := strings.Split(ctx.Host(), ":")[0]
+
+		// Search DB of virtual host
+
+		ndb, search := SearchHost(vhost)
+
+		uri := ctx.Path()
+		method := ctx.Method()
+
+		hkeys := ctx.GetHeader("DigestKeys")
+		hdate := ctx.GetHeader("DigestDate")
+
+		badhost := true
+		badip := true
+
+		base := "/notfound"
+
+		getdigest := false
+
+		opentries := 5
+		locktimeout := 5
+
+		filemode := os.FileMode(0640)
+
+		log4xx := true
+
+		for _, Server := range config.Server {
+
+			if vhost == Server.HOST {
+
+				badhost = false
+
+				base = filepath.Clean(Server.ROOT)
+
+				for _, Vhost := range AllowGet() {
+
+					if vhost == Vhost.Vhost {
+
+						for _, CIDR := range Vhost.CIDR {
+							_, ipnet, _ := net.ParseCIDR(CIDR.Addr)
+							if ipnet.Contains(cip) {
+								badip = false
+								break
+							}
+						}
+
+						break
+
+					}
+
+				}
+
+				getdigest = Server.GETDIGEST
+
+				opentries = Server.OPENTRIES
+				locktimeout = Server.LOCKTIMEOUT
+
+				cfilemode, err := strconv.ParseUint(fmt.Sprintf("%d", Server.FILEMODE), 8, 32)
+				switch {
+				case err != nil || cfilemode == 0:
+					filemode = os.FileMode(0640)
+				default:
+					filemode = os.FileMode(cfilemode)
+				}
+
+				log4xx = Server.LOG4XX
+
+				break
+
+			}
+
+		}
+
+		if badhost {
+
+			ctx.StatusCode(iris.StatusMisdirectedRequest)
+
+			if log4xx {
+				getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 421 | Not found configured virtual host", vhost, ip)
+			}
+
+			if debugmode {
+
+				_, err = ctx.Writef("[ERRO] Not found configured virtual host | Virtual Host [%s]\n", vhost)
+				if err != nil {
+					getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+				}
+
+			}
+
+			return
+
+		}
+
+		if badip {
+
+			ctx.StatusCode(iris.StatusForbidden)
+
+			if log4xx {
+				getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 403 | Forbidden", vhost, ip)
+			}
+
+			if debugmode {
+
+				_, err = ctx.Writef("[ERRO] Not found allowed ip | Virtual Host [%s]\n", vhost)
+				if err != nil {
+					getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+				}
+
+			}
+
+			return
+
+		}
+
+		if !getdigest || !search {
+
+			ctx.StatusCode(iris.StatusForbidden)
+
+			if log4xx {
+				getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 403 | The digest request is not allowed during GET request, getdigest and search must be enabled", vhost, ip)
+			}
+
+			if debugmode {
+
+				_, err = ctx.WriteString("[ERRO] The digest request is not allowed during GET request, getdigest and search must be enabled\n")
+				if err != nil {
+					getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+				}
+
+			}
+
+			return
+
+		}
+
+		if method != "GET" {
+
+			ctx.StatusCode(iris.StatusMethodNotAllowed)
+
+			if log4xx {
+				getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 405 | The digest request is allowed only with GET method | Method [%s]", vhost, ip, method)
+			}
+
+			return
+
+		}
+
+		abs := filepath.Clean(base + uri)
+
+		if !DirExists(abs) {
+
+			ctx.StatusCode(iris.StatusNotFound)
+
+			if log4xx {
+				getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 404 | Not found | Path [%s]", vhost, ip, abs)
+			}
+
+			if debugmode {
+
+				_, err = ctx.WriteString("[ERRO] Can`t find directory error\n")
+				if err != nil {
+					getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+				}
+
+			}
+
+			return
+
+		}
+
+		withdate := hdate == "1"
+		timeout := time.Duration(locktimeout) * time.Second
+
+		digest, err := DigestTree(cache, ndb, abs, withdate, filemode, timeout, opentries)
+		if err == nil {
+
+			var own []byte
+			var keys []DigestKey
+
+			own, keys, err = DigestOwn(ndb, abs, withdate, filemode, timeout, opentries)
+
+			node := DigestNode{Path: "/" + strings.TrimPrefix(strings.TrimPrefix(abs, base), "/"), Digest: hex.EncodeToString(digest), Own: hex.EncodeToString(own), Dirs: []DigestDir{}}
+
+			if hkeys == "1" {
+				node.Keys = keys
+			}
+
+			for _, name := range DigestChildren(abs) {
+
+				if err != nil {
+					break
+				}
+
+				var cdigest []byte
+
+				cdigest, err = DigestTree(cache, ndb, filepath.Join(abs, name), withdate, filemode, timeout, opentries)
+				node.Dirs = append(node.Dirs, DigestDir{Name: name, Digest: hex.EncodeToString(cdigest)})
+
+			}
+
+			if err == nil {
+
+				rbytes, _ := json.Marshal(node)
+
+				ctx.Header("Content-Type", "application/json")
+				ctx.Header("Content-Length", fmt.Sprintf("%d", len(rbytes)))
+
+				_, err = ctx.Write(rbytes)
+				if err != nil {
+
+					if log4xx {
+						getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+					}
+
+				}
+
+				return
+
+			}
+
+		}
+
+		ctx.StatusCode(iris.StatusInternalServerError)
+		getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 500 | Can`t calculate digest of directory error | Path [%s] | %v", vhost, ip, abs, err)
+
+		if debugmode {
+
+			_, err = ctx.WriteString("[ERRO] Can`t calculate digest of directory error\n")
+			if err != nil {
+				getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+			}
+
+		}
+
+	}
+
+}
+
+// DigestTree : Merkle digest of directory, own digest and digests of child directories from radix tree are rolled up and cached until changes in directory or its subdirectories
+func DigestTree(cache *freecache.Cache, ndb *nutsdb.DB, dir string, withdate bool, filemode os.FileMode, timeout time.Duration, opentries int) ([]byte, error) {
+
+	ckey := []byte("digest:" + strconv.FormatBool(withdate) + ":" + dir)
+
+	digest, err := cache.Get(ckey)
+	if err == nil {
+		return digest, nil
+	}
+
+	seq := CacheSeq()
+
+	own, _, err := DigestOwn(ndb, dir, withdate, filemode, timeout, opentries)
+	if err != nil {
+		return nil, err
+	}
+
+	h := sha256.New()
+	h.Write(own)
+
+	for _, name := range DigestChildren(dir) {
+
+		cdigest, err := DigestTree(cache, ndb, filepath.Join(dir, name), withdate, filemode, timeout, opentries)
+		if err != nil {
+			return nil, err
+		}
+
+		h.Write([]byte(name))
+		h.Write([]byte{0})
+		h.Write(cdigest)
+
+	}
+
+	digest = h.Sum(nil)
+
+	err = CacheSet(cache, ckey, digest, 0, map[string]int{dir: -1}, seq)
+	if err != nil {
+		return nil, err
+	}
+
+	return digest, nil
+
+}
+
+// DigestChildren : sorted names of child directories of directory from radix tree
+func DigestChildren(dir string) []string {
+
+	var names []string
+
+	bdir := []byte(dir + "/")
+
+	radix.RLock()
+	stree := tree
+	radix.RUnlock()
+
+	stree.Root().WalkPrefix(bdir, func(sdir []byte, dcrc interface{}) bool {
+
+		name := string(sdir[len(bdir):])
+
+		if name != "" && !strings.Contains(name, "/") {
+			names = append(names, name)
+		}
+
+		return false
+
+	})
+
+	sort.Strings(names)
+
+	return names
+
+}
+
+// DigestOwn : digest of files and keys of directory from search index entries, CRC of keys is read from headers in Bolt archives, dates are included only if withdate is set
+func DigestOwn(ndb *nutsdb.DB, dir string, withdate bool, filemode os.FileMode, timeout time.Duration, opentries int) ([]byte, []DigestKey, error) {
+
+	keys := []DigestKey{}
+
+	nbucket := strconv.FormatUint(crc64.Checksum([]byte(dir), ctbl64), 16)
+
+	err := ndb.View(func(tx *nutsdb.Tx) error {
+
+		for _, tprefix := range []string{"f:", "b:"} {
+
+			entries, _, err := tx.PrefixScan(nbucket, []byte(tprefix), -1, -1)
+
+			if entries == nil {
+				continue
+			}
+
+			if err != nil {
+				return err
+			}
+
+			for _, entry := range entries {
+
+				var ev RawKeysData
+
+				err = binary.Read(bytes.NewReader(entry.Value), Endian, &ev)
+				if err != nil {
+					return err
+				}
+
+				dkey := DigestKey{Key: strings.TrimPrefix(string(entry.Key), tprefix), Size: ev.Size, prnt: ev.Prnt, buck: ev.Buck}
+
+				if tprefix == "b:" {
+					dkey.Type = 1
+				}
+
+				if withdate {
+					dkey.Date = ev.Date
+				}
+
+				keys = append(keys, dkey)
+
+			}
+
+		}
+
+		return nil
+
+	})
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// CRC from headers of values, one open of every Bolt archive
+
+	shards := make(map[uint32][]int)
+
+	for i, dkey := range keys {
+
+		if dkey.Type == 1 {
+			shards[dkey.prnt] = append(shards[dkey.prnt], i)
+		}
+
+	}
+
+	dbn := filepath.Base(dir)
+
+	for prnt, idxs := range shards {
+
+		dbf := filepath.Join(dir, dbn+".bolt")
+		if prnt > 0 {
+			dbf = fmt.Sprintf("%s/%s_%08d.bolt", dir, dbn, prnt)
+		}
+
+		db, err := BoltOpenRead(dbf, filemode, timeout, opentries, freelist)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		err = db.View(func(tx *bolt.Tx) error {
+
+			for _, i := range idxs {
+
+				b := tx.Bucket([]byte("wzd" + strconv.Itoa(int(keys[i].buck))))
+				if b == nil {
+					return fmt.Errorf("bucket wzd%d not exists in db %s", keys[i].buck, dbf)
+				}
+
+				pheader := b.GetLimit([]byte(keys[i].Key), uint32(36))
+				if len(pheader) < 36 {
+					continue
+				}
+
+				var readhead Header
+
+				err := binary.Read(bytes.NewReader(pheader[:36]), Endian, &readhead)
+				if err != nil {
+					return err
+				}
+
+				keys[i].Crc = readhead.Crcs
+
+			}
+
+			return nil
+
+		})
+
+		db.Close()
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+
+		if keys[i].Type != keys[j].Type {
+			return keys[i].Type < keys[j].Type
+		}
+
+		return keys[i].Key < keys[j].Key
+
+	})
+
+	h := sha256.New()
+
+	lbuf := make([]byte, 20)
+
+	for _, dkey := range keys {
+
+		h.Write([]byte{byte(dkey.Type)})
+		h.Write([]byte(dkey.Key))
+		h.Write([]byte{0})
+
+		binary.BigEndian.PutUint64(lbuf[0:8], dkey.Size)
+		binary.BigEndian.PutUint64(lbuf[8:16], dkey.Date)
+		binary.BigEndian.PutUint32(lbuf[16:20], dkey.Crc)
+
+		h.Write(lbuf)
+
+	}
+
+	return h.Sum(nil), keys, nil
+
+}
@@ -0,0 +1,868 @@
+/*
+
+Copyright © 2020 Andrey Kuvshinov. Contacts: <syslinux@protonmail.com>
+Copyright © 2020 Eltaline OU. Contacts: <eltaline.ou@gmail.com>
+All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+The wZD project contains unmodified/modified libraries imports too with
+separate copyright notices and license terms. Your use of the source code
+this libraries is subject to the terms and conditions of licenses these libraries.
+
+*/
+
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/eltaline/nutsdb"
+	"github.com/kataras/iris/v12"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Feed Handlers
+
+const feedbatch = 1024
+const feedlimit = 1000
+const feedmaxlimit = 100000
+
+var errFeedGone = errors.New("change feed sequence is already removed by retention")
+
+// ZDFeed : GET method with Feed header, change feed events of virtual host from requested sequence number as JSON with long polling or as server-sent events, replication state of replicas if Replication header is set
+func ZDFeed(wg *sync.WaitGroup) iris.Handler {
+	return func(ctx iris.Context) {
+		defer wg.Done()
+
+		var err error
+
+		// Wait Group
+
+		wg.Add(1)
+
+		// Loggers
+
+		getLogger, getlogfile := GetLogger()
+		defer getlogfile.Close()
+
+		// Shutdown
+
+		if shutdown {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			return
+		}
+
+		// Headers
+
+		ip := ctx.RemoteAddr()
+		cip := net.ParseIP(ip)
+		vhost := strings.Split(ctx.Host(), ":")[0]
+
+		method := ctx.Method()
+
+		hfeed := ctx.GetHeader("Feed")
+		hlimit := ctx.GetHeader("Limit")
+		hwait := ctx.GetHeader("Wait")
+		haccept := ctx.GetHeader("Accept")
+		hlastid := ctx.GetHeader("Last-Event-ID")
+		hreplication := ctx.GetHeader("Replication")
+
+		badhost := true
+		badip := true
+
+		log4xx := true
+
+		for _, Server := range config.Server {
+
+			if vhost == Server.HOST {
+
+				badhost = false
+
+				for _, Vhost := range AllowGet() {
+
+					if vhost == Vhost.Vhost {
+
+						for _, CIDR := range Vhost.CIDR {
+							_, ipnet, _ := net.ParseCIDR(CIDR.Addr)
+							if ipnet.Contains(cip) {
+								badip = false
+								break
+							}
+						}
+
+						break
+
+					}
+
+				}
+
+				log4xx = Server.LOG4XX
+
+				break
+
+			}
+
+		}
+
+		if badhost {
+
+			ctx.StatusCode(iris.StatusMisdirectedRequest)
+
+			if log4xx {
+				getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 421 | Not found configured virtual host", vhost, ip)
+			}
+
+			if debugmode {
+
+				_, err = ctx.Writef("[ERRO] Not found configured virtual host | Virtual Host [%s]\n", vhost)
+				if err != nil {
+					getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+				}
+
+			}
+
+			return
+
+		}
+
+		if badip {
+
+			ctx.StatusCode(iris.StatusForbidden)
+
+			if log4xx {
+				getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 403 | Forbidden", vhost, ip)
+			}
+
+			if debugmode {
+
+				_, err = ctx.Writef("[ERRO] Not found allowed ip | Virtual Host [%s]\n", vhost)
+				if err != nil {
+					getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+				}
+
+			}
+
+			return
+
+		}
+
+		flog := FeedHost(vhost)
+
+		if flog == nil {
+
+			ctx.StatusCode(iris.StatusForbidden)
+
+			if log4xx {
+				getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 403 | The change feed is not allowed during GET request", vhost, ip)
+			}
+
+			if debugmode {
+
+				_, err = ctx.WriteString("[ERRO] The change feed is not allowed during GET request\n")
+				if err != nil {
+					getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+				}
+
+			}
+
+			return
+
+		}
+
+		if method != "GET" {
+
+			ctx.StatusCode(iris.StatusMethodNotAllowed)
+
+			if log4xx {
+				getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 405 | The change feed is allowed only with GET method | Method [%s]", vhost, ip, method)
+			}
+
+			if debugmode {
+
+				_, err = ctx.WriteString("[ERRO] The change feed is allowed only with GET method\n")
+				if err != nil {
+					getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+				}
+
+			}
+
+			return
+
+		}
+
+		if hreplication == "1" {
+
+			rbytes, _ := json.Marshal(RepStatus(vhost))
+
+			ctx.Header("Content-Type", "application/json")
+			ctx.Header("Content-Length", fmt.Sprintf("%d", len(rbytes)))
+
+			_, err = ctx.Write(rbytes)
+			if err != nil {
+
+				if log4xx {
+					getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+				}
+
+			}
+
+			return
+
+		}
+
+		from, err := strconv.ParseUint(hfeed, 10, 64)
+		if err == nil && hlastid != "" {
+
+			var lastid uint64
+
+			lastid, err = strconv.ParseUint(hlastid, 10, 64)
+			from = lastid + 1
+
+		}
+
+		if err != nil {
+
+			ctx.StatusCode(iris.StatusBadRequest)
+
+			if log4xx {
+				getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 400 | Feed and Last-Event-ID headers must be a non-negative number during GET feed request | Feed [%s] | Last-Event-ID [%s]", vhost, ip, hfeed, hlastid)
+			}
+
+			if debugmode {
+
+				_, err = ctx.WriteString("[ERRO] Feed and Last-Event-ID headers must be a non-negative number during GET feed request\n")
+				if err != nil {
+					getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+				}
+
+			}
+
+			return
+
+		}
+
+		limit := feedlimit
+
+		if hlimit != "" {
+
+			limit, err = strconv.Atoi(hlimit)
+			if err != nil || limit < 1 || limit > feedmaxlimit {
+
+				ctx.StatusCode(iris.StatusBadRequest)
+
+				if log4xx {
+					getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 400 | Limit header must be from 1 to %d during GET feed request | Limit [%s]", vhost, ip, feedmaxlimit, hlimit)
+				}
+
+				if debugmode {
+
+					_, err = ctx.Writef("[ERRO] Limit header must be from 1 to %d during GET feed request\n", feedmaxlimit)
+					if err != nil {
+						getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+					}
+
+				}
+
+				return
+
+			}
+
+		}
+
+		wait := time.Duration(0)
+
+		if hwait != "" {
+
+			swait, err := strconv.Atoi(hwait)
+			if err != nil || swait < 0 {
+
+				ctx.StatusCode(iris.StatusBadRequest)
+
+				if log4xx {
+					getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 400 | Wait header must be a non-negative number of seconds during GET feed request | Wait [%s]", vhost, ip, hwait)
+				}
+
+				if debugmode {
+
+					_, err = ctx.WriteString("[ERRO] Wait header must be a non-negative number of seconds during GET feed request\n")
+					if err != nil {
+						getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+					}
+
+				}
+
+				return
+
+			}
+
+			wait = time.Duration(swait) * time.Second
+
+			if wait > feedwait {
+				wait = feedwait
+			}
+
+		}
+
+		flog.Lock()
+		first := flog.First
+		flog.Unlock()
+
+		if from == 0 {
+			from = first
+		}
+
+		if from < first {
+
+			ctx.StatusCode(iris.StatusGone)
+			ctx.Header("Feed-First", strconv.FormatUint(first, 10))
+
+			if log4xx {
+				getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 410 | Requested change feed sequence is already removed by retention | Feed [%d] | First [%d]", vhost, ip, from, first)
+			}
+
+			if debugmode {
+
+				_, err = ctx.WriteString("[ERRO] Requested change feed sequence is already removed by retention\n")
+				if err != nil {
+					getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+				}
+
+			}
+
+			return
+
+		}
+
+		done := ctx.Request().Context().Done()
+
+		if !strings.Contains(haccept, "text/event-stream") {
+
+			flist, err := FeedWait(flog, from, limit, wait, done)
+			if err != nil {
+
+				ctx.StatusCode(iris.StatusInternalServerError)
+				getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 500 | Can`t read change feed error | Feed [%d] | %v", vhost, ip, from, err)
+
+				if debugmode {
+
+					_, err = ctx.WriteString("[ERRO] Can`t read change feed error\n")
+					if err != nil {
+						getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+					}
+
+				}
+
+				return
+
+			}
+
+			rbytes, _ := json.Marshal(flist)
+
+			ctx.Header("Content-Type", "application/json")
+			ctx.Header("Content-Length", fmt.Sprintf("%d", len(rbytes)))
+
+			_, err = ctx.Write(rbytes)
+			if err != nil {
+
+				if log4xx {
+					getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+				}
+
+			}
+
+			return
+
+		}
+
+		// Server-Sent Events
+
+		ctx.Header("Content-Type", "text/event-stream")
+		ctx.Header("Cache-Control", "no-cache")
+		ctx.Header("X-Accel-Buffering", "no")
+		ctx.StatusCode(iris.StatusOK)
+
+		for !shutdown {
+
+			flist, err := FeedWait(flog, from, limit, feedwait, done)
+			if err != nil {
+				getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 599 | Change feed stream is closed | Feed [%d] | %v", vhost, ip, from, err)
+				return
+			}
+
+			if ctx.Request().Context().Err() != nil {
+				return
+			}
+
+			switch {
+			case len(flist.Events) == 0:
+				_, err = ctx.WriteString(": ping\n\n")
+			default:
+
+				for _, ev := range flist.Events {
+
+					ebytes, _ := json.Marshal(ev)
+
+					_, err = ctx.Writef("id: %d\nevent: %s\ndata: %s\n\n", ev.Seq, ev.Op, ebytes)
+					if err != nil {
+						break
+					}
+
+				}
+
+			}
+
+			if err != nil {
+
+				if log4xx {
+					getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+				}
+
+				return
+
+			}
+
+			ctx.ResponseWriter().Flush()
+
+			from = flist.Next
+
+		}
+
+	}
+
+}
+
+// FeedOpen : open change feed db and load sequence numbers of virtual hosts with enabled change feed, db is not opened without such virtual hosts
+func FeedOpen() (*nutsdb.DB, error) {
+
+	var hosts []string
+
+	for _, Server := range config.Server {
+
+		if Server.FEED {
+			hosts = append(hosts, Server.HOST)
+		}
+
+	}
+
+	if len(hosts) == 0 {
+		return nil, nil
+	}
+
+	if !DirExists(feeddir) {
+
+		err := os.MkdirAll(feeddir, 0700)
+		if err != nil {
+			return nil, err
+		}
+
+	}
+
+	fopt := nutsdb.DefaultOptions
+	fopt.Dir = feeddir
+	fopt.EntryIdxMode = nutsdb.HintKeyAndRAMIdxMode
+	fopt.SegmentSize = 67108864
+	fopt.NodeNum = 1
+	fopt.StartFileLoadingMode = nutsdb.MMap
+
+	fopt.RWMode = nutsdb.FileIO
+	fopt.SyncEnable = true
+
+	fdb, err := nutsdb.Open(fopt)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, host := range hosts {
+
+		flog := &FeedLog{DB: fdb, Host: host, First: 1, Wake: make(chan struct{})}
+
+		_ = fdb.View(func(tx *nutsdb.Tx) error {
+
+			entry, err := tx.Get(feedbucket, []byte("s:"+host))
+			if err == nil && len(entry.Value) == 8 {
+				flog.Seq = binary.BigEndian.Uint64(entry.Value)
+			}
+
+			entry, err = tx.Get(feedbucket, []byte("f:"+host))
+			if err == nil && len(entry.Value) == 8 {
+				flog.First = binary.BigEndian.Uint64(entry.Value)
+			}
+
+			return nil
+
+		})
+
+		fhosts[host] = flog
+
+	}
+
+	return fdb, nil
+
+}
+
+// FeedHost : change feed of virtual host, nil if change feed is disabled for virtual host
+func FeedHost(vhost string) *FeedLog {
+
+	flog, ok := fhosts[vhost]
+	if !ok {
+		return nil
+	}
+
+	return flog
+
+}
+
+// FeedBucket : change feed db bucket of virtual host events
+func FeedBucket(vhost string) string {
+	return "e:" + vhost
+}
+
+// FeedKey : change feed db key of sequence number, big endian keeps events ordered in db index
+func FeedKey(seq uint64) []byte {
+
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, seq)
+
+	return key
+
+}
+
+// FeedPath : path of file, key, archive or directory relative to root directory of virtual host
+func FeedPath(base string, path string) string {
+
+	rpath := strings.TrimPrefix(path, base)
+	if rpath == "" {
+		rpath = "/"
+	}
+
+	return rpath
+
+}
+
+// FeedOp : change feed operation of upload by existence of file or key before upload
+func FeedOp(exists bool) string {
+
+	if exists {
+		return "update"
+	}
+
+	return "create"
+
+}
+
+// FeedAppend : append event to change feed of virtual host with next sequence number and enqueue it to webhooks of virtual host
+func FeedAppend(vhost string, ev FeedEvent) error {
+
+	var err error
+
+	ev.Host = vhost
+	ev.Date = uint64(time.Now().Unix())
+
+	flog := FeedHost(vhost)
+	if flog != nil {
+		ev.Seq, err = FeedPut(flog, ev)
+	}
+
+	herr := HookAppend(vhost, ev)
+	if err != nil {
+		return err
+	}
+
+	return herr
+
+}
+
+// FeedPut : write event to change feed with next sequence number and wake all waiting consumers
+func FeedPut(flog *FeedLog, ev FeedEvent) (uint64, error) {
+
+	flog.Lock()
+	defer flog.Unlock()
+
+	seq := flog.Seq + 1
+
+	ev.Seq = seq
+
+	eval, err := json.Marshal(ev)
+	if err != nil {
+		return 0, err
+	}
+
+	key := FeedKey(seq)
+
+	err = flog.DB.Update(func(tx *nutsdb.Tx) error {
+
+		err := tx.Put(FeedBucket(flog.Host), key, eval, 0)
+		if err != nil {
+			return err
+		}
+
+		return tx.Put(feedbucket, []byte("s:"+flog.Host), key, 0)
+
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	flog.Seq = seq
+
+	close(flog.Wake)
+	flog.Wake = make(chan struct{})
+
+	return seq, nil
+
+}
+
+// FeedCompact : append compaction event of archive to change feed and webhooks of all virtual hosts with root directory including archive
+func FeedCompact(dbf string) error {
+
+	var ferr error
+
+	for _, Server := range config.Server {
+
+		if !Server.FEED && len(Server.WEBHOOK) == 0 {
+			continue
+		}
+
+		base := filepath.Clean(Server.ROOT)
+
+		if !strings.HasPrefix(dbf, base+"/") {
+			continue
+		}
+
+		var size uint64
+
+		infile, err := os.Stat(dbf)
+		if err == nil {
+			size = uint64(infile.Size())
+		}
+
+		err = FeedAppend(Server.HOST, FeedEvent{Path: FeedPath(base, filepath.Dir(dbf)), Archive: FeedPath(base, dbf), Type: 1, Size: size, Op: "compact"})
+		if err != nil {
+			ferr = err
+		}
+
+	}
+
+	return ferr
+
+}
+
+// FeedRead : change feed events from sequence number up to limit, not greater than last sequence number
+func FeedRead(flog *FeedLog, from uint64, last uint64, limit int) ([]FeedEvent, error) {
+
+	var events []FeedEvent
+
+	end := from + uint64(limit) - 1
+	if end > last {
+		end = last
+	}
+
+	err := flog.DB.View(func(tx *nutsdb.Tx) error {
+
+		entries, err := tx.RangeScan(FeedBucket(flog.Host), FeedKey(from), FeedKey(end))
+
+		if entries == nil {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		for _, entry := range entries {
+
+			var ev FeedEvent
+
+			err = json.Unmarshal(entry.Value, &ev)
+			if err != nil {
+				return err
+			}
+
+			events = append(events, ev)
+
+		}
+
+		return nil
+
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(events, func(i, j int) bool { return events[i].Seq < events[j].Seq })
+
+	return events, nil
+
+}
+
+// FeedWait : change feed events from sequence number, waits for new events up to wait duration if there are no events yet
+func FeedWait(flog *FeedLog, from uint64, limit int, wait time.Duration, done <-chan struct{}) (FeedList, error) {
+
+	var deadline <-chan time.Time
+
+	if wait > 0 {
+
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+
+		deadline = timer.C
+
+	}
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+
+		flog.Lock()
+		seq := flog.Seq
+		first := flog.First
+		wake := flog.Wake
+		flog.Unlock()
+
+		flist := FeedList{First: first, Last: seq, Next: from, Events: []FeedEvent{}}
+
+		if from < first {
+			return flist, errFeedGone
+		}
+
+		if from <= seq {
+
+			events, err := FeedRead(flog, from, seq, limit)
+			if err != nil {
+				return flist, err
+			}
+
+			if len(events) > 0 {
+				flist.Events = events
+				flist.Next = events[len(events)-1].Seq + 1
+			}
+
+			return flist, nil
+
+		}
+
+		if deadline == nil {
+			return flist, nil
+		}
+
+		select {
+		case <-wake:
+		case <-deadline:
+			return flist, nil
+		case <-done:
+			return flist, nil
+		case <-ticker.C:
+			if shutdown {
+				return flist, nil
+			}
+		}
+
+	}
+
+}
+
+// FeedTrim : delete change feed events older than retention time of all virtual hosts
+func FeedTrim() {
+
+	// Loggers
+
+	appLogger, applogfile := AppLogger()
+	defer applogfile.Close()
+
+	if feedretention <= 0 {
+		return
+	}
+
+	past := uint64(time.Now().Add(-feedretention).Unix())
+
+	for _, flog := range fhosts {
+
+		for !shutdown {
+
+			flog.Lock()
+			seq := flog.Seq
+			first := flog.First
+			flog.Unlock()
+
+			if first > seq {
+				break
+			}
+
+			events, err := FeedRead(flog, first, seq, feedbatch)
+			if err != nil {
+				appLogger.Errorf("| Host [%s] | Can`t read change feed for retention error | Feed [%d] | %v", flog.Host, first, err)
+				break
+			}
+
+			nfirst := first
+
+			for _, ev := range events {
+
+				if ev.Date >= past {
+					break
+				}
+
+				nfirst = ev.Seq + 1
+
+			}
+
+			if len(events) == 0 {
+				nfirst = first + feedbatch
+				if nfirst > seq+1 {
+					nfirst = seq + 1
+				}
+			}
+
+			if nfirst == first {
+				break
+			}
+
+			err = flog.DB.Update(func(tx *nutsdb.Tx) error {
+
+				for dseq := first; dseq < nfirst; dseq++ {
+					_ = tx.Delete(FeedBucket(flog.Host), FeedKey(dseq))
+				}
+
+				return tx.Put(feedbucket, []byte("f:"+flog.Host), FeedKey(nfirst), 0)
+
+			})
+
+			if err != nil {
+				appLogger.Errorf("| Host [%s] | Can`t delete change feed events by retention error | Feed [%d] | %v", flog.Host, first, err)
+				break
+			}
+
+			flog.Lock()
+			flog.First = nfirst
+			flog.Unlock()
+
+			appLogger.Warnf("| Host [%s] | Change feed events deleted by retention | From [%d] | To [%d]", flog.Host, first, nfirst-1)
+
+		}
+
+	}
+
+}
@@ -0,0 +1,469 @@
+/*
+
+Copyright © 2020 Andrey Kuvshinov. Contacts: <syslinux@protonmail.com>
+Copyright © 2020 Eltaline OU. Contacts: <eltaline.ou@gmail.com>
+All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+The wZD project contains unmodified/modified libraries imports too with
+separate copyright notices and license terms. Your use of the source code
+this libraries is subject to the terms and conditions of licenses these libraries.
+
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"github.com/eltaline/bolt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Fsck Subcommand
+
+var rgxdatabucket = regexp.MustCompile(`^wzd([0-9]+)$`)
+
+// Fsck : offline check of bolt archives in files and directories, with --repair rebuilds index, size, time and count buckets from data buckets and moves unrecoverable keys to lost+found bucket, exit codes are 0 for clean, 1 for repaired, 4 for not repaired errors, 8 for operational errors and 16 for usage errors
+func Fsck(args []string) int {
+
+	fs := flag.NewFlagSet("fsck", flag.ContinueOnError)
+
+	repair := fs.Bool("repair", false, "--repair - rebuild auxiliary buckets and move unrecoverable keys to lost+found bucket")
+	lostfound := fs.String("lostfound", "lost+found", "--lostfound=lost+found - bucket for unrecoverable keys")
+	fs.StringVar(&freelist, "freelist", freelist, "--freelist=hashmap - freelist type of bolt archives, hashmap or array")
+
+	fs.Usage = func() {
+		fmt.Printf("Usage: wzd fsck [--repair] [--lostfound=lost+found] [--freelist=hashmap] path ...\n")
+		fs.PrintDefaults()
+	}
+
+	err := fs.Parse(args)
+	if err != nil || fs.NArg() == 0 || rgxdatabucket.MatchString(*lostfound) || *lostfound == "" {
+		fs.Usage()
+		return 16
+	}
+
+	return FsckWalk(fs.Args(), *repair, *lostfound)
+
+}
+
+// FsckWalk : check of bolt archives in files and directories with exit code of fsck
+func FsckWalk(roots []string, repair bool, lostfound string) int {
+
+	code := 0
+
+	for _, root := range roots {
+
+		err := filepath.Walk(filepath.Clean(root), func(path string, info os.FileInfo, err error) error {
+
+			if err != nil {
+				return err
+			}
+
+			if !info.Mode().IsRegular() || !rgxbolt.MatchString(path) {
+				return nil
+			}
+
+			fst, err := FsckArchive(path, repair, lostfound, FsckPrint)
+			if err != nil {
+				fmt.Printf("| Archive [%s] | Can`t check archive error | %v\n", path, err)
+				code |= 8
+				return nil
+			}
+
+			switch {
+			case fst.Errors == 0:
+				fmt.Printf("| Archive [%s] | Keys [%d] | OK\n", path, fst.Keys)
+			case fst.Repaired > 0:
+				fmt.Printf("| Archive [%s] | Keys [%d] | Errors [%d] | Lost [%d] | REPAIRED\n", path, fst.Keys, fst.Errors, fst.Lost)
+				code |= 1
+			default:
+				fmt.Printf("| Archive [%s] | Keys [%d] | Errors [%d] | Lost [%d] | NOT REPAIRED\n", path, fst.Keys, fst.Errors, fst.Lost)
+				code |= 4
+			}
+
+			return nil
+
+		})
+
+		if err != nil {
+			fmt.Printf("| Path [%s] | Can`t walk path error | %v\n", root, err)
+			code |= 8
+		}
+
+	}
+
+	return code
+
+}
+
+// FsckPrint : print of one error of archive check
+func FsckPrint(dbf string, key string, msg string) {
+	fmt.Printf("| Archive [%s] | Key [%s] | %s\n", dbf, key, msg)
+}
+
+// FsckArchive : check of one bolt archive, index entries must point to existing keys, every key must have size and time, header size must match data length, crc must verify, counter must match last data bucket
+func FsckArchive(dbf string, repair bool, lostfound string, report func(dbf string, key string, msg string)) (FsckStats, error) {
+
+	var fst FsckStats
+
+	var err error
+
+	timeout := time.Duration(5) * time.Second
+
+	copies := make(map[string][]FsckCopy)
+	index := make(map[string]string)
+	sizes := make(map[string][]byte)
+	times := make(map[string][]byte)
+
+	var counter uint64
+	var maxbucket uint64
+
+	hascount := false
+
+	bad := func(key string, format string, a ...interface{}) {
+		fst.Errors++
+		report(dbf, key, fmt.Sprintf(format, a...))
+	}
+
+	db, err := BoltOpenRead(dbf, os.FileMode(0640), timeout, 1, freelist)
+	if err != nil {
+		return fst, err
+	}
+
+	err = db.View(func(tx *bolt.Tx) error {
+
+		return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+
+			sname := string(name)
+
+			switch sname {
+			case "index":
+				return b.ForEach(func(k, v []byte) error {
+					index[string(k)] = string(v)
+					return nil
+				})
+			case "size":
+				return b.ForEach(func(k, v []byte) error {
+					sizes[string(k)] = append([]byte(nil), v...)
+					return nil
+				})
+			case "time":
+				return b.ForEach(func(k, v []byte) error {
+					times[string(k)] = append([]byte(nil), v...)
+					return nil
+				})
+			case "count":
+				v := b.Get([]byte("counter"))
+				if len(v) == 8 {
+					counter = Endian.Uint64(v)
+					hascount = true
+				}
+				return nil
+			}
+
+			mch := rgxdatabucket.FindStringSubmatch(sname)
+			if mch == nil {
+				return nil
+			}
+
+			nbucket, _ := strconv.ParseUint(mch[1], 10, 64)
+			if nbucket > maxbucket {
+				maxbucket = nbucket
+			}
+
+			return b.ForEach(func(k, v []byte) error {
+
+				fc := FsckCopy{Bucket: sname}
+
+				var head Header
+
+				switch {
+				case len(v) < 36:
+					fc.Broken = "header is truncated"
+				case binary.Read(bytes.NewReader(v[:36]), Endian, &head) != nil:
+					fc.Broken = "header is unreadable"
+				case head.Size != uint64(len(v)-36):
+					fc.Broken = fmt.Sprintf("header size %d does not match data length %d", head.Size, len(v)-36)
+				case head.Crcs != 0 && crc32.Checksum(v[36:], ctbl32) != head.Crcs:
+					fc.Broken = "crc does not verify"
+				}
+
+				fc.Size = head.Size
+				fc.Date = head.Date
+
+				copies[string(k)] = append(copies[string(k)], fc)
+
+				return nil
+
+			})
+
+		})
+
+	})
+
+	db.Close()
+
+	if err != nil {
+		return fst, err
+	}
+
+	// Keys which stay in data buckets and copies which are moved to lost+found
+
+	keep := make(map[string]FsckCopy)
+
+	var lost []FsckCopy
+	var lostkeys []string
+
+	for key, kcopies := range copies {
+
+		best := -1
+
+		for i, fc := range kcopies {
+
+			if fc.Broken != "" {
+				bad(key, "Bucket [%s] | Unrecoverable key | %s", fc.Bucket, fc.Broken)
+				continue
+			}
+
+			switch {
+			case best < 0:
+				best = i
+			case fc.Bucket == index[key] && kcopies[best].Bucket != index[key]:
+				best = i
+			case kcopies[best].Bucket != index[key] && fc.Date > kcopies[best].Date:
+				best = i
+			}
+
+		}
+
+		if len(kcopies) > 1 {
+			bad(key, "Key exists in %d data buckets", len(kcopies))
+		}
+
+		for i, fc := range kcopies {
+
+			if i == best {
+				keep[key] = fc
+				continue
+			}
+
+			lost = append(lost, fc)
+			lostkeys = append(lostkeys, key)
+
+		}
+
+	}
+
+	fst.Keys = uint64(len(keep))
+	fst.Lost = uint64(len(lost))
+
+	for key, fc := range keep {
+
+		ibucket, ok := index[key]
+
+		switch {
+		case !ok:
+			bad(key, "Index entry is missing")
+		case ibucket != fc.Bucket:
+			bad(key, "Index entry points to bucket [%s] instead of [%s]", ibucket, fc.Bucket)
+		}
+
+		sb, ok := sizes[key]
+
+		switch {
+		case !ok:
+			bad(key, "Size entry is missing")
+		case len(sb) != 8 || Endian.Uint64(sb) != fc.Size:
+			bad(key, "Size entry does not match header size %d", fc.Size)
+		}
+
+		tb, ok := times[key]
+		if !ok || len(tb) != 8 {
+			bad(key, "Time entry is missing or malformed")
+		}
+
+	}
+
+	for key, ibucket := range index {
+
+		if _, ok := keep[key]; !ok {
+			bad(key, "Index entry points to not existing key in bucket [%s]", ibucket)
+		}
+
+	}
+
+	for key := range sizes {
+
+		if _, ok := keep[key]; !ok {
+			bad(key, "Size entry of not existing key")
+		}
+
+	}
+
+	for key := range times {
+
+		if _, ok := keep[key]; !ok {
+			bad(key, "Time entry of not existing key")
+		}
+
+	}
+
+	switch {
+	case !hascount:
+		bad("counter", "Count bucket or counter is missing")
+	case counter != maxbucket && maxbucket > 0:
+		bad("counter", "Counter %d does not match last data bucket wzd%d", counter, maxbucket)
+	}
+
+	if fst.Errors == 0 || !repair {
+		return fst, nil
+	}
+
+	// Repair
+
+	if maxbucket == 0 {
+		maxbucket = 1
+	}
+
+	db, err = BoltOpenWrite(dbf, os.FileMode(0640), timeout, 1, freelist)
+	if err != nil {
+		return fst, err
+	}
+
+	defer db.Close()
+
+	err = db.Update(func(tx *bolt.Tx) error {
+
+		if len(lost) > 0 {
+
+			lb, err := tx.CreateBucketIfNotExists([]byte(lostfound))
+			if err != nil {
+				return err
+			}
+
+			for i, fc := range lost {
+
+				b := tx.Bucket([]byte(fc.Bucket))
+
+				val := append([]byte(nil), b.Get([]byte(lostkeys[i]))...)
+
+				err = lb.Put([]byte(fc.Bucket+"/"+lostkeys[i]), val)
+				if err != nil {
+					return err
+				}
+
+				err = b.Delete([]byte(lostkeys[i]))
+				if err != nil {
+					return err
+				}
+
+			}
+
+		}
+
+		for _, name := range []string{"index", "size", "time"} {
+
+			err := tx.DeleteBucket([]byte(name))
+			if err != nil && err != bolt.ErrBucketNotFound {
+				return err
+			}
+
+		}
+
+		ib, err := tx.CreateBucket([]byte("index"))
+		if err != nil {
+			return err
+		}
+
+		sb, err := tx.CreateBucket([]byte("size"))
+		if err != nil {
+			return err
+		}
+
+		tb, err := tx.CreateBucket([]byte("time"))
+		if err != nil {
+			return err
+		}
+
+		keys := make([]string, 0, len(keep))
+
+		for key := range keep {
+			keys = append(keys, key)
+		}
+
+		sort.Strings(keys)
+
+		for _, key := range keys {
+
+			fc := keep[key]
+
+			sv := make([]byte, 8)
+			Endian.PutUint64(sv, fc.Size)
+
+			tv := make([]byte, 8)
+			Endian.PutUint64(tv, fc.Date)
+
+			err = ib.Put([]byte(key), []byte(fc.Bucket))
+			if err != nil {
+				return err
+			}
+
+			err = sb.Put([]byte(key), sv)
+			if err != nil {
+				return err
+			}
+
+			err = tb.Put([]byte(key), tv)
+			if err != nil {
+				return err
+			}
+
+		}
+
+		_, err = tx.CreateBucketIfNotExists([]byte(fmt.Sprintf("wzd%d", maxbucket)))
+		if err != nil {
+			return err
+		}
+
+		cb, err := tx.CreateBucketIfNotExists([]byte("count"))
+		if err != nil {
+			return err
+		}
+
+		nb := make([]byte, 8)
+		Endian.PutUint64(nb, maxbucket)
+
+		return cb.Put([]byte("counter"), nb)
+
+	})
+
+	if err != nil {
+		return fst, err
+	}
+
+	fst.Repaired = fst.Errors
+
+	return fst, nil
+
+}
@@ -0,0 +1,333 @@
+/*
+
+Copyright © 2020 Andrey Kuvshinov. Contacts: <syslinux@protonmail.com>
+Copyright © 2020 Eltaline OU. Contacts: <eltaline.ou@gmail.com>
+All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+The wZD project contains unmodified/modified libraries imports too with
+separate copyright notices and license terms. Your use of the source code
+this libraries is subject to the terms and conditions of licenses these libraries.
+
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/eltaline/nutsdb"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Full-Text Search Helpers
+
+var rgxfttags = regexp.MustCompile(`(?is)<script.*?</script>|<style.*?</style>|<[^>]*>`)
+
+// FTRegistry : tokenization options of each virtual host with enabled full-text search
+func FTRegistry() {
+
+	for _, Server := range config.Server {
+
+		if !Server.FULLTEXT {
+			continue
+		}
+
+		ftopts := &FTOptions{MinLen: 2, MaxLen: 32, MaxSize: Server.FTMAXSIZE, Stop: make(map[string]bool)}
+
+		if Server.FTMINLEN > 0 {
+			ftopts.MinLen = Server.FTMINLEN
+		}
+
+		if Server.FTMAXLEN > 0 {
+			ftopts.MaxLen = Server.FTMAXLEN
+		}
+
+		for _, word := range strings.Split(Server.FTSTOPWORDS, ",") {
+
+			word = strings.ToLower(strings.TrimSpace(word))
+
+			if word != "" {
+				ftopts.Stop[word] = true
+			}
+
+		}
+
+		fthosts[Server.HOST] = ftopts
+
+	}
+
+}
+
+// FTHost : tokenization options of virtual host, nil if full-text search is disabled for virtual host
+func FTHost(vhost string) *FTOptions {
+	return fthosts[vhost]
+}
+
+// FTBucket : full-text search bucket of directory by search bucket of directory
+func FTBucket(nbucket string) string {
+	return "t:" + nbucket
+}
+
+// FTWords : lowercase words of text, words are sequences of unicode letters and digits
+func FTWords(text string) []string {
+
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+}
+
+// FTAllowed : check word length and stop words
+func FTAllowed(word string, ftopts *FTOptions) bool {
+
+	wlen := utf8.RuneCountInString(word)
+
+	return wlen >= ftopts.MinLen && wlen <= ftopts.MaxLen && !ftopts.Stop[word]
+
+}
+
+// FTTokens : sorted unique tokens of text-like value, nil for binary value
+func FTTokens(value []byte, ftopts *FTOptions) []string {
+
+	html := false
+
+	ctype := http.DetectContentType(value)
+
+	switch {
+	case strings.HasPrefix(ctype, "text/html"), strings.HasPrefix(ctype, "text/xml"):
+		html = true
+	case strings.HasPrefix(ctype, "text/"):
+	case json.Valid(value):
+	default:
+		return nil
+	}
+
+	if ftopts.MaxSize > 0 && int64(len(value)) > ftopts.MaxSize {
+		value = value[:ftopts.MaxSize]
+	}
+
+	text := string(value)
+
+	if html {
+		text = rgxfttags.ReplaceAllString(text, " ")
+	}
+
+	uniq := make(map[string]bool)
+
+	for _, word := range FTWords(text) {
+
+		if FTAllowed(word, ftopts) {
+			uniq[word] = true
+		}
+
+	}
+
+	tokens := make([]string, 0, len(uniq))
+	for token := range uniq {
+		tokens = append(tokens, token)
+	}
+
+	sort.Strings(tokens)
+
+	return tokens
+
+}
+
+// FTQuery : tokens of full-text query, word with trailing asterisk is searched as prefix of tokens
+func FTQuery(query string, ftopts *FTOptions) []string {
+
+	var qtokens []string
+
+	uniq := make(map[string]bool)
+
+	for _, field := range strings.Fields(query) {
+
+		wildcard := strings.HasSuffix(field, "*")
+
+		words := FTWords(strings.TrimSuffix(field, "*"))
+
+		for i, word := range words {
+
+			qtoken := word
+
+			switch {
+			case wildcard && i == len(words)-1:
+				qtoken = word + "*"
+			case !FTAllowed(word, ftopts):
+				continue
+			}
+
+			if !uniq[qtoken] {
+				uniq[qtoken] = true
+				qtokens = append(qtokens, qtoken)
+			}
+
+		}
+
+	}
+
+	return qtokens
+
+}
+
+// FTIndex : replace tokens of archive key in full-text search db, binary value only removes old tokens
+func FTIndex(ndb *nutsdb.DB, nbucket string, key string, value []byte, ftopts *FTOptions) error {
+
+	tbucket := FTBucket(nbucket)
+
+	tokens := FTTokens(value, ftopts)
+
+	return ndb.Update(func(tx *nutsdb.Tx) error {
+
+		err := FTDeleteTx(tx, tbucket, key)
+		if err != nil {
+			return err
+		}
+
+		if len(tokens) == 0 {
+			return nil
+		}
+
+		for _, token := range tokens {
+
+			err = tx.Put(tbucket, []byte("w:"+token+"\x00"+key), []byte{1}, 0)
+			if err != nil {
+				return err
+			}
+
+		}
+
+		return tx.Put(tbucket, []byte("k:"+key), []byte(strings.Join(tokens, "\x00")), 0)
+
+	})
+
+}
+
+// FTDelete : delete tokens of archive key from full-text search db
+func FTDelete(ndb *nutsdb.DB, nbucket string, key string) error {
+
+	return ndb.Update(func(tx *nutsdb.Tx) error {
+		return FTDeleteTx(tx, FTBucket(nbucket), key)
+	})
+
+}
+
+// FTDeleteTx : delete tokens of archive key inside search db transaction
+func FTDeleteTx(tx *nutsdb.Tx, tbucket string, key string) error {
+
+	entry, err := tx.Get(tbucket, []byte("k:"+key))
+	if err != nil || entry == nil {
+		return nil
+	}
+
+	for _, token := range strings.Split(string(entry.Value), "\x00") {
+
+		err = tx.Delete(tbucket, []byte("w:"+token+"\x00"+key))
+		if err != nil {
+			return err
+		}
+
+	}
+
+	return tx.Delete(tbucket, []byte("k:"+key))
+
+}
+
+// FTDeleteDirTx : delete all tokens of directory inside search db transaction
+func FTDeleteDirTx(tx *nutsdb.Tx, nbucket string) error {
+
+	tbucket := FTBucket(nbucket)
+
+	entries, err := tx.GetAll(tbucket)
+
+	if entries == nil {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+
+		err = tx.Delete(tbucket, entry.Key)
+		if err != nil {
+			return err
+		}
+
+	}
+
+	return nil
+
+}
+
+// FTSearch : archive keys of directory containing all query tokens
+func FTSearch(tx *nutsdb.Tx, nbucket string, qtokens []string) (map[string]bool, error) {
+
+	tbucket := FTBucket(nbucket)
+
+	keys := make(map[string]bool)
+
+	for i, qtoken := range qtokens {
+
+		prefix := "w:" + qtoken + "\x00"
+
+		if strings.HasSuffix(qtoken, "*") {
+			prefix = "w:" + strings.TrimSuffix(qtoken, "*")
+		}
+
+		entries, _, err := tx.PrefixScan(tbucket, []byte(prefix), -1, -1)
+
+		if entries == nil {
+			return make(map[string]bool), nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		tkeys := make(map[string]bool)
+
+		for _, entry := range entries {
+
+			z := bytes.IndexByte(entry.Key, 0)
+			if z < 0 {
+				continue
+			}
+
+			key := string(entry.Key[z+1:])
+
+			if i == 0 || keys[key] {
+				tkeys[key] = true
+			}
+
+		}
+
+		keys = tkeys
+
+		if len(keys) == 0 {
+			break
+		}
+
+	}
+
+	return keys, nil
+
+}
@@ -34,7 +34,6 @@ import (
 	"fmt"
 	"github.com/coocood/freecache"
 	"github.com/eltaline/bolt"
-	"github.com/eltaline/nutsdb"
 	"github.com/kataras/iris/v12"
 	"golang.org/x/crypto/blake2b"
 	"hash/crc32"
@@ -52,9 +51,30 @@ import (
 
 // Get
 
-// ZDGet : GET/HEAD/OPTIONS methods
-func ZDGet(cache *freecache.Cache, ndb *nutsdb.DB, wg *sync.WaitGroup) iris.Handler {
+// ZDGet : GET/HEAD/OPTIONS methods, change feed if Feed or Replication header is set
+func ZDGet(cache *freecache.Cache, wg *sync.WaitGroup) iris.Handler {
+
+	feed := ZDFeed(wg)
+	proxy := ZDProxy(wg)
+	digest := ZDDigest(cache, wg)
+
 	return func(ctx iris.Context) {
+
+		if ProxyHost(strings.Split(ctx.Host(), ":")[0]) != nil {
+			proxy(ctx)
+			return
+		}
+
+		if ctx.GetHeader("Feed") != "" || ctx.GetHeader("Replication") != "" {
+			feed(ctx)
+			return
+		}
+
+		if ctx.GetHeader("Digest") == "1" {
+			digest(ctx)
+			return
+		}
+
 		defer wg.Done()
 
 		var err error
@@ -85,6 +105,12 @@ func ZDGet(cache *freecache.Cache, ndb *nutsdb.DB, wg *sync.WaitGroup) iris.Hand
 		cip := net.ParseIP(ip)
 		vhost := strings.Split(ctx.Host(), ":")[0]
 
+		// Search DB of virtual host
+
+		ndb, search := SearchHost(vhost)
+		ftopts := FTHost(vhost)
+		jxfields := JXHost(vhost)
+
 		uri := ctx.Path()
 		furi := ctx.FullRequestURI()
 		params := ctx.URLParams()
@@ -119,6 +145,8 @@ func ZDGet(cache *freecache.Cache, ndb *nutsdb.DB, wg *sync.WaitGroup) iris.Hand
 
 		searchthreads := 4
 		searchtimeout := 10
+		searchmaxdirs := 0
+		searchmaxresults := 0
 
 		readintegrity := true
 
@@ -154,7 +182,7 @@ func ZDGet(cache *freecache.Cache, ndb *nutsdb.DB, wg *sync.WaitGroup) iris.Hand
 
 				base = filepath.Clean(Server.ROOT)
 
-				for _, Vhost := range getallow {
+				for _, Vhost := range AllowGet() {
 
 					if vhost == Vhost.Vhost {
 
@@ -188,6 +216,8 @@ func ZDGet(cache *freecache.Cache, ndb *nutsdb.DB, wg *sync.WaitGroup) iris.Hand
 
 				searchthreads = Server.SEARCHTHREADS
 				searchtimeout = Server.SEARCHTIMEOUT
+				searchmaxdirs = Server.SEARCHMAXDIRS
+				searchmaxresults = Server.SEARCHMAXRES
 
 				readintegrity = Server.READINTEGRITY
 
@@ -368,6 +398,10 @@ func ZDGet(cache *freecache.Cache, ndb *nutsdb.DB, wg *sync.WaitGroup) iris.Hand
 			withjoin := make(map[string]int)
 			withvalue := false
 
+			var match *KeysMatch
+
+			cursor := ""
+
 			minsize := uint64(0)
 			maxsize := uint64(0)
 
@@ -378,10 +412,16 @@ func ZDGet(cache *freecache.Cache, ndb *nutsdb.DB, wg *sync.WaitGroup) iris.Hand
 			limit := -1
 
 			msort := uint8(0)
+			msortby := uint8(0)
 
 			expire := -1
 			skipcache := false
 
+			groupdepth := 0
+			histdate := uint64(0)
+			histsize := uint64(0)
+			histlog := false
+
 			// sbucket := "size"
 			// tbucket := "time"
 
@@ -401,8 +441,27 @@ func ZDGet(cache *freecache.Cache, ndb *nutsdb.DB, wg *sync.WaitGroup) iris.Hand
 			hcountfiles := ctx.GetHeader("KeysCountFiles")
 			hcountarchives := ctx.GetHeader("KeysCountArchives")
 
+			hstats := ctx.GetHeader("KeysStats")
+			hstatsfiles := ctx.GetHeader("KeysStatsFiles")
+			hstatsarchives := ctx.GetHeader("KeysStatsArchives")
+
+			hgroupdepth := ctx.GetHeader("GroupDepth")
+			hhistdate := ctx.GetHeader("HistDate")
+			hhistsize := ctx.GetHeader("HistSize")
+
 			hprefix := ctx.GetHeader("Prefix")
 			hexpression := ctx.GetHeader("Expression")
+			hglob := ctx.GetHeader("Glob")
+			hquery := ctx.GetHeader("Query")
+			hfield := ctx.GetHeader("Field")
+			hvalue := ctx.GetHeader("Value")
+			hminvalue := ctx.GetHeader("MinValue")
+			hmaxvalue := ctx.GetHeader("MaxValue")
+			hstartkey := ctx.GetHeader("StartKey")
+			hendkey := ctx.GetHeader("EndKey")
+			hdelimiter := ctx.GetHeader("Delimiter")
+			hcursor := ctx.GetHeader("Cursor")
+			hignorecase := ctx.GetHeader("IgnoreCase")
 			hrecursive := ctx.GetHeader("Recursive")
 			hstopfirst := ctx.GetHeader("StopFirst")
 
@@ -420,13 +479,14 @@ func ZDGet(cache *freecache.Cache, ndb *nutsdb.DB, wg *sync.WaitGroup) iris.Hand
 			hlimit := ctx.GetHeader("Limit")
 
 			hsort := ctx.GetHeader("Sort")
+			hsortby := ctx.GetHeader("SortBy")
 
 			hexpire := ctx.GetHeader("Expire")
 			hskipcache := ctx.GetHeader("SkipCache")
 
 			hjson := ctx.GetHeader("JSON")
 
-			if !getkeys && (hkeys != "" || hkeysfiles != "" || hkeysarchives != "" || hexpression != "" || hprefix != "") {
+			if !getkeys && (hkeys != "" || hkeysfiles != "" || hkeysarchives != "" || hexpression != "" || hprefix != "" || hglob != "") {
 
 				ctx.StatusCode(iris.StatusForbidden)
 
@@ -447,7 +507,7 @@ func ZDGet(cache *freecache.Cache, ndb *nutsdb.DB, wg *sync.WaitGroup) iris.Hand
 
 			}
 
-			if !getinfo && (hinfo != "" || hinfofiles != "" || hinfoarchives != "" || hexpression != "" || hprefix != "") {
+			if !getinfo && (hinfo != "" || hinfofiles != "" || hinfoarchives != "" || hexpression != "" || hprefix != "" || hglob != "") {
 
 				ctx.StatusCode(iris.StatusForbidden)
 
@@ -468,7 +528,7 @@ func ZDGet(cache *freecache.Cache, ndb *nutsdb.DB, wg *sync.WaitGroup) iris.Hand
 
 			}
 
-			if !getsearch && (hsearch != "" || hsearchfiles != "" || hsearcharchives != "" || hexpression != "" || hprefix != "") {
+			if !getsearch && (hsearch != "" || hsearchfiles != "" || hsearcharchives != "" || hexpression != "" || hprefix != "" || hglob != "") {
 
 				ctx.StatusCode(iris.StatusForbidden)
 
@@ -552,7 +612,49 @@ func ZDGet(cache *freecache.Cache, ndb *nutsdb.DB, wg *sync.WaitGroup) iris.Hand
 
 			}
 
-			if !getcount && (hcount != "" || hcountfiles != "" || hcountarchives != "") {
+			if ftopts == nil && hquery != "" {
+
+				ctx.StatusCode(iris.StatusForbidden)
+
+				if log4xx {
+					getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 403 | The full-text query request is not allowed during GET request", vhost, ip)
+				}
+
+				if debugmode {
+
+					_, err = ctx.WriteString("[ERRO] The full-text query request is not allowed during GET request\n")
+					if err != nil {
+						getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+					}
+
+				}
+
+				return
+
+			}
+
+			if jxfields == nil && hfield != "" {
+
+				ctx.StatusCode(iris.StatusForbidden)
+
+				if log4xx {
+					getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 403 | The JSON field request is not allowed during GET request", vhost, ip)
+				}
+
+				if debugmode {
+
+					_, err = ctx.WriteString("[ERRO] The JSON field request is not allowed during GET request\n")
+					if err != nil {
+						getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+					}
+
+				}
+
+				return
+
+			}
+
+			if !getcount && (hcount != "" || hcountfiles != "" || hcountarchives != "" || hstats != "" || hstatsfiles != "" || hstatsarchives != "") {
 
 				ctx.StatusCode(iris.StatusForbidden)
 
@@ -606,19 +708,19 @@ func ZDGet(cache *freecache.Cache, ndb *nutsdb.DB, wg *sync.WaitGroup) iris.Hand
 				expression = "(.+)"
 			}
 
-			if hrecursive != "" {
+			if hglob != "" {
 
-				if hwithjoin != "" {
+				if hexpression != "" || hprefix != "" {
 
 					ctx.StatusCode(iris.StatusConflict)
 
 					if log4xx {
-						getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 400 | Recursive header conflicts with WithJoin header error during GET keys* request", vhost, ip)
+						getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 409 | Glob header conflicts with Expression or Prefix header error during GET keys* request", vhost, ip)
 					}
 
 					if debugmode {
 
-						_, err = ctx.WriteString("[ERRO] Recursive header conflicts with WithJoin header error during GET keys* request\n")
+						_, err = ctx.WriteString("[ERRO] Glob header conflicts with Expression or Prefix header error during GET keys* request\n")
 						if err != nil {
 							getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
 						}
@@ -629,18 +731,18 @@ func ZDGet(cache *freecache.Cache, ndb *nutsdb.DB, wg *sync.WaitGroup) iris.Hand
 
 				}
 
-				recursive64, err := strconv.ParseInt(hrecursive, 10, 32)
+				prefix, expression, err = GlobSearch(hglob, hignorecase == "1")
 				if err != nil {
 
 					ctx.StatusCode(iris.StatusBadRequest)
 
 					if log4xx {
-						getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 400 | Recursive int error during GET keys* request | Recursive [%s] | %v", vhost, ip, hrecursive, err)
+						getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 400 | Bad glob pattern error during GET keys* request | Glob [%s] | %v", vhost, ip, hglob, err)
 					}
 
 					if debugmode {
 
-						_, err = ctx.WriteString("[ERRO] Recursive int error during GET keys* request\n")
+						_, err = ctx.WriteString("[ERRO] Bad glob pattern error during GET keys* request\n")
 						if err != nil {
 							getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
 						}
@@ -651,24 +753,48 @@ func ZDGet(cache *freecache.Cache, ndb *nutsdb.DB, wg *sync.WaitGroup) iris.Hand
 
 				}
 
-				recursive = int(recursive64)
+			}
 
+			if hignorecase == "1" && hexpression != "" {
+				expression = "(?i)" + expression
 			}
 
-			if hstopfirst != "" {
+			if (hquery != "" || hfield != "") && hkeys == "" && hkeysarchives == "" && hinfo == "" && hinfoarchives == "" && hsearch == "" && hsearcharchives == "" {
 
-				stopfirst64, err := strconv.ParseUint(hstopfirst, 10, 8)
-				if err != nil {
+				ctx.StatusCode(iris.StatusBadRequest)
+
+				if log4xx {
+					getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 400 | Query and Field headers are allowed only with Keys, KeysArchives, KeysInfo, KeysInfoArchives, KeysSearch or KeysSearchArchives header error during GET keys* request", vhost, ip)
+				}
+
+				if debugmode {
+
+					_, err = ctx.WriteString("[ERRO] Query and Field headers are allowed only with Keys, KeysArchives, KeysInfo, KeysInfoArchives, KeysSearch or KeysSearchArchives header error during GET keys* request\n")
+					if err != nil {
+						getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+					}
+
+				}
+
+				return
+
+			}
+
+			if hquery != "" {
+
+				match = &KeysMatch{Text: FTQuery(hquery, ftopts)}
+
+				if len(match.Text) == 0 {
 
 					ctx.StatusCode(iris.StatusBadRequest)
 
 					if log4xx {
-						getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 400 | StopFirst uint error during GET keys* request | StopFirst [%s] | %v", vhost, ip, hstopfirst, err)
+						getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 400 | Query has no searchable words error during GET keys* request | Query [%s]", vhost, ip, hquery)
 					}
 
 					if debugmode {
 
-						_, err = ctx.WriteString("[ERRO] StopFirst uint error during GET keys* request\n")
+						_, err = ctx.WriteString("[ERRO] Query has no searchable words error during GET keys* request\n")
 						if err != nil {
 							getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
 						}
@@ -679,28 +805,36 @@ func ZDGet(cache *freecache.Cache, ndb *nutsdb.DB, wg *sync.WaitGroup) iris.Hand
 
 				}
 
-				if stopfirst64 >= 1 {
-					stopfirst = 1
-				} else {
-					stopfirst = uint8(stopfirst64)
+			}
+
+			if hfield != "" {
+
+				jf := &JXFilter{Path: hfield}
+
+				if hvalue != "" {
+					jf.Eq = JXValue(hvalue)
 				}
 
-			}
+				if hminvalue != "" {
+					jf.Min = JXValue(hminvalue)
+				}
 
-			if hminsize != "" {
+				if hmaxvalue != "" {
+					jf.Max = JXValue(hmaxvalue)
+				}
 
-				minsize, err = strconv.ParseUint(hminsize, 10, 64)
+				err = JXCompile(jf, jxfields)
 				if err != nil {
 
 					ctx.StatusCode(iris.StatusBadRequest)
 
 					if log4xx {
-						getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 400 | MinSize uint error during GET keys* request | MinSize [%s] | %v", vhost, ip, hminsize, err)
+						getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 400 | Bad JSON field lookup error during GET keys* request | Field [%s] | %v", vhost, ip, hfield, err)
 					}
 
 					if debugmode {
 
-						_, err = ctx.WriteString("[ERRO] MinSize uint error during GET keys* request\n")
+						_, err = ctx.WriteString("[ERRO] Bad JSON field lookup error during GET keys* request\n")
 						if err != nil {
 							getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
 						}
@@ -711,22 +845,27 @@ func ZDGet(cache *freecache.Cache, ndb *nutsdb.DB, wg *sync.WaitGroup) iris.Hand
 
 				}
 
+				if match == nil {
+					match = &KeysMatch{}
+				}
+
+				match.Field = jf
+
 			}
 
-			if hmaxsize != "" {
+			if hstartkey != "" || hendkey != "" {
 
-				maxsize, err = strconv.ParseUint(hmaxsize, 10, 64)
-				if err != nil {
+				if hkeys == "" && hkeysfiles == "" && hkeysarchives == "" && hinfo == "" && hinfofiles == "" && hinfoarchives == "" && hsearch == "" && hsearchfiles == "" && hsearcharchives == "" {
 
 					ctx.StatusCode(iris.StatusBadRequest)
 
 					if log4xx {
-						getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 400 | MaxSize uint error during GET keys* request | MaxSize [%s] | %v", vhost, ip, hmaxsize, err)
+						getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 400 | StartKey and EndKey headers are allowed only with Keys*, KeysInfo* or KeysSearch* headers error during GET keys* request", vhost, ip)
 					}
 
 					if debugmode {
 
-						_, err = ctx.WriteString("[ERRO] MaxSize uint error during GET keys* request\n")
+						_, err = ctx.WriteString("[ERRO] StartKey and EndKey headers are allowed only with Keys*, KeysInfo* or KeysSearch* headers error during GET keys* request\n")
 						if err != nil {
 							getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
 						}
@@ -737,22 +876,17 @@ func ZDGet(cache *freecache.Cache, ndb *nutsdb.DB, wg *sync.WaitGroup) iris.Hand
 
 				}
 
-			}
-
-			if hminstmp != "" {
-
-				minstmp, err = strconv.ParseUint(hminstmp, 10, 64)
-				if err != nil {
+				if hstartkey != "" && hendkey != "" && hstartkey >= hendkey {
 
 					ctx.StatusCode(iris.StatusBadRequest)
 
 					if log4xx {
-						getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 400 | MinStmp uint error during GET keys* request | MinStmp [%s] | %v", vhost, ip, hminstmp, err)
+						getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 400 | StartKey must be less than EndKey error during GET keys* request | StartKey [%s] | EndKey [%s]", vhost, ip, hstartkey, hendkey)
 					}
 
 					if debugmode {
 
-						_, err = ctx.WriteString("[ERRO] MinStmp uint error during GET keys* request\n")
+						_, err = ctx.WriteString("[ERRO] StartKey must be less than EndKey error during GET keys* request\n")
 						if err != nil {
 							getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
 						}
@@ -763,48 +897,49 @@ func ZDGet(cache *freecache.Cache, ndb *nutsdb.DB, wg *sync.WaitGroup) iris.Hand
 
 				}
 
-			}
+				if match == nil {
+					match = &KeysMatch{}
+				}
 
-			if hmaxstmp != "" {
+				match.StartKey = hstartkey
+				match.EndKey = hendkey
 
-				maxstmp, err = strconv.ParseUint(hmaxstmp, 10, 64)
-				if err != nil {
+			}
 
-					ctx.StatusCode(iris.StatusBadRequest)
+			if hcursor != "" && hdelimiter == "" {
 
-					if log4xx {
-						getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 400 | MaxStmp uint error during GET keys* request | MaxStmp [%s] | %v", vhost, ip, hmaxstmp, err)
-					}
+				ctx.StatusCode(iris.StatusBadRequest)
 
-					if debugmode {
+				if log4xx {
+					getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 400 | Cursor header is allowed only with Delimiter header error during GET keys* request", vhost, ip)
+				}
 
-						_, err = ctx.WriteString("[ERRO] MaxStmp uint error during GET keys* request\n")
-						if err != nil {
-							getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
-						}
+				if debugmode {
 
+					_, err = ctx.WriteString("[ERRO] Cursor header is allowed only with Delimiter header error during GET keys* request\n")
+					if err != nil {
+						getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
 					}
 
-					return
-
 				}
 
+				return
+
 			}
 
-			if hwithurl != "" {
+			if hdelimiter != "" {
 
-				withurl64, err := strconv.ParseUint(hwithurl, 10, 8)
-				if err != nil {
+				if hdelimiter != "/" {
 
 					ctx.StatusCode(iris.StatusBadRequest)
 
 					if log4xx {
-						getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 400 | WithUrl uint error during GET keys* request | WithUrl [%s] | %v", vhost, ip, hwithurl, err)
+						getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 400 | Delimiter header supports only / value error during GET keys* request", vhost, ip)
 					}
 
 					if debugmode {
 
-						_, err = ctx.WriteString("[ERRO] WithUrl uint error during GET keys* request\n")
+						_, err = ctx.WriteString("[ERRO] Delimiter header supports only / value error during GET keys* request\n")
 						if err != nil {
 							getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
 						}
@@ -815,25 +950,17 @@ func ZDGet(cache *freecache.Cache, ndb *nutsdb.DB, wg *sync.WaitGroup) iris.Hand
 
 				}
 
-				if withurl64 >= 1 {
-					withurl = true
-				}
-
-			}
-
-			if hwithjoin != "" {
-
-				if hrecursive != "" {
+				if (hkeys == "" && hkeysfiles == "" && hkeysarchives == "" && hinfo == "" && hinfofiles == "" && hinfoarchives == "") || hjson != "1" {
 
-					ctx.StatusCode(iris.StatusConflict)
+					ctx.StatusCode(iris.StatusBadRequest)
 
 					if log4xx {
-						getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 400 | WithJoin header conflicts with Recursive header error during GET keys* request", vhost, ip)
+						getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 400 | Delimiter header is allowed only with Keys* or KeysInfo* and JSON headers error during GET keys* request", vhost, ip)
 					}
 
 					if debugmode {
 
-						_, err = ctx.WriteString("[ERRO] WithJoin header conflicts with Recursive header error during GET keys* request\n")
+						_, err = ctx.WriteString("[ERRO] Delimiter header is allowed only with Keys* or KeysInfo* and JSON headers error during GET keys* request\n")
 						if err != nil {
 							getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
 						}
@@ -844,46 +971,41 @@ func ZDGet(cache *freecache.Cache, ndb *nutsdb.DB, wg *sync.WaitGroup) iris.Hand
 
 				}
 
-				abs = base
-
-				jpaths := rgxjoin.FindAllStringSubmatch(hwithjoin, -1)
+				if hrecursive != "" || hwithjoin != "" || hoffset != "" || hsortby != "" || hstopfirst != "" || match != nil {
 
-				for _, kval := range jpaths {
-					kdir := filepath.Clean(base + "/" + strings.TrimSpace(kval[1]))
+					ctx.StatusCode(iris.StatusConflict)
 
-					if !DirExists(kdir) {
+					if log4xx {
+						getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 409 | Delimiter header conflicts with Recursive, WithJoin, Offset, SortBy, StopFirst, Query, Field, StartKey or EndKey headers error during GET keys* request", vhost, ip)
+					}
 
-						ctx.StatusCode(iris.StatusNotFound)
+					if debugmode {
 
-						if log4xx {
-							getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 404 | Not found | Path [%s]", vhost, ip, kdir)
+						_, err = ctx.WriteString("[ERRO] Delimiter header conflicts with Recursive, WithJoin, Offset, SortBy, StopFirst, Query, Field, StartKey or EndKey headers error during GET keys* request\n")
+						if err != nil {
+							getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
 						}
 
-						if debugmode {
-
-							_, err = ctx.WriteString("[ERRO] Can`t find join directory error\n")
-							if err != nil {
-								getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
-							}
+					}
 
-						}
+					return
 
-						return
+				}
 
-					}
+				if hcursor != "" {
 
-					rval, err := strconv.ParseInt(kval[2], 10, 32)
+					cursor, err = ListParse(hcursor)
 					if err != nil {
 
 						ctx.StatusCode(iris.StatusBadRequest)
 
 						if log4xx {
-							getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 400 | Recursive int error during GET keys* request | WithJoin [%s] | %v", vhost, ip, hwithjoin, err)
+							getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 400 | Bad cursor error during GET keys* request | Cursor [%s] | %v", vhost, ip, hcursor, err)
 						}
 
 						if debugmode {
 
-							_, err = ctx.WriteString("[ERRO] Recursive int error during GET keys* request\n")
+							_, err = ctx.WriteString("[ERRO] Bad cursor error during GET keys* request\n")
 							if err != nil {
 								getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
 							}
@@ -894,26 +1016,23 @@ func ZDGet(cache *freecache.Cache, ndb *nutsdb.DB, wg *sync.WaitGroup) iris.Hand
 
 					}
 
-					withjoin[kdir] = int(rval)
-
 				}
 
 			}
 
-			if hwithvalue != "" {
+			if hrecursive != "" {
 
-				withvalue64, err := strconv.ParseUint(hwithvalue, 10, 8)
-				if err != nil {
+				if hwithjoin != "" {
 
-					ctx.StatusCode(iris.StatusBadRequest)
+					ctx.StatusCode(iris.StatusConflict)
 
 					if log4xx {
-						getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 400 | WithValue uint error during GET keys* request | WithValue [%s] | %v", vhost, ip, hwithvalue, err)
+						getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 400 | Recursive header conflicts with WithJoin header error during GET keys* request", vhost, ip)
 					}
 
 					if debugmode {
 
-						_, err = ctx.WriteString("[ERRO] WithValue uint error during GET keys* request\n")
+						_, err = ctx.WriteString("[ERRO] Recursive header conflicts with WithJoin header error during GET keys* request\n")
 						if err != nil {
 							getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
 						}
@@ -924,26 +1043,18 @@ func ZDGet(cache *freecache.Cache, ndb *nutsdb.DB, wg *sync.WaitGroup) iris.Hand
 
 				}
 
-				if withvalue64 >= 1 {
-					withvalue = true
-				}
-
-			}
-
-			if hoffset != "" {
-
-				offset64, err := strconv.ParseInt(hoffset, 10, 32)
+				recursive64, err := strconv.ParseInt(hrecursive, 10, 32)
 				if err != nil {
 
 					ctx.StatusCode(iris.StatusBadRequest)
 
 					if log4xx {
-						getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 400 | Offset int error during GET keys* request | Offset [%s] | %v", vhost, ip, hoffset, err)
+						getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 400 | Recursive int error during GET keys* request | Recursive [%s] | %v", vhost, ip, hrecursive, err)
 					}
 
 					if debugmode {
 
-						_, err = ctx.WriteString("[ERRO] Offset int error during GET keys* request\n")
+						_, err = ctx.WriteString("[ERRO] Recursive int error during GET keys* request\n")
 						if err != nil {
 							getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
 						}
@@ -954,24 +1065,24 @@ func ZDGet(cache *freecache.Cache, ndb *nutsdb.DB, wg *sync.WaitGroup) iris.Hand
 
 				}
 
-				offset = int(offset64)
+				recursive = int(recursive64)
 
 			}
 
-			if hlimit != "" {
+			if hstopfirst != "" {
 
-				limit64, err := strconv.ParseInt(hlimit, 10, 32)
+				stopfirst64, err := strconv.ParseUint(hstopfirst, 10, 8)
 				if err != nil {
 
 					ctx.StatusCode(iris.StatusBadRequest)
 
 					if log4xx {
-						getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 400 | Limit int error during GET keys* request | Limit [%s] | %v", vhost, ip, hlimit, err)
+						getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 400 | StopFirst uint error during GET keys* request | StopFirst [%s] | %v", vhost, ip, hstopfirst, err)
 					}
 
 					if debugmode {
 
-						_, err = ctx.WriteString("[ERRO] Limit int error during GET keys* request\n")
+						_, err = ctx.WriteString("[ERRO] StopFirst uint error during GET keys* request\n")
 						if err != nil {
 							getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
 						}
@@ -982,37 +1093,28 @@ func ZDGet(cache *freecache.Cache, ndb *nutsdb.DB, wg *sync.WaitGroup) iris.Hand
 
 				}
 
-				limit = int(limit64)
-
-			}
-
-			if hsort != "" {
-
-				switch {
-				case hsort == "0":
-					msort = uint8(0)
-				case hsort == "1":
-					msort = uint8(1)
-				default:
-					msort = uint8(0)
+				if stopfirst64 >= 1 {
+					stopfirst = 1
+				} else {
+					stopfirst = uint8(stopfirst64)
 				}
 
 			}
 
-			if hexpire != "" {
+			if hminsize != "" {
 
-				expire64, err := strconv.ParseUint(hexpire, 10, 32)
+				minsize, err = strconv.ParseUint(hminsize, 10, 64)
 				if err != nil {
 
 					ctx.StatusCode(iris.StatusBadRequest)
 
 					if log4xx {
-						getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 400 | Expire uint error during GET keys* request | Expire [%s] | %v", vhost, ip, hexpire, err)
+						getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 400 | MinSize uint error during GET keys* request | MinSize [%s] | %v", vhost, ip, hminsize, err)
 					}
 
 					if debugmode {
 
-						_, err = ctx.WriteString("[ERRO] Expire uint error during GET keys* request\n")
+						_, err = ctx.WriteString("[ERRO] MinSize uint error during GET keys* request\n")
 						if err != nil {
 							getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
 						}
@@ -1023,11 +1125,355 @@ func ZDGet(cache *freecache.Cache, ndb *nutsdb.DB, wg *sync.WaitGroup) iris.Hand
 
 				}
 
-				expire = int(expire64)
-
 			}
 
-			if hskipcache != "" {
+			if hmaxsize != "" {
+
+				maxsize, err = strconv.ParseUint(hmaxsize, 10, 64)
+				if err != nil {
+
+					ctx.StatusCode(iris.StatusBadRequest)
+
+					if log4xx {
+						getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 400 | MaxSize uint error during GET keys* request | MaxSize [%s] | %v", vhost, ip, hmaxsize, err)
+					}
+
+					if debugmode {
+
+						_, err = ctx.WriteString("[ERRO] MaxSize uint error during GET keys* request\n")
+						if err != nil {
+							getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+						}
+
+					}
+
+					return
+
+				}
+
+			}
+
+			if hminstmp != "" {
+
+				minstmp, err = strconv.ParseUint(hminstmp, 10, 64)
+				if err != nil {
+
+					ctx.StatusCode(iris.StatusBadRequest)
+
+					if log4xx {
+						getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 400 | MinStmp uint error during GET keys* request | MinStmp [%s] | %v", vhost, ip, hminstmp, err)
+					}
+
+					if debugmode {
+
+						_, err = ctx.WriteString("[ERRO] MinStmp uint error during GET keys* request\n")
+						if err != nil {
+							getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+						}
+
+					}
+
+					return
+
+				}
+
+			}
+
+			if hmaxstmp != "" {
+
+				maxstmp, err = strconv.ParseUint(hmaxstmp, 10, 64)
+				if err != nil {
+
+					ctx.StatusCode(iris.StatusBadRequest)
+
+					if log4xx {
+						getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 400 | MaxStmp uint error during GET keys* request | MaxStmp [%s] | %v", vhost, ip, hmaxstmp, err)
+					}
+
+					if debugmode {
+
+						_, err = ctx.WriteString("[ERRO] MaxStmp uint error during GET keys* request\n")
+						if err != nil {
+							getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+						}
+
+					}
+
+					return
+
+				}
+
+			}
+
+			if hwithurl != "" {
+
+				withurl64, err := strconv.ParseUint(hwithurl, 10, 8)
+				if err != nil {
+
+					ctx.StatusCode(iris.StatusBadRequest)
+
+					if log4xx {
+						getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 400 | WithUrl uint error during GET keys* request | WithUrl [%s] | %v", vhost, ip, hwithurl, err)
+					}
+
+					if debugmode {
+
+						_, err = ctx.WriteString("[ERRO] WithUrl uint error during GET keys* request\n")
+						if err != nil {
+							getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+						}
+
+					}
+
+					return
+
+				}
+
+				if withurl64 >= 1 {
+					withurl = true
+				}
+
+			}
+
+			if hwithjoin != "" {
+
+				if hrecursive != "" {
+
+					ctx.StatusCode(iris.StatusConflict)
+
+					if log4xx {
+						getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 400 | WithJoin header conflicts with Recursive header error during GET keys* request", vhost, ip)
+					}
+
+					if debugmode {
+
+						_, err = ctx.WriteString("[ERRO] WithJoin header conflicts with Recursive header error during GET keys* request\n")
+						if err != nil {
+							getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+						}
+
+					}
+
+					return
+
+				}
+
+				abs = base
+
+				jpaths := rgxjoin.FindAllStringSubmatch(hwithjoin, -1)
+
+				for _, kval := range jpaths {
+					kdir := filepath.Clean(base + "/" + strings.TrimSpace(kval[1]))
+
+					if !DirExists(kdir) {
+
+						ctx.StatusCode(iris.StatusNotFound)
+
+						if log4xx {
+							getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 404 | Not found | Path [%s]", vhost, ip, kdir)
+						}
+
+						if debugmode {
+
+							_, err = ctx.WriteString("[ERRO] Can`t find join directory error\n")
+							if err != nil {
+								getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+							}
+
+						}
+
+						return
+
+					}
+
+					rval, err := strconv.ParseInt(kval[2], 10, 32)
+					if err != nil {
+
+						ctx.StatusCode(iris.StatusBadRequest)
+
+						if log4xx {
+							getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 400 | Recursive int error during GET keys* request | WithJoin [%s] | %v", vhost, ip, hwithjoin, err)
+						}
+
+						if debugmode {
+
+							_, err = ctx.WriteString("[ERRO] Recursive int error during GET keys* request\n")
+							if err != nil {
+								getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+							}
+
+						}
+
+						return
+
+					}
+
+					withjoin[kdir] = int(rval)
+
+				}
+
+			}
+
+			if hwithvalue != "" {
+
+				withvalue64, err := strconv.ParseUint(hwithvalue, 10, 8)
+				if err != nil {
+
+					ctx.StatusCode(iris.StatusBadRequest)
+
+					if log4xx {
+						getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 400 | WithValue uint error during GET keys* request | WithValue [%s] | %v", vhost, ip, hwithvalue, err)
+					}
+
+					if debugmode {
+
+						_, err = ctx.WriteString("[ERRO] WithValue uint error during GET keys* request\n")
+						if err != nil {
+							getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+						}
+
+					}
+
+					return
+
+				}
+
+				if withvalue64 >= 1 {
+					withvalue = true
+				}
+
+			}
+
+			if hoffset != "" {
+
+				offset64, err := strconv.ParseInt(hoffset, 10, 32)
+				if err != nil {
+
+					ctx.StatusCode(iris.StatusBadRequest)
+
+					if log4xx {
+						getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 400 | Offset int error during GET keys* request | Offset [%s] | %v", vhost, ip, hoffset, err)
+					}
+
+					if debugmode {
+
+						_, err = ctx.WriteString("[ERRO] Offset int error during GET keys* request\n")
+						if err != nil {
+							getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+						}
+
+					}
+
+					return
+
+				}
+
+				offset = int(offset64)
+
+			}
+
+			if hlimit != "" {
+
+				limit64, err := strconv.ParseInt(hlimit, 10, 32)
+				if err != nil {
+
+					ctx.StatusCode(iris.StatusBadRequest)
+
+					if log4xx {
+						getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 400 | Limit int error during GET keys* request | Limit [%s] | %v", vhost, ip, hlimit, err)
+					}
+
+					if debugmode {
+
+						_, err = ctx.WriteString("[ERRO] Limit int error during GET keys* request\n")
+						if err != nil {
+							getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+						}
+
+					}
+
+					return
+
+				}
+
+				limit = int(limit64)
+
+			}
+
+			if hsort != "" {
+
+				switch {
+				case hsort == "0":
+					msort = uint8(0)
+				case hsort == "1":
+					msort = uint8(1)
+				default:
+					msort = uint8(0)
+				}
+
+			}
+
+			if hsortby != "" {
+
+				switch {
+				case hsortby == "key":
+					msortby = uint8(0)
+				case hsortby == "size":
+					msortby = uint8(1)
+				case hsortby == "date":
+					msortby = uint8(2)
+				default:
+
+					ctx.StatusCode(iris.StatusBadRequest)
+
+					if log4xx {
+						getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 400 | SortBy must be key, size or date error during GET keys* request | SortBy [%s]", vhost, ip, hsortby)
+					}
+
+					if debugmode {
+
+						_, err = ctx.WriteString("[ERRO] SortBy must be key, size or date error during GET keys* request\n")
+						if err != nil {
+							getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+						}
+
+					}
+
+					return
+
+				}
+
+			}
+
+			if hexpire != "" {
+
+				expire64, err := strconv.ParseUint(hexpire, 10, 32)
+				if err != nil {
+
+					ctx.StatusCode(iris.StatusBadRequest)
+
+					if log4xx {
+						getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 400 | Expire uint error during GET keys* request | Expire [%s] | %v", vhost, ip, hexpire, err)
+					}
+
+					if debugmode {
+
+						_, err = ctx.WriteString("[ERRO] Expire uint error during GET keys* request\n")
+						if err != nil {
+							getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+						}
+
+					}
+
+					return
+
+				}
+
+				expire = int(expire64)
+
+			}
+
+			if hskipcache != "" {
 
 				skipcache64, err := strconv.ParseUint(hskipcache, 10, 8)
 				if err != nil {
@@ -1057,8 +1503,106 @@ func ZDGet(cache *freecache.Cache, ndb *nutsdb.DB, wg *sync.WaitGroup) iris.Hand
 
 			}
 
+			if hgroupdepth != "" {
+
+				groupdepth64, err := strconv.ParseInt(hgroupdepth, 10, 32)
+				if err != nil {
+
+					ctx.StatusCode(iris.StatusBadRequest)
+
+					if log4xx {
+						getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 400 | GroupDepth int error during GET keys* request | GroupDepth [%s] | %v", vhost, ip, hgroupdepth, err)
+					}
+
+					if debugmode {
+
+						_, err = ctx.WriteString("[ERRO] GroupDepth int error during GET keys* request\n")
+						if err != nil {
+							getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+						}
+
+					}
+
+					return
+
+				}
+
+				groupdepth = int(groupdepth64)
+
+			}
+
+			if hhistdate != "" {
+
+				histdate, err = strconv.ParseUint(hhistdate, 10, 64)
+				if err != nil {
+
+					ctx.StatusCode(iris.StatusBadRequest)
+
+					if log4xx {
+						getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 400 | HistDate uint error during GET keys* request | HistDate [%s] | %v", vhost, ip, hhistdate, err)
+					}
+
+					if debugmode {
+
+						_, err = ctx.WriteString("[ERRO] HistDate uint error during GET keys* request\n")
+						if err != nil {
+							getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+						}
+
+					}
+
+					return
+
+				}
+
+			}
+
+			if hhistsize == "log2" {
+				histlog = true
+			}
+
+			if hhistsize != "" && !histlog {
+
+				histsize, err = strconv.ParseUint(hhistsize, 10, 64)
+				if err != nil {
+
+					ctx.StatusCode(iris.StatusBadRequest)
+
+					if log4xx {
+						getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 400 | HistSize uint error during GET keys* request | HistSize [%s] | %v", vhost, ip, hhistsize, err)
+					}
+
+					if debugmode {
+
+						_, err = ctx.WriteString("[ERRO] HistSize uint error during GET keys* request\n")
+						if err != nil {
+							getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+						}
+
+					}
+
+					return
+
+				}
+
+			}
+
 			// Cache
 
+			croots := make(map[string]int)
+
+			if len(withjoin) > 0 {
+
+				for jdir, jrec := range withjoin {
+					croots[jdir] = jrec
+				}
+
+			} else {
+				croots[abs] = recursive
+			}
+
+			cseq := CacheSeq()
+
 			var vckey []byte
 			var vchash []byte = nil
 			var vcerr string = "0"
@@ -1070,10 +1614,12 @@ func ZDGet(cache *freecache.Cache, ndb *nutsdb.DB, wg *sync.WaitGroup) iris.Hand
 					";hi:" + hinfo + ";hif:" + hinfofiles + ";hia:" + hinfoarchives +
 					";hs:" + hsearch + ";hsf:" + hsearchfiles + ";hsa:" + hsearcharchives +
 					";hc:" + hcount + ";hcf:" + hcountfiles + ";hca:" + hcountarchives +
-					";hp:" + hprefix + ";he:" + hexpression + ";hr:" + hrecursive + ";ht:" + hstopfirst +
+					";hst:" + hstats + ";hstf:" + hstatsfiles + ";hsta:" + hstatsarchives +
+					";hgd:" + hgroupdepth + ";hhd:" + hhistdate + ";hhs:" + hhistsize +
+					";hp:" + hprefix + ";he:" + hexpression + ";hg:" + hglob + ";hq:" + hquery + ";hfl:" + hfield + ";hvl:" + hvalue + ";hmiv:" + hminvalue + ";hmav:" + hmaxvalue + ";hsk:" + hstartkey + ";hek:" + hendkey + ";hdl:" + hdelimiter + ";hcr:" + hcursor + ";hic:" + hignorecase + ";hr:" + hrecursive + ";ht:" + hstopfirst +
 					";hmix:" + hminsize + ";hmax:" + hmaxsize + ";hsix:" + hminstmp + ";hsax:" + hmaxstmp +
 					";hwrl:" + hwithurl + ";hwjn:" + hwithjoin + ";hwvl:" + hwithvalue +
-					";hoff:" + hoffset + ";hlim:" + hlimit + ";hsrt:" + hsort + ";hjsn:" + hjson)
+					";hoff:" + hoffset + ";hlim:" + hlimit + ";hsrt:" + hsort + ";hsrb:" + hsortby + ";hjsn:" + hjson)
 
 				vcblk := blake2b.Sum256(vckey)
 				vchash = vcblk[:]
@@ -1116,6 +1662,137 @@ func ZDGet(cache *freecache.Cache, ndb *nutsdb.DB, wg *sync.WaitGroup) iris.Hand
 
 			// Standart/Bolt Keys Iterator
 
+			if hdelimiter != "" {
+
+				if !DirExists(abs) {
+
+					ctx.StatusCode(iris.StatusNotFound)
+
+					if log4xx {
+						getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 404 | Not found | Path [%s]", vhost, ip, abs)
+					}
+
+					if debugmode {
+
+						_, err = ctx.WriteString("[ERRO] Can`t find directory error\n")
+						if err != nil {
+							getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+						}
+
+					}
+
+					return
+
+				}
+
+				info := false
+
+				istrue, ccnm := StringOne(hkeys, hkeysfiles, hkeysarchives)
+				if !istrue {
+					_, ccnm = StringOne(hinfo, hinfofiles, hinfoarchives)
+					info = true
+				}
+
+				klist, err := ListKeys(ndb, base, abs, msort, limit, cursor, prefix, expression, withurl, furi, ccnm != 3, ccnm != 2, searchmaxresults)
+				if err == errMaxResults {
+
+					ctx.StatusCode(iris.StatusRequestEntityTooLarge)
+
+					if log4xx {
+						getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 413 | Search limits exceeded error | Path [%s] | %v", vhost, ip, abs, err)
+					}
+
+					if debugmode {
+
+						_, err = ctx.Writef("[ERRO] Search limits exceeded error | %v\n", err)
+						if err != nil {
+							getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+						}
+
+					}
+
+					return
+
+				}
+
+				if err != nil {
+
+					ctx.StatusCode(iris.StatusInternalServerError)
+					getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 500 | Can`t list directories, files and keys in directory error | Path [%s] | %v", vhost, ip, abs, err)
+
+					if debugmode {
+
+						_, err = ctx.WriteString("[ERRO] Can`t list directories, files and keys in directory error\n")
+						if err != nil {
+							getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+						}
+
+					}
+
+					return
+
+				}
+
+				var jkeys []byte
+
+				switch {
+				case info:
+					jkeys, _ = json.Marshal(klist.Keys)
+				default:
+
+					pkeys := []Keys{}
+
+					for _, vs := range klist.Keys {
+						pkeys = append(pkeys, Keys{Key: vs.Key, Type: vs.Type})
+					}
+
+					jkeys, _ = json.Marshal(pkeys)
+
+				}
+
+				jprefixes, _ := json.Marshal(klist.Prefixes)
+				jnext, _ := json.Marshal(klist.Next)
+
+				rbytes := []byte(fmt.Sprintf("{\"prefixes\": %s, \"keys\": %s, \"next\": %s}", string(jprefixes), string(jkeys), string(jnext)))
+
+				conttype := http.DetectContentType(rbytes)
+
+				hsize := fmt.Sprintf("%d", len(rbytes))
+				scctrl := fmt.Sprintf("max-age=%d", cctrl)
+
+				ctx.Header("Content-Type", conttype)
+				ctx.Header("Content-Length", hsize)
+				ctx.Header("Cache-Control", scctrl)
+
+				ctx.Header("Hitcache", "0")
+
+				if search && getcache && expire >= 0 {
+
+					err = CacheSet(cache, vchash, rbytes, expire, croots, cseq)
+					if err != nil {
+						vcerr = "1"
+						errmsg = fmt.Sprintf("%v", err)
+						getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 599 | Write search results to cache error | Path [%s] | %v", vhost, ip, abs, err)
+					}
+
+				}
+
+				ctx.Header("Errcache", vcerr)
+				ctx.Header("Errmsg", errmsg)
+
+				_, err = ctx.Write(rbytes)
+				if err != nil {
+
+					if log4xx {
+						getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+					}
+
+				}
+
+				return
+
+			}
+
 			istrue, ccnm := StringOne(hkeys, hkeysfiles, hkeysarchives)
 
 			switch {
@@ -1124,7 +1801,28 @@ func ZDGet(cache *freecache.Cache, ndb *nutsdb.DB, wg *sync.WaitGroup) iris.Hand
 
 				if DirExists(abs) {
 
-					getkeys, err := AllKeys(ndb, base, abs, msort, offset, limit, prefix, expression, recursive, stopfirst, minsize, maxsize, minstmp, maxstmp, withurl, furi, withjoin, searchthreads, searchtimeout)
+					getkeys, err := AllKeys(ndb, base, abs, msort, offset, limit, prefix, expression, recursive, stopfirst, minsize, maxsize, minstmp, maxstmp, withurl, furi, withjoin, match, searchthreads, searchtimeout, searchmaxdirs, searchmaxresults)
+					if err == errMaxDirs || err == errMaxResults {
+
+						ctx.StatusCode(iris.StatusRequestEntityTooLarge)
+
+						if log4xx {
+							getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 413 | Search limits exceeded error | Path [%s] | %v", vhost, ip, abs, err)
+						}
+
+						if debugmode {
+
+							_, err = ctx.Writef("[ERRO] Search limits exceeded error | %v\n", err)
+							if err != nil {
+								getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+							}
+
+						}
+
+						return
+
+					}
+
 					if err != nil {
 
 						ctx.StatusCode(iris.StatusInternalServerError)
@@ -1177,7 +1875,7 @@ func ZDGet(cache *freecache.Cache, ndb *nutsdb.DB, wg *sync.WaitGroup) iris.Hand
 
 						if search && getcache && expire >= 0 {
 
-							err = cache.Set(vchash, rbytes, expire)
+							err = CacheSet(cache, vchash, rbytes, expire, croots, cseq)
 							if err != nil {
 								vcerr = "1"
 								errmsg = fmt.Sprintf("%v", err)
@@ -1225,7 +1923,28 @@ func ZDGet(cache *freecache.Cache, ndb *nutsdb.DB, wg *sync.WaitGroup) iris.Hand
 
 				if DirExists(abs) {
 
-					getkeys, _, _, err := FileKeys(ndb, base, abs, msort, offset, limit, prefix, expression, recursive, stopfirst, minsize, maxsize, minstmp, maxstmp, withurl, furi, withjoin, searchthreads, searchtimeout)
+					getkeys, _, _, err := FileKeys(ndb, base, abs, msort, offset, limit, prefix, expression, recursive, stopfirst, minsize, maxsize, minstmp, maxstmp, withurl, furi, withjoin, match, searchthreads, searchtimeout, searchmaxdirs, searchmaxresults)
+					if err == errMaxDirs || err == errMaxResults {
+
+						ctx.StatusCode(iris.StatusRequestEntityTooLarge)
+
+						if log4xx {
+							getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 413 | Search limits exceeded error | Path [%s] | %v", vhost, ip, abs, err)
+						}
+
+						if debugmode {
+
+							_, err = ctx.Writef("[ERRO] Search limits exceeded error | %v\n", err)
+							if err != nil {
+								getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+							}
+
+						}
+
+						return
+
+					}
+
 					if err != nil {
 
 						ctx.StatusCode(iris.StatusInternalServerError)
@@ -1277,7 +1996,7 @@ func ZDGet(cache *freecache.Cache, ndb *nutsdb.DB, wg *sync.WaitGroup) iris.Hand
 
 						if search && getcache && expire >= 0 {
 
-							err = cache.Set(vchash, rbytes, expire)
+							err = CacheSet(cache, vchash, rbytes, expire, croots, cseq)
 							if err != nil {
 								vcerr = "1"
 								errmsg = fmt.Sprintf("%v", err)
@@ -1325,7 +2044,28 @@ func ZDGet(cache *freecache.Cache, ndb *nutsdb.DB, wg *sync.WaitGroup) iris.Hand
 
 				if DirExists(abs) {
 
-					getkeys, err := DBKeys(ndb, base, abs, msort, offset, limit, prefix, expression, recursive, stopfirst, minsize, maxsize, minstmp, maxstmp, withurl, furi, withjoin, searchthreads, searchtimeout)
+					getkeys, err := DBKeys(ndb, base, abs, msort, offset, limit, prefix, expression, recursive, stopfirst, minsize, maxsize, minstmp, maxstmp, withurl, furi, withjoin, match, searchthreads, searchtimeout, searchmaxdirs, searchmaxresults)
+					if err == errMaxDirs || err == errMaxResults {
+
+						ctx.StatusCode(iris.StatusRequestEntityTooLarge)
+
+						if log4xx {
+							getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 413 | Search limits exceeded error | Path [%s] | %v", vhost, ip, abs, err)
+						}
+
+						if debugmode {
+
+							_, err = ctx.Writef("[ERRO] Search limits exceeded error | %v\n", err)
+							if err != nil {
+								getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+							}
+
+						}
+
+						return
+
+					}
+
 					if err != nil {
 
 						ctx.StatusCode(iris.StatusInternalServerError)
@@ -1377,7 +2117,7 @@ func ZDGet(cache *freecache.Cache, ndb *nutsdb.DB, wg *sync.WaitGroup) iris.Hand
 
 						if search && getcache && expire >= 0 {
 
-							err = cache.Set(vchash, rbytes, expire)
+							err = CacheSet(cache, vchash, rbytes, expire, croots, cseq)
 							if err != nil {
 								vcerr = "1"
 								errmsg = fmt.Sprintf("%v", err)
@@ -1429,11 +2169,32 @@ func ZDGet(cache *freecache.Cache, ndb *nutsdb.DB, wg *sync.WaitGroup) iris.Hand
 
 			switch {
 
-			case istrue && ccnm == 1:
+			case istrue && ccnm == 1:
+
+				if DirExists(abs) {
+
+					getkeys, err := AllKeysInfo(ndb, base, abs, msort, msortby, offset, limit, prefix, expression, recursive, stopfirst, minsize, maxsize, minstmp, maxstmp, withurl, furi, withjoin, match, searchthreads, searchtimeout, searchmaxdirs, searchmaxresults)
+					if err == errMaxDirs || err == errMaxResults {
+
+						ctx.StatusCode(iris.StatusRequestEntityTooLarge)
+
+						if log4xx {
+							getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 413 | Search limits exceeded error | Path [%s] | %v", vhost, ip, abs, err)
+						}
+
+						if debugmode {
+
+							_, err = ctx.Writef("[ERRO] Search limits exceeded error | %v\n", err)
+							if err != nil {
+								getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+							}
+
+						}
+
+						return
 
-				if DirExists(abs) {
+					}
 
-					getkeys, err := AllKeysInfo(ndb, base, abs, msort, offset, limit, prefix, expression, recursive, stopfirst, minsize, maxsize, minstmp, maxstmp, withurl, furi, withjoin, searchthreads, searchtimeout)
 					if err != nil {
 
 						ctx.StatusCode(iris.StatusInternalServerError)
@@ -1485,7 +2246,7 @@ func ZDGet(cache *freecache.Cache, ndb *nutsdb.DB, wg *sync.WaitGroup) iris.Hand
 
 						if search && getcache && expire >= 0 {
 
-							err = cache.Set(vchash, rbytes, expire)
+							err = CacheSet(cache, vchash, rbytes, expire, croots, cseq)
 							if err != nil {
 								vcerr = "1"
 								errmsg = fmt.Sprintf("%v", err)
@@ -1533,7 +2294,28 @@ func ZDGet(cache *freecache.Cache, ndb *nutsdb.DB, wg *sync.WaitGroup) iris.Hand
 
 				if DirExists(abs) {
 
-					getkeys, _, _, err := FileKeysInfo(ndb, base, abs, msort, offset, limit, prefix, expression, recursive, stopfirst, minsize, maxsize, minstmp, maxstmp, withurl, furi, withjoin, searchthreads, searchtimeout)
+					getkeys, _, _, err := FileKeysInfo(ndb, base, abs, msort, msortby, offset, limit, prefix, expression, recursive, stopfirst, minsize, maxsize, minstmp, maxstmp, withurl, furi, withjoin, match, searchthreads, searchtimeout, searchmaxdirs, searchmaxresults)
+					if err == errMaxDirs || err == errMaxResults {
+
+						ctx.StatusCode(iris.StatusRequestEntityTooLarge)
+
+						if log4xx {
+							getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 413 | Search limits exceeded error | Path [%s] | %v", vhost, ip, abs, err)
+						}
+
+						if debugmode {
+
+							_, err = ctx.Writef("[ERRO] Search limits exceeded error | %v\n", err)
+							if err != nil {
+								getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+							}
+
+						}
+
+						return
+
+					}
+
 					if err != nil {
 
 						ctx.StatusCode(iris.StatusInternalServerError)
@@ -1585,7 +2367,7 @@ func ZDGet(cache *freecache.Cache, ndb *nutsdb.DB, wg *sync.WaitGroup) iris.Hand
 
 						if search && getcache && expire >= 0 {
 
-							err = cache.Set(vchash, rbytes, expire)
+							err = CacheSet(cache, vchash, rbytes, expire, croots, cseq)
 							if err != nil {
 								vcerr = "1"
 								errmsg = fmt.Sprintf("%v", err)
@@ -1633,7 +2415,28 @@ func ZDGet(cache *freecache.Cache, ndb *nutsdb.DB, wg *sync.WaitGroup) iris.Hand
 
 				if DirExists(abs) {
 
-					getkeys, err := DBKeysInfo(ndb, base, abs, msort, offset, limit, prefix, expression, recursive, stopfirst, minsize, maxsize, minstmp, maxstmp, withurl, furi, withjoin, searchthreads, searchtimeout)
+					getkeys, err := DBKeysInfo(ndb, base, abs, msort, msortby, offset, limit, prefix, expression, recursive, stopfirst, minsize, maxsize, minstmp, maxstmp, withurl, furi, withjoin, match, searchthreads, searchtimeout, searchmaxdirs, searchmaxresults)
+					if err == errMaxDirs || err == errMaxResults {
+
+						ctx.StatusCode(iris.StatusRequestEntityTooLarge)
+
+						if log4xx {
+							getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 413 | Search limits exceeded error | Path [%s] | %v", vhost, ip, abs, err)
+						}
+
+						if debugmode {
+
+							_, err = ctx.Writef("[ERRO] Search limits exceeded error | %v\n", err)
+							if err != nil {
+								getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+							}
+
+						}
+
+						return
+
+					}
+
 					if err != nil {
 
 						ctx.StatusCode(iris.StatusInternalServerError)
@@ -1685,7 +2488,7 @@ func ZDGet(cache *freecache.Cache, ndb *nutsdb.DB, wg *sync.WaitGroup) iris.Hand
 
 						if search && getcache && expire >= 0 {
 
-							err = cache.Set(vchash, rbytes, expire)
+							err = CacheSet(cache, vchash, rbytes, expire, croots, cseq)
 							if err != nil {
 								vcerr = "1"
 								errmsg = fmt.Sprintf("%v", err)
@@ -1741,7 +2544,28 @@ func ZDGet(cache *freecache.Cache, ndb *nutsdb.DB, wg *sync.WaitGroup) iris.Hand
 
 				if DirExists(abs) {
 
-					getkeys, err := AllKeysSearch(filemode, timeout, opentries, freelist, ndb, base, abs, msort, offset, limit, prefix, expression, recursive, stopfirst, minsize, maxsize, minstmp, maxstmp, withurl, furi, withjoin, withvalue, vmaxsize, searchthreads, searchtimeout)
+					getkeys, err := AllKeysSearch(filemode, timeout, opentries, freelist, ndb, base, abs, msort, msortby, offset, limit, prefix, expression, recursive, stopfirst, minsize, maxsize, minstmp, maxstmp, withurl, furi, withjoin, match, withvalue, vmaxsize, searchthreads, searchtimeout, searchmaxdirs, searchmaxresults)
+					if err == errMaxDirs || err == errMaxResults {
+
+						ctx.StatusCode(iris.StatusRequestEntityTooLarge)
+
+						if log4xx {
+							getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 413 | Search limits exceeded error | Path [%s] | %v", vhost, ip, abs, err)
+						}
+
+						if debugmode {
+
+							_, err = ctx.Writef("[ERRO] Search limits exceeded error | %v\n", err)
+							if err != nil {
+								getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+							}
+
+						}
+
+						return
+
+					}
+
 					if err != nil {
 
 						ctx.StatusCode(iris.StatusInternalServerError)
@@ -1793,7 +2617,7 @@ func ZDGet(cache *freecache.Cache, ndb *nutsdb.DB, wg *sync.WaitGroup) iris.Hand
 
 						if search && getcache && expire >= 0 {
 
-							err = cache.Set(vchash, rbytes, expire)
+							err = CacheSet(cache, vchash, rbytes, expire, croots, cseq)
 							if err != nil {
 								vcerr = "1"
 								errmsg = fmt.Sprintf("%v", err)
@@ -1841,7 +2665,28 @@ func ZDGet(cache *freecache.Cache, ndb *nutsdb.DB, wg *sync.WaitGroup) iris.Hand
 
 				if DirExists(abs) {
 
-					getkeys, _, _, err := FileKeysSearch(ndb, base, abs, msort, offset, limit, prefix, expression, recursive, stopfirst, minsize, maxsize, minstmp, maxstmp, withurl, furi, withjoin, withvalue, vmaxsize, searchthreads, searchtimeout)
+					getkeys, _, _, err := FileKeysSearch(ndb, base, abs, msort, msortby, offset, limit, prefix, expression, recursive, stopfirst, minsize, maxsize, minstmp, maxstmp, withurl, furi, withjoin, match, withvalue, vmaxsize, searchthreads, searchtimeout, searchmaxdirs, searchmaxresults)
+					if err == errMaxDirs || err == errMaxResults {
+
+						ctx.StatusCode(iris.StatusRequestEntityTooLarge)
+
+						if log4xx {
+							getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 413 | Search limits exceeded error | Path [%s] | %v", vhost, ip, abs, err)
+						}
+
+						if debugmode {
+
+							_, err = ctx.Writef("[ERRO] Search limits exceeded error | %v\n", err)
+							if err != nil {
+								getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+							}
+
+						}
+
+						return
+
+					}
+
 					if err != nil {
 
 						ctx.StatusCode(iris.StatusInternalServerError)
@@ -1893,7 +2738,7 @@ func ZDGet(cache *freecache.Cache, ndb *nutsdb.DB, wg *sync.WaitGroup) iris.Hand
 
 						if search && getcache && expire >= 0 {
 
-							err = cache.Set(vchash, rbytes, expire)
+							err = CacheSet(cache, vchash, rbytes, expire, croots, cseq)
 							if err != nil {
 								vcerr = "1"
 								errmsg = fmt.Sprintf("%v", err)
@@ -1941,7 +2786,28 @@ func ZDGet(cache *freecache.Cache, ndb *nutsdb.DB, wg *sync.WaitGroup) iris.Hand
 
 				if DirExists(abs) {
 
-					getkeys, err := DBKeysSearch(filemode, timeout, opentries, freelist, ndb, base, abs, msort, offset, limit, prefix, expression, recursive, stopfirst, minsize, maxsize, minstmp, maxstmp, withurl, furi, withjoin, withvalue, vmaxsize, searchthreads, searchtimeout)
+					getkeys, err := DBKeysSearch(filemode, timeout, opentries, freelist, ndb, base, abs, msort, msortby, offset, limit, prefix, expression, recursive, stopfirst, minsize, maxsize, minstmp, maxstmp, withurl, furi, withjoin, match, withvalue, vmaxsize, searchthreads, searchtimeout, searchmaxdirs, searchmaxresults)
+					if err == errMaxDirs || err == errMaxResults {
+
+						ctx.StatusCode(iris.StatusRequestEntityTooLarge)
+
+						if log4xx {
+							getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 413 | Search limits exceeded error | Path [%s] | %v", vhost, ip, abs, err)
+						}
+
+						if debugmode {
+
+							_, err = ctx.Writef("[ERRO] Search limits exceeded error | %v\n", err)
+							if err != nil {
+								getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+							}
+
+						}
+
+						return
+
+					}
+
 					if err != nil {
 
 						ctx.StatusCode(iris.StatusInternalServerError)
@@ -1993,7 +2859,7 @@ func ZDGet(cache *freecache.Cache, ndb *nutsdb.DB, wg *sync.WaitGroup) iris.Hand
 
 						if search && getcache && expire >= 0 {
 
-							err = cache.Set(vchash, rbytes, expire)
+							err = CacheSet(cache, vchash, rbytes, expire, croots, cseq)
 							if err != nil {
 								vcerr = "1"
 								errmsg = fmt.Sprintf("%v", err)
@@ -2053,7 +2919,28 @@ func ZDGet(cache *freecache.Cache, ndb *nutsdb.DB, wg *sync.WaitGroup) iris.Hand
 
 					allkeyscount := 0
 
-					getkeys, err := AllKeys(ndb, base, abs, msort, offset, limit, prefix, expression, recursive, stopfirst, minsize, maxsize, minstmp, maxstmp, withurl, furi, withjoin, searchthreads, searchtimeout)
+					getkeys, err := AllKeys(ndb, base, abs, msort, offset, limit, prefix, expression, recursive, stopfirst, minsize, maxsize, minstmp, maxstmp, withurl, furi, withjoin, nil, searchthreads, searchtimeout, searchmaxdirs, searchmaxresults)
+					if err == errMaxDirs || err == errMaxResults {
+
+						ctx.StatusCode(iris.StatusRequestEntityTooLarge)
+
+						if log4xx {
+							getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 413 | Search limits exceeded error | Path [%s] | %v", vhost, ip, abs, err)
+						}
+
+						if debugmode {
+
+							_, err = ctx.Writef("[ERRO] Search limits exceeded error | %v\n", err)
+							if err != nil {
+								getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+							}
+
+						}
+
+						return
+
+					}
+
 					if err != nil {
 
 						ctx.StatusCode(iris.StatusInternalServerError)
@@ -2127,7 +3014,7 @@ func ZDGet(cache *freecache.Cache, ndb *nutsdb.DB, wg *sync.WaitGroup) iris.Hand
 
 					if search && getcache && expire >= 0 {
 
-						err = cache.Set(vchash, rbytes, expire)
+						err = CacheSet(cache, vchash, rbytes, expire, croots, cseq)
 						if err != nil {
 							vcerr = "1"
 							errmsg = fmt.Sprintf("%v", err)
@@ -2177,7 +3064,28 @@ func ZDGet(cache *freecache.Cache, ndb *nutsdb.DB, wg *sync.WaitGroup) iris.Hand
 
 				if DirExists(abs) {
 
-					getkeys, _, _, err := FileKeys(ndb, base, abs, msort, offset, limit, prefix, expression, recursive, stopfirst, minsize, maxsize, minstmp, maxstmp, withurl, furi, withjoin, searchthreads, searchtimeout)
+					getkeys, _, _, err := FileKeys(ndb, base, abs, msort, offset, limit, prefix, expression, recursive, stopfirst, minsize, maxsize, minstmp, maxstmp, withurl, furi, withjoin, nil, searchthreads, searchtimeout, searchmaxdirs, searchmaxresults)
+					if err == errMaxDirs || err == errMaxResults {
+
+						ctx.StatusCode(iris.StatusRequestEntityTooLarge)
+
+						if log4xx {
+							getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 413 | Search limits exceeded error | Path [%s] | %v", vhost, ip, abs, err)
+						}
+
+						if debugmode {
+
+							_, err = ctx.Writef("[ERRO] Search limits exceeded error | %v\n", err)
+							if err != nil {
+								getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+							}
+
+						}
+
+						return
+
+					}
+
 					if err != nil {
 
 						ctx.StatusCode(iris.StatusInternalServerError)
@@ -2251,7 +3159,7 @@ func ZDGet(cache *freecache.Cache, ndb *nutsdb.DB, wg *sync.WaitGroup) iris.Hand
 
 					if search && getcache && expire >= 0 {
 
-						err = cache.Set(vchash, rbytes, expire)
+						err = CacheSet(cache, vchash, rbytes, expire, croots, cseq)
 						if err != nil {
 							vcerr = "1"
 							errmsg = fmt.Sprintf("%v", err)
@@ -2301,7 +3209,28 @@ func ZDGet(cache *freecache.Cache, ndb *nutsdb.DB, wg *sync.WaitGroup) iris.Hand
 
 				if DirExists(abs) {
 
-					getkeys, err := DBKeys(ndb, base, abs, msort, offset, limit, prefix, expression, recursive, stopfirst, minsize, maxsize, minstmp, maxstmp, withurl, furi, withjoin, searchthreads, searchtimeout)
+					getkeys, err := DBKeys(ndb, base, abs, msort, offset, limit, prefix, expression, recursive, stopfirst, minsize, maxsize, minstmp, maxstmp, withurl, furi, withjoin, nil, searchthreads, searchtimeout, searchmaxdirs, searchmaxresults)
+					if err == errMaxDirs || err == errMaxResults {
+
+						ctx.StatusCode(iris.StatusRequestEntityTooLarge)
+
+						if log4xx {
+							getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 413 | Search limits exceeded error | Path [%s] | %v", vhost, ip, abs, err)
+						}
+
+						if debugmode {
+
+							_, err = ctx.Writef("[ERRO] Search limits exceeded error | %v\n", err)
+							if err != nil {
+								getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+							}
+
+						}
+
+						return
+
+					}
+
 					if err != nil {
 
 						ctx.StatusCode(iris.StatusInternalServerError)
@@ -2375,7 +3304,130 @@ func ZDGet(cache *freecache.Cache, ndb *nutsdb.DB, wg *sync.WaitGroup) iris.Hand
 
 					if search && getcache && expire >= 0 {
 
-						err = cache.Set(vchash, rbytes, expire)
+						err = CacheSet(cache, vchash, rbytes, expire, croots, cseq)
+						if err != nil {
+							vcerr = "1"
+							errmsg = fmt.Sprintf("%v", err)
+							getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 599 | Write search results to cache error | Path [%s] | %v", vhost, ip, abs, err)
+						}
+
+					}
+
+					ctx.Header("Errcache", vcerr)
+					ctx.Header("Errmsg", errmsg)
+
+					_, err = ctx.Write(rbytes)
+					if err != nil {
+
+						if log4xx {
+							getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+						}
+
+					}
+
+					return
+
+				}
+
+				ctx.StatusCode(iris.StatusNotFound)
+
+				if log4xx {
+					getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 404 | Not found | Path [%s]", vhost, ip, abs)
+				}
+
+				if debugmode {
+
+					_, err = ctx.WriteString("[ERRO] Can`t find directory error\n")
+					if err != nil {
+						getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+					}
+
+				}
+
+				return
+
+			}
+
+			// Standart/Bolt Stats
+
+			istrue, ccnm = StringOne(hstats, hstatsfiles, hstatsarchives)
+
+			if istrue {
+
+				if DirExists(abs) {
+
+					getstats, err := StatKeys(ndb, base, abs, prefix, expression, recursive, minsize, maxsize, minstmp, maxstmp, withjoin, ccnm != 3, ccnm != 2, groupdepth, histdate, histsize, histlog, searchthreads, searchtimeout, searchmaxdirs)
+					if err == errMaxDirs {
+
+						ctx.StatusCode(iris.StatusRequestEntityTooLarge)
+
+						if log4xx {
+							getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 413 | Search limits exceeded error | Path [%s] | %v", vhost, ip, abs, err)
+						}
+
+						if debugmode {
+
+							_, err = ctx.Writef("[ERRO] Search limits exceeded error | %v\n", err)
+							if err != nil {
+								getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+							}
+
+						}
+
+						return
+
+					}
+
+					if err != nil {
+
+						ctx.StatusCode(iris.StatusInternalServerError)
+						getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 500 | Can`t aggregate files and keys in directory error | Path [%s] | %v", vhost, ip, abs, err)
+
+						if debugmode {
+
+							_, err = ctx.WriteString("[ERRO] Can`t aggregate files and keys in directory error\n")
+							if err != nil {
+								getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+							}
+
+						}
+
+						return
+
+					}
+
+					allstats := ""
+
+					if hjson == "1" {
+						jstats, _ := json.Marshal(getstats)
+						allstats = fmt.Sprintf("{\"stats\": %s}", string(jstats))
+					} else {
+
+						var sgetstats []string
+
+						for _, vs := range getstats {
+							sgetstats = append(sgetstats, fmt.Sprintf("%s %d %d %d %d %d %d %d %d", vs.Path, vs.Bytes, vs.Files, vs.Archives, vs.MinSize, vs.MaxSize, vs.AvgSize, vs.MinDate, vs.MaxDate))
+						}
+
+						allstats = strings.Join(sgetstats, "\n")
+
+					}
+
+					rbytes := []byte(allstats)
+
+					conttype := http.DetectContentType(rbytes)
+					hsize := fmt.Sprintf("%d", len(rbytes))
+					scctrl := fmt.Sprintf("max-age=%d", cctrl)
+
+					ctx.Header("Content-Type", conttype)
+					ctx.Header("Content-Length", hsize)
+					ctx.Header("Cache-Control", scctrl)
+
+					ctx.Header("Hitcache", "0")
+
+					if search && getcache && expire >= 0 {
+
+						err = CacheSet(cache, vchash, rbytes, expire, croots, cseq)
 						if err != nil {
 							vcerr = "1"
 							errmsg = fmt.Sprintf("%v", err)
@@ -2976,6 +4028,11 @@ func ZDGet(cache *freecache.Cache, ndb *nutsdb.DB, wg *sync.WaitGroup) iris.Hand
 
 		if fromfile == "1" {
 
+			if OriginHost(vhost) != nil && (method == "GET" || method == "HEAD") && !OriginTried(ctx) {
+				OriginPull(ctx, cache, wg, log4xx)
+				return
+			}
+
 			ctx.StatusCode(iris.StatusNotFound)
 
 			if log4xx {
@@ -2997,6 +4054,11 @@ func ZDGet(cache *freecache.Cache, ndb *nutsdb.DB, wg *sync.WaitGroup) iris.Hand
 
 		if dir == "/" && dbn == "/" {
 
+			if OriginHost(vhost) != nil && (method == "GET" || method == "HEAD") && !OriginTried(ctx) {
+				OriginPull(ctx, cache, wg, log4xx)
+				return
+			}
+
 			ctx.StatusCode(iris.StatusNotFound)
 
 			if log4xx {
@@ -3018,6 +4080,11 @@ func ZDGet(cache *freecache.Cache, ndb *nutsdb.DB, wg *sync.WaitGroup) iris.Hand
 
 		if !FileExists(dbf) {
 
+			if OriginHost(vhost) != nil && (method == "GET" || method == "HEAD") && !OriginTried(ctx) {
+				OriginPull(ctx, cache, wg, log4xx)
+				return
+			}
+
 			ctx.StatusCode(iris.StatusNotFound)
 
 			if log4xx {
@@ -3188,6 +4255,11 @@ func ZDGet(cache *freecache.Cache, ndb *nutsdb.DB, wg *sync.WaitGroup) iris.Hand
 
 		if keyexists == "" {
 
+			if OriginHost(vhost) != nil && (method == "GET" || method == "HEAD") && !OriginTried(ctx) {
+				OriginPull(ctx, cache, wg, log4xx)
+				return
+			}
+
 			ctx.StatusCode(iris.StatusNotFound)
 
 			if log4xx {
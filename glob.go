@@ -0,0 +1,144 @@
+/*
+
+Copyright © 2020 Andrey Kuvshinov. Contacts: <syslinux@protonmail.com>
+Copyright © 2020 Eltaline OU. Contacts: <eltaline.ou@gmail.com>
+All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+The wZD project contains unmodified/modified libraries imports too with
+separate copyright notices and license terms. Your use of the source code
+this libraries is subject to the terms and conditions of licenses these libraries.
+
+*/
+
+package main
+
+import (
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Glob Helpers
+
+// GlobRegex : split glob pattern to literal prefix and anchored regular expression for the rest of name
+func GlobRegex(glob string) (literal string, rest string, err error) {
+
+	_, err = filepath.Match(glob, "")
+	if err != nil {
+		return "", "", err
+	}
+
+	i := strings.IndexAny(glob, "*?[\\")
+	if i < 0 {
+		return glob, "^$", nil
+	}
+
+	literal = glob[:i]
+
+	var rgx strings.Builder
+
+	rgx.WriteString("^")
+
+	for i < len(glob) {
+
+		c := glob[i]
+
+		switch c {
+		case '*':
+			rgx.WriteString("[^/]*")
+		case '?':
+			rgx.WriteString("[^/]")
+		case '\\':
+			i++
+			if i < len(glob) {
+				rgx.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+			}
+		case '[':
+
+			j := i + 1
+
+			rgx.WriteString("[")
+
+			if j < len(glob) && glob[j] == '^' {
+				rgx.WriteString("^")
+				j++
+			}
+
+			// Closing bracket as the first character of class is a bad pattern like in filepath.Match, not a literal
+
+			if j < len(glob) && glob[j] == ']' {
+				return "", "", filepath.ErrBadPattern
+			}
+
+			for j < len(glob) && glob[j] != ']' {
+
+				escaped := false
+
+				if glob[j] == '\\' && j+1 < len(glob) {
+					escaped = true
+					j++
+				}
+
+				switch {
+				case glob[j] == '-' && escaped:
+					rgx.WriteString("\\-")
+				case glob[j] == '-':
+					rgx.WriteString("-")
+				default:
+					rgx.WriteString(regexp.QuoteMeta(glob[j : j+1]))
+				}
+
+				j++
+
+			}
+
+			rgx.WriteString("]")
+
+			i = j
+
+		default:
+			rgx.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		}
+
+		i++
+
+	}
+
+	rgx.WriteString("$")
+
+	_, err = regexp.Compile(rgx.String())
+	if err != nil {
+		return "", "", err
+	}
+
+	return literal, rgx.String(), nil
+
+}
+
+// GlobSearch : prefix and regular expression for prefix search scan by glob pattern, literal prefix of glob is pushed down into the prefix scan if case sensitive
+func GlobSearch(glob string, ignorecase bool) (prefix string, expression string, err error) {
+
+	literal, rest, err := GlobRegex(glob)
+	if err != nil {
+		return "", "", err
+	}
+
+	if ignorecase {
+		return "", "(?i)^" + regexp.QuoteMeta(literal) + strings.TrimPrefix(rest, "^"), nil
+	}
+
+	return literal, rest, nil
+
+}
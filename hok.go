@@ -0,0 +1,508 @@
+/*
+
+Copyright © 2020 Andrey Kuvshinov. Contacts: <syslinux@protonmail.com>
+Copyright © 2020 Eltaline OU. Contacts: <eltaline.ou@gmail.com>
+All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+The wZD project contains unmodified/modified libraries imports too with
+separate copyright notices and license terms. Your use of the source code
+this libraries is subject to the terms and conditions of licenses these libraries.
+
+*/
+
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/eltaline/nutsdb"
+	"hash/crc64"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Webhook Handlers
+
+const hookmindelay = 1 * time.Second
+const hookmaxdelay = 10 * time.Minute
+
+// HookOpen : open webhooks db and load queues of webhook targets of all virtual hosts, db is not opened without webhook targets
+func HookOpen() (*nutsdb.DB, error) {
+
+	var hdb *nutsdb.DB
+
+	for _, Server := range config.Server {
+
+		for _, Webhook := range Server.WEBHOOK {
+
+			if hdb == nil {
+
+				if !DirExists(hookdir) {
+
+					err := os.MkdirAll(hookdir, 0700)
+					if err != nil {
+						return nil, err
+					}
+
+				}
+
+				hopt := nutsdb.DefaultOptions
+				hopt.Dir = hookdir
+				hopt.EntryIdxMode = nutsdb.HintKeyAndRAMIdxMode
+				hopt.SegmentSize = 67108864
+				hopt.NodeNum = 1
+				hopt.StartFileLoadingMode = nutsdb.MMap
+
+				hopt.RWMode = nutsdb.FileIO
+				hopt.SyncEnable = true
+
+				db, err := nutsdb.Open(hopt)
+				if err != nil {
+					return nil, err
+				}
+
+				hdb = db
+
+			}
+
+			hook := &HookTarget{
+				DB:     hdb,
+				ID:     strconv.FormatUint(crc64.Checksum([]byte(Server.HOST+" "+Webhook.URL+" "+Webhook.PREFIX), ctbl64), 16),
+				Host:   Server.HOST,
+				URL:    Webhook.URL,
+				Prefix: Webhook.PREFIX,
+				Events: make(map[string]bool),
+				Secret: Webhook.SECRET,
+				First:  1,
+				Wake:   make(chan struct{}, 1),
+			}
+
+			for _, event := range strings.Split(Webhook.EVENTS, ",") {
+
+				if event != "" {
+					hook.Events[event] = true
+				}
+
+			}
+
+			_ = hdb.View(func(tx *nutsdb.Tx) error {
+
+				entry, err := tx.Get(hookbucket, []byte("s:"+hook.ID))
+				if err == nil && len(entry.Value) == 8 {
+					hook.Seq = binary.BigEndian.Uint64(entry.Value)
+				}
+
+				entry, err = tx.Get(hookbucket, []byte("f:"+hook.ID))
+				if err == nil && len(entry.Value) == 8 {
+					hook.First = binary.BigEndian.Uint64(entry.Value)
+				}
+
+				return nil
+
+			})
+
+			hhosts[Server.HOST] = append(hhosts[Server.HOST], hook)
+
+		}
+
+	}
+
+	return hdb, nil
+
+}
+
+// HookQueue : webhooks db bucket of queued events of webhook target
+func HookQueue(hook *HookTarget) string {
+	return "q:" + hook.ID
+}
+
+// HookDead : webhooks db bucket of undeliverable events of webhook target
+func HookDead(hook *HookTarget) string {
+	return "d:" + hook.ID
+}
+
+// HookMatch : path is equal to prefix of webhook target or is inside of it, /a/b prefix does not match /a/bc path
+func HookMatch(prefix string, path string) bool {
+	return path == prefix || strings.HasPrefix(path, strings.TrimSuffix(prefix, "/")+"/")
+}
+
+// HookAppend : enqueue event to all webhook targets of virtual host with matched path prefix and event type
+func HookAppend(vhost string, ev FeedEvent) error {
+
+	var herr error
+
+	for _, hook := range hhosts[vhost] {
+
+		if !HookMatch(hook.Prefix, ev.Path) {
+			continue
+		}
+
+		if len(hook.Events) > 0 && !hook.Events[ev.Op] {
+			continue
+		}
+
+		tval, err := json.Marshal(HookTask{Event: ev})
+		if err != nil {
+			return err
+		}
+
+		hook.Lock()
+
+		seq := hook.Seq + 1
+		key := FeedKey(seq)
+
+		err = hook.DB.Update(func(tx *nutsdb.Tx) error {
+
+			err := tx.Put(HookQueue(hook), key, tval, 0)
+			if err != nil {
+				return err
+			}
+
+			return tx.Put(hookbucket, []byte("s:"+hook.ID), key, 0)
+
+		})
+
+		if err == nil {
+			hook.Seq = seq
+		}
+
+		hook.Unlock()
+
+		if err != nil {
+			herr = err
+			continue
+		}
+
+		select {
+		case hook.Wake <- struct{}{}:
+		default:
+		}
+
+	}
+
+	return herr
+
+}
+
+// HookHead : first queued event of webhook target with its sequence number, false if queue is empty
+func HookHead(hook *HookTarget) (uint64, HookTask, bool, error) {
+
+	var task HookTask
+	var tseq uint64
+
+	hook.Lock()
+	first := hook.First
+	seq := hook.Seq
+	hook.Unlock()
+
+	if first > seq {
+		return 0, task, false, nil
+	}
+
+	end := first + feedbatch - 1
+	if end > seq {
+		end = seq
+	}
+
+	found := false
+
+	err := hook.DB.View(func(tx *nutsdb.Tx) error {
+
+		entries, err := tx.RangeScan(HookQueue(hook), FeedKey(first), FeedKey(end))
+
+		if entries == nil {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		for _, entry := range entries {
+
+			eseq := binary.BigEndian.Uint64(entry.Key)
+
+			if found && eseq > tseq {
+				continue
+			}
+
+			var etask HookTask
+
+			err = json.Unmarshal(entry.Value, &etask)
+			if err != nil {
+				return err
+			}
+
+			tseq = eseq
+			task = etask
+			found = true
+
+		}
+
+		return nil
+
+	})
+
+	if err != nil {
+		return 0, task, false, err
+	}
+
+	if !found {
+
+		// Missed events are skipped
+
+		err = HookDone(hook, end, nil)
+		if err != nil {
+			return 0, task, false, err
+		}
+
+		return HookHead(hook)
+
+	}
+
+	return tseq, task, true, nil
+
+}
+
+// HookDone : remove delivered or undeliverable event from queue of webhook target, undeliverable event is moved to dead-letter bucket
+func HookDone(hook *HookTarget, seq uint64, dead *HookTask) error {
+
+	hook.Lock()
+	first := hook.First
+	hook.Unlock()
+
+	err := hook.DB.Update(func(tx *nutsdb.Tx) error {
+
+		if dead != nil {
+
+			tval, err := json.Marshal(dead)
+			if err != nil {
+				return err
+			}
+
+			err = tx.Put(HookDead(hook), FeedKey(seq), tval, 0)
+			if err != nil {
+				return err
+			}
+
+		}
+
+		for dseq := first; dseq <= seq; dseq++ {
+			_ = tx.Delete(HookQueue(hook), FeedKey(dseq))
+		}
+
+		return tx.Put(hookbucket, []byte("f:"+hook.ID), FeedKey(seq+1), 0)
+
+	})
+
+	if err != nil {
+		return err
+	}
+
+	hook.Lock()
+	hook.First = seq + 1
+	hook.Unlock()
+
+	return nil
+
+}
+
+// HookRetry : save count of failed tries of first queued event of webhook target
+func HookRetry(hook *HookTarget, seq uint64, task HookTask) error {
+
+	tval, err := json.Marshal(task)
+	if err != nil {
+		return err
+	}
+
+	return NDBInsert(hook.DB, HookQueue(hook), FeedKey(seq), tval, 0)
+
+}
+
+// HookSign : hex encoded HMAC-SHA256 signature of webhook body
+func HookSign(secret string, body []byte) string {
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = mac.Write(body)
+
+	return hex.EncodeToString(mac.Sum(nil))
+
+}
+
+// HookSend : deliver event to webhook target by POST method, only 2xx status codes are successful
+func HookSend(client *http.Client, hook *HookTarget, seq uint64, ev FeedEvent) error {
+
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "wZD")
+	req.Header.Set("X-Wzd-Event", ev.Op)
+	req.Header.Set("X-Wzd-Delivery", fmt.Sprintf("%s-%d", hook.ID, seq))
+
+	if hook.Secret != "" {
+		req.Header.Set("X-Wzd-Signature", "sha256="+HookSign(hook.Secret, body))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook response status %d", resp.StatusCode)
+	}
+
+	return nil
+
+}
+
+// HookSleep : wait for new queued events of webhook target or for retry delay, interrupted by shutdown
+func HookSleep(hook *HookTarget, delay time.Duration) {
+
+	var deadline <-chan time.Time
+	var wake <-chan struct{}
+
+	switch {
+	case delay > 0:
+
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+
+		deadline = timer.C
+
+	default:
+		wake = hook.Wake
+	}
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for !shutdown {
+
+		select {
+		case <-wake:
+			return
+		case <-deadline:
+			return
+		case <-ticker.C:
+		}
+
+	}
+
+}
+
+// HookLoop : deliver queued events of webhook target in order with retries and exponential backoff, undeliverable events are moved to dead-letter bucket
+func HookLoop(hook *HookTarget, wg *sync.WaitGroup) {
+
+	// Loggers
+
+	appLogger, applogfile := AppLogger()
+	defer applogfile.Close()
+
+	client := &http.Client{Timeout: hooktimeout}
+
+	for !shutdown {
+
+		seq, task, ok, err := HookHead(hook)
+		if err != nil {
+			appLogger.Errorf("| Host [%s] | Webhook [%s] | Can`t read webhooks queue error | %v", hook.Host, hook.URL, err)
+			HookSleep(hook, hookmaxdelay)
+			continue
+		}
+
+		if !ok {
+			HookSleep(hook, 0)
+			continue
+		}
+
+		wg.Add(1)
+
+		err = HookSend(client, hook, seq, task.Event)
+		if err == nil {
+
+			err = HookDone(hook, seq, nil)
+			if err != nil {
+				appLogger.Errorf("| Host [%s] | Webhook [%s] | Can`t remove delivered event from webhooks queue error | Seq [%d] | %v", hook.Host, hook.URL, seq, err)
+			}
+
+			wg.Done()
+			continue
+
+		}
+
+		task.Tries++
+		task.Error = err.Error()
+
+		if task.Tries >= hooktries {
+
+			appLogger.Errorf("| Host [%s] | Webhook [%s] | Webhook event is moved to dead-letter bucket | Seq [%d] | Path [%s] | Tries [%d] | %v", hook.Host, hook.URL, seq, task.Event.Path, task.Tries, err)
+
+			err = HookDone(hook, seq, &task)
+			if err != nil {
+				appLogger.Errorf("| Host [%s] | Webhook [%s] | Can`t move event to dead-letter bucket error | Seq [%d] | %v", hook.Host, hook.URL, seq, err)
+			}
+
+			wg.Done()
+			continue
+
+		}
+
+		appLogger.Errorf("| Host [%s] | Webhook [%s] | Webhook delivery error | Seq [%d] | Path [%s] | Tries [%d] | %v", hook.Host, hook.URL, seq, task.Event.Path, task.Tries, err)
+
+		err = HookRetry(hook, seq, task)
+		if err != nil {
+			appLogger.Errorf("| Host [%s] | Webhook [%s] | Can`t save webhook delivery tries error | Seq [%d] | %v", hook.Host, hook.URL, seq, err)
+		}
+
+		wg.Done()
+
+		delay := hookmaxdelay
+
+		if task.Tries < 30 {
+
+			delay = hookmindelay << uint(task.Tries-1)
+			if delay > hookmaxdelay {
+				delay = hookmaxdelay
+			}
+
+		}
+
+		HookSleep(hook, delay)
+
+	}
+
+}
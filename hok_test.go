@@ -0,0 +1,353 @@
+/*
+
+Copyright © 2020 Andrey Kuvshinov. Contacts: <syslinux@protonmail.com>
+Copyright © 2020 Eltaline OU. Contacts: <eltaline.ou@gmail.com>
+All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+The wZD project contains unmodified/modified libraries imports too with
+separate copyright notices and license terms. Your use of the source code
+this libraries is subject to the terms and conditions of licenses these libraries.
+
+*/
+
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/eltaline/nutsdb"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Webhook Tests
+
+// HookTestOpen : webhooks db in temporary directory with one webhook target of virtual host localhost
+func HookTestOpen(t *testing.T, dir string, url string, secret string) (*nutsdb.DB, *HookTarget) {
+
+	hookdir = dir + "/hook"
+	logdir = dir
+	logmode = 0640
+
+	hhosts = make(map[string][]*HookTarget)
+
+	config.Server = map[string]server{
+		"localhost": {HOST: "localhost", WEBHOOK: []webhook{{URL: url, SECRET: secret}}},
+	}
+
+	hdb, err := HookOpen()
+	if err != nil {
+		t.Fatalf("open webhooks db error: %v", err)
+	}
+
+	if len(hhosts["localhost"]) != 1 {
+		t.Fatalf("webhook targets %d, want 1", len(hhosts["localhost"]))
+	}
+
+	return hdb, hhosts["localhost"][0]
+
+}
+
+func TestHookSignature(t *testing.T) {
+
+	secret := "0123456789abcdef"
+
+	ev := FeedEvent{Seq: 7, Host: "localhost", Path: "/test/a.txt", Op: "create", Size: 3}
+
+	var got FeedEvent
+	var verified bool
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		body, _ := ioutil.ReadAll(r.Body)
+
+		mac := hmac.New(sha256.New, []byte(secret))
+		_, _ = mac.Write(body)
+
+		verified = hmac.Equal([]byte(r.Header.Get("X-Wzd-Signature")), []byte("sha256="+hex.EncodeToString(mac.Sum(nil))))
+
+		if r.Header.Get("X-Wzd-Event") != ev.Op {
+			t.Errorf("event header %q, want %q", r.Header.Get("X-Wzd-Event"), ev.Op)
+		}
+
+		_ = json.Unmarshal(body, &got)
+
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+
+	}))
+	defer srv.Close()
+
+	hook := &HookTarget{ID: "test", URL: srv.URL, Secret: secret}
+
+	err := HookSend(srv.Client(), hook, 1, ev)
+	if err != nil {
+		t.Fatalf("send error: %v", err)
+	}
+
+	if !verified {
+		t.Errorf("signature of body is not verified by receiver")
+	}
+
+	if got != ev {
+		t.Errorf("received event %+v, want %+v", got, ev)
+	}
+
+	hook.Secret = "fedcba9876543210"
+
+	err = HookSend(srv.Client(), hook, 2, ev)
+	if err != nil {
+		t.Fatalf("send error: %v", err)
+	}
+
+	if verified {
+		t.Errorf("signature with other secret is verified by receiver")
+	}
+
+	hook.URL = srv.URL + "/fail"
+
+	err = HookSend(srv.Client(), hook, 3, ev)
+	if err == nil {
+		t.Errorf("send to failed receiver is successful")
+	}
+
+}
+
+func TestHookMatch(t *testing.T) {
+
+	tests := []struct {
+		prefix string
+		path   string
+		want   bool
+	}{
+		{"", "/a/b.txt", true},
+		{"/", "/a/b.txt", true},
+		{"/a", "/a", true},
+		{"/a", "/a/b.txt", true},
+		{"/a/", "/a/b.txt", true},
+		{"/a/b", "/a/b/c/d.txt", true},
+		{"/a/b", "/a/bc/d.txt", false},
+		{"/a/b", "/a/bc", false},
+		{"/a/b", "/a", false},
+	}
+
+	for _, tt := range tests {
+
+		if got := HookMatch(tt.prefix, tt.path); got != tt.want {
+			t.Errorf("prefix %q path %q: match %v, want %v", tt.prefix, tt.path, got, tt.want)
+		}
+
+	}
+
+}
+
+func TestHookQueue(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "wzd-hook")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	hdb, hook := HookTestOpen(t, dir, "http://127.0.0.1:1/", "")
+
+	paths := []string{"/test/a.txt", "/test/b.txt", "/test/c.txt"}
+
+	for _, path := range paths {
+
+		err = HookAppend("localhost", FeedEvent{Host: "localhost", Path: path, Op: "create"})
+		if err != nil {
+			t.Fatalf("append error: %v", err)
+		}
+
+	}
+
+	// Events are delivered in order of queue, next event is available only after previous one is done
+
+	for i, path := range paths[:2] {
+
+		seq, task, ok, err := HookHead(hook)
+		if err != nil || !ok {
+			t.Fatalf("head %d: ok %t, error %v", i, ok, err)
+		}
+
+		if seq != uint64(i+1) || task.Event.Path != path {
+			t.Fatalf("head %d: seq %d path %s, want seq %d path %s", i, seq, task.Event.Path, i+1, path)
+		}
+
+		seq2, _, _, _ := HookHead(hook)
+		if seq2 != seq {
+			t.Fatalf("head %d: repeated head seq %d, want %d", i, seq2, seq)
+		}
+
+		err = HookDone(hook, seq, nil)
+		if err != nil {
+			t.Fatalf("done error: %v", err)
+		}
+
+	}
+
+	// Queue positions are kept after reopen
+
+	err = hdb.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hdb, hook = HookTestOpen(t, dir, "http://127.0.0.1:1/", "")
+	defer hdb.Close()
+
+	if hook.First != 3 || hook.Seq != 3 {
+		t.Fatalf("reopened queue first %d seq %d, want 3 and 3", hook.First, hook.Seq)
+	}
+
+	seq, task, ok, err := HookHead(hook)
+	if err != nil || !ok || seq != 3 || task.Event.Path != paths[2] {
+		t.Fatalf("head after reopen: seq %d path %s ok %t error %v", seq, task.Event.Path, ok, err)
+	}
+
+	err = HookDone(hook, seq, nil)
+	if err != nil {
+		t.Fatalf("done error: %v", err)
+	}
+
+	_, _, ok, err = HookHead(hook)
+	if err != nil || ok {
+		t.Fatalf("head of empty queue: ok %t error %v", ok, err)
+	}
+
+}
+
+func TestHookDeadLetter(t *testing.T) {
+
+	var mu sync.Mutex
+	var hits []time.Time
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		mu.Lock()
+		hits = append(hits, time.Now())
+		mu.Unlock()
+
+		w.WriteHeader(http.StatusInternalServerError)
+
+	}))
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "wzd-hook")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	hdb, hook := HookTestOpen(t, dir, srv.URL, "")
+	defer hdb.Close()
+
+	otries := hooktries
+	hooktries = 2
+
+	defer func() {
+		hooktries = otries
+		lsht.Lock()
+		shutdown = false
+		lsht.Unlock()
+	}()
+
+	err = HookAppend("localhost", FeedEvent{Host: "localhost", Path: "/test/a.txt", Op: "delete"})
+	if err != nil {
+		t.Fatalf("append error: %v", err)
+	}
+
+	var wg sync.WaitGroup
+
+	done := make(chan struct{})
+
+	go func() {
+		HookLoop(hook, &wg)
+		close(done)
+	}()
+
+	deadline := time.Now().Add(10 * time.Second)
+
+	for {
+
+		hook.Lock()
+		first := hook.First
+		hook.Unlock()
+
+		if first > 1 {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("event is not moved to dead-letter bucket")
+		}
+
+		time.Sleep(50 * time.Millisecond)
+
+	}
+
+	lsht.Lock()
+	shutdown = true
+	lsht.Unlock()
+
+	<-done
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if len(hits) != hooktries {
+		t.Fatalf("delivery tries %d, want %d", len(hits), hooktries)
+	}
+
+	if gap := hits[1].Sub(hits[0]); gap < hookmindelay*9/10 {
+		t.Errorf("retry after %v, want backoff of at least %v", gap, hookmindelay)
+	}
+
+	var task HookTask
+
+	err = hdb.View(func(tx *nutsdb.Tx) error {
+
+		entry, err := tx.Get(HookDead(hook), FeedKey(1))
+		if err != nil {
+			return err
+		}
+
+		return json.Unmarshal(entry.Value, &task)
+
+	})
+
+	if err != nil {
+		t.Fatalf("read dead-letter bucket error: %v", err)
+	}
+
+	if task.Tries != hooktries || task.Error == "" || task.Event.Path != "/test/a.txt" {
+		t.Errorf("dead-letter task %+v, want %d tries with error", task, hooktries)
+	}
+
+	_, _, ok, err := HookHead(hook)
+	if err != nil || ok {
+		t.Errorf("queue is not empty after dead-letter: ok %t error %v", ok, err)
+	}
+
+}
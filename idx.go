@@ -0,0 +1,996 @@
+/*
+
+Copyright © 2020 Andrey Kuvshinov. Contacts: <syslinux@protonmail.com>
+Copyright © 2020 Eltaline OU. Contacts: <eltaline.ou@gmail.com>
+All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+The wZD project contains unmodified/modified libraries imports too with
+separate copyright notices and license terms. Your use of the source code
+this libraries is subject to the terms and conditions of licenses these libraries.
+
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/coocood/freecache"
+	"github.com/eltaline/bolt"
+	"github.com/eltaline/mmutex"
+	"github.com/eltaline/nutsdb"
+	"github.com/kataras/iris/v12"
+	"hash/crc64"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Reindex Handlers
+
+const reindexbatch = 1024
+
+var errShutdown = errors.New("server shutdown in progress")
+
+var errStopped = errors.New("stopped by admin request")
+
+// ZDReindex : POST method with Reindex header, reconciliation of search db with real directories and archives through requested directory
+func ZDReindex(cache *freecache.Cache, keymutex *mmutex.Mutex, wg *sync.WaitGroup) iris.Handler {
+	return func(ctx iris.Context) {
+		defer wg.Done()
+
+		var err error
+
+		// Wait Group
+
+		wg.Add(1)
+
+		// Loggers
+
+		putLogger, putlogfile := PutLogger()
+		defer putlogfile.Close()
+
+		// Shutdown
+
+		if shutdown {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			return
+		}
+
+		// Headers
+
+		ip := ctx.RemoteAddr()
+		cip := net.ParseIP(ip)
+		vhost := strings.Split(ctx.Host(), ":")[0]
+
+		// Search DB of virtual host
+
+		ndb, search := SearchHost(vhost)
+
+		uri := ctx.Path()
+
+		hrecursive := ctx.GetHeader("Recursive")
+		hwait := ctx.GetHeader("Wait")
+
+		badhost := true
+		badip := true
+
+		base := "/notfound"
+
+		reindex := false
+		reindexrate := 0
+
+		opentries := 5
+		locktimeout := 5
+
+		filemode := os.FileMode(0640)
+
+		log4xx := true
+
+		for _, Server := range config.Server {
+
+			if vhost == Server.HOST {
+
+				badhost = false
+
+				base = filepath.Clean(Server.ROOT)
+
+				for _, Vhost := range AllowPut() {
+
+					if vhost == Vhost.Vhost {
+
+						for _, CIDR := range Vhost.CIDR {
+							_, ipnet, _ := net.ParseCIDR(CIDR.Addr)
+							if ipnet.Contains(cip) {
+								badip = false
+								break
+							}
+						}
+
+						break
+
+					}
+
+				}
+
+				reindex = Server.REINDEX
+				reindexrate = Server.REINDEXRATE
+
+				opentries = Server.OPENTRIES
+				locktimeout = Server.LOCKTIMEOUT
+
+				cfilemode, err := strconv.ParseUint(fmt.Sprintf("%d", Server.FILEMODE), 8, 32)
+				switch {
+				case err != nil || cfilemode == 0:
+					filemode = os.FileMode(0640)
+				default:
+					filemode = os.FileMode(cfilemode)
+				}
+
+				log4xx = Server.LOG4XX
+
+				break
+
+			}
+
+		}
+
+		if badhost {
+
+			ctx.StatusCode(iris.StatusMisdirectedRequest)
+
+			if log4xx {
+				putLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 421 | Not found configured virtual host", vhost, ip)
+			}
+
+			if debugmode {
+
+				_, err = ctx.Writef("[ERRO] Not found configured virtual host | Virtual Host [%s]\n", vhost)
+				if err != nil {
+					putLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+				}
+
+			}
+
+			return
+
+		}
+
+		if badip {
+
+			ctx.StatusCode(iris.StatusForbidden)
+
+			if log4xx {
+				putLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 403 | Forbidden", vhost, ip)
+			}
+
+			if debugmode {
+
+				_, err = ctx.Writef("[ERRO] Not found allowed ip | Virtual Host [%s]\n", vhost)
+				if err != nil {
+					putLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+				}
+
+			}
+
+			return
+
+		}
+
+		if !search || !reindex {
+
+			ctx.StatusCode(iris.StatusForbidden)
+
+			if log4xx {
+				putLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 403 | The reindex request is not allowed during POST request", vhost, ip)
+			}
+
+			if debugmode {
+
+				_, err = ctx.WriteString("[ERRO] The reindex request is not allowed during POST request\n")
+				if err != nil {
+					putLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+				}
+
+			}
+
+			return
+
+		}
+
+		depth := -1
+
+		if hrecursive != "" {
+
+			depth, err = strconv.Atoi(hrecursive)
+			if err != nil || depth < 0 {
+
+				ctx.StatusCode(iris.StatusBadRequest)
+
+				if log4xx {
+					putLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 400 | Recursive header must be a non-negative number during reindex request | Recursive [%s]", vhost, ip, hrecursive)
+				}
+
+				if debugmode {
+
+					_, err = ctx.WriteString("[ERRO] Recursive header must be a non-negative number during reindex request\n")
+					if err != nil {
+						putLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+					}
+
+				}
+
+				return
+
+			}
+
+		}
+
+		abs := filepath.Clean(base + uri)
+
+		if !DirExists(abs) {
+
+			ctx.StatusCode(iris.StatusNotFound)
+
+			if log4xx {
+				putLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 404 | Not found | Path [%s]", vhost, ip, abs)
+			}
+
+			if debugmode {
+
+				_, err = ctx.WriteString("[ERRO] Can`t find reindex directory error\n")
+				if err != nil {
+					putLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+				}
+
+			}
+
+			return
+
+		}
+
+		if !keymutex.TryLock("reindex") {
+
+			ctx.StatusCode(iris.StatusConflict)
+
+			if log4xx {
+				putLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 409 | Reindex is already in progress error | Path [%s]", vhost, ip, abs)
+			}
+
+			if debugmode {
+
+				_, err = ctx.WriteString("[ERRO] Reindex is already in progress error\n")
+				if err != nil {
+					putLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+				}
+
+			}
+
+			return
+
+		}
+
+		timeout := time.Duration(locktimeout) * time.Second
+
+		if hwait != "1" {
+
+			wg.Add(1)
+
+			go func() {
+				defer wg.Done()
+				defer keymutex.UnLock("reindex")
+
+				_, _ = Reindex(cache, ndb, base, abs, depth, reindexrate, filemode, timeout, opentries)
+
+			}()
+
+			ctx.StatusCode(iris.StatusAccepted)
+			return
+
+		}
+
+		rs, err := Reindex(cache, ndb, base, abs, depth, reindexrate, filemode, timeout, opentries)
+		keymutex.UnLock("reindex")
+
+		if err != nil {
+
+			ctx.StatusCode(iris.StatusInternalServerError)
+			putLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 500 | Can`t reindex directory error | Path [%s] | %v", vhost, ip, abs, err)
+
+			if debugmode {
+
+				_, err = ctx.WriteString("[ERRO] Can`t reindex directory error\n")
+				if err != nil {
+					putLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+				}
+
+			}
+
+			return
+
+		}
+
+		rbytes, _ := json.Marshal(rs)
+
+		ctx.Header("Content-Type", "application/json")
+		ctx.Header("Content-Length", fmt.Sprintf("%d", len(rbytes)))
+
+		_, err = ctx.Write(rbytes)
+		if err != nil {
+
+			if log4xx {
+				putLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+			}
+
+		}
+
+	}
+
+}
+
+// Reindex : reconcile search db entries and radix tree with real directories and archives through requested directory, throttled by directories per second, negative depth is unlimited
+func Reindex(cache *freecache.Cache, ndb *nutsdb.DB, base string, dirpath string, depth int, rate int, filemode os.FileMode, timeout time.Duration, opentries int) (ReindexStats, error) {
+
+	var rs ReindexStats
+
+	// Loggers
+
+	appLogger, applogfile := AppLogger()
+	defer applogfile.Close()
+
+	dirpath = filepath.Clean(dirpath)
+
+	rs.Path = strings.TrimPrefix(dirpath, base)
+
+	if rs.Path == "" {
+		rs.Path = "/"
+	}
+
+	appLogger.Infof("| Reindex started | Path [%s] | Depth [%d] | Rate [%d]", dirpath, depth, rate)
+
+	atomic.StoreInt32(&reindexstop, 0)
+
+	spc := strings.Count(dirpath, "/")
+
+	var dirs []string
+
+	err := filepath.Walk(dirpath, func(path string, info os.FileInfo, err error) error {
+
+		if err != nil {
+
+			if os.IsNotExist(err) {
+				return nil
+			}
+
+			return err
+
+		}
+
+		if !info.IsDir() {
+			return nil
+		}
+
+		if depth >= 0 && strings.Count(path, "/")-spc > depth {
+			return filepath.SkipDir
+		}
+
+		dirs = append(dirs, path)
+
+		return nil
+
+	})
+
+	if err != nil {
+		appLogger.Errorf("| Reindex walk directories error | Path [%s] | %v", dirpath, err)
+		return rs, err
+	}
+
+	// Stale Directories
+
+	err = ReindexStale(cache, ndb, dirpath, depth, &rs)
+	if err != nil {
+		return rs, err
+	}
+
+	// Real Directories
+
+	var tick *time.Ticker
+
+	if rate > 0 {
+		tick = time.NewTicker(time.Second / time.Duration(rate))
+		defer tick.Stop()
+	}
+
+	for _, dirname := range dirs {
+
+		if shutdown {
+			appLogger.Warnf("| Reindex interrupted by shutdown | Path [%s] | Dirs [%d]", dirpath, rs.Dirs)
+			return rs, errShutdown
+		}
+
+		if atomic.LoadInt32(&reindexstop) == 1 {
+			appLogger.Warnf("| Reindex stopped by admin request | Path [%s] | Dirs [%d]", dirpath, rs.Dirs)
+			return rs, errStopped
+		}
+
+		if tick != nil {
+			<-tick.C
+		}
+
+		changed, err := ReindexDir(ndb, dirname, filemode, timeout, opentries, &rs)
+
+		rs.Dirs++
+
+		if err != nil {
+			rs.Errors++
+			appLogger.Errorf("| Reindex directory error | Path [%s] | %v", dirname, err)
+			continue
+		}
+
+		if changed {
+			CacheInvalidate(cache, dirname)
+		}
+
+	}
+
+	appLogger.Infof("| Reindex finished | Path [%s] | Dirs [%d] | Added [%d] | Updated [%d] | Deleted [%d] | Stale [%d] | Errors [%d]", dirpath, rs.Dirs, rs.Added, rs.Updated, rs.Deleted, rs.Stale, rs.Errors)
+
+	return rs, nil
+
+}
+
+// ReindexStale : delete from radix tree and search db directories which no longer exist through requested directory, negative depth is unlimited
+func ReindexStale(cache *freecache.Cache, ndb *nutsdb.DB, dirpath string, depth int, rs *ReindexStats) error {
+
+	// Loggers
+
+	appLogger, applogfile := AppLogger()
+	defer applogfile.Close()
+
+	dirpath = filepath.Clean(dirpath)
+
+	spc := strings.Count(dirpath, "/")
+
+	var sdirs []string
+
+	bdir := []byte(dirpath)
+
+	radix.RLock()
+	tree.Root().WalkPrefix(bdir, func(sdir []byte, dcrc interface{}) bool {
+
+		if len(sdir) > len(bdir) && sdir[len(bdir)] != '/' {
+			return false
+		}
+
+		if depth >= 0 && bytes.Count(sdir, bslash)-spc > depth {
+			return false
+		}
+
+		sdirs = append(sdirs, string(sdir))
+
+		return false
+
+	})
+	radix.RUnlock()
+
+	for _, sdir := range sdirs {
+
+		if shutdown {
+			return errShutdown
+		}
+
+		if DirExists(sdir) {
+			continue
+		}
+
+		err := TreeDelete(ndb, sdir)
+		if err != nil {
+			rs.Errors++
+			appLogger.Errorf("| Reindex delete stale directory from search tree db error | Path [%s] | %v", sdir, err)
+			continue
+		}
+
+		nbucket := strconv.FormatUint(crc64.Checksum([]byte(sdir), ctbl64), 16)
+
+		var sdeleted uint64
+
+		err = ndb.Update(func(tx *nutsdb.Tx) error {
+
+			err := FTDeleteDirTx(tx, nbucket)
+			if err != nil {
+				return err
+			}
+
+			err = JXDeleteDirTx(tx, nbucket)
+			if err != nil {
+				return err
+			}
+
+			entries, err := tx.GetAll(nbucket)
+
+			if entries == nil {
+				return nil
+			}
+
+			if err != nil {
+				return err
+			}
+
+			for _, entry := range entries {
+
+				err = tx.Delete(nbucket, entry.Key)
+				if err != nil {
+					return err
+				}
+
+			}
+
+			sdeleted = uint64(len(entries))
+
+			return nil
+
+		})
+
+		if err != nil {
+			rs.Errors++
+			appLogger.Errorf("| Reindex delete stale directory from search db error | Path [%s] | Bucket [%s] | %v", sdir, nbucket, err)
+			continue
+		}
+
+		rs.Deleted += sdeleted
+		rs.Stale++
+
+		CacheInvalidate(cache, sdir)
+
+	}
+
+	return nil
+
+}
+
+// ReindexDir : reconcile search db entries of one directory, entries changed by concurrent writers since snapshot are left as is
+func ReindexDir(ndb *nutsdb.DB, dirname string, filemode os.FileMode, timeout time.Duration, opentries int, rs *ReindexStats) (bool, error) {
+
+	type change struct {
+		key   string
+		value []byte
+		add   bool
+	}
+
+	dcrc := crc64.Checksum([]byte(dirname), ctbl64)
+	nbucket := strconv.FormatUint(dcrc, 16)
+
+	err := TreeInsert(ndb, dirname, dcrc)
+	if err != nil {
+		return false, err
+	}
+
+	// Actual entries snapshot must be taken before reading of directory and archives
+
+	actual := make(map[string][]byte)
+
+	err = ndb.View(func(tx *nutsdb.Tx) error {
+
+		entries, err := tx.GetAll(nbucket)
+
+		if entries == nil {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		for _, entry := range entries {
+			actual[string(entry.Key)] = entry.Value
+		}
+
+		return nil
+
+	})
+
+	if err != nil {
+		return false, err
+	}
+
+	// Full-text and JSON indexes of added and changed archive keys are updated from values read during reading of archives
+
+	var index func(key string, ev RawKeysData, value []byte) error
+
+	ftopts, jxfields := ReindexValues(dirname)
+
+	if ftopts != nil || jxfields != nil {
+
+		index = func(key string, ev RawKeysData, value []byte) error {
+
+			if av, ok := actual["b:"+key]; ok {
+
+				var ov RawKeysData
+
+				err := binary.Read(bytes.NewReader(av), Endian, &ov)
+				if err == nil && ReindexEqual(ov, ev) {
+					return nil
+				}
+
+			}
+
+			if ftopts != nil {
+
+				err := FTIndex(ndb, nbucket, key, value, ftopts)
+				if err != nil {
+					return err
+				}
+
+			}
+
+			if jxfields != nil {
+
+				err := JXIndex(ndb, nbucket, key, value, jxfields)
+				if err != nil {
+					return err
+				}
+
+			}
+
+			return nil
+
+		}
+
+	}
+
+	expected, err := ReindexEntries(dirname, filemode, timeout, opentries, index)
+	if err != nil {
+		return false, err
+	}
+
+	var changes []change
+
+	for nkey, ev := range expected {
+
+		av, ok := actual[nkey]
+
+		if ok {
+
+			var ov RawKeysData
+
+			err = binary.Read(bytes.NewReader(av), Endian, &ov)
+			if err == nil && ReindexEqual(ov, ev) {
+				continue
+			}
+
+		}
+
+		nbuffer := new(bytes.Buffer)
+
+		err = binary.Write(nbuffer, Endian, ev)
+		if err != nil {
+			return false, err
+		}
+
+		changes = append(changes, change{key: nkey, value: nbuffer.Bytes(), add: !ok})
+
+	}
+
+	for nkey := range actual {
+
+		if _, ok := expected[nkey]; !ok {
+			changes = append(changes, change{key: nkey})
+		}
+
+	}
+
+	if len(changes) == 0 {
+		return false, nil
+	}
+
+	changed := false
+
+	for len(changes) > 0 {
+
+		batch := changes
+
+		if len(batch) > reindexbatch {
+			batch = batch[:reindexbatch]
+		}
+
+		changes = changes[len(batch):]
+
+		var added, updated, deleted uint64
+
+		err = ndb.Update(func(tx *nutsdb.Tx) error {
+
+			for _, chg := range batch {
+
+				var cur []byte
+
+				entry, err := tx.Get(nbucket, []byte(chg.key))
+				if err == nil && entry != nil {
+					cur = entry.Value
+				}
+
+				if !bytes.Equal(cur, actual[chg.key]) {
+					continue
+				}
+
+				// Standart files and directories are checked again right before the change
+
+				if !strings.HasPrefix(chg.key, "b:") {
+
+					_, serr := os.Lstat(filepath.Join(dirname, chg.key[2:]))
+
+					if (serr == nil) != (chg.value != nil) {
+						continue
+					}
+
+				}
+
+				switch {
+				case chg.value == nil:
+
+					err = tx.Delete(nbucket, []byte(chg.key))
+					if err != nil {
+						return err
+					}
+
+					if strings.HasPrefix(chg.key, "b:") {
+
+						err = FTDeleteTx(tx, FTBucket(nbucket), chg.key[2:])
+						if err != nil {
+							return err
+						}
+
+						err = JXDeleteTx(tx, JXBucket(nbucket), chg.key[2:])
+						if err != nil {
+							return err
+						}
+
+					}
+
+					deleted++
+
+				default:
+
+					err = tx.Put(nbucket, []byte(chg.key), chg.value, 0)
+					if err != nil {
+						return err
+					}
+
+					if chg.add {
+						added++
+					} else {
+						updated++
+					}
+
+				}
+
+			}
+
+			return nil
+
+		})
+
+		if err != nil {
+			return changed, err
+		}
+
+		rs.Added += added
+		rs.Updated += updated
+		rs.Deleted += deleted
+
+		if added+updated+deleted > 0 {
+			changed = true
+		}
+
+	}
+
+	return changed, nil
+
+}
+
+// ReindexValues : full-text options and indexed JSON fields of virtual host with the longest root containing directory
+func ReindexValues(dirname string) (*FTOptions, []string) {
+
+	var base, vhost string
+
+	for _, Server := range config.Server {
+
+		root := filepath.Clean(Server.ROOT)
+
+		if dirname != root && !strings.HasPrefix(dirname, root+"/") {
+			continue
+		}
+
+		if vhost != "" && len(root) <= len(base) {
+			continue
+		}
+
+		base = root
+		vhost = Server.HOST
+
+	}
+
+	if vhost == "" {
+		return nil, nil
+	}
+
+	return FTHost(vhost), JXHost(vhost)
+
+}
+
+// ReindexEntries : expected search db entries of one directory from real files, directories and archives, index is called with value of every archive key if set
+func ReindexEntries(dirname string, filemode os.FileMode, timeout time.Duration, opentries int, index func(key string, ev RawKeysData, value []byte) error) (map[string]RawKeysData, error) {
+
+	expected := make(map[string]RawKeysData)
+
+	ibucket := "index"
+	sbucket := "size"
+	tbucket := "time"
+
+	bdir := filepath.Base(dirname)
+
+	files, err := ioutil.ReadDir(dirname)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, file := range files {
+
+		fname := file.Name()
+
+		if !file.IsDir() && file.Mode()&os.ModeType != 0 {
+			continue
+		}
+
+		bname := rgxbolt.MatchString(fname)
+		cname := rgxcrcbolt.MatchString(fname)
+
+		if !bname && !cname {
+
+			switch {
+			case file.IsDir():
+				expected["d:"+fname] = RawKeysData{Size: uint64(file.Size()), Date: uint64(file.ModTime().Unix()), Type: uint16(2)}
+			default:
+				expected["f:"+fname] = RawKeysData{Size: uint64(file.Size()), Date: uint64(file.ModTime().Unix()), Type: uint16(0)}
+			}
+
+			continue
+
+		}
+
+		if file.IsDir() || cname {
+			continue
+		}
+
+		var prnt uint64 = 0
+
+		switch {
+		case fname == bdir+".bolt":
+		case strings.HasPrefix(fname, bdir+"_"):
+
+			prnt, err = strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(fname, bdir+"_"), ".bolt"), 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("bad db file name %s: %v", fname, err)
+			}
+
+		default:
+			continue
+		}
+
+		dbf := filepath.Join(dirname, fname)
+
+		db, err := BoltOpenRead(dbf, filemode, timeout, opentries, freelist)
+		if err != nil {
+			return nil, err
+		}
+
+		err = db.View(func(tx *bolt.Tx) error {
+
+			b := tx.Bucket([]byte(ibucket))
+			if b == nil {
+				return fmt.Errorf("index bucket not exists in db %s", dbf)
+			}
+
+			bs := tx.Bucket([]byte(sbucket))
+			bt := tx.Bucket([]byte(tbucket))
+
+			if bs == nil || bt == nil {
+				return fmt.Errorf("size/time bucket not exists in db %s", dbf)
+			}
+
+			pos := b.Cursor()
+
+			for inkey, inval := pos.First(); inkey != nil; inkey, inval = pos.Next() {
+
+				nbck, err := strconv.Atoi(strings.TrimPrefix(string(inval), "wzd"))
+				if err != nil {
+					return err
+				}
+
+				ksize := bs.Get(inkey)
+				kdate := bt.Get(inkey)
+
+				if len(ksize) < 8 || len(kdate) < 8 {
+					return fmt.Errorf("key size/date empty in db %s for key %s", dbf, string(inkey))
+				}
+
+				nkey := "b:" + string(inkey)
+
+				if _, ok := expected[nkey]; ok {
+					continue
+				}
+
+				ev := RawKeysData{Size: Endian.Uint64(ksize), Date: Endian.Uint64(kdate), Prnt: uint32(prnt), Buck: uint16(nbck), Type: uint16(1)}
+
+				expected[nkey] = ev
+
+				if index == nil {
+					continue
+				}
+
+				bd := tx.Bucket(inval)
+				if bd == nil {
+					continue
+				}
+
+				val := bd.Get(inkey)
+				if len(val) < 36 {
+					continue
+				}
+
+				err = index(string(inkey), ev, val[36:])
+				if err != nil {
+					return err
+				}
+
+			}
+
+			return nil
+
+		})
+
+		db.Close()
+
+		if err != nil {
+			return nil, err
+		}
+
+	}
+
+	return expected, nil
+
+}
+
+// ReindexEqual : compare actual and expected entries, upload date of standart files is not a file modification date and is not compared
+func ReindexEqual(a RawKeysData, e RawKeysData) bool {
+
+	switch e.Type {
+	case 0:
+		return a.Type == 0 && a.Size == e.Size
+	case 2:
+		return a.Type == 2
+	}
+
+	return a == e
+
+}
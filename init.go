@@ -29,7 +29,6 @@ import (
 	"encoding/binary"
 	// "encoding/json"
 	"errors"
-	"fmt"
 	"github.com/eltaline/bolt"
 	"github.com/eltaline/nutsdb"
 	"io/ioutil"
@@ -42,8 +41,8 @@ import (
 	"time"
 )
 
-// SearchInit : Search Metadata Database Initialization
-func SearchInit(nopt nutsdb.Options) {
+// SearchInit : Search Metadata Database Initialization of virtual hosts root directories
+func SearchInit(nopt nutsdb.Options, roots []string) error {
 
 	// Wait Group
 
@@ -52,6 +51,8 @@ func SearchInit(nopt nutsdb.Options) {
 	// Variables
 
 	var err error
+	var ierr error
+	var ierrmu sync.Mutex
 
 	type mfiles struct {
 		dir string
@@ -77,31 +78,52 @@ func SearchInit(nopt nutsdb.Options) {
 	appLogger, applogfile := AppLogger()
 	defer applogfile.Close()
 
+	fail := func(err error) {
+
+		ierrmu.Lock()
+
+		if ierr == nil {
+			ierr = err
+		}
+
+		ierrmu.Unlock()
+
+	}
+
 	ndb, err := nutsdb.Open(nopt)
 	if err != nil {
 		appLogger.Errorf("| Can`t open search db error | DB Directory [%s] | %v", nopt.Dir, err)
-		fmt.Printf("Can`t open search db error | DB Directory [%s] | %v\n", nopt.Dir, err)
-		os.Exit(1)
+		return err
 	}
 	defer ndb.Close()
 
 	root := tree.Root()
 
-	walk := func(bdir []byte, dcrc interface{}) bool {
+	rand.Seed(time.Now().UnixNano())
 
-		rand.Seed(time.Now().UnixNano())
+	for _, sroot := range roots {
 
-		m.dir = string(bdir)
-		m.crc = dcrc.(uint64)
-		m.thr = rand.Intn(searchinit-1+1) + 1
+		broot := []byte(sroot)
 
-		mslice = append(mslice, m)
+		walk := func(bdir []byte, dcrc interface{}) bool {
 
-		return false
+			if len(bdir) > len(broot) && bdir[len(broot)] != '/' {
+				return false
+			}
 
-	}
+			m.dir = string(bdir)
+			m.crc = dcrc.(uint64)
+			m.thr = rand.Intn(searchinit-1+1) + 1
 
-	root.Walk(walk)
+			mslice = append(mslice, m)
+
+			return false
+
+		}
+
+		root.WalkPrefix(broot, walk)
+
+	}
 
 	for i := 1; i <= searchinit; i++ {
 
@@ -126,8 +148,8 @@ func SearchInit(nopt nutsdb.Options) {
 				files, err := ioutil.ReadDir(dirname)
 				if err != nil {
 					appLogger.Errorf("| Can`t read directory error | Path [%s] | %v", dirname, err)
-					fmt.Printf("Can`t read directory error | Path [%s] | %v\n", dirname, err)
-					os.Exit(1)
+					fail(err)
+					return
 				}
 
 				for _, file := range files {
@@ -171,8 +193,8 @@ func SearchInit(nopt nutsdb.Options) {
 						err = binary.Write(nbuffer, Endian, nval)
 						if err != nil {
 							appLogger.Errorf("| Can`t write to binary buffer error | File/Path [%s] | %v", fname, err)
-							fmt.Printf("Can`t write to binary buffer error | File/Path [%s] | %v\n", fname, err)
-							os.Exit(1)
+							fail(err)
+							return
 						}
 
 						// json.NewEncoder(nbuffer).Encode(nval)
@@ -192,8 +214,8 @@ func SearchInit(nopt nutsdb.Options) {
 
 						if err != nil {
 							appLogger.Errorf("| Can`t write to search db error | File/Path [%s] | %v", fname, err)
-							fmt.Printf("Can`t write to search db error | File/Path [%s] | %v\n", fname, err)
-							os.Exit(1)
+							fail(err)
+							return
 						}
 
 						continue
@@ -213,8 +235,8 @@ func SearchInit(nopt nutsdb.Options) {
 						prnt, err = strconv.ParseUint(strings.Split(strings.TrimSuffix(fname, ".bolt"), "_")[1], 10, 64)
 						if err != nil {
 							appLogger.Errorf("| Bad db file name error | DB [%s] | %v", dbf, err)
-							fmt.Printf("Bad db file name error | DB [%s] | %v\n", dbf, err)
-							os.Exit(1)
+							fail(err)
+							return
 						}
 
 					}
@@ -222,8 +244,8 @@ func SearchInit(nopt nutsdb.Options) {
 					db, err := BoltOpenRead(dbf, fmode, timeout, opentries, freelist)
 					if err != nil {
 						appLogger.Errorf("| Can`t open db file error | DB [%s] | %v", dbf, err)
-						fmt.Printf("Can`t open db file error | DB [%s] | %v\n", dbf, err)
-						os.Exit(1)
+						fail(err)
+						return
 					}
 
 					err = db.View(func(tx *bolt.Tx) error {
@@ -276,8 +298,7 @@ func SearchInit(nopt nutsdb.Options) {
 								err = NDBInsert(ndb, nbucket, nkey, nbuffer.Bytes(), 0)
 								if err != nil {
 									appLogger.Errorf("| Can`t write to search db error | File/Path [%s] | In-Bolt File [%s] | %v", fname, string(inkey), err)
-									fmt.Printf("Can`t write to search db error | File/Path [%s] | In-Bolt File [%s] | %v\n", fname, string(inkey), err)
-									os.Exit(1)
+									return err
 								}
 
 								nbuffer.Reset()
@@ -295,8 +316,8 @@ func SearchInit(nopt nutsdb.Options) {
 					if err != nil {
 						db.Close()
 						appLogger.Errorf("| Can`t do work with db`s error | File [%s] | DB [%s] | Search DB [%s] | %v", fname, dbf, nopt.Dir, err)
-						fmt.Printf("Can`t do work with db`s error | File [%s] | DB [%s] | Search DB [%s] | %v\n", fname, dbf, nopt.Dir, err)
-						os.Exit(1)
+						fail(err)
+						return
 					}
 
 					db.Close()
@@ -314,4 +335,6 @@ func SearchInit(nopt nutsdb.Options) {
 
 	mslice = nil
 
+	return ierr
+
 }
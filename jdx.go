@@ -0,0 +1,485 @@
+/*
+
+Copyright © 2020 Andrey Kuvshinov. Contacts: <syslinux@protonmail.com>
+Copyright © 2020 Eltaline OU. Contacts: <eltaline.ou@gmail.com>
+All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+The wZD project contains unmodified/modified libraries imports too with
+separate copyright notices and license terms. Your use of the source code
+this libraries is subject to the terms and conditions of licenses these libraries.
+
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/eltaline/nutsdb"
+	"math"
+	"strings"
+)
+
+// JSON Index Helpers
+
+// JXRegistry : indexed JSON field paths of each virtual host with json index
+func JXRegistry() {
+
+	for _, Server := range config.Server {
+
+		var fields []string
+
+		for _, field := range strings.Split(Server.JSONINDEX, ",") {
+
+			field = strings.TrimSpace(field)
+
+			if field != "" {
+				fields = append(fields, field)
+			}
+
+		}
+
+		if len(fields) > 0 {
+			jxhosts[Server.HOST] = fields
+		}
+
+	}
+
+}
+
+// JXHost : indexed JSON field paths of virtual host, nil if json index is disabled for virtual host
+func JXHost(vhost string) []string {
+	return jxhosts[vhost]
+}
+
+// JXBucket : JSON index bucket of directory by search bucket of directory
+func JXBucket(nbucket string) string {
+	return "j:" + nbucket
+}
+
+// JXEncode : order preserving encoding of JSON scalar value, empty for null, objects and arrays
+func JXEncode(v interface{}) string {
+
+	switch t := v.(type) {
+	case json.Number:
+
+		f, err := t.Float64()
+		if err != nil {
+			return ""
+		}
+
+		if f == 0 {
+			f = 0
+		}
+
+		bits := math.Float64bits(f)
+
+		switch {
+		case bits>>63 == 0:
+			bits |= 1 << 63
+		default:
+			bits = ^bits
+		}
+
+		return fmt.Sprintf("n%016x", bits)
+
+	case string:
+
+		if strings.IndexByte(t, 0) >= 0 {
+			return ""
+		}
+
+		return "s" + t
+
+	case bool:
+
+		if t {
+			return "b1"
+		}
+
+		return "b0"
+
+	}
+
+	return ""
+
+}
+
+// JXParse : encoding of raw JSON scalar value from query
+func JXParse(raw []byte) (string, error) {
+
+	var v interface{}
+
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+
+	err := dec.Decode(&v)
+	if err != nil {
+		return "", err
+	}
+
+	enc := JXEncode(v)
+	if enc == "" {
+		return "", fmt.Errorf("field value %s must be a number, string or boolean", raw)
+	}
+
+	return enc, nil
+
+}
+
+// JXValue : raw JSON value from header, header which is not valid JSON is a string
+func JXValue(hval string) json.RawMessage {
+
+	if json.Valid([]byte(hval)) {
+		return json.RawMessage(hval)
+	}
+
+	raw, _ := json.Marshal(hval)
+
+	return raw
+
+}
+
+// JXCompile : validate lookup by indexed JSON field and encode its equality value or range bounds
+func JXCompile(jf *JXFilter, fields []string) error {
+
+	var err error
+
+	if jf.Path == "" {
+		return errors.New("field path is empty")
+	}
+
+	indexed := false
+
+	for _, field := range fields {
+
+		if field == jf.Path {
+			indexed = true
+			break
+		}
+
+	}
+
+	if !indexed {
+		return fmt.Errorf("field %q is not indexed", jf.Path)
+	}
+
+	switch {
+	case jf.Eq != nil && (jf.Min != nil || jf.Max != nil):
+		return errors.New("field equality conflicts with field range")
+	case jf.Eq == nil && jf.Min == nil && jf.Max == nil:
+		return errors.New("field value or range is required")
+	}
+
+	if jf.Eq != nil {
+
+		jf.eq, err = JXParse(jf.Eq)
+		if err != nil {
+			return err
+		}
+
+	}
+
+	if jf.Min != nil {
+
+		jf.min, err = JXParse(jf.Min)
+		if err != nil {
+			return err
+		}
+
+	}
+
+	if jf.Max != nil {
+
+		jf.max, err = JXParse(jf.Max)
+		if err != nil {
+			return err
+		}
+
+	}
+
+	if jf.min != "" && jf.max != "" && jf.min[0] != jf.max[0] {
+		return errors.New("field range bounds must be of the same type")
+	}
+
+	return nil
+
+}
+
+// JXLookup : scalar values of JSON document by field path, arrays are expanded on every level
+func JXLookup(v interface{}, path []string) []interface{} {
+
+	switch t := v.(type) {
+	case []interface{}:
+
+		var vals []interface{}
+
+		for _, e := range t {
+			vals = append(vals, JXLookup(e, path)...)
+		}
+
+		return vals
+
+	case map[string]interface{}:
+
+		if len(path) == 0 {
+			return nil
+		}
+
+		sub, ok := t[path[0]]
+		if !ok {
+			return nil
+		}
+
+		return JXLookup(sub, path[1:])
+
+	}
+
+	if len(path) > 0 {
+		return nil
+	}
+
+	return []interface{}{v}
+
+}
+
+// JXEntries : unique indexed field paths with encoded values of JSON object value, nil for other values
+func JXEntries(value []byte, fields []string) []string {
+
+	var doc interface{}
+
+	dec := json.NewDecoder(bytes.NewReader(value))
+	dec.UseNumber()
+
+	err := dec.Decode(&doc)
+	if err != nil {
+		return nil
+	}
+
+	obj, ok := doc.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	var ientries []string
+
+	uniq := make(map[string]bool)
+
+	for _, field := range fields {
+
+		for _, v := range JXLookup(obj, strings.Split(field, ".")) {
+
+			enc := JXEncode(v)
+			if enc == "" {
+				continue
+			}
+
+			ientry := field + "\x00" + enc
+
+			if !uniq[ientry] {
+				uniq[ientry] = true
+				ientries = append(ientries, ientry)
+			}
+
+		}
+
+	}
+
+	return ientries
+
+}
+
+// JXIndex : replace indexed JSON field values of archive key in search db, value which is not JSON object only removes old values
+func JXIndex(ndb *nutsdb.DB, nbucket string, key string, value []byte, fields []string) error {
+
+	jbucket := JXBucket(nbucket)
+
+	ientries := JXEntries(value, fields)
+
+	return ndb.Update(func(tx *nutsdb.Tx) error {
+
+		err := JXDeleteTx(tx, jbucket, key)
+		if err != nil {
+			return err
+		}
+
+		if len(ientries) == 0 {
+			return nil
+		}
+
+		for _, ientry := range ientries {
+
+			err = tx.Put(jbucket, []byte("v:"+ientry+"\x00"+key), []byte{1}, 0)
+			if err != nil {
+				return err
+			}
+
+		}
+
+		kval, err := json.Marshal(ientries)
+		if err != nil {
+			return err
+		}
+
+		return tx.Put(jbucket, []byte("k:"+key), kval, 0)
+
+	})
+
+}
+
+// JXDelete : delete indexed JSON field values of archive key from search db
+func JXDelete(ndb *nutsdb.DB, nbucket string, key string) error {
+
+	return ndb.Update(func(tx *nutsdb.Tx) error {
+		return JXDeleteTx(tx, JXBucket(nbucket), key)
+	})
+
+}
+
+// JXDeleteTx : delete indexed JSON field values of archive key inside search db transaction
+func JXDeleteTx(tx *nutsdb.Tx, jbucket string, key string) error {
+
+	var ientries []string
+
+	entry, err := tx.Get(jbucket, []byte("k:"+key))
+	if err != nil || entry == nil {
+		return nil
+	}
+
+	err = json.Unmarshal(entry.Value, &ientries)
+	if err != nil {
+		return err
+	}
+
+	for _, ientry := range ientries {
+
+		err = tx.Delete(jbucket, []byte("v:"+ientry+"\x00"+key))
+		if err != nil {
+			return err
+		}
+
+	}
+
+	return tx.Delete(jbucket, []byte("k:"+key))
+
+}
+
+// JXDeleteDirTx : delete all indexed JSON field values of directory inside search db transaction
+func JXDeleteDirTx(tx *nutsdb.Tx, nbucket string) error {
+
+	jbucket := JXBucket(nbucket)
+
+	entries, err := tx.GetAll(jbucket)
+
+	if entries == nil {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+
+		err = tx.Delete(jbucket, entry.Key)
+		if err != nil {
+			return err
+		}
+
+	}
+
+	return nil
+
+}
+
+// JXSearch : archive keys of directory with indexed JSON field value equal to value or inside inclusive range
+func JXSearch(tx *nutsdb.Tx, nbucket string, jf *JXFilter) (map[string]bool, error) {
+
+	var entries nutsdb.Entries
+	var err error
+
+	jbucket := JXBucket(nbucket)
+
+	fprefix := "v:" + jf.Path + "\x00"
+
+	var tag string
+
+	switch {
+	case jf.eq != "":
+		entries, _, err = tx.PrefixScan(jbucket, []byte(fprefix+jf.eq+"\x00"), -1, -1)
+	default:
+
+		if jf.min != "" {
+			tag = jf.min[:1]
+		} else {
+			tag = jf.max[:1]
+		}
+
+		start := fprefix + jf.min
+		end := fprefix + jf.max + "\x01"
+
+		if jf.min == "" {
+			start = fprefix + tag
+		}
+
+		if jf.max == "" {
+			end = fprefix + tag + "\xff"
+		}
+
+		entries, err = tx.RangeScan(jbucket, []byte(start), []byte(end))
+
+	}
+
+	keys := make(map[string]bool)
+
+	if entries == nil {
+		return keys, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+
+		ekey := string(entry.Key)
+
+		z := strings.LastIndexByte(ekey, 0)
+		if z <= len(fprefix) || !strings.HasPrefix(ekey, fprefix) {
+			continue
+		}
+
+		enc := ekey[len(fprefix):z]
+
+		switch {
+		case jf.eq != "" && enc != jf.eq:
+			continue
+		case jf.eq == "" && enc[:1] != tag:
+			continue
+		case jf.min != "" && enc < jf.min:
+			continue
+		case jf.max != "" && enc > jf.max:
+			continue
+		}
+
+		keys[ekey[z+1:]] = true
+
+	}
+
+	return keys, nil
+
+}
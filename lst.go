@@ -0,0 +1,473 @@
+/*
+
+Copyright © 2020 Andrey Kuvshinov. Contacts: <syslinux@protonmail.com>
+Copyright © 2020 Eltaline OU. Contacts: <eltaline.ou@gmail.com>
+All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+The wZD project contains unmodified/modified libraries imports too with
+separate copyright notices and license terms. Your use of the source code
+this libraries is subject to the terms and conditions of licenses these libraries.
+
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"github.com/eltaline/nutsdb"
+	"hash/crc64"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// List Handlers
+
+const listbatch = 1024
+
+// ListCursor : order key of listed directory, file or key, it is also used as hex encoded cursor
+func ListCursor(name string, ktype int) string {
+	return name + "\x00" + strconv.Itoa(ktype)
+}
+
+// ListParse : order key from hex encoded cursor
+func ListParse(cursor string) (string, error) {
+
+	ocursor, err := hex.DecodeString(cursor)
+	if err != nil {
+		return "", err
+	}
+
+	if bytes.IndexByte(ocursor, 0) < 0 {
+		return "", errors.New("bad cursor")
+	}
+
+	return string(ocursor), nil
+
+}
+
+// ListKeys : one page of directory listing with child directories as common prefixes, files and/or keys in requested order after cursor
+func ListKeys(ndb *nutsdb.DB, base string, dirpath string, msort uint8, limit int, cursor string, prefix string, expression string, withurl bool, url string, files bool, archives bool, searchmaxresults int) (KeysList, error) {
+
+	var klist KeysList
+	var items []KeysInfo
+	var okeys []string
+
+	var rgx *regexp.Regexp
+	var err error
+
+	if expression != "(.+)" {
+
+		rgx, err = regexp.Compile(expression)
+		if err != nil {
+			return klist, err
+		}
+
+	}
+
+	tprefixes := []string{"d:"}
+
+	if files {
+		tprefixes = append(tprefixes, "f:")
+	}
+
+	if archives {
+		tprefixes = append(tprefixes, "b:")
+	}
+
+	dirpath = filepath.Clean(dirpath)
+
+	nbucket := strconv.FormatUint(crc64.Checksum([]byte(dirpath), ctbl64), 16)
+
+	vdirname := strings.TrimPrefix(dirpath, base) + "/"
+
+	cname := ""
+
+	if cursor != "" {
+		cname = cursor[:strings.IndexByte(cursor, 0)]
+	}
+
+	// Every type is read in order of keys from cursor and at most limit + 1 entries of every type are taken for merge, results limit caps taken entries
+
+	want := -1
+
+	if limit > 0 {
+		want = limit + 1
+	}
+
+	if searchmaxresults > 0 && (want < 0 || want > searchmaxresults+1) {
+		want = searchmaxresults + 1
+	}
+
+	seen := make(map[string]bool)
+
+	take := func(tprefix string, entry *nutsdb.Entry) (string, bool, error) {
+
+		var ev RawKeysData
+
+		kname := strings.TrimPrefix(string(entry.Key), tprefix)
+
+		if !strings.HasPrefix(kname, prefix) || (rgx != nil && !rgx.MatchString(strings.TrimPrefix(kname, prefix))) {
+			return kname, false, nil
+		}
+
+		ktype := 0
+		oname := kname
+
+		switch tprefix {
+		case "b:":
+			ktype = 1
+		case "d:":
+			ktype = 2
+			oname = kname + "/"
+		}
+
+		okey := ListCursor(oname, ktype)
+
+		switch {
+		case seen[okey]:
+			return kname, false, nil
+		case cursor != "" && msort != 1 && okey <= cursor:
+			return kname, false, nil
+		case cursor != "" && msort == 1 && okey >= cursor:
+			return kname, false, nil
+		}
+
+		err := binary.Read(bytes.NewReader(entry.Value), Endian, &ev)
+		if err != nil {
+			return kname, false, err
+		}
+
+		seen[okey] = true
+
+		items = append(items, KeysInfo{Key: oname, Size: ev.Size, Date: ev.Date, Type: ktype})
+		okeys = append(okeys, okey)
+
+		return kname, true, nil
+
+	}
+
+	err = ndb.View(func(tx *nutsdb.Tx) error {
+
+		for _, tprefix := range tprefixes {
+
+			bprefix := []byte(tprefix + prefix)
+			from := bprefix
+
+			switch {
+			case cursor != "" && msort != 1 && tprefix+cname > string(bprefix):
+				from = []byte(tprefix + cname)
+			case cursor != "" && msort == 1 && tprefix+cname < string(PrefixEnd(bprefix)):
+				from = []byte(tprefix + cname)
+			}
+
+			if !bytes.HasPrefix(from, bprefix) {
+				continue
+			}
+
+			// Directories are ordered with trailing slash, so directory which is a prefix of cursor followed by character less than slash is after cursor, but before start of scan
+
+			if tprefix == "d:" && cursor != "" && msort != 1 {
+
+				for i := 1; i < len(cname); i++ {
+
+					if cname[i] >= '/' {
+						continue
+					}
+
+					entry, err := tx.Get(nbucket, []byte(tprefix+cname[:i]))
+					if err != nil || entry == nil {
+						continue
+					}
+
+					_, _, err = take(tprefix, entry)
+					if err != nil {
+						return err
+					}
+
+				}
+
+			}
+
+			taken := 0
+			bound := ""
+
+			var dnames []string
+
+			// Later directory in ascending order can precede taken directory only inside of its name and slash
+
+			err := ListScan(tx, nbucket, bprefix, from, msort == 1, func(entry *nutsdb.Entry) (bool, error) {
+
+				kname := strings.TrimPrefix(string(entry.Key), tprefix)
+
+				if want > 0 && taken >= want && (tprefix != "d:" || msort == 1 || kname >= bound) {
+					return true, nil
+				}
+
+				kname, ok, err := take(tprefix, entry)
+				if err != nil || !ok {
+					return false, err
+				}
+
+				taken++
+
+				if tprefix == "d:" {
+					dnames = append(dnames, kname)
+				}
+
+				if kname+"/" > bound {
+					bound = kname + "/"
+				}
+
+				return false, nil
+
+			})
+
+			if err != nil {
+				return err
+			}
+
+			// Earlier directory in descending order can follow taken directory only as a prefix of its name followed by character less than slash
+
+			if tprefix == "d:" && msort == 1 {
+
+				for _, dname := range dnames {
+
+					for i := 1; i < len(dname); i++ {
+
+						if dname[i] >= '/' {
+							continue
+						}
+
+						entry, err := tx.Get(nbucket, []byte(tprefix+dname[:i]))
+						if err != nil || entry == nil {
+							continue
+						}
+
+						_, _, err = take(tprefix, entry)
+						if err != nil {
+							return err
+						}
+
+					}
+
+				}
+
+			}
+
+		}
+
+		return nil
+
+	})
+
+	if err != nil {
+		return klist, err
+	}
+
+	order := make([]int, len(items))
+	for i := range order {
+		order[i] = i
+	}
+
+	sort.Slice(order, func(i, j int) bool {
+		return (okeys[order[i]] < okeys[order[j]]) != (msort == 1)
+	})
+
+	if limit > 0 && len(order) > limit {
+		klist.Next = hex.EncodeToString([]byte(okeys[order[limit-1]]))
+		order = order[:limit]
+	}
+
+	if searchmaxresults > 0 && len(order) > searchmaxresults {
+		return klist, errMaxResults
+	}
+
+	klist.Prefixes = []string{}
+	klist.Keys = []KeysInfo{}
+
+	for _, i := range order {
+
+		item := items[i]
+
+		item.Key = strings.TrimPrefix(vdirname+item.Key, "/")
+
+		if withurl {
+			item.Key = url + "/" + item.Key
+		}
+
+		if item.Type == 2 {
+			klist.Prefixes = append(klist.Prefixes, item.Key)
+			continue
+		}
+
+		klist.Keys = append(klist.Keys, item)
+
+	}
+
+	return klist, nil
+
+}
+
+// ListScan : entries of bucket with prefix in ascending order from key or in descending order up to key, keys after or before it are read as subtrees of every next byte
+func ListScan(tx *nutsdb.Tx, bucket string, prefix []byte, from []byte, desc bool, fn func(*nutsdb.Entry) (bool, error)) error {
+
+	sub := func(i int, c int) []byte {
+		return append(append([]byte{}, from[:i]...), byte(c))
+	}
+
+	if !desc {
+
+		stop, err := ListWalk(tx, bucket, from, false, fn)
+		if err != nil || stop {
+			return err
+		}
+
+		for i := len(from) - 1; i >= len(prefix); i-- {
+
+			for c := int(from[i]) + 1; c < 256; c++ {
+
+				stop, err = ListWalk(tx, bucket, sub(i, c), false, fn)
+				if err != nil || stop {
+					return err
+				}
+
+			}
+
+		}
+
+		return nil
+
+	}
+
+	if len(from) == len(prefix) {
+		_, err := ListWalk(tx, bucket, from, true, fn)
+		return err
+	}
+
+	for i := len(from); i >= len(prefix); i-- {
+
+		if i < len(from) {
+
+			for c := int(from[i]) - 1; c >= 0; c-- {
+
+				stop, err := ListWalk(tx, bucket, sub(i, c), true, fn)
+				if err != nil || stop {
+					return err
+				}
+
+			}
+
+		}
+
+		entry, err := tx.Get(bucket, from[:i])
+		if err != nil || entry == nil {
+			continue
+		}
+
+		stop, err := fn(entry)
+		if err != nil || stop {
+			return err
+		}
+
+	}
+
+	return nil
+
+}
+
+// ListWalk : entries of bucket with prefix in ascending or descending order, read by prefix scans of at most listbatch entries
+func ListWalk(tx *nutsdb.Tx, bucket string, prefix []byte, desc bool, fn func(*nutsdb.Entry) (bool, error)) (bool, error) {
+
+	if !desc {
+
+		for off := 0; ; off += listbatch {
+
+			entries, _, err := tx.PrefixScan(bucket, prefix, off, listbatch)
+
+			if entries == nil {
+				return false, nil
+			}
+
+			if err != nil {
+				return false, err
+			}
+
+			for _, entry := range entries {
+
+				stop, err := fn(entry)
+				if err != nil || stop {
+					return stop, err
+				}
+
+			}
+
+			if len(entries) < listbatch {
+				return false, nil
+			}
+
+		}
+
+	}
+
+	entries, _, err := tx.PrefixScan(bucket, prefix, 0, listbatch+1)
+
+	if entries == nil {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	if len(entries) <= listbatch {
+
+		for i := len(entries) - 1; i >= 0; i-- {
+
+			stop, err := fn(entries[i])
+			if err != nil || stop {
+				return stop, err
+			}
+
+		}
+
+		return false, nil
+
+	}
+
+	// Larger subtree is read from the end by subtrees of every next byte, key of subtree itself is the last one
+
+	for c := 255; c >= 0; c-- {
+
+		stop, err := ListWalk(tx, bucket, append(append([]byte{}, prefix...), byte(c)), true, fn)
+		if err != nil || stop {
+			return stop, err
+		}
+
+	}
+
+	entry, err := tx.Get(bucket, prefix)
+	if err != nil || entry == nil {
+		return false, nil
+	}
+
+	return fn(entry)
+
+}
@@ -29,6 +29,7 @@ import (
 	"context"
 	"crypto/tls"
 	"encoding/binary"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"github.com/coocood/freecache"
@@ -42,6 +43,7 @@ import (
 	"github.com/kataras/iris/v12/middleware/recover"
 	"hash/crc32"
 	"hash/crc64"
+	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
@@ -50,6 +52,7 @@ import (
 	"regexp"
 	"runtime/debug"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
@@ -81,10 +84,22 @@ type global struct {
 	SEARCHDIR         string
 	SEARCHINIT        int
 	SEARCHINDEX       string
+	WATCH             bool
+	WATCHDELAY        int
+	WATCHRESCAN       int
 	CMPSCHED          bool
 	CMPDIR            string
 	CMPTIME           int
 	CMPCHECK          int
+	FEEDDIR           string
+	FEEDRETENTION     int
+	FEEDWAIT          int
+	HOOKDIR           string
+	HOOKTRIES         int
+	HOOKTIMEOUT       int
+	BACKUPDIR         string
+	ADMINBIND         string
+	ADMINTOKEN        string
 	PIDFILE           string
 	LOGDIR            string
 	LOGMODE           uint32
@@ -114,8 +129,32 @@ type server struct {
 	GETVALUE       bool
 	GETCOUNT       bool
 	GETCACHE       bool
+	GETDIGEST      bool
+	BACKUP         bool
 	SEARCHTHREADS  int
 	SEARCHTIMEOUT  int
+	SEARCHMAXDIRS  int
+	SEARCHMAXRES   int
+	SEARCHDIR      string
+	SEARCHINDEX    string
+	REINDEX        bool
+	REINDEXRATE    int
+	FULLTEXT       bool
+	FTMINLEN       int
+	FTMAXLEN       int
+	FTMAXSIZE      int64
+	FTSTOPWORDS    string
+	JSONINDEX      string
+	FEED           bool
+	WEBHOOK        []webhook
+	REPLICA        []replica
+	MODE           string
+	NODES          string
+	COPIES         int
+	NODETIMEOUT    int
+	ORIGIN         string
+	ORIGINTIMEOUT  int
+	ORIGINMISS     int
 	NONUNIQUE      bool
 	WRITEINTEGRITY bool
 	READINTEGRITY  bool
@@ -140,6 +179,20 @@ type server struct {
 	LOG4XX         bool
 }
 
+type webhook struct {
+	URL    string
+	PREFIX string
+	EVENTS string
+	SECRET string
+}
+
+type replica struct {
+	URL     string
+	HOST    string
+	RATE    int
+	TIMEOUT int
+}
+
 // Header : type contains binary header fields
 type Header struct {
 	Size uint64
@@ -195,6 +248,13 @@ type KeysInfo struct {
 	Type int    `json:"type"`
 }
 
+// KeysList : type for one page of delimiter listing of directory, child directories are returned as common prefixes
+type KeysList struct {
+	Prefixes []string   `json:"prefixes"`
+	Keys     []KeysInfo `json:"keys"`
+	Next     string     `json:"next,omitempty"`
+}
+
 // KeysInfoListAsc : type for ascending sort
 type KeysInfoListAsc []KeysInfo
 
@@ -208,6 +268,385 @@ type KeysSearch struct {
 	Date  uint64 `json:"date"`
 	Type  int    `json:"type"`
 	Value string `json:"value"`
+	dbn   string
+	buck  uint16
+}
+
+// CacheTags : type for directory tags of cached search results, used for invalidation of cache after changes in directories
+type CacheTags struct {
+	sync.Mutex
+	Seq    uint64
+	Adds   int
+	Tags   map[uint64]map[string]CacheTag
+	Recent []CacheInval
+}
+
+// CacheTag : type for recursion depth and expiration time of cached search result tagged by directory
+type CacheTag struct {
+	Depth  int
+	Expire int64
+}
+
+// CacheInval : type for recent invalidation of directory with sequence number
+type CacheInval struct {
+	Seq uint64
+	Dir string
+}
+
+// FeedEvent : type for one change feed event of file, key or archive mutation with sequence number
+type FeedEvent struct {
+	Seq     uint64 `json:"seq"`
+	Host    string `json:"host"`
+	Path    string `json:"path"`
+	Archive string `json:"archive,omitempty"`
+	Type    int    `json:"type"`
+	Size    uint64 `json:"size"`
+	Crc     uint32 `json:"crc"`
+	Op      string `json:"op"`
+	Date    uint64 `json:"date"`
+}
+
+// FeedLog : type for change feed of virtual host with last and first retained sequence numbers, waiting consumers are woken by closing of channel
+type FeedLog struct {
+	sync.Mutex
+	DB    *nutsdb.DB
+	Host  string
+	Seq   uint64
+	First uint64
+	Wake  chan struct{}
+}
+
+// FeedList : type for one read of change feed with first and last retained sequence numbers and next sequence number for consumer
+type FeedList struct {
+	First  uint64      `json:"first"`
+	Last   uint64      `json:"last"`
+	Next   uint64      `json:"next"`
+	Events []FeedEvent `json:"events"`
+}
+
+// HookTarget : type for webhook target of virtual host with persistent queue of events, first and last queued sequence numbers
+type HookTarget struct {
+	sync.Mutex
+	DB     *nutsdb.DB
+	ID     string
+	Host   string
+	URL    string
+	Prefix string
+	Events map[string]bool
+	Secret string
+	Seq    uint64
+	First  uint64
+	Wake   chan struct{}
+}
+
+// HookTask : type for queued webhook delivery of event with count of failed tries
+type HookTask struct {
+	Event FeedEvent `json:"event"`
+	Tries int       `json:"tries"`
+	Error string    `json:"error,omitempty"`
+}
+
+// RepTarget : type for replica of virtual host with replicated position in change feed and replication counters
+type RepTarget struct {
+	sync.Mutex
+	DB        *nutsdb.DB
+	ID        string
+	Host      string
+	Base      string
+	URL       string
+	RHost     string
+	Rate      int
+	Timeout   time.Duration
+	LockWait  time.Duration
+	OpenTries int
+	Pos       uint64
+	Pending   uint64
+	Stats     RepStats
+}
+
+// RepTask : type for change feed event rejected by replica with error
+type RepTask struct {
+	Event FeedEvent `json:"event"`
+	Error string    `json:"error"`
+}
+
+// RepStats : type for replication position, lag and counters of replica
+type RepStats struct {
+	URL      string `json:"url"`
+	Host     string `json:"host"`
+	Position uint64 `json:"position"`
+	Last     uint64 `json:"last"`
+	Lag      uint64 `json:"lag"`
+	LagTime  uint64 `json:"lagtime"`
+	Sent     uint64 `json:"sent"`
+	Skipped  uint64 `json:"skipped"`
+	Lost     uint64 `json:"lost"`
+	Errors   uint64 `json:"errors"`
+	Error    string `json:"error,omitempty"`
+}
+
+// ProxyRing : type contains consistent hash ring of backend nodes of virtual host in proxy mode
+type ProxyRing struct {
+	Host   string
+	Nodes  []string
+	Copies int
+	Points []ProxyPoint
+	Client *http.Client
+}
+
+// ProxyPoint : type contains one virtual point of backend node on consistent hash ring
+type ProxyPoint struct {
+	Hash uint64
+	Node int
+}
+
+// DigestNode : type for Merkle digest of directory with digests of own files and keys and of child directories
+type DigestNode struct {
+	Path   string      `json:"path"`
+	Digest string      `json:"digest"`
+	Own    string      `json:"own"`
+	Dirs   []DigestDir `json:"dirs"`
+	Keys   []DigestKey `json:"keys,omitempty"`
+}
+
+// DigestDir : type for Merkle digest of child directory
+type DigestDir struct {
+	Name   string `json:"name"`
+	Digest string `json:"digest"`
+}
+
+// DigestKey : type for file or key of directory included in Merkle digest
+type DigestKey struct {
+	Key  string `json:"key"`
+	Type int    `json:"type"`
+	Size uint64 `json:"size"`
+	Date uint64 `json:"date,omitempty"`
+	Crc  uint32 `json:"crc"`
+	prnt uint32
+	buck uint16
+}
+
+// BackupManifest : type for manifest and statistics of snapshot of directory of virtual host
+type BackupManifest struct {
+	Host     string `json:"host"`
+	Path     string `json:"path"`
+	Name     string `json:"name"`
+	Base     string `json:"base,omitempty"`
+	Time     int64  `json:"time"`
+	Feed     uint64 `json:"feed,omitempty"`
+	Dirs     uint64 `json:"dirs"`
+	Files    uint64 `json:"files"`
+	Archives uint64 `json:"archives"`
+	Linked   uint64 `json:"linked"`
+	Bytes    uint64 `json:"bytes"`
+	Search   bool   `json:"search"`
+	Compact  bool   `json:"compact"`
+}
+
+// AdminTask : type for state of background task started through admin listener
+type AdminTask struct {
+	Name     string      `json:"name"`
+	Host     string      `json:"host"`
+	Path     string      `json:"path"`
+	Started  int64       `json:"started"`
+	Finished int64       `json:"finished,omitempty"`
+	Running  bool        `json:"running"`
+	Result   interface{} `json:"result,omitempty"`
+	Error    string      `json:"error,omitempty"`
+}
+
+// AdminSearch : type for state of search db in runtime statistics
+type AdminSearch struct {
+	Dir    string   `json:"dir"`
+	Index  string   `json:"index"`
+	Hosts  []string `json:"hosts"`
+	Broken bool     `json:"broken"`
+}
+
+// AdminStats : type for runtime statistics of admin listener
+type AdminStats struct {
+	Pid          int               `json:"pid"`
+	Uptime       int64             `json:"uptime"`
+	Goroutines   int               `json:"goroutines"`
+	Alloc        uint64            `json:"alloc"`
+	Sys          uint64            `json:"sys"`
+	NumGC        uint32            `json:"numgc"`
+	CacheEntries int64             `json:"cacheentries"`
+	CacheHitRate float64           `json:"cachehitrate"`
+	CacheEvicted int64             `json:"cacheevicted"`
+	Compactions  int               `json:"compactions"`
+	Search       []AdminSearch     `json:"search"`
+	Feed         map[string]uint64 `json:"feed,omitempty"`
+	Replicas     []RepStats        `json:"replicas,omitempty"`
+	ReadOnly     []string          `json:"readonly"`
+	Tasks        []AdminTask       `json:"tasks"`
+}
+
+// ScrubStats : type for results of background check of archives through directory
+type ScrubStats struct {
+	Path     string   `json:"path"`
+	Archives uint64   `json:"archives"`
+	Keys     uint64   `json:"keys"`
+	Errors   uint64   `json:"errors"`
+	Broken   []string `json:"broken,omitempty"`
+}
+
+// ArchiveOptions : type for layout and open options of archive subcommands, defaults or values of virtual host from config
+type ArchiveOptions struct {
+	Skeyscnt  int
+	Smaxsize  int64
+	Fmaxsize  int64
+	Integrity bool
+	Filemode  os.FileMode
+	Timeout   time.Duration
+	Opentries int
+}
+
+// ArchiveKey : type for key of bolt archive with header, data bucket and archive path
+type ArchiveKey struct {
+	Name    string
+	Archive string
+	Bucket  string
+	Head    Header
+}
+
+// FsckStats : type for results of offline check and repair of bolt archive
+type FsckStats struct {
+	Keys     uint64
+	Errors   uint64
+	Repaired uint64
+	Lost     uint64
+}
+
+// FsckCopy : type for copy of key in data bucket of bolt archive with size and date from header
+type FsckCopy struct {
+	Bucket string
+	Size   uint64
+	Date   uint64
+	Broken string
+}
+
+// OriginServer : type contains origin of virtual host for pull-through of missing files and keys
+type OriginServer struct {
+	URL    string
+	Miss   int
+	Client *http.Client
+}
+
+// OriginCall : type contains single in-flight fetch of missing file or key from origin, other requests of the same file or key wait for it
+type OriginCall struct {
+	Done   chan struct{}
+	Stored bool
+	Status int
+}
+
+// OriginWriter : type for response writer of client, which ignores errors of client during fetch from origin
+type OriginWriter struct {
+	W   io.Writer
+	Err error
+}
+
+// OriginKey : type of request context key of internal uploads of files and keys fetched from origin
+type OriginKey struct{}
+
+// ProxyResult : type contains response of one backend node during fan out of search request
+type ProxyResult struct {
+	Node   string
+	Status int
+	Keys   []KeysSearch
+	Err    error
+}
+
+// KeysTop : type for bounded heap of top N files and/or keys sorted by key, size or date
+type KeysTop struct {
+	Keys []KeysSearch
+	By   uint8
+	Desc bool
+	Size int
+}
+
+// KeysStats : type for aggregated statistics of files and/or keys per directory subtree
+type KeysStats struct {
+	Path     string     `json:"path"`
+	Bytes    uint64     `json:"bytes"`
+	Files    uint64     `json:"files"`
+	Archives uint64     `json:"archives"`
+	MinSize  uint64     `json:"minsize"`
+	MaxSize  uint64     `json:"maxsize"`
+	AvgSize  uint64     `json:"avgsize"`
+	MinDate  uint64     `json:"mindate"`
+	MaxDate  uint64     `json:"maxdate"`
+	HistDate []KeysHist `json:"histdate,omitempty"`
+	HistSize []KeysHist `json:"histsize,omitempty"`
+	hdate    map[uint64]uint64
+	hsize    map[uint64]uint64
+}
+
+// KeysHist : type for one histogram bucket of aggregated statistics
+type KeysHist struct {
+	From  uint64 `json:"from"`
+	Count uint64 `json:"count"`
+}
+
+// ReindexStats : type for results of search db reconciliation with real directories and archives
+type ReindexStats struct {
+	Path    string `json:"path"`
+	Dirs    uint64 `json:"dirs"`
+	Added   uint64 `json:"added"`
+	Updated uint64 `json:"updated"`
+	Deleted uint64 `json:"deleted"`
+	Stale   uint64 `json:"stale"`
+	Errors  uint64 `json:"errors"`
+}
+
+// SearchDB : type for search db with own search directory and index mode of virtual hosts
+type SearchDB struct {
+	DB     *nutsdb.DB
+	Dir    string
+	Index  string
+	Hosts  []string
+	Roots  []string
+	Broken bool
+}
+
+// FTOptions : type for full-text search tokenization options of virtual host
+type FTOptions struct {
+	MinLen  int
+	MaxLen  int
+	MaxSize int64
+	Stop    map[string]bool
+}
+
+// JXFilter : type for lookup by indexed JSON field of values, equality or inclusive range
+type JXFilter struct {
+	Path string          `json:"path"`
+	Eq   json.RawMessage `json:"eq,omitempty"`
+	Min  json.RawMessage `json:"min,omitempty"`
+	Max  json.RawMessage `json:"max,omitempty"`
+	eq   string
+	min  string
+	max  string
+}
+
+// KeysMatch : type for search limited to range of key names and/or archive keys found by full-text and JSON field indexes, start key is inclusive, end key is exclusive
+type KeysMatch struct {
+	Text     []string
+	Field    *JXFilter
+	StartKey string
+	EndKey   string
+}
+
+// WatchDirs : type for directories changed on filesystem and waiting for reindex
+type WatchDirs struct {
+	sync.Mutex
+	Dirs   map[string]WatchDir
+	Rescan bool
+}
+
+// WatchDir : type for times of first and last change of directory
+type WatchDir struct {
+	First time.Time
+	Last  time.Time
 }
 
 // KeysSearchListAsc : type for ascending sort
@@ -230,6 +669,55 @@ type BoltFiles struct {
 	Name string
 }
 
+// Query : type for JSON search query received through POST method
+type Query struct {
+	Paths     []QueryPath `json:"paths"`
+	Where     *QueryNode  `json:"where"`
+	Sort      uint8       `json:"sort"`
+	SortBy    string      `json:"sortby"`
+	Offset    int         `json:"offset"`
+	Limit     int         `json:"limit"`
+	StopFirst bool        `json:"stopfirst"`
+	WithUrl   bool        `json:"withurl"`
+	WithValue bool        `json:"withvalue"`
+	Count     bool        `json:"count"`
+	Expire    int         `json:"expire"`
+	SkipCache bool        `json:"skipcache"`
+}
+
+// QueryPath : type for root path and recursion depth of JSON search query
+type QueryPath struct {
+	Path  string `json:"path"`
+	Depth int    `json:"depth"`
+}
+
+// QueryNode : type for boolean expression node of JSON search query, all conditions of one node are joined by AND
+type QueryNode struct {
+	And    []*QueryNode `json:"and,omitempty"`
+	Or     []*QueryNode `json:"or,omitempty"`
+	Not    *QueryNode   `json:"not,omitempty"`
+	Prefix string       `json:"prefix,omitempty"`
+	Regex  string       `json:"regex,omitempty"`
+	Glob   string       `json:"glob,omitempty"`
+	Size   *QueryRange  `json:"size,omitempty"`
+	Date   *QueryRange  `json:"date,omitempty"`
+	Type   string       `json:"type,omitempty"`
+	Text   string       `json:"text,omitempty"`
+	Field  *JXFilter    `json:"field,omitempty"`
+	ICase  bool         `json:"ignorecase,omitempty"`
+	rgx    *regexp.Regexp
+	grx    *regexp.Regexp
+	glit   string
+	ktype  int
+	match  *KeysMatch
+}
+
+// QueryRange : type for inclusive min/max range of JSON search query, zero value means no limit
+type QueryRange struct {
+	Min uint64 `json:"min"`
+	Max uint64 `json:"max"`
+}
+
 // Global Variables
 
 var (
@@ -238,6 +726,10 @@ var (
 
 	lsht = &sync.Mutex{}
 
+	// Origin Mutex
+
+	omutex = &sync.Mutex{}
+
 	// Endian : Endianess
 	Endian binary.ByteOrder
 
@@ -252,6 +744,12 @@ var (
 	radix = &sync.RWMutex{}
 	tree  = iradix.New()
 
+	// Search Cache Tags
+
+	wdirs = &WatchDirs{Dirs: make(map[string]WatchDir)}
+
+	ctags = &CacheTags{Tags: make(map[uint64]map[string]CacheTag)}
+
 	// CRC32/64 Table
 
 	ctbl32 = crc32.MakeTable(0xEDB88320)
@@ -271,6 +769,7 @@ var (
 	getallow []Allow
 	putallow []Allow
 	delallow []Allow
+	allowmu  sync.RWMutex
 
 	readtimeout       time.Duration = 60 * time.Second
 	readheadertimeout time.Duration = 5 * time.Second
@@ -283,6 +782,7 @@ var (
 	shutdown bool = false
 
 	debugmode bool = false
+	recovery  bool = false
 
 	gcpercent int = 25
 
@@ -294,6 +794,10 @@ var (
 	searchinit  int    = 4
 	searchindex string = "ram"
 
+	watch       bool          = false
+	watchdelay  time.Duration = 2 * time.Second
+	watchrescan time.Duration = 0
+
 	pidfile string = "/run/wzd/wzd.pid"
 
 	logdir  string = "/var/log/wzd"
@@ -303,20 +807,66 @@ var (
 
 	cmpbucket = "cmp"
 
+	treebucket = "tree"
+
+	feedbucket = "feed"
+	hookbucket = "hook"
+	repbucket  = "repl"
+
+	sdbs   []*SearchDB
+	shosts = make(map[string]*SearchDB)
+
+	fthosts = make(map[string]*FTOptions)
+	jxhosts = make(map[string][]string)
+
+	fhosts = make(map[string]*FeedLog)
+	hhosts = make(map[string][]*HookTarget)
+	rhosts = make(map[string][]*RepTarget)
+
+	phosts = make(map[string]*ProxyRing)
+
+	ohosts = make(map[string]*OriginServer)
+	ocalls = make(map[string]*OriginCall)
+
 	cmpsched bool          = true
 	cmpdir   string        = "/var/lib/wzd/compact"
 	cmptime  int           = 7
 	cmpcheck time.Duration = 1
 
+	feeddir       string        = "/var/lib/wzd/feed"
+	feedretention time.Duration = 0
+	feedwait      time.Duration = 30 * time.Second
+
+	hookdir     string        = "/var/lib/wzd/hook"
+	hooktries   int           = 10
+	hooktimeout time.Duration = 10 * time.Second
+
+	backupdir string = "/var/lib/wzd/backup"
+
+	adminbind  string = ""
+	admintoken string = ""
+
+	starttime time.Time
+
+	reindexstop int32
+	scrubstop   int32
+
+	rohosts = make(map[string]bool)
+	romutex sync.RWMutex
+
+	atasks = make(map[string]*AdminTask)
+	amutex sync.Mutex
+
 	rgxbolt    = regexp.MustCompile(`(\.bolt$)`)
 	rgxcrcbolt = regexp.MustCompile(`(\.crcbolt$)`)
 	rgxctype   = regexp.MustCompile("(multipart)")
-	rgxjoin    = regexp.MustCompile(`(.+?):(\d+)`)
+	rgxjoin    = regexp.MustCompile(`(.+?):(-?\d+)`)
 )
 
 // Init Function
 
-func init() {
+// Setup : subcommands, command line options, configuration checks and global options, called first from main, so tests of package do not read configuration
+func Setup() {
 
 	var err error
 
@@ -324,10 +874,24 @@ func init() {
 	var vprint bool = false
 	var help bool = false
 
+	// Subcommands
+
+	if len(os.Args) > 1 {
+
+		switch os.Args[1] {
+		case "fsck", "pack", "unpack", "ls", "cat", "verify", "stat":
+			DetectEndian()
+			DetectUser()
+			os.Exit(Subcommand(os.Args[1], os.Args[2:]))
+		}
+
+	}
+
 	// Command Line Options
 
 	flag.StringVar(&configfile, "config", configfile, "--config=/etc/wzd/wzd.conf")
 	flag.BoolVar(&debugmode, "debug", debugmode, "--debug - debug mode")
+	flag.BoolVar(&recovery, "recover", recovery, "--recover - rebuild search dbs, search tree, compaction queue and shard names from archives and files before start")
 	flag.BoolVar(&vprint, "version", vprint, "--version - print version")
 	flag.BoolVar(&help, "help", help, "--help - displays help")
 
@@ -432,6 +996,20 @@ func init() {
 		config.Global.SEARCHINDEX = "ram"
 	}
 
+	rgxwatch := regexp.MustCompile("^(?i)(true|false)$")
+	mchwatch := rgxwatch.MatchString(fmt.Sprintf("%t", config.Global.WATCH))
+	Check(mchwatch, "[global]", "watch", fmt.Sprintf("%t", config.Global.WATCH), "true or false", DoExit)
+
+	if config.Global.WATCHDELAY != 0 {
+		mchwatchdelay := RBInt(config.Global.WATCHDELAY, 1, 3600)
+		Check(mchwatchdelay, "[global]", "watchdelay", fmt.Sprintf("%d", config.Global.WATCHDELAY), "from 1 to 3600", DoExit)
+	} else {
+		config.Global.WATCHDELAY = 2
+	}
+
+	mchwatchrescan := RBInt(config.Global.WATCHRESCAN, 0, 2592000)
+	Check(mchwatchrescan, "[global]", "watchrescan", fmt.Sprintf("%d", config.Global.WATCHRESCAN), "from 0 to 2592000", DoExit)
+
 	if config.Global.CMPDIR != "" {
 		rgxcmpdir := regexp.MustCompile("^(/?[^/\x00]*)+/?$")
 		mchcmpdir := rgxcmpdir.MatchString(config.Global.CMPDIR)
@@ -454,6 +1032,59 @@ func init() {
 
 	}
 
+	if config.Global.FEEDDIR != "" {
+		rgxfeeddir := regexp.MustCompile("^(/?[^/\x00]*)+/?$")
+		mchfeeddir := rgxfeeddir.MatchString(config.Global.FEEDDIR)
+		Check(mchfeeddir, "[global]", "feeddir", config.Global.FEEDDIR, "ex. /var/lib/wzd/feed", DoExit)
+	} else {
+		config.Global.FEEDDIR = "/var/lib/wzd/feed"
+	}
+
+	mchfeedretention := RBInt(config.Global.FEEDRETENTION, 0, 87600)
+	Check(mchfeedretention, "[global]", "feedretention", fmt.Sprintf("%d", config.Global.FEEDRETENTION), "from 0 to 87600", DoExit)
+
+	if config.Global.FEEDWAIT != 0 {
+		mchfeedwait := RBInt(config.Global.FEEDWAIT, 1, 3600)
+		Check(mchfeedwait, "[global]", "feedwait", fmt.Sprintf("%d", config.Global.FEEDWAIT), "from 1 to 3600", DoExit)
+	} else {
+		config.Global.FEEDWAIT = 30
+	}
+
+	if config.Global.HOOKDIR != "" {
+		rgxhookdir := regexp.MustCompile("^(/?[^/\x00]*)+/?$")
+		mchhookdir := rgxhookdir.MatchString(config.Global.HOOKDIR)
+		Check(mchhookdir, "[global]", "hookdir", config.Global.HOOKDIR, "ex. /var/lib/wzd/hook", DoExit)
+	} else {
+		config.Global.HOOKDIR = "/var/lib/wzd/hook"
+	}
+
+	if config.Global.HOOKTRIES != 0 {
+		mchhooktries := RBInt(config.Global.HOOKTRIES, 1, 100)
+		Check(mchhooktries, "[global]", "hooktries", fmt.Sprintf("%d", config.Global.HOOKTRIES), "from 1 to 100", DoExit)
+	} else {
+		config.Global.HOOKTRIES = 10
+	}
+
+	if config.Global.HOOKTIMEOUT != 0 {
+		mchhooktimeout := RBInt(config.Global.HOOKTIMEOUT, 1, 600)
+		Check(mchhooktimeout, "[global]", "hooktimeout", fmt.Sprintf("%d", config.Global.HOOKTIMEOUT), "from 1 to 600", DoExit)
+	} else {
+		config.Global.HOOKTIMEOUT = 10
+	}
+
+	if config.Global.BACKUPDIR != "" {
+		rgxbackupdir := regexp.MustCompile("^(/?[^/\x00]*)+/?$")
+		mchbackupdir := rgxbackupdir.MatchString(config.Global.BACKUPDIR)
+		Check(mchbackupdir, "[global]", "backupdir", config.Global.BACKUPDIR, "ex. /var/lib/wzd/backup", DoExit)
+	} else {
+		config.Global.BACKUPDIR = "/var/lib/wzd/backup"
+	}
+
+	if config.Global.ADMINBIND != "" {
+		mchadmintoken := len(config.Global.ADMINTOKEN) >= 16
+		Check(mchadmintoken, "[global]", "admintoken", "********", "at least 16 characters when adminbind is set", DoExit)
+	}
+
 	if config.Global.PIDFILE != "" {
 		rgxpidfile := regexp.MustCompile("^(/?[^/\x00]*)+/?$")
 		mchpidfile := rgxpidfile.MatchString(config.Global.PIDFILE)
@@ -512,6 +1143,21 @@ func init() {
 		appLogger.Warnf("| Search [DISABLED]")
 	}
 
+	switch {
+	case config.Global.WATCH:
+		appLogger.Warnf("| Search Watch [ENABLED]")
+		appLogger.Warnf("| Search Watch Delay [%d] seconds", config.Global.WATCHDELAY)
+	default:
+		appLogger.Warnf("| Search Watch [DISABLED]")
+	}
+
+	switch {
+	case config.Global.WATCHRESCAN > 0:
+		appLogger.Warnf("| Search Rescan Every [%d] seconds", config.Global.WATCHRESCAN)
+	default:
+		appLogger.Warnf("| Search Rescan [DISABLED]")
+	}
+
 	switch {
 	case config.Global.ONLYSSL:
 		appLogger.Warnf("| Only SSL Mode [ENABLED]")
@@ -528,6 +1174,20 @@ func init() {
 		appLogger.Warnf("| Compaction Scheduler [DISABLED]")
 	}
 
+	appLogger.Warnf("| Change Feed DB Directory [%s]", config.Global.FEEDDIR)
+	appLogger.Warnf("| Change Feed Max Wait [%d] seconds", config.Global.FEEDWAIT)
+
+	switch {
+	case config.Global.FEEDRETENTION > 0:
+		appLogger.Warnf("| Change Feed Retention [%d] hours", config.Global.FEEDRETENTION)
+	default:
+		appLogger.Warnf("| Change Feed Retention [DISABLED]")
+	}
+
+	appLogger.Warnf("| Webhooks DB Directory [%s]", config.Global.HOOKDIR)
+	appLogger.Warnf("| Webhooks Delivery Tries [%d]", config.Global.HOOKTRIES)
+	appLogger.Warnf("| Webhooks Delivery Timeout [%d] seconds", config.Global.HOOKTIMEOUT)
+
 	switch {
 	case config.Global.KEEPALIVE:
 		appLogger.Warnf("| KeepAlive [ENABLED]")
@@ -555,7 +1215,21 @@ func init() {
 	rgxgetjoin := regexp.MustCompile("^(?i)(true|false)$")
 	rgxgetvalue := regexp.MustCompile("^(?i)(true|false)$")
 	rgxgetcount := regexp.MustCompile("^(?i)(true|false)$")
+	rgxgetdigest := regexp.MustCompile("^(?i)(true|false)$")
+	rgxbackup := regexp.MustCompile("^(?i)(true|false)$")
 	rgxgetcache := regexp.MustCompile("^(?i)(true|false)$")
+	rgxreindex := regexp.MustCompile("^(?i)(true|false)$")
+	rgxfulltext := regexp.MustCompile("^(?i)(true|false)$")
+	rgxjsonindex := regexp.MustCompile(`^(\s*[^,.\s\x00]+(\.[^,.\s\x00]+)*\s*(,\s*[^,.\s\x00]+(\.[^,.\s\x00]+)*\s*)*)?$`)
+	rgxfeed := regexp.MustCompile("^(?i)(true|false)$")
+	rgxwebhookurl := regexp.MustCompile(`^https?://[^\s/]+(/\S*)?$`)
+	rgxwebhookprefix := regexp.MustCompile(`^(/[^\x00]*)?$`)
+	rgxreplicaurl := regexp.MustCompile(`^https?://[^\s/]+/?$`)
+	rgxreplicahost := regexp.MustCompile(`^([^\s/:]+)?$`)
+	rgxmode := regexp.MustCompile("^(proxy)?$")
+	rgxorigin := regexp.MustCompile(`^(https?://[^\s/]+(/[^\s]*)?)?$`)
+	rgxnodes := regexp.MustCompile(`^https?://[^\s,/]+/?(\s*,\s*https?://[^\s,/]+/?)*$`)
+	rgxwebhookevents := regexp.MustCompile(`^((create|update|delete|compact)(,(create|update|delete|compact))*)?$`)
 	rgxnonunique := regexp.MustCompile("^(?i)(true|false)$")
 	rgxwriteintegrity := regexp.MustCompile("^(?i)(true|false)$")
 	rgxreadintegrity := regexp.MustCompile("^(?i)(true|false)$")
@@ -793,12 +1467,120 @@ func init() {
 		mchgetcache := rgxgetcache.MatchString(fmt.Sprintf("%t", Server.GETCACHE))
 		Check(mchgetcache, section, "getcache", fmt.Sprintf("%t", Server.GETCACHE), "true or false", DoExit)
 
+		mchgetdigest := rgxgetdigest.MatchString(fmt.Sprintf("%t", Server.GETDIGEST))
+		Check(mchgetdigest, section, "getdigest", fmt.Sprintf("%t", Server.GETDIGEST), "true or false", DoExit)
+
+		mchbackup := rgxbackup.MatchString(fmt.Sprintf("%t", Server.BACKUP))
+		Check(mchbackup, section, "backup", fmt.Sprintf("%t", Server.BACKUP), "true or false", DoExit)
+
 		mchsearchthreads := RBInt(Server.SEARCHTHREADS, 1, 256)
 		Check(mchsearchthreads, section, "searchthreads", fmt.Sprintf("%d", Server.SEARCHTHREADS), "from 1 to 256", DoExit)
 
 		mchsearchtimeout := RBInt(Server.SEARCHTIMEOUT, 1, 86400)
 		Check(mchsearchtimeout, section, "searchtimeout", fmt.Sprintf("%d", Server.SEARCHTIMEOUT), "from 1 to 86400", DoExit)
 
+		mchsearchmaxdirs := RBInt(Server.SEARCHMAXDIRS, 0, 100000000)
+		Check(mchsearchmaxdirs, section, "searchmaxdirs", fmt.Sprintf("%d", Server.SEARCHMAXDIRS), "from 0 to 100000000", DoExit)
+
+		mchsearchmaxres := RBInt(Server.SEARCHMAXRES, 0, 100000000)
+		Check(mchsearchmaxres, section, "searchmaxres", fmt.Sprintf("%d", Server.SEARCHMAXRES), "from 0 to 100000000", DoExit)
+
+		if Server.SEARCHDIR != "" {
+			rgxsearchdir := regexp.MustCompile("^(/?[^/\x00]*)+/?$")
+			mchsearchdir := rgxsearchdir.MatchString(Server.SEARCHDIR)
+			Check(mchsearchdir, section, "searchdir", Server.SEARCHDIR, "ex. /var/lib/wzd/search", DoExit)
+		}
+
+		if Server.SEARCHINDEX != "" {
+			rgxsearchindex := regexp.MustCompile("^(?i)(ram|bpt|disk)$")
+			mchsearchindex := rgxsearchindex.MatchString(Server.SEARCHINDEX)
+			Check(mchsearchindex, section, "searchindex", Server.SEARCHINDEX, "ram or disk or bpt", DoExit)
+		}
+
+		mchreindex := rgxreindex.MatchString(fmt.Sprintf("%t", Server.REINDEX))
+		Check(mchreindex, section, "reindex", fmt.Sprintf("%t", Server.REINDEX), "true or false", DoExit)
+
+		mchreindexrate := RBInt(Server.REINDEXRATE, 0, 1000000)
+		Check(mchreindexrate, section, "reindexrate", fmt.Sprintf("%d", Server.REINDEXRATE), "from 0 to 1000000", DoExit)
+
+		mchfulltext := rgxfulltext.MatchString(fmt.Sprintf("%t", Server.FULLTEXT))
+		Check(mchfulltext, section, "fulltext", fmt.Sprintf("%t", Server.FULLTEXT), "true or false", DoExit)
+
+		mchftmaxlen := RBInt(Server.FTMAXLEN, 0, 256)
+		Check(mchftmaxlen, section, "ftmaxlen", fmt.Sprintf("%d", Server.FTMAXLEN), "from 0 to 256", DoExit)
+
+		mchftminlen := RBInt(Server.FTMINLEN, 0, 256) && (Server.FTMAXLEN == 0 || Server.FTMINLEN <= Server.FTMAXLEN)
+		Check(mchftminlen, section, "ftminlen", fmt.Sprintf("%d", Server.FTMINLEN), "from 0 to 256 and not greater than ftmaxlen", DoExit)
+
+		mchftmaxsize := RBInt64(Server.FTMAXSIZE, 0, 1073741824)
+		Check(mchftmaxsize, section, "ftmaxsize", fmt.Sprintf("%d", Server.FTMAXSIZE), "from 0 to 1073741824", DoExit)
+
+		mchjsonindex := rgxjsonindex.MatchString(Server.JSONINDEX)
+		Check(mchjsonindex, section, "jsonindex", Server.JSONINDEX, "ex. user.id,status", DoExit)
+
+		mchfeed := rgxfeed.MatchString(fmt.Sprintf("%t", Server.FEED))
+		Check(mchfeed, section, "feed", fmt.Sprintf("%t", Server.FEED), "true or false", DoExit)
+
+		for _, Webhook := range Server.WEBHOOK {
+
+			mchwebhookurl := rgxwebhookurl.MatchString(Webhook.URL)
+			Check(mchwebhookurl, section, "webhook url", Webhook.URL, "ex. http://localhost:8080/hook", DoExit)
+
+			mchwebhookprefix := rgxwebhookprefix.MatchString(Webhook.PREFIX)
+			Check(mchwebhookprefix, section, "webhook prefix", Webhook.PREFIX, "ex. /images", DoExit)
+
+			mchwebhookevents := rgxwebhookevents.MatchString(Webhook.EVENTS)
+			Check(mchwebhookevents, section, "webhook events", Webhook.EVENTS, "ex. create,update,delete,compact", DoExit)
+
+		}
+
+		for _, Replica := range Server.REPLICA {
+
+			mchreplicafeed := Server.FEED
+			Check(mchreplicafeed, section, "feed", fmt.Sprintf("%t", Server.FEED), "true with replica", DoExit)
+
+			mchreplicaurl := rgxreplicaurl.MatchString(Replica.URL)
+			Check(mchreplicaurl, section, "replica url", Replica.URL, "ex. http://10.0.0.2:9699", DoExit)
+
+			mchreplicahost := rgxreplicahost.MatchString(Replica.HOST)
+			Check(mchreplicahost, section, "replica host", Replica.HOST, "ex. localhost", DoExit)
+
+			mchreplicarate := RBInt(Replica.RATE, 0, 1000000)
+			Check(mchreplicarate, section, "replica rate", fmt.Sprintf("%d", Replica.RATE), "from 0 to 1000000", DoExit)
+
+			mchreplicatimeout := RBInt(Replica.TIMEOUT, 0, 86400)
+			Check(mchreplicatimeout, section, "replica timeout", fmt.Sprintf("%d", Replica.TIMEOUT), "from 0 to 86400", DoExit)
+
+		}
+
+		mchmode := rgxmode.MatchString(Server.MODE)
+		Check(mchmode, section, "mode", Server.MODE, "empty or proxy", DoExit)
+
+		if Server.MODE == "proxy" {
+
+			mchnodes := rgxnodes.MatchString(Server.NODES)
+			Check(mchnodes, section, "nodes", Server.NODES, "ex. http://10.0.0.2:9699,http://10.0.0.3:9699", DoExit)
+
+			mchcopies := RBInt(Server.COPIES, 0, 16)
+			Check(mchcopies, section, "copies", fmt.Sprintf("%d", Server.COPIES), "from 0 to 16", DoExit)
+
+			mchnodetimeout := RBInt(Server.NODETIMEOUT, 0, 3600)
+			Check(mchnodetimeout, section, "nodetimeout", fmt.Sprintf("%d", Server.NODETIMEOUT), "from 0 to 3600", DoExit)
+
+			mchproxyfeed := !Server.FEED
+			Check(mchproxyfeed, section, "feed", fmt.Sprintf("%t", Server.FEED), "false with proxy mode", DoExit)
+
+		}
+
+		mchorigin := rgxorigin.MatchString(Server.ORIGIN)
+		Check(mchorigin, section, "origin", Server.ORIGIN, "ex. http://10.0.0.5:8080/storage", DoExit)
+
+		mchorigintimeout := RBInt(Server.ORIGINTIMEOUT, 0, 3600)
+		Check(mchorigintimeout, section, "origintimeout", fmt.Sprintf("%d", Server.ORIGINTIMEOUT), "from 0 to 3600", DoExit)
+
+		mchoriginmiss := RBInt(Server.ORIGINMISS, 0, 86400)
+		Check(mchoriginmiss, section, "originmiss", fmt.Sprintf("%d", Server.ORIGINMISS), "from 0 to 86400", DoExit)
+
 		mchnonunique := rgxnonunique.MatchString(fmt.Sprintf("%t", Server.NONUNIQUE))
 		Check(mchnonunique, section, "nonunique", fmt.Sprintf("%t", Server.NONUNIQUE), "true or false", DoExit)
 
@@ -977,8 +1759,87 @@ func init() {
 			appLogger.Warnf("| Host [%s] | Get Expire Cache [DISABLED]", Server.HOST)
 		}
 
+		switch {
+		case Server.GETDIGEST:
+			appLogger.Warnf("| Host [%s] | Get Merkle Digests [ENABLED]", Server.HOST)
+		default:
+			appLogger.Warnf("| Host [%s] | Get Merkle Digests [DISABLED]", Server.HOST)
+		}
+
+		switch {
+		case Server.BACKUP:
+			appLogger.Warnf("| Host [%s] | Backup And Restore [ENABLED]", Server.HOST)
+		default:
+			appLogger.Warnf("| Host [%s] | Backup And Restore [DISABLED]", Server.HOST)
+		}
+
 		appLogger.Warnf("| Host [%s] | Search Threads [COUNT: %d]", Server.HOST, Server.SEARCHTHREADS)
 		appLogger.Warnf("| Host [%s] | Search Timeout [SECONDS: %d]", Server.HOST, Server.SEARCHTIMEOUT)
+		appLogger.Warnf("| Host [%s] | Search Max Directories [COUNT: %d]", Server.HOST, Server.SEARCHMAXDIRS)
+		appLogger.Warnf("| Host [%s] | Search Max Results [COUNT: %d]", Server.HOST, Server.SEARCHMAXRES)
+
+		if Server.SEARCHDIR != "" {
+			appLogger.Warnf("| Host [%s] | Search DB Directory [%s]", Server.HOST, Server.SEARCHDIR)
+		}
+
+		if Server.SEARCHINDEX != "" {
+			appLogger.Warnf("| Host [%s] | Search Index [%s]", Server.HOST, Server.SEARCHINDEX)
+		}
+
+		switch {
+		case Server.REINDEX:
+			appLogger.Warnf("| Host [%s] | Search Reindex [ENABLED]", Server.HOST)
+		default:
+			appLogger.Warnf("| Host [%s] | Search Reindex [DISABLED]", Server.HOST)
+		}
+
+		appLogger.Warnf("| Host [%s] | Search Reindex Rate [DIRS PER SECOND: %d]", Server.HOST, Server.REINDEXRATE)
+
+		switch {
+		case Server.FULLTEXT:
+			appLogger.Warnf("| Host [%s] | Full-Text Search [ENABLED]", Server.HOST)
+			appLogger.Warnf("| Host [%s] | Full-Text Word Length [MIN: %d] [MAX: %d]", Server.HOST, Server.FTMINLEN, Server.FTMAXLEN)
+			appLogger.Warnf("| Host [%s] | Full-Text Max Value Size [BYTES: %d]", Server.HOST, Server.FTMAXSIZE)
+			appLogger.Warnf("| Host [%s] | Full-Text Stop Words [%s]", Server.HOST, Server.FTSTOPWORDS)
+		default:
+			appLogger.Warnf("| Host [%s] | Full-Text Search [DISABLED]", Server.HOST)
+		}
+
+		switch {
+		case Server.JSONINDEX != "":
+			appLogger.Warnf("| Host [%s] | JSON Index Fields [%s]", Server.HOST, Server.JSONINDEX)
+		default:
+			appLogger.Warnf("| Host [%s] | JSON Index [DISABLED]", Server.HOST)
+		}
+
+		switch {
+		case Server.FEED:
+			appLogger.Warnf("| Host [%s] | Change Feed [ENABLED]", Server.HOST)
+		default:
+			appLogger.Warnf("| Host [%s] | Change Feed [DISABLED]", Server.HOST)
+		}
+
+		for _, Webhook := range Server.WEBHOOK {
+			appLogger.Warnf("| Host [%s] | Webhook [%s] | Prefix [%s] | Events [%s]", Server.HOST, Webhook.URL, Webhook.PREFIX, Webhook.EVENTS)
+		}
+
+		for _, Replica := range Server.REPLICA {
+			appLogger.Warnf("| Host [%s] | Replica [%s] | Replica Host [%s] | Rate [EVENTS PER SECOND: %d] | Timeout [%d] seconds", Server.HOST, Replica.URL, Replica.HOST, Replica.RATE, Replica.TIMEOUT)
+		}
+
+		switch {
+		case Server.MODE == "proxy":
+			appLogger.Warnf("| Host [%s] | Proxy Mode [ENABLED] | Nodes [%s] | Copies [%d] | Node Timeout [%d] seconds", Server.HOST, Server.NODES, Server.COPIES, Server.NODETIMEOUT)
+		default:
+			appLogger.Warnf("| Host [%s] | Proxy Mode [DISABLED]", Server.HOST)
+		}
+
+		switch {
+		case Server.ORIGIN != "":
+			appLogger.Warnf("| Host [%s] | Pull-Through Origin [%s] | Timeout [%d] seconds | Negative Cache [%d] seconds", Server.HOST, Server.ORIGIN, Server.ORIGINTIMEOUT, Server.ORIGINMISS)
+		default:
+			appLogger.Warnf("| Host [%s] | Pull-Through Origin [DISABLED]", Server.HOST)
+		}
 
 		switch {
 		case Server.NONUNIQUE:
@@ -1031,12 +1892,18 @@ func init() {
 
 func main() {
 
+	Setup()
+
 	var err error
 
 	// Main WaitGroup
 
 	var wg sync.WaitGroup
 
+	// Start Time
+
+	starttime = time.Now()
+
 	// System Handling
 
 	DetectEndian()
@@ -1122,69 +1989,63 @@ func main() {
 
 	// Search Metadata Database
 
-	if search {
-		TreeInit()
-	}
-
 	searchdir = filepath.Clean(config.Global.SEARCHDIR)
 	searchinit = config.Global.SEARCHINIT
 	searchindex = config.Global.SEARCHINDEX
 
-	if !DirExists(searchdir) {
+	watch = config.Global.WATCH
+	watchdelay = time.Duration(config.Global.WATCHDELAY) * time.Second
+	watchrescan = time.Duration(config.Global.WATCHRESCAN) * time.Second
 
-		err = os.MkdirAll(searchdir, 0700)
+	// Backup Directory
+
+	backupdir = filepath.Clean(config.Global.BACKUPDIR)
+
+	// Recovery
+
+	if recovery {
+
+		appLogger.Warnf("| Recovery mode | Search dbs, search tree, compaction queue and shard names are rebuilt from archives and files")
+
+		err = RecoverShards(RecoverRoots())
 		if err != nil {
-			appLogger.Errorf("| Can`t create search db directory error | DB Directory [%s] | %v", searchdir, err)
-			fmt.Printf("Can`t create db directory error | DB Directory [%s] | %v\n", searchdir, err)
+			fmt.Printf("Can`t repair shard names error | %v\n", err)
 			os.Exit(1)
 		}
 
 	}
 
-	nopt := nutsdb.DefaultOptions
-	nopt.Dir = searchdir
+	// Search Databases
 
-	switch {
-	case searchindex == "ram":
-		nopt.EntryIdxMode = nutsdb.HintKeyValAndRAMIdxMode
-	case searchindex == "disk":
-		nopt.EntryIdxMode = nutsdb.HintKeyAndRAMIdxMode
-	case searchindex == "bpt":
-		nopt.EntryIdxMode = nutsdb.HintBPTSparseIdxMode
-	}
+	SearchRegistry()
+	FTRegistry()
+	JXRegistry()
 
-	nopt.SegmentSize = 67108864
-	nopt.NodeNum = 1
-	nopt.StartFileLoadingMode = nutsdb.MMap
+	if recovery {
 
-	nempty, err := IsEmptyDir(searchdir)
-	if err != nil {
-		appLogger.Errorf("| Can`t check search db directory error | DB Directory [%s] | %v", searchdir, err)
-		fmt.Printf("Can`t check search db directory error | DB Directory [%s] | %v\n", searchdir, err)
-		os.Exit(1)
-	}
+		err = RecoverSearch()
+		if err != nil {
+			fmt.Printf("Can`t wipe search db error | %v\n", err)
+			os.Exit(1)
+		}
 
-	if nempty {
+	}
 
-		nopt.RWMode = nutsdb.MMap
-		nopt.SyncEnable = false
+	for _, sdb := range sdbs {
 
-		if search {
-			SearchInit(nopt)
+		err = SearchOpen(cache, sdb, &wg)
+		if err != nil {
+			sdb.Broken = true
+			appLogger.Errorf("| Search db is broken, search is disabled for virtual hosts | DB Directory [%s] | Hosts [%s] | %v", sdb.Dir, strings.Join(sdb.Hosts, " "), err)
 		}
 
 	}
 
-	nopt.RWMode = nutsdb.FileIO
-	nopt.SyncEnable = true
+	defer SearchClose()
 
-	ndb, err := nutsdb.Open(nopt)
-	if err != nil {
-		appLogger.Errorf("| Can`t open search db error | DB Directory [%s] | %v", searchdir, err)
-		fmt.Printf("Can`t open search db error | DB Directory [%s] | %v\n", searchdir, err)
-		os.Exit(1)
+	if recovery {
+		RecoverValues()
 	}
-	defer ndb.Close()
 
 	// Compaction Database
 
@@ -1224,6 +2085,10 @@ func main() {
 	}
 	defer cdb.Close()
 
+	if recovery {
+		RecoverCompact(cdb, RecoverRoots())
+	}
+
 	if cmpsched {
 
 		cron.AddFunc(gron.Every(cmpcheck*(24*time.Hour)), func() {
@@ -1234,6 +2099,95 @@ func main() {
 
 	}
 
+	// Change Feed Database
+
+	feeddir = filepath.Clean(config.Global.FEEDDIR)
+	feedretention = time.Duration(config.Global.FEEDRETENTION) * time.Hour
+	feedwait = time.Duration(config.Global.FEEDWAIT) * time.Second
+
+	fdb, err := FeedOpen()
+	if err != nil {
+		appLogger.Errorf("| Can`t open/create change feed db error | DB Directory [%s] | %v", feeddir, err)
+		fmt.Printf("Can`t open/create change feed db error | DB Directory [%s] | %v\n", feeddir, err)
+		os.Exit(1)
+	}
+
+	if fdb != nil {
+
+		defer fdb.Close()
+
+		RepOpen(fdb)
+
+		for _, reps := range rhosts {
+
+			for _, rep := range reps {
+				go RepLoop(rep, &wg)
+			}
+
+		}
+
+		if feedretention > 0 {
+
+			cron.AddFunc(gron.Every(1*time.Hour), func() {
+				wg.Add(1)
+				FeedTrim()
+				wg.Done()
+			})
+
+		}
+
+	}
+
+	// Webhooks Database
+
+	hookdir = filepath.Clean(config.Global.HOOKDIR)
+	hooktries = config.Global.HOOKTRIES
+	hooktimeout = time.Duration(config.Global.HOOKTIMEOUT) * time.Second
+
+	hdb, err := HookOpen()
+	if err != nil {
+		appLogger.Errorf("| Can`t open/create webhooks db error | DB Directory [%s] | %v", hookdir, err)
+		fmt.Printf("Can`t open/create webhooks db error | DB Directory [%s] | %v\n", hookdir, err)
+		os.Exit(1)
+	}
+
+	if hdb != nil {
+
+		defer hdb.Close()
+
+		for _, hooks := range hhosts {
+
+			for _, hook := range hooks {
+				go HookLoop(hook, &wg)
+			}
+
+		}
+
+	}
+
+	// Proxy Mode
+
+	ProxyOpen()
+
+	// Pull-Through Origin
+
+	OriginOpen()
+
+	// Search Watch
+
+	if search && watch {
+
+		err = WatchInit()
+		if err != nil {
+			appLogger.Errorf("| Can`t start search watch, only rescan is used | %v", err)
+		}
+
+	}
+
+	if search && (watch || watchrescan > 0) {
+		go WatchLoop(cache, keymutex, &wg)
+	}
+
 	// Garbage Collection Percent
 
 	gcpercent = config.Global.GCPERCENT
@@ -1259,15 +2213,17 @@ func main() {
 
 	// Web Routing
 
-	app.Get("/{directory:path}", ZDGet(cache, ndb, &wg))
-	app.Head("/{directory:path}", ZDGet(cache, ndb, &wg))
-	app.Options("/{directory:path}", ZDGet(cache, ndb, &wg))
-	app.Put("/{directory:path}", ZDPut(keymutex, cdb, ndb, &wg))
-	app.Post("/{directory:path}", ZDPut(keymutex, cdb, ndb, &wg))
-	app.Delete("/{directory:path}", ZDDel(keymutex, cdb, ndb, &wg))
+	app.Get("/{directory:path}", ZDGet(cache, &wg))
+	app.Head("/{directory:path}", ZDGet(cache, &wg))
+	app.Options("/{directory:path}", ZDGet(cache, &wg))
+	app.Put("/{directory:path}", ZDPut(cache, keymutex, cdb, &wg))
+	app.Post("/{directory:path}", ZDPost(cache, keymutex, cdb, &wg))
+	app.Delete("/{directory:path}", ZDDel(cache, keymutex, cdb, &wg))
 
 	// Interrupt Handler
 
+	var srvadm *http.Server
+
 	iris.RegisterOnInterrupt(func() {
 
 		// Shutdown Server
@@ -1280,6 +2236,23 @@ func main() {
 		shutdown = true
 		lsht.Unlock()
 
+		// Stop Admin Listener
+
+		if srvadm != nil {
+
+			actx, acancel := context.WithTimeout(context.Background(), 5*time.Second)
+
+			err := srvadm.Shutdown(actx)
+			if err != nil {
+				appLogger.Errorf("Shutdown admin listener error | %v", err)
+			}
+
+			acancel()
+
+			appLogger.Warnf("Stopped admin listener")
+
+		}
+
 		// Wait Go Routines
 
 		appLogger.Warnf("Awaiting all go routines")
@@ -1292,18 +2265,11 @@ func main() {
 
 		// Merge Search DB
 
-		if searchindex != "bpt" {
-
-			appLogger.Warnf("Merging search db")
-
-			err = NDBMerge(ndb, searchdir)
-			if err != nil {
-				appLogger.Errorf("Merge search db error | %v", err)
-			}
+		appLogger.Warnf("Merging search dbs")
 
-			appLogger.Warnf("Finished merge search db")
+		SearchMerge()
 
-		}
+		appLogger.Warnf("Finished merge search dbs")
 
 		// Merge Compaction DB
 
@@ -1316,6 +2282,36 @@ func main() {
 
 		appLogger.Warnf("Finished merge compaction db")
 
+		// Merge Change Feed DB
+
+		if fdb != nil {
+
+			appLogger.Warnf("Merging change feed db")
+
+			err = NDBMerge(fdb, feeddir)
+			if err != nil {
+				appLogger.Errorf("Merge change feed db error | %v", err)
+			}
+
+			appLogger.Warnf("Finished merge change feed db")
+
+		}
+
+		// Merge Webhooks DB
+
+		if hdb != nil {
+
+			appLogger.Warnf("Merging webhooks db")
+
+			err = NDBMerge(hdb, hookdir)
+			if err != nil {
+				appLogger.Errorf("Merge webhooks db error | %v", err)
+			}
+
+			appLogger.Warnf("Finished merge webhooks db")
+
+		}
+
 		// Stop Iris
 
 		ctx, cancel := context.WithTimeout(context.Background(), timeout)
@@ -1427,6 +2423,56 @@ func main() {
 
 	cron.Start()
 
+	// Start Admin Listener
+
+	adminbind = config.Global.ADMINBIND
+	admintoken = config.Global.ADMINTOKEN
+
+	if adminbind != "" {
+
+		adm := AdminApp(cache, keymutex, cdb, &wg)
+
+		adm.Logger().SetLevel("warn")
+		adm.Logger().SetOutput(applogfile)
+
+		err = adm.Build()
+		if err != nil {
+			fmt.Printf("Something wrong when building wZD Admin Listener | %v\n", err)
+			os.Exit(1)
+		}
+
+		srvadm = &http.Server{
+			Handler:           adm,
+			Addr:              adminbind,
+			ReadTimeout:       readtimeout,
+			ReadHeaderTimeout: readheadertimeout,
+			IdleTimeout:       idletimeout,
+			WriteTimeout:      writetimeout,
+			MaxHeaderBytes:    1 << 20,
+		}
+
+		// Admin address is bound before start of main listeners, so busy or wrong address stops startup like main listeners
+
+		lnadm, err := net.Listen("tcp", adminbind)
+		if err != nil {
+			fmt.Printf("Something wrong when starting wZD Admin Listener | %v\n", err)
+			os.Exit(1)
+		}
+
+		go func() {
+
+			err := srvadm.Serve(lnadm)
+			if err != nil && !shutdown {
+				fmt.Printf("Something wrong when starting wZD Admin Listener | %v\n", err)
+				os.Exit(1)
+			}
+
+		}()
+
+		appLogger.Warnf("Admin listening on: http://%s", adminbind)
+
+	}
+
 	// Start WebServer
 
 	switch {
@@ -0,0 +1,347 @@
+/*
+
+Copyright © 2020 Andrey Kuvshinov. Contacts: <syslinux@protonmail.com>
+Copyright © 2020 Eltaline OU. Contacts: <eltaline.ou@gmail.com>
+All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+The wZD project contains unmodified/modified libraries imports too with
+separate copyright notices and license terms. Your use of the source code
+this libraries is subject to the terms and conditions of licenses these libraries.
+
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/coocood/freecache"
+	"github.com/kataras/iris/v12"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Origin Handlers
+
+const originmark = "origin"
+
+// OriginOpen : build origins of virtual hosts for pull-through of missing files and keys
+func OriginOpen() {
+
+	for _, Server := range config.Server {
+
+		if Server.ORIGIN == "" {
+			continue
+		}
+
+		timeout := 60
+		if Server.ORIGINTIMEOUT > 0 {
+			timeout = Server.ORIGINTIMEOUT
+		}
+
+		ohosts[Server.HOST] = &OriginServer{
+			URL:  strings.TrimSuffix(Server.ORIGIN, "/"),
+			Miss: Server.ORIGINMISS,
+			Client: &http.Client{
+				Transport: &http.Transport{
+					DialContext:           (&net.Dialer{Timeout: time.Duration(timeout) * time.Second, KeepAlive: 30 * time.Second}).DialContext,
+					MaxIdleConnsPerHost:   64,
+					IdleConnTimeout:       90 * time.Second,
+					ResponseHeaderTimeout: time.Duration(timeout) * time.Second,
+				},
+			},
+		}
+
+	}
+
+}
+
+// OriginHost : origin of virtual host, nil if pull-through is disabled
+func OriginHost(vhost string) *OriginServer {
+	return ohosts[vhost]
+}
+
+// OriginTried : true if request is already served once after fetch from origin, origin is not used again
+func OriginTried(ctx iris.Context) bool {
+	return ctx.Values().Get(originmark) != nil
+}
+
+// OriginFill : true if request is internal upload of file or key fetched from origin
+func OriginFill(ctx iris.Context) bool {
+	return ctx.Request().Context().Value(OriginKey{}) != nil
+}
+
+// Write : write to client until first error, other errors are ignored and data is still saved
+func (w *OriginWriter) Write(p []byte) (int, error) {
+
+	if w.Err == nil {
+		_, w.Err = w.W.Write(p)
+	}
+
+	return len(p), nil
+
+}
+
+// OriginPull : missing file or key is fetched from origin once for all concurrent requests, streamed to client and uploaded to virtual host, not found files and keys are cached for originmiss seconds
+func OriginPull(ctx iris.Context, cache *freecache.Cache, wg *sync.WaitGroup, log4xx bool) {
+
+	var err error
+
+	// Loggers
+
+	getLogger, getlogfile := GetLogger()
+	defer getlogfile.Close()
+
+	// Headers
+
+	ip := ctx.RemoteAddr()
+	vhost := strings.Split(ctx.Host(), ":")[0]
+
+	uri := ctx.Path()
+	method := ctx.Method()
+
+	fromfile := ctx.GetHeader("FromFile")
+
+	origin := OriginHost(vhost)
+
+	okey := vhost + uri
+	mkey := []byte("origin:" + okey)
+
+	if _, err = cache.Get(mkey); err == nil {
+
+		ctx.StatusCode(iris.StatusNotFound)
+
+		if log4xx {
+			getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 404 | Not found on origin, cached | Path [%s]", vhost, ip, uri)
+		}
+
+		return
+
+	}
+
+	// Single Flight
+
+	omutex.Lock()
+
+	call, inflight := ocalls[okey]
+	if !inflight {
+		call = &OriginCall{Done: make(chan struct{}), Status: iris.StatusBadGateway}
+		ocalls[okey] = call
+	}
+
+	omutex.Unlock()
+
+	if inflight {
+
+		<-call.Done
+
+		if call.Stored {
+			ctx.Values().Set(originmark, true)
+			ZDGet(cache, wg)(ctx)
+			return
+		}
+
+		ctx.StatusCode(call.Status)
+
+		if log4xx {
+			getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | %d | Fetch from origin failed in other request | Path [%s]", vhost, ip, call.Status, uri)
+		}
+
+		return
+
+	}
+
+	defer func() {
+
+		omutex.Lock()
+		delete(ocalls, okey)
+		omutex.Unlock()
+
+		close(call.Done)
+
+	}()
+
+	// Origin
+
+	resp, err := origin.Client.Get(origin.URL + ctx.Request().URL.EscapedPath())
+	if err != nil {
+
+		ctx.StatusCode(iris.StatusBadGateway)
+		getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 502 | Can`t fetch from origin error | Origin [%s] | Path [%s] | %v", vhost, ip, origin.URL, uri, err)
+
+		if debugmode {
+
+			_, err = ctx.WriteString("[ERRO] Can`t fetch from origin error\n")
+			if err != nil {
+				getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+			}
+
+		}
+
+		return
+
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+
+		call.Status = iris.StatusNotFound
+
+		if origin.Miss > 0 {
+
+			err = cache.Set(mkey, []byte("1"), origin.Miss)
+			if err != nil {
+				getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 599 | Write not found of origin to cache error | Path [%s] | %v", vhost, ip, uri, err)
+			}
+
+		}
+
+		ctx.StatusCode(iris.StatusNotFound)
+
+		if log4xx {
+			getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 404 | Not found on origin | Origin [%s] | Path [%s]", vhost, ip, origin.URL, uri)
+		}
+
+		return
+
+	case resp.StatusCode != http.StatusOK:
+
+		ctx.StatusCode(iris.StatusBadGateway)
+		getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 502 | Origin response status error | Origin [%s] | Status [%d] | Path [%s]", vhost, ip, origin.URL, resp.StatusCode, uri)
+
+		return
+
+	}
+
+	spool, err := ioutil.TempFile("", "wzd-origin-")
+	if err != nil {
+
+		ctx.StatusCode(iris.StatusInternalServerError)
+		getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 500 | Can`t create temporary file for fetch from origin error | Path [%s] | %v", vhost, ip, uri, err)
+
+		if debugmode {
+
+			_, err = ctx.WriteString("[ERRO] Can`t create temporary file for fetch from origin error\n")
+			if err != nil {
+				getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+			}
+
+		}
+
+		return
+
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	// Stream to client and temporary file at the same time
+
+	if ctype := resp.Header.Get("Content-Type"); ctype != "" {
+		ctx.Header("Content-Type", ctype)
+	}
+
+	if resp.ContentLength >= 0 {
+		ctx.Header("Content-Length", strconv.FormatInt(resp.ContentLength, 10))
+	}
+
+	if lmod := resp.Header.Get("Last-Modified"); lmod != "" {
+		ctx.Header("Last-Modified", lmod)
+	}
+
+	ctx.Header("Hitorigin", "1")
+
+	ctx.StatusCode(iris.StatusOK)
+
+	var client io.Writer = ioutil.Discard
+	if method == "GET" {
+		client = ctx.ResponseWriter()
+	}
+
+	cw := &OriginWriter{W: client}
+
+	size, err := io.Copy(spool, io.TeeReader(resp.Body, cw))
+	if err == nil && resp.ContentLength >= 0 && size != resp.ContentLength {
+		err = io.ErrUnexpectedEOF
+	}
+
+	if err != nil {
+		getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 502 | Can`t read body from origin error | Origin [%s] | Path [%s] | %v", vhost, ip, origin.URL, uri, err)
+		return
+	}
+
+	if cw.Err != nil && log4xx {
+		getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client, fetch from origin is continued | %v", vhost, ip, cw.Err)
+	}
+
+	// Upload
+
+	status, err := OriginStore(ctx, spool, size, fromfile == "1")
+	if err != nil || status >= 300 {
+		getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | %d | Can`t upload file or key fetched from origin error | Path [%s] | %v", vhost, ip, status, uri, err)
+		return
+	}
+
+	call.Stored = true
+
+}
+
+// OriginStore : upload file or key fetched from origin through PUT method of virtual host, files not greater than fmaxsize are saved to Bolt archive of directory
+func OriginStore(ctx iris.Context, spool *os.File, size int64, tofile bool) (int, error) {
+
+	handler, ok := ctx.Application().(http.Handler)
+	if !ok {
+		return 0, errors.New("application can`t serve internal requests")
+	}
+
+	_, err := spool.Seek(0, io.SeekStart)
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequest("PUT", ctx.Request().URL.EscapedPath(), ioutil.NopCloser(spool))
+	if err != nil {
+		return 0, err
+	}
+
+	req = req.WithContext(context.WithValue(req.Context(), OriginKey{}, true))
+
+	req.Host = ctx.Host()
+	req.RemoteAddr = ctx.Request().RemoteAddr
+	req.ContentLength = size
+	req.Header.Set("Content-Length", strconv.FormatInt(size, 10))
+
+	switch {
+	case tofile || filepath.Dir(ctx.Path()) == "/":
+		req.Header.Set("File", "1")
+	default:
+		req.Header.Set("Archive", "1")
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	return rec.Code, nil
+
+}
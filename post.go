@@ -0,0 +1,588 @@
+/*
+
+Copyright © 2020 Andrey Kuvshinov. Contacts: <syslinux@protonmail.com>
+Copyright © 2020 Eltaline OU. Contacts: <eltaline.ou@gmail.com>
+All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+The wZD project contains unmodified/modified libraries imports too with
+separate copyright notices and license terms. Your use of the source code
+this libraries is subject to the terms and conditions of licenses these libraries.
+
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/coocood/freecache"
+	"github.com/eltaline/mmutex"
+	"github.com/eltaline/nutsdb"
+	"github.com/kataras/iris/v12"
+	"golang.org/x/crypto/blake2b"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Post
+
+// ZDPost : POST method, JSON search query if Sea header is set, search db reindex if Reindex header is set, snapshot or restore if Backup or Restore header is set, otherwise upload
+func ZDPost(cache *freecache.Cache, keymutex *mmutex.Mutex, cdb *nutsdb.DB, wg *sync.WaitGroup) iris.Handler {
+
+	put := ZDPut(cache, keymutex, cdb, wg)
+	query := ZDQuery(cache, wg)
+	reindex := ZDReindex(cache, keymutex, wg)
+	backup := ZDBackup(cache, keymutex, cdb, wg)
+	proxy := ZDProxy(wg)
+
+	return func(ctx iris.Context) {
+
+		if ProxyHost(strings.Split(ctx.Host(), ":")[0]) != nil {
+			proxy(ctx)
+			return
+		}
+
+		if ctx.GetHeader("Sea") == "1" {
+			query(ctx)
+			return
+		}
+
+		if ctx.GetHeader("Reindex") == "1" {
+			reindex(ctx)
+			return
+		}
+
+		if ctx.GetHeader("Backup") != "" || ctx.GetHeader("Restore") != "" {
+			backup(ctx)
+			return
+		}
+
+		put(ctx)
+
+	}
+
+}
+
+// ZDQuery : POST method with JSON search query in a body
+func ZDQuery(cache *freecache.Cache, wg *sync.WaitGroup) iris.Handler {
+	return func(ctx iris.Context) {
+		defer wg.Done()
+
+		var err error
+
+		// Wait Group
+
+		wg.Add(1)
+
+		// Loggers
+
+		getLogger, getlogfile := GetLogger()
+		defer getlogfile.Close()
+
+		// Shutdown
+
+		if shutdown {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			return
+		}
+
+		// Headers
+
+		ip := ctx.RemoteAddr()
+		cip := net.ParseIP(ip)
+		vhost := strings.Split(ctx.Host(), ":")[0]
+
+		// Search DB of virtual host
+
+		ndb, search := SearchHost(vhost)
+
+		uri := ctx.Path()
+		furi := ctx.FullRequestURI()
+
+		badhost := true
+		badip := true
+
+		base := "/notfound"
+
+		getsearch := false
+		getrecursive := false
+		getjoin := false
+		getvalue := false
+		getcount := false
+		getcache := false
+
+		searchthreads := 4
+		searchtimeout := 10
+		searchmaxdirs := 0
+		searchmaxresults := 0
+
+		opentries := 5
+		locktimeout := 5
+
+		vmaxsize := int64(1024)
+
+		cctrl := 0
+
+		filemode := os.FileMode(0640)
+
+		log4xx := true
+
+		for _, Server := range config.Server {
+
+			if vhost == Server.HOST {
+
+				badhost = false
+
+				base = filepath.Clean(Server.ROOT)
+
+				for _, Vhost := range AllowGet() {
+
+					if vhost == Vhost.Vhost {
+
+						for _, CIDR := range Vhost.CIDR {
+							_, ipnet, _ := net.ParseCIDR(CIDR.Addr)
+							if ipnet.Contains(cip) {
+								badip = false
+								break
+							}
+						}
+
+						break
+
+					}
+
+				}
+
+				getsearch = Server.GETSEARCH
+				getrecursive = Server.GETRECURSIVE
+				getjoin = Server.GETJOIN
+				getvalue = Server.GETVALUE
+				getcount = Server.GETCOUNT
+				getcache = Server.GETCACHE
+
+				searchthreads = Server.SEARCHTHREADS
+				searchtimeout = Server.SEARCHTIMEOUT
+				searchmaxdirs = Server.SEARCHMAXDIRS
+				searchmaxresults = Server.SEARCHMAXRES
+
+				opentries = Server.OPENTRIES
+				locktimeout = Server.LOCKTIMEOUT
+
+				vmaxsize = Server.VMAXSIZE
+
+				cctrl = Server.CCTRL
+
+				cfilemode, err := strconv.ParseUint(fmt.Sprintf("%d", Server.FILEMODE), 8, 32)
+				switch {
+				case err != nil || cfilemode == 0:
+					filemode = os.FileMode(0640)
+				default:
+					filemode = os.FileMode(cfilemode)
+				}
+
+				log4xx = Server.LOG4XX
+
+				break
+
+			}
+
+		}
+
+		if badhost {
+
+			ctx.StatusCode(iris.StatusMisdirectedRequest)
+
+			if log4xx {
+				getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 421 | Not found configured virtual host", vhost, ip)
+			}
+
+			if debugmode {
+
+				_, err = ctx.Writef("[ERRO] Not found configured virtual host | Virtual Host [%s]\n", vhost)
+				if err != nil {
+					getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+				}
+
+			}
+
+			return
+
+		}
+
+		if badip {
+
+			ctx.StatusCode(iris.StatusForbidden)
+
+			if log4xx {
+				getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 403 | Forbidden", vhost, ip)
+			}
+
+			if debugmode {
+
+				_, err = ctx.Writef("[ERRO] Not found allowed ip | Virtual Host [%s]\n", vhost)
+				if err != nil {
+					getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+				}
+
+			}
+
+			return
+
+		}
+
+		if !search || !getsearch {
+
+			ctx.StatusCode(iris.StatusForbidden)
+
+			if log4xx {
+				getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 403 | The keys search request is not allowed during POST query request", vhost, ip)
+			}
+
+			if debugmode {
+
+				_, err = ctx.WriteString("[ERRO] The keys search request is not allowed during POST query request\n")
+				if err != nil {
+					getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+				}
+
+			}
+
+			return
+
+		}
+
+		abs := filepath.Clean(base + uri)
+
+		timeout := time.Duration(locktimeout) * time.Second
+
+		ups, _ := url.Parse(furi)
+		furi = ups.Scheme + "://" + ups.Host
+
+		// Query Body
+
+		rawbuffer := new(bytes.Buffer)
+
+		_, err = rawbuffer.ReadFrom(io.LimitReader(ctx.Request().Body, 1048577))
+		if err != nil && err != io.EOF {
+
+			ctx.StatusCode(iris.StatusInternalServerError)
+			getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 500 | Can`t read request body data error | Path [%s] | %v", vhost, ip, abs, err)
+
+			if debugmode {
+
+				_, err = ctx.WriteString("[ERRO] Can`t read request body data error\n")
+				if err != nil {
+					getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+				}
+
+			}
+
+			return
+
+		}
+
+		if rawbuffer.Len() > 1048576 {
+
+			ctx.StatusCode(iris.StatusRequestEntityTooLarge)
+
+			if log4xx {
+				getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 413 | The query body is too large during POST query request", vhost, ip)
+			}
+
+			if debugmode {
+
+				_, err = ctx.WriteString("[ERRO] The query body is too large during POST query request\n")
+				if err != nil {
+					getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+				}
+
+			}
+
+			return
+
+		}
+
+		var query Query
+
+		query.Expire = -1
+
+		err = json.Unmarshal(rawbuffer.Bytes(), &query)
+		if err == nil {
+			err = QueryCompile(query.Where, 0, vhost)
+		}
+
+		if err == nil && query.SortBy != "" && query.SortBy != "key" && query.SortBy != "size" && query.SortBy != "date" {
+			err = fmt.Errorf("unknown query sortby %q", query.SortBy)
+		}
+
+		if err != nil {
+
+			ctx.StatusCode(iris.StatusBadRequest)
+
+			if log4xx {
+				getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 400 | Bad JSON query error during POST query request | %v", vhost, ip, err)
+			}
+
+			if debugmode {
+
+				_, err = ctx.WriteString("[ERRO] Bad JSON query error during POST query request\n")
+				if err != nil {
+					getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+				}
+
+			}
+
+			return
+
+		}
+
+		if len(query.Paths) == 0 {
+			query.Paths = append(query.Paths, QueryPath{Path: "", Depth: 0})
+		}
+
+		withjoin := make(map[string]int)
+
+		for _, qpath := range query.Paths {
+
+			kdir := filepath.Clean(abs + "/" + qpath.Path)
+
+			if kdir != base && !strings.HasPrefix(kdir, base+"/") {
+
+				ctx.StatusCode(iris.StatusForbidden)
+
+				if log4xx {
+					getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 403 | Query path is outside of virtual host root error | Path [%s]", vhost, ip, kdir)
+				}
+
+				if debugmode {
+
+					_, err = ctx.WriteString("[ERRO] Query path is outside of virtual host root error\n")
+					if err != nil {
+						getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+					}
+
+				}
+
+				return
+
+			}
+
+			if !DirExists(kdir) {
+
+				ctx.StatusCode(iris.StatusNotFound)
+
+				if log4xx {
+					getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 404 | Not found | Path [%s]", vhost, ip, kdir)
+				}
+
+				if debugmode {
+
+					_, err = ctx.WriteString("[ERRO] Can`t find query directory error\n")
+					if err != nil {
+						getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+					}
+
+				}
+
+				return
+
+			}
+
+			withjoin[kdir] = qpath.Depth
+
+		}
+
+		var qerr string = ""
+
+		switch {
+		case !getrecursive && len(query.Paths) == 1 && query.Paths[0].Depth != 0:
+			qerr = "recursive"
+		case !getjoin && len(query.Paths) > 1:
+			qerr = "with join"
+		case !getvalue && query.WithValue:
+			qerr = "with value"
+		case !getcount && query.Count:
+			qerr = "count"
+		case !getcache && query.Expire >= 0:
+			qerr = "expire"
+		}
+
+		if qerr != "" {
+
+			ctx.StatusCode(iris.StatusForbidden)
+
+			if log4xx {
+				getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 403 | The %s request is not allowed during POST query request", vhost, ip, qerr)
+			}
+
+			if debugmode {
+
+				_, err = ctx.Writef("[ERRO] The %s request is not allowed during POST query request\n", qerr)
+				if err != nil {
+					getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+				}
+
+			}
+
+			return
+
+		}
+
+		// Cache
+
+		cseq := CacheSeq()
+
+		var vchash []byte = nil
+		var vcerr string = "0"
+		var errmsg string = "none"
+
+		if getcache {
+
+			vckey := []byte("host:" + vhost + ";path:" + abs + ";query:" + rawbuffer.String())
+
+			vcblk := blake2b.Sum256(vckey)
+			vchash = vcblk[:]
+
+			if !query.SkipCache {
+
+				vcget, err := cache.Get(vchash)
+				if err == nil {
+
+					hsize := fmt.Sprintf("%d", len(vcget))
+					scctrl := fmt.Sprintf("max-age=%d", cctrl)
+
+					ctx.Header("Content-Type", "application/json")
+					ctx.Header("Content-Length", hsize)
+					ctx.Header("Cache-Control", scctrl)
+
+					ctx.Header("Hitcache", "1")
+					ctx.Header("Errcache", vcerr)
+					ctx.Header("Errmsg", errmsg)
+
+					_, err = ctx.Write(vcget)
+					if err != nil {
+
+						if log4xx {
+							getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+						}
+
+					}
+
+					return
+
+				}
+
+			}
+
+		}
+
+		// Query Iterator
+
+		getkeys, total, err := QueryKeys(filemode, timeout, opentries, freelist, ndb, base, &query, withjoin, furi, vmaxsize, searchthreads, searchtimeout, searchmaxdirs, searchmaxresults)
+		if err == errMaxDirs || err == errMaxResults {
+
+			ctx.StatusCode(iris.StatusRequestEntityTooLarge)
+
+			if log4xx {
+				getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 413 | Search limits exceeded error | Path [%s] | %v", vhost, ip, abs, err)
+			}
+
+			if debugmode {
+
+				_, err = ctx.Writef("[ERRO] Search limits exceeded error | %v\n", err)
+				if err != nil {
+					getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+				}
+
+			}
+
+			return
+
+		}
+
+		if err != nil {
+
+			ctx.StatusCode(iris.StatusInternalServerError)
+			getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 500 | Can`t query files and keys in directory error | Path [%s] | %v", vhost, ip, abs, err)
+
+			if debugmode {
+
+				_, err = ctx.WriteString("[ERRO] Can`t query files and keys in directory error\n")
+				if err != nil {
+					getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+				}
+
+			}
+
+			return
+
+		}
+
+		var rbytes []byte
+
+		switch {
+		case query.Count:
+			jcount, _ := json.Marshal(total)
+			rbytes = []byte(fmt.Sprintf("{\"count\": %s}", string(jcount)))
+		case len(getkeys) == 0:
+			rbytes = []byte("{\"keys\": []}")
+		default:
+			jkeys, _ := json.Marshal(getkeys)
+			rbytes = []byte(fmt.Sprintf("{\"keys\": %s}", string(jkeys)))
+		}
+
+		hsize := fmt.Sprintf("%d", len(rbytes))
+		scctrl := fmt.Sprintf("max-age=%d", cctrl)
+
+		ctx.Header("Content-Type", "application/json")
+		ctx.Header("Content-Length", hsize)
+		ctx.Header("Cache-Control", scctrl)
+
+		ctx.Header("Hitcache", "0")
+
+		if getcache && query.Expire >= 0 {
+
+			err = CacheSet(cache, vchash, rbytes, query.Expire, withjoin, cseq)
+			if err != nil {
+				vcerr = "1"
+				errmsg = fmt.Sprintf("%v", err)
+				getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 599 | Write search results to cache error | Path [%s] | %v", vhost, ip, abs, err)
+			}
+
+		}
+
+		ctx.Header("Errcache", vcerr)
+		ctx.Header("Errmsg", errmsg)
+
+		_, err = ctx.Write(rbytes)
+		if err != nil {
+
+			if log4xx {
+				getLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+			}
+
+		}
+
+	}
+
+}
@@ -30,6 +30,7 @@ import (
 	"encoding/gob"
 	"errors"
 	"fmt"
+	"github.com/coocood/freecache"
 	"github.com/eltaline/bolt"
 	"github.com/eltaline/mmutex"
 	"github.com/eltaline/nutsdb"
@@ -49,8 +50,17 @@ import (
 // Put
 
 // ZDPut : PUT/POST/PATCH methods
-func ZDPut(keymutex *mmutex.Mutex, cdb *nutsdb.DB, ndb *nutsdb.DB, wg *sync.WaitGroup) iris.Handler {
+func ZDPut(cache *freecache.Cache, keymutex *mmutex.Mutex, cdb *nutsdb.DB, wg *sync.WaitGroup) iris.Handler {
+
+	proxy := ZDProxy(wg)
+
 	return func(ctx iris.Context) {
+
+		if ProxyHost(strings.Split(ctx.Host(), ":")[0]) != nil {
+			proxy(ctx)
+			return
+		}
+
 		defer wg.Done()
 
 		var err error
@@ -70,6 +80,12 @@ func ZDPut(keymutex *mmutex.Mutex, cdb *nutsdb.DB, ndb *nutsdb.DB, wg *sync.Wait
 		cip := net.ParseIP(ip)
 		vhost := strings.Split(ctx.Host(), ":")[0]
 
+		// Search DB of virtual host
+
+		ndb, search := SearchHost(vhost)
+		ftopts := FTHost(vhost)
+		jxfields := JXHost(vhost)
+
 		// Shutdown
 
 		if shutdown {
@@ -138,7 +154,7 @@ func ZDPut(keymutex *mmutex.Mutex, cdb *nutsdb.DB, ndb *nutsdb.DB, wg *sync.Wait
 
 				base = filepath.Clean(Server.ROOT)
 
-				for _, Vhost := range putallow {
+				for _, Vhost := range AllowPut() {
 
 					if vhost == Vhost.Vhost {
 
@@ -206,6 +222,13 @@ func ZDPut(keymutex *mmutex.Mutex, cdb *nutsdb.DB, ndb *nutsdb.DB, wg *sync.Wait
 
 		}
 
+		// Files and keys fetched from origin are uploaded by server itself
+
+		if OriginFill(ctx) {
+			badip = false
+			upload = true
+		}
+
 		if badhost {
 
 			ctx.StatusCode(iris.StatusMisdirectedRequest)
@@ -269,6 +292,27 @@ func ZDPut(keymutex *mmutex.Mutex, cdb *nutsdb.DB, ndb *nutsdb.DB, wg *sync.Wait
 
 		}
 
+		if ReadOnly(vhost) {
+
+			ctx.StatusCode(iris.StatusForbidden)
+
+			if log4xx {
+				putLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 403 | Virtual host is read-only", vhost, ip)
+			}
+
+			if debugmode {
+
+				_, err = ctx.Writef("[ERRO] Virtual host is read-only | Virtual Host [%s]\n", vhost)
+				if err != nil {
+					putLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+				}
+
+			}
+
+			return
+
+		}
+
 		if len(params) != 0 {
 
 			ctx.StatusCode(iris.StatusForbidden)
@@ -450,6 +494,13 @@ func ZDPut(keymutex *mmutex.Mutex, cdb *nutsdb.DB, ndb *nutsdb.DB, wg *sync.Wait
 		}
 
 		if !DirExists(ddir) {
+
+			var mdirs []string
+
+			for mdir := ddir; mdir != base && mdir != filepath.Dir(mdir) && !DirExists(mdir); mdir = filepath.Dir(mdir) {
+				mdirs = append(mdirs, mdir)
+			}
+
 			err = os.MkdirAll(ddir, dirmode)
 			if err != nil {
 
@@ -488,6 +539,21 @@ func ZDPut(keymutex *mmutex.Mutex, cdb *nutsdb.DB, ndb *nutsdb.DB, wg *sync.Wait
 
 			}
 
+			if search {
+
+				for i := len(mdirs) - 1; i >= 0; i-- {
+
+					err = TreeMkdir(ndb, mdirs[i])
+					if err != nil {
+						putLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 599 | Write created directory to search db error | Directory [%s] | %v", vhost, ip, mdirs[i], err)
+					}
+
+					CacheInvalidate(cache, filepath.Dir(mdirs[i]))
+
+				}
+
+			}
+
 		}
 
 		// Standart Writer
@@ -642,6 +708,8 @@ func ZDPut(keymutex *mmutex.Mutex, cdb *nutsdb.DB, ndb *nutsdb.DB, wg *sync.Wait
 
 			if key {
 
+				fexists := FileExists(abs)
+
 				wfile, err := os.OpenFile(abs, os.O_WRONLY|os.O_TRUNC|os.O_CREATE, filemode)
 				if err != nil {
 
@@ -883,15 +951,21 @@ func ZDPut(keymutex *mmutex.Mutex, cdb *nutsdb.DB, ndb *nutsdb.DB, wg *sync.Wait
 
 					}
 
+					err = FeedAppend(vhost, FeedEvent{Path: FeedPath(base, abs), Type: 0, Size: uint64(realsize), Op: FeedOp(fexists)})
+					if err != nil {
+						putLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 599 | Write event to change feed or webhooks queue db error | File [%s] | Path [%s] | %v", vhost, ip, file, abs, err)
+					}
+
 					if search {
 
 						var nval RawKeysData
 
 						dcrc := crc64.Checksum([]byte(ddir), ctbl64)
 
-						radix.Lock()
-						tree, _, _ = tree.Insert([]byte(ddir), dcrc)
-						radix.Unlock()
+						err = TreeInsert(ndb, ddir, dcrc)
+						if err != nil {
+							putLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 599 | Write directory to search tree db error | Path [%s] | %v", vhost, ip, ddir, err)
+						}
 
 						nbucket := strconv.FormatUint(dcrc, 16)
 
@@ -927,6 +1001,8 @@ func ZDPut(keymutex *mmutex.Mutex, cdb *nutsdb.DB, ndb *nutsdb.DB, wg *sync.Wait
 
 						}
 
+						CacheInvalidate(cache, ddir)
+
 					}
 
 					keymutex.UnLock(abs)
@@ -1042,15 +1118,21 @@ func ZDPut(keymutex *mmutex.Mutex, cdb *nutsdb.DB, ndb *nutsdb.DB, wg *sync.Wait
 
 				}
 
+				err = FeedAppend(vhost, FeedEvent{Path: FeedPath(base, abs), Type: 0, Size: uint64(realsize), Op: FeedOp(fexists)})
+				if err != nil {
+					putLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 599 | Write event to change feed or webhooks queue db error | File [%s] | Path [%s] | %v", vhost, ip, file, abs, err)
+				}
+
 				if search {
 
 					var nval RawKeysData
 
 					dcrc := crc64.Checksum([]byte(ddir), ctbl64)
 
-					radix.Lock()
-					tree, _, _ = tree.Insert([]byte(ddir), dcrc)
-					radix.Unlock()
+					err = TreeInsert(ndb, ddir, dcrc)
+					if err != nil {
+						putLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 599 | Write directory to search tree db error | Path [%s] | %v", vhost, ip, ddir, err)
+					}
 
 					nbucket := strconv.FormatUint(dcrc, 16)
 
@@ -1086,6 +1168,8 @@ func ZDPut(keymutex *mmutex.Mutex, cdb *nutsdb.DB, ndb *nutsdb.DB, wg *sync.Wait
 
 					}
 
+					CacheInvalidate(cache, ddir)
+
 				}
 
 				keymutex.UnLock(abs)
@@ -1135,20 +1219,7 @@ func ZDPut(keymutex *mmutex.Mutex, cdb *nutsdb.DB, ndb *nutsdb.DB, wg *sync.Wait
 
 			}
 
-			var perbucket int = 1024
-
-			switch {
-			case clength >= 262144 && clength < 1048576:
-				perbucket = 512
-			case clength >= 1048576 && clength < 4194304:
-				perbucket = 256
-			case clength >= 4194304 && clength < 8388608:
-				perbucket = 128
-			case clength >= 8388608 && clength < 16777216:
-				perbucket = 64
-			case clength >= 16777216:
-				perbucket = 32
-			}
+			perbucket := PerBucket(clength)
 
 			var bf BoltFiles
 			var bfiles []BoltFiles
@@ -1828,6 +1899,8 @@ func ZDPut(keymutex *mmutex.Mutex, cdb *nutsdb.DB, ndb *nutsdb.DB, wg *sync.Wait
 
 				realsize := int64(len(rawbuffer.Bytes()))
 
+				ivalue := rawbuffer.Bytes()
+
 				if realsize == 0 {
 
 					ctx.StatusCode(iris.StatusBadRequest)
@@ -2294,6 +2367,11 @@ func ZDPut(keymutex *mmutex.Mutex, cdb *nutsdb.DB, ndb *nutsdb.DB, wg *sync.Wait
 
 				}
 
+				err = FeedAppend(vhost, FeedEvent{Path: FeedPath(base, ddir+"/"+file), Archive: FeedPath(base, dbf), Type: 1, Size: uint64(realsize), Crc: wcrc, Op: FeedOp(keyexists != "")})
+				if err != nil {
+					putLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 599 | Write event to change feed or webhooks queue db error | File [%s] | DB [%s] | %v", vhost, ip, file, dbf, err)
+				}
+
 				if search {
 
 					var nval RawKeysData
@@ -2303,9 +2381,10 @@ func ZDPut(keymutex *mmutex.Mutex, cdb *nutsdb.DB, ndb *nutsdb.DB, wg *sync.Wait
 
 					dcrc := crc64.Checksum([]byte(ddir), ctbl64)
 
-					radix.Lock()
-					tree, _, _ = tree.Insert([]byte(ddir), dcrc)
-					radix.Unlock()
+					err = TreeInsert(ndb, ddir, dcrc)
+					if err != nil {
+						putLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 599 | Write directory to search tree db error | Path [%s] | %v", vhost, ip, ddir, err)
+					}
 
 					nbucket := strconv.FormatUint(dcrc, 16)
 
@@ -2348,6 +2427,26 @@ func ZDPut(keymutex *mmutex.Mutex, cdb *nutsdb.DB, ndb *nutsdb.DB, wg *sync.Wait
 
 					}
 
+					if ftopts != nil {
+
+						err = FTIndex(ndb, nbucket, file, ivalue, ftopts)
+						if err != nil {
+							putLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 599 | Write file words to full-text search db error | File [%s] | DB [%s] | NDB Bucket [%s] | %v", vhost, ip, file, dbf, nbucket, err)
+						}
+
+					}
+
+					if jxfields != nil {
+
+						err = JXIndex(ndb, nbucket, file, ivalue, jxfields)
+						if err != nil {
+							putLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 599 | Write file JSON fields to search db error | File [%s] | DB [%s] | NDB Bucket [%s] | %v", vhost, ip, file, dbf, nbucket, err)
+						}
+
+					}
+
+					CacheInvalidate(cache, ddir)
+
 				}
 
 				if keyexists != "" && compaction && cmpsched || compact {
@@ -2357,6 +2456,8 @@ func ZDPut(keymutex *mmutex.Mutex, cdb *nutsdb.DB, ndb *nutsdb.DB, wg *sync.Wait
 						err = db.CompactQuietly()
 						if err != nil {
 							putLogger.Errorf("| On the fly compaction error | DB [%s] | %v", dbf, err)
+						} else if ferr := FeedCompact(dbf); ferr != nil {
+							putLogger.Errorf("| Write compaction event to change feed or webhooks queue db error | DB [%s] | %v", dbf, ferr)
 						}
 
 						err = os.Chmod(dbf, filemode)
@@ -2401,6 +2502,8 @@ func ZDPut(keymutex *mmutex.Mutex, cdb *nutsdb.DB, ndb *nutsdb.DB, wg *sync.Wait
 						err = db.CompactQuietly()
 						if err != nil {
 							putLogger.Errorf("| On the fly compaction error | DB [%s] | %v", dbf, err)
+						} else if ferr := FeedCompact(dbf); ferr != nil {
+							putLogger.Errorf("| Write compaction event to change feed or webhooks queue db error | DB [%s] | %v", dbf, ferr)
 						}
 
 						err = os.Chmod(dbf, filemode)
@@ -0,0 +1,989 @@
+/*
+
+Copyright © 2020 Andrey Kuvshinov. Contacts: <syslinux@protonmail.com>
+Copyright © 2020 Eltaline OU. Contacts: <eltaline.ou@gmail.com>
+All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+The wZD project contains unmodified/modified libraries imports too with
+separate copyright notices and license terms. Your use of the source code
+this libraries is subject to the terms and conditions of licenses these libraries.
+
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/kataras/golog"
+	"github.com/kataras/iris/v12"
+	"hash/crc64"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Proxy Handlers
+
+const proxypoints = 160
+
+// proxyhop : hop-by-hop headers, which are not forwarded between client and backend nodes
+var proxyhop = map[string]bool{
+	"Connection":          true,
+	"Keep-Alive":          true,
+	"Proxy-Authenticate":  true,
+	"Proxy-Authorization": true,
+	"Te":                  true,
+	"Trailer":             true,
+	"Transfer-Encoding":   true,
+	"Upgrade":             true,
+}
+
+// ZDProxy : all methods of virtual host in proxy mode, objects are routed to backend nodes by consistent hash of directory, keys searches are sent to all nodes and merged
+func ZDProxy(wg *sync.WaitGroup) iris.Handler {
+	return func(ctx iris.Context) {
+		defer wg.Done()
+
+		var err error
+
+		// Wait Group
+
+		wg.Add(1)
+
+		// Loggers
+
+		method := ctx.Method()
+
+		logger := GetLogger
+		allow := AllowGet()
+
+		switch method {
+		case "PUT", "POST", "PATCH":
+			logger = PutLogger
+			allow = AllowPut()
+		case "DELETE":
+			logger = DelLogger
+			allow = AllowDel()
+		}
+
+		pxyLogger, pxylogfile := logger()
+		defer pxylogfile.Close()
+
+		// Shutdown
+
+		if shutdown {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			return
+		}
+
+		// Headers
+
+		ip := ctx.RemoteAddr()
+		cip := net.ParseIP(ip)
+		vhost := strings.Split(ctx.Host(), ":")[0]
+
+		uri := ctx.Path()
+
+		hsea := ctx.GetHeader("Sea")
+
+		ring := ProxyHost(vhost)
+
+		badip := true
+
+		log4xx := true
+
+		for _, Server := range config.Server {
+
+			if vhost == Server.HOST {
+				log4xx = Server.LOG4XX
+				break
+			}
+
+		}
+
+		for _, Vhost := range allow {
+
+			if vhost == Vhost.Vhost {
+
+				for _, CIDR := range Vhost.CIDR {
+					_, ipnet, _ := net.ParseCIDR(CIDR.Addr)
+					if ipnet.Contains(cip) {
+						badip = false
+						break
+					}
+				}
+
+				break
+
+			}
+
+		}
+
+		if badip {
+
+			ctx.StatusCode(iris.StatusForbidden)
+
+			if log4xx {
+				pxyLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 403 | Forbidden", vhost, ip)
+			}
+
+			if debugmode {
+
+				_, err = ctx.Writef("[ERRO] Not found allowed ip | Virtual Host [%s]\n", vhost)
+				if err != nil {
+					pxyLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+				}
+
+			}
+
+			return
+
+		}
+
+		if ctx.GetHeader("Feed") != "" || ctx.GetHeader("Replication") != "" || ctx.GetHeader("Digest") != "" || (method == "POST" && (hsea == "1" || ctx.GetHeader("Reindex") == "1" || ctx.GetHeader("Backup") != "" || ctx.GetHeader("Restore") != "")) {
+
+			ctx.StatusCode(iris.StatusNotImplemented)
+
+			if log4xx {
+				pxyLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 501 | The change feed, digests, JSON queries, reindex, backups and restores are not available in proxy mode, they must be sent to backend nodes", vhost, ip)
+			}
+
+			if debugmode {
+
+				_, err = ctx.WriteString("[ERRO] The change feed, digests, JSON queries, reindex, backups and restores are not available in proxy mode, they must be sent to backend nodes\n")
+				if err != nil {
+					pxyLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+				}
+
+			}
+
+			return
+
+		}
+
+		if (method == "PUT" || method == "POST" || method == "PATCH" || method == "DELETE") && ReadOnly(vhost) {
+
+			ctx.StatusCode(iris.StatusForbidden)
+
+			if log4xx {
+				pxyLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 403 | Virtual host is read-only", vhost, ip)
+			}
+
+			if debugmode {
+
+				_, err = ctx.Writef("[ERRO] Virtual host is read-only | Virtual Host [%s]\n", vhost)
+				if err != nil {
+					pxyLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+				}
+
+			}
+
+			return
+
+		}
+
+		switch {
+		case (method == "GET" || method == "HEAD") && hsea == "1":
+			ProxySearch(ctx, ring, pxyLogger, log4xx)
+		case method == "GET" || method == "HEAD" || method == "OPTIONS":
+			ProxyRead(ctx, ring, ProxyNodes(ring, filepath.Dir(uri)), pxyLogger, log4xx)
+		case method == "PUT" || method == "POST" || method == "PATCH" || method == "DELETE":
+			ProxyWrite(ctx, ring, ProxyNodes(ring, filepath.Dir(uri)), pxyLogger, log4xx)
+		default:
+
+			ctx.StatusCode(iris.StatusMethodNotAllowed)
+
+			if log4xx {
+				pxyLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 405 | Method is not allowed in proxy mode | Method [%s]", vhost, ip, method)
+			}
+
+		}
+
+	}
+
+}
+
+// ProxyOpen : build consistent hash rings of backend nodes for virtual hosts in proxy mode
+func ProxyOpen() {
+
+	for _, Server := range config.Server {
+
+		if Server.MODE != "proxy" {
+			continue
+		}
+
+		phosts[Server.HOST] = ProxyRingNew(Server.HOST, Server.NODES, Server.COPIES, Server.NODETIMEOUT)
+
+	}
+
+}
+
+// ProxyRingNew : consistent hash ring of comma separated backend nodes with number of copies limited by number of nodes
+func ProxyRingNew(vhost string, nodes string, copies int, nodetimeout int) *ProxyRing {
+
+	timeout := 60
+	if nodetimeout > 0 {
+		timeout = nodetimeout
+	}
+
+	ring := &ProxyRing{
+		Host:   vhost,
+		Copies: copies,
+		Client: &http.Client{
+			Transport: &http.Transport{
+				DialContext:           (&net.Dialer{Timeout: time.Duration(timeout) * time.Second, KeepAlive: 30 * time.Second}).DialContext,
+				MaxIdleConnsPerHost:   64,
+				IdleConnTimeout:       90 * time.Second,
+				ResponseHeaderTimeout: time.Duration(timeout) * time.Second,
+			},
+		},
+	}
+
+	for _, node := range strings.Split(nodes, ",") {
+
+		node = strings.TrimSuffix(strings.TrimSpace(node), "/")
+		if node != "" {
+			ring.Nodes = append(ring.Nodes, node)
+		}
+
+	}
+
+	if ring.Copies < 1 {
+		ring.Copies = 1
+	}
+
+	if ring.Copies > len(ring.Nodes) {
+		ring.Copies = len(ring.Nodes)
+	}
+
+	for n, node := range ring.Nodes {
+
+		for i := 0; i < proxypoints; i++ {
+			ring.Points = append(ring.Points, ProxyPoint{Hash: crc64.Checksum([]byte(node+"#"+strconv.Itoa(i)), ctbl64), Node: n})
+		}
+
+	}
+
+	sort.Slice(ring.Points, func(i, j int) bool { return ring.Points[i].Hash < ring.Points[j].Hash })
+
+	return ring
+
+}
+
+// ProxyHost : consistent hash ring of virtual host, nil if virtual host is not in proxy mode
+func ProxyHost(vhost string) *ProxyRing {
+	return phosts[vhost]
+}
+
+// ProxyNodes : backend nodes of directory in order of consistent hash ring, all files and Bolt archive of one directory are placed on the same nodes
+func ProxyNodes(ring *ProxyRing, dir string) []string {
+
+	var nodes []string
+
+	if len(ring.Points) == 0 {
+		return nodes
+	}
+
+	hash := crc64.Checksum([]byte(dir), ctbl64)
+	first := sort.Search(len(ring.Points), func(i int) bool { return ring.Points[i].Hash >= hash })
+
+	seen := make(map[int]bool)
+
+	for i := 0; i < len(ring.Points) && len(nodes) < ring.Copies; i++ {
+
+		point := ring.Points[(first+i)%len(ring.Points)]
+
+		if seen[point.Node] {
+			continue
+		}
+
+		seen[point.Node] = true
+		nodes = append(nodes, ring.Nodes[point.Node])
+
+	}
+
+	return nodes
+
+}
+
+// ProxyForward : send client request to backend node with original virtual host, path, query and headers
+func ProxyForward(ring *ProxyRing, ctx iris.Context, method string, node string, header http.Header, body io.Reader, length int64) (*http.Response, error) {
+
+	req, err := http.NewRequest(method, node+ctx.Request().URL.RequestURI(), body)
+	if err != nil {
+		return nil, err
+	}
+
+	for name, values := range header {
+
+		if !proxyhop[name] {
+			req.Header[name] = values
+		}
+
+	}
+
+	req.Host = ctx.Host()
+	req.ContentLength = length
+
+	return ring.Client.Do(req)
+
+}
+
+// ProxyReply : copy status, headers and body of backend node response to client
+func ProxyReply(ctx iris.Context, resp *http.Response) error {
+
+	defer resp.Body.Close()
+
+	for name, values := range resp.Header {
+
+		if proxyhop[name] {
+			continue
+		}
+
+		for _, value := range values {
+			ctx.ResponseWriter().Header().Add(name, value)
+		}
+
+	}
+
+	ctx.StatusCode(resp.StatusCode)
+
+	_, err := io.Copy(ctx.ResponseWriter(), resp.Body)
+
+	return err
+
+}
+
+// ProxyRead : GET, HEAD and OPTIONS methods are sent to backend nodes of directory in order, next node is used if node is unavailable or has no object
+func ProxyRead(ctx iris.Context, ring *ProxyRing, nodes []string, pxyLogger *golog.Logger, log4xx bool) {
+
+	var err error
+
+	ip := ctx.RemoteAddr()
+	vhost := strings.Split(ctx.Host(), ":")[0]
+	uri := ctx.Path()
+
+	notfound := false
+
+	for n, node := range nodes {
+
+		resp, err := ProxyForward(ring, ctx, ctx.Method(), node, ctx.Request().Header, nil, 0)
+		if err != nil {
+			pxyLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 502 | Can`t send request to backend node error | Node [%s] | Path [%s] | %v", vhost, ip, node, uri, err)
+			continue
+		}
+
+		if n < len(nodes)-1 && (resp.StatusCode == http.StatusNotFound || resp.StatusCode >= 500) {
+
+			if resp.StatusCode == http.StatusNotFound {
+				notfound = true
+			}
+
+			resp.Body.Close()
+			continue
+
+		}
+
+		err = ProxyReply(ctx, resp)
+		if err != nil {
+
+			if log4xx {
+				pxyLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+			}
+
+		}
+
+		return
+
+	}
+
+	if notfound {
+
+		ctx.StatusCode(iris.StatusNotFound)
+
+		if log4xx {
+			pxyLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 404 | Not found | Path [%s]", vhost, ip, uri)
+		}
+
+		return
+
+	}
+
+	ctx.StatusCode(iris.StatusBadGateway)
+	pxyLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 502 | All backend nodes of directory are unavailable | Nodes [%s] | Path [%s]", vhost, ip, strings.Join(nodes, ","), uri)
+
+	if debugmode {
+
+		_, err = ctx.WriteString("[ERRO] All backend nodes of directory are unavailable\n")
+		if err != nil {
+			pxyLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+		}
+
+	}
+
+}
+
+// ProxyWrite : PUT, POST and DELETE methods are sent to all backend nodes of directory, body is spooled to temporary file if there are several copies
+func ProxyWrite(ctx iris.Context, ring *ProxyRing, nodes []string, pxyLogger *golog.Logger, log4xx bool) {
+
+	var err error
+
+	ip := ctx.RemoteAddr()
+	vhost := strings.Split(ctx.Host(), ":")[0]
+	uri := ctx.Path()
+	method := ctx.Method()
+
+	var body io.Reader = ctx.Request().Body
+	length := ctx.Request().ContentLength
+
+	var spool *os.File
+
+	switch {
+	case method == "DELETE":
+		body = nil
+		length = 0
+	case len(nodes) > 1:
+
+		spool, err = ioutil.TempFile("", "wzd-proxy-")
+		if err != nil {
+
+			ctx.StatusCode(iris.StatusInternalServerError)
+			pxyLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 500 | Can`t create temporary file for body of request error | Path [%s] | %v", vhost, ip, uri, err)
+
+			if debugmode {
+
+				_, err = ctx.WriteString("[ERRO] Can`t create temporary file for body of request error\n")
+				if err != nil {
+					pxyLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+				}
+
+			}
+
+			return
+
+		}
+
+		defer os.Remove(spool.Name())
+		defer spool.Close()
+
+		length, err = io.Copy(spool, ctx.Request().Body)
+		if err != nil {
+
+			ctx.StatusCode(iris.StatusBadRequest)
+
+			if log4xx {
+				pxyLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 400 | Can`t receive body of request error | Path [%s] | %v", vhost, ip, uri, err)
+			}
+
+			return
+
+		}
+
+	}
+
+	var okresp *http.Response
+	var badresp *http.Response
+	var anyresp *http.Response
+
+	failed := 0
+
+	for _, node := range nodes {
+
+		if spool != nil {
+
+			_, err = spool.Seek(0, io.SeekStart)
+			if err != nil {
+				pxyLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 500 | Can`t seek temporary file for body of request error | Path [%s] | %v", vhost, ip, uri, err)
+				failed++
+				continue
+			}
+
+			body = ioutil.NopCloser(spool)
+
+		}
+
+		resp, err := ProxyForward(ring, ctx, method, node, ctx.Request().Header, body, length)
+		if err != nil {
+			pxyLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 502 | Can`t send request to backend node error | Node [%s] | Path [%s] | %v", vhost, ip, node, uri, err)
+			failed++
+			continue
+		}
+
+		rbody, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		resp.Body = ioutil.NopCloser(bytes.NewReader(rbody))
+
+		switch {
+		case err != nil:
+			pxyLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 502 | Can`t read response of backend node error | Node [%s] | Path [%s] | %v", vhost, ip, node, uri, err)
+			failed++
+			continue
+		case resp.StatusCode < 300:
+
+			if okresp == nil {
+				okresp = resp
+			}
+
+		case method == "DELETE" && resp.StatusCode == http.StatusNotFound:
+
+			if anyresp == nil {
+				anyresp = resp
+			}
+
+		default:
+
+			pxyLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | %d | Backend node rejected request | Node [%s] | Path [%s]", vhost, ip, resp.StatusCode, node, uri)
+			failed++
+
+			if badresp == nil {
+				badresp = resp
+			}
+
+		}
+
+	}
+
+	if failed > 0 && okresp != nil {
+		pxyLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 502 | Copies of object are inconsistent, request is completed only on part of backend nodes | Nodes [%s] | Failed [%d] | Path [%s]", vhost, ip, strings.Join(nodes, ","), failed, uri)
+	}
+
+	reply := badresp
+
+	switch {
+	case reply == nil && okresp != nil:
+		reply = okresp
+	case reply == nil:
+		reply = anyresp
+	}
+
+	if reply == nil {
+
+		ctx.StatusCode(iris.StatusBadGateway)
+		pxyLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 502 | All backend nodes of directory are unavailable | Nodes [%s] | Path [%s]", vhost, ip, strings.Join(nodes, ","), uri)
+
+		if debugmode {
+
+			_, err = ctx.WriteString("[ERRO] All backend nodes of directory are unavailable\n")
+			if err != nil {
+				pxyLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+			}
+
+		}
+
+		return
+
+	}
+
+	err = ProxyReply(ctx, reply)
+	if err != nil {
+
+		if log4xx {
+			pxyLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+		}
+
+	}
+
+}
+
+// ProxySearch : GET method with Sea header, keys, info, search and count requests are sent to all backend nodes in parallel, keys are merged and counted without duplicates of copies
+func ProxySearch(ctx iris.Context, ring *ProxyRing, pxyLogger *golog.Logger, log4xx bool) {
+
+	var err error
+
+	ip := ctx.RemoteAddr()
+	vhost := strings.Split(ctx.Host(), ":")[0]
+	uri := ctx.Path()
+
+	header := ctx.Request().Header.Clone()
+
+	kind := ""
+
+	switch {
+	case header.Get("Delimiter") != "" || header.Get("KeysStats") != "" || header.Get("KeysStatsFiles") != "" || header.Get("KeysStatsArchives") != "":
+		kind = ""
+	case header.Get("Keys") != "" || header.Get("KeysFiles") != "" || header.Get("KeysArchives") != "":
+		kind = "keys"
+	case header.Get("KeysInfo") != "" || header.Get("KeysInfoFiles") != "" || header.Get("KeysInfoArchives") != "":
+		kind = "info"
+	case header.Get("KeysSearch") != "" || header.Get("KeysSearchFiles") != "" || header.Get("KeysSearchArchives") != "":
+		kind = "search"
+	case header.Get("KeysCount") != "" || header.Get("KeysCountFiles") != "" || header.Get("KeysCountArchives") != "":
+		kind = "count"
+	}
+
+	if kind == "" {
+
+		ctx.StatusCode(iris.StatusNotImplemented)
+
+		if log4xx {
+			pxyLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 501 | Only keys, info, search and count requests are available in proxy mode | Path [%s]", vhost, ip, uri)
+		}
+
+		if debugmode {
+
+			_, err = ctx.WriteString("[ERRO] Only keys, info, search and count requests are available in proxy mode\n")
+			if err != nil {
+				pxyLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+			}
+
+		}
+
+		return
+
+	}
+
+	hoffset := header.Get("Offset")
+	hlimit := header.Get("Limit")
+	hsortby := header.Get("SortBy")
+	hjson := header.Get("JSON")
+
+	offset := -1
+	limit := -1
+
+	if hoffset != "" {
+		offset, err = strconv.Atoi(hoffset)
+	}
+
+	if err == nil && hlimit != "" {
+		limit, err = strconv.Atoi(hlimit)
+	}
+
+	if err != nil || (hsortby != "" && hsortby != "key" && hsortby != "size" && hsortby != "date") {
+
+		ctx.StatusCode(iris.StatusBadRequest)
+
+		if log4xx {
+			pxyLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 400 | Offset and Limit must be numbers and SortBy must be key, size or date error during GET keys* request | Offset [%s] | Limit [%s] | SortBy [%s]", vhost, ip, hoffset, hlimit, hsortby)
+		}
+
+		if debugmode {
+
+			_, err = ctx.WriteString("[ERRO] Offset and Limit must be numbers and SortBy must be key, size or date error during GET keys* request\n")
+			if err != nil {
+				pxyLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+			}
+
+		}
+
+		return
+
+	}
+
+	// Every node returns first offset+limit keys of own sorted results, page is cut after merge
+
+	header.Set("JSON", "1")
+	header.Del("Offset")
+	header.Del("Limit")
+
+	// Counts are taken from keys of every node, so key which is written only on part of its copies is counted once
+
+	if kind == "count" {
+
+		for _, name := range []string{"", "Files", "Archives"} {
+
+			if header.Get("KeysCount"+name) != "" {
+				header.Set("Keys"+name, header.Get("KeysCount"+name))
+				header.Del("KeysCount" + name)
+			}
+
+		}
+
+	}
+
+	if kind != "count" && limit > 0 {
+
+		first := 0
+		if offset > 0 {
+			first = offset
+		}
+
+		header.Set("Limit", strconv.Itoa(first+limit))
+
+	}
+
+	results := ProxyFan(ctx, ring, header)
+
+	var keys []KeysSearch
+
+	failed := 0
+
+	for _, result := range results {
+
+		if result.Err != nil {
+			pxyLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 502 | Keys request to backend node error | Node [%s] | Path [%s] | %v", vhost, ip, result.Node, uri, result.Err)
+			failed++
+			continue
+		}
+
+		keys = append(keys, result.Keys...)
+
+	}
+
+	// Keys and counts of every directory are complete while at least one copy answered
+
+	if failed > 0 && failed >= ring.Copies {
+
+		ctx.StatusCode(iris.StatusBadGateway)
+		pxyLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 502 | Not enough backend nodes answered keys request | Failed [%d] | Copies [%d] | Path [%s]", vhost, ip, failed, ring.Copies, uri)
+
+		if debugmode {
+
+			_, err = ctx.WriteString("[ERRO] Not enough backend nodes answered keys request\n")
+			if err != nil {
+				pxyLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+			}
+
+		}
+
+		return
+
+	}
+
+	allkeys := ""
+
+	switch {
+	case kind == "count":
+
+		if hjson == "1" {
+			jcount, _ := json.Marshal(ProxyCount(keys))
+			allkeys = fmt.Sprintf("{\"count\": %s}", string(jcount))
+		} else {
+			allkeys = fmt.Sprintf("%d", ProxyCount(keys))
+		}
+
+	default:
+
+		merged := ProxyMerge(keys, hsortby, header.Get("Sort") == "1", offset, limit)
+
+		if len(merged) == 0 {
+			return
+		}
+
+		var sgetkeys []string
+
+		switch {
+		case hjson == "1" && kind == "keys":
+
+			getkeys := make([]Keys, 0, len(merged))
+
+			for _, vs := range merged {
+				getkeys = append(getkeys, Keys{Key: vs.Key, Type: vs.Type})
+			}
+
+			jkeys, _ := json.Marshal(getkeys)
+			allkeys = fmt.Sprintf("{\"keys\": %s}", string(jkeys))
+
+		case hjson == "1" && kind == "info":
+
+			getkeys := make([]KeysInfo, 0, len(merged))
+
+			for _, vs := range merged {
+				getkeys = append(getkeys, KeysInfo{Key: vs.Key, Size: vs.Size, Date: vs.Date, Type: vs.Type})
+			}
+
+			jkeys, _ := json.Marshal(getkeys)
+			allkeys = fmt.Sprintf("{\"keys\": %s}", string(jkeys))
+
+		case hjson == "1":
+
+			jkeys, _ := json.Marshal(merged)
+			allkeys = fmt.Sprintf("{\"keys\": %s}", string(jkeys))
+
+		case kind == "keys":
+
+			for _, vs := range merged {
+				sgetkeys = append(sgetkeys, vs.Key, strconv.FormatInt(int64(vs.Type), 10), "\n")
+			}
+
+			allkeys = strings.TrimSpace(strings.Join(strings.SplitAfterN(strings.Replace(strings.Trim(fmt.Sprintf("%s", sgetkeys), "[]"), "\n ", "\n", -1), "\n", 1), "\n"))
+
+		default:
+
+			for _, vs := range merged {
+				sgetkeys = append(sgetkeys, vs.Key, strconv.FormatUint(vs.Size, 10), strconv.FormatUint(vs.Date, 10), strconv.FormatInt(int64(vs.Type), 10), "\n")
+			}
+
+			allkeys = strings.TrimSpace(strings.Join(strings.SplitAfterN(strings.Replace(strings.Trim(fmt.Sprintf("%s", sgetkeys), "[]"), "\n ", "\n", -1), "\n", 1), "\n"))
+
+		}
+
+	}
+
+	rbytes := []byte(allkeys)
+
+	ctx.Header("Content-Type", http.DetectContentType(rbytes))
+	ctx.Header("Content-Length", fmt.Sprintf("%d", len(rbytes)))
+
+	_, err = ctx.Write(rbytes)
+	if err != nil {
+
+		if log4xx {
+			pxyLogger.Errorf("| Virtual Host [%s] | Client IP [%s] | 499 | Can`t complete response to client | %v", vhost, ip, err)
+		}
+
+	}
+
+}
+
+// ProxyFan : send keys request to all backend nodes in parallel
+func ProxyFan(ctx iris.Context, ring *ProxyRing, header http.Header) []ProxyResult {
+
+	results := make([]ProxyResult, len(ring.Nodes))
+
+	var fg sync.WaitGroup
+
+	for n, node := range ring.Nodes {
+
+		fg.Add(1)
+
+		go func(n int, node string) {
+			defer fg.Done()
+			results[n] = ProxyCollect(ctx, ring, node, header)
+		}(n, node)
+
+	}
+
+	fg.Wait()
+
+	return results
+
+}
+
+// ProxyCollect : keys of one backend node, not existing directory on node is an empty result
+func ProxyCollect(ctx iris.Context, ring *ProxyRing, node string, header http.Header) ProxyResult {
+
+	result := ProxyResult{Node: node}
+
+	resp, err := ProxyForward(ring, ctx, "GET", node, header, nil, 0)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	defer resp.Body.Close()
+
+	result.Status = resp.StatusCode
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return result
+	case resp.StatusCode != http.StatusOK:
+		result.Err = fmt.Errorf("backend node response status %d", resp.StatusCode)
+		return result
+	}
+
+	rbody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+
+	if len(rbody) == 0 {
+		return result
+	}
+
+	var rkeys struct {
+		Keys []KeysSearch `json:"keys"`
+	}
+
+	err = json.Unmarshal(rbody, &rkeys)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+
+	result.Keys = rkeys.Keys
+
+	return result
+
+}
+
+// ProxyCount : count of keys of answered backend nodes without duplicates of copies
+func ProxyCount(keys []KeysSearch) int {
+
+	seen := make(map[string]bool)
+
+	for _, key := range keys {
+		seen[key.Key+"\x00"+strconv.Itoa(key.Type)] = true
+	}
+
+	return len(seen)
+
+}
+
+// ProxyMerge : merge keys of backend nodes without duplicates of copies, sort them by key, size or date and cut requested page
+func ProxyMerge(keys []KeysSearch, sortby string, desc bool, offset int, limit int) []KeysSearch {
+
+	var merged []KeysSearch
+
+	seen := make(map[string]bool)
+
+	for _, key := range keys {
+
+		kid := key.Key + "\x00" + strconv.Itoa(key.Type)
+
+		if seen[kid] {
+			continue
+		}
+
+		seen[kid] = true
+		merged = append(merged, key)
+
+	}
+
+	sort.SliceStable(merged, func(i, j int) bool {
+
+		a, b := merged[i], merged[j]
+
+		if desc {
+			a, b = b, a
+		}
+
+		switch {
+		case sortby == "size" && a.Size != b.Size:
+			return a.Size < b.Size
+		case sortby == "date" && a.Date != b.Date:
+			return a.Date < b.Date
+		}
+
+		return a.Key < b.Key
+
+	})
+
+	if offset > 0 {
+
+		if offset >= len(merged) {
+			return nil
+		}
+
+		merged = merged[offset:]
+
+	}
+
+	if limit > 0 && len(merged) > limit {
+		merged = merged[:limit]
+	}
+
+	return merged
+
+}
@@ -0,0 +1,535 @@
+/*
+
+Copyright © 2020 Andrey Kuvshinov. Contacts: <syslinux@protonmail.com>
+Copyright © 2020 Eltaline OU. Contacts: <eltaline.ou@gmail.com>
+All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+The wZD project contains unmodified/modified libraries imports too with
+separate copyright notices and license terms. Your use of the source code
+this libraries is subject to the terms and conditions of licenses these libraries.
+
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/kataras/iris/v12"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Proxy Tests
+
+func TestProxyRingNew(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		nodes  string
+		copies int
+		want   []string
+		wcopy  int
+	}{
+		{"single", "http://a:9699", 1, []string{"http://a:9699"}, 1},
+		{"trim", " http://a:9699/ , http://b:9699,,", 2, []string{"http://a:9699", "http://b:9699"}, 2},
+		{"zero copies", "http://a:9699,http://b:9699", 0, []string{"http://a:9699", "http://b:9699"}, 1},
+		{"too many copies", "http://a:9699,http://b:9699", 5, []string{"http://a:9699", "http://b:9699"}, 2},
+	}
+
+	for _, tt := range tests {
+
+		ring := ProxyRingNew("localhost", tt.nodes, tt.copies, 0)
+
+		if !reflect.DeepEqual(ring.Nodes, tt.want) {
+			t.Errorf("%s: nodes %v, want %v", tt.name, ring.Nodes, tt.want)
+		}
+
+		if ring.Copies != tt.wcopy {
+			t.Errorf("%s: copies %d, want %d", tt.name, ring.Copies, tt.wcopy)
+		}
+
+		if len(ring.Points) != len(tt.want)*proxypoints {
+			t.Errorf("%s: points %d, want %d", tt.name, len(ring.Points), len(tt.want)*proxypoints)
+		}
+
+		if !sort.SliceIsSorted(ring.Points, func(i, j int) bool { return ring.Points[i].Hash < ring.Points[j].Hash }) {
+			t.Errorf("%s: points are not sorted", tt.name)
+		}
+
+	}
+
+}
+
+func TestProxyNodes(t *testing.T) {
+
+	nodes := "http://a:9699,http://b:9699,http://c:9699"
+
+	if got := ProxyNodes(&ProxyRing{Copies: 1}, "/var/storage/test"); len(got) != 0 {
+		t.Errorf("empty ring: nodes %v, want none", got)
+	}
+
+	tests := []struct {
+		copies int
+		want   int
+	}{
+		{1, 1},
+		{2, 2},
+		{3, 3},
+	}
+
+	primary := ProxyRingNew("localhost", nodes, 1, 0)
+
+	for _, tt := range tests {
+
+		ring := ProxyRingNew("localhost", nodes, tt.copies, 0)
+
+		for d := 0; d < 100; d++ {
+
+			dir := fmt.Sprintf("/var/storage/test/%d", d)
+
+			got := ProxyNodes(ring, dir)
+
+			if len(got) != tt.want {
+				t.Fatalf("copies %d: dir %s placed on %v, want %d nodes", tt.copies, dir, got, tt.want)
+			}
+
+			uniq := make(map[string]bool)
+
+			for _, node := range got {
+				uniq[node] = true
+			}
+
+			if len(uniq) != len(got) {
+				t.Errorf("copies %d: dir %s placed twice on the same node %v", tt.copies, dir, got)
+			}
+
+			if !reflect.DeepEqual(got, ProxyNodes(ring, dir)) {
+				t.Errorf("copies %d: dir %s placement is not deterministic", tt.copies, dir)
+			}
+
+			if got[0] != ProxyNodes(primary, dir)[0] {
+				t.Errorf("copies %d: dir %s first node %s differs from placement without copies", tt.copies, dir, got[0])
+			}
+
+		}
+
+	}
+
+}
+
+func TestProxyNodesStability(t *testing.T) {
+
+	before := ProxyRingNew("localhost", "http://a:9699,http://b:9699,http://c:9699", 1, 0)
+	after := ProxyRingNew("localhost", "http://a:9699,http://b:9699,http://c:9699,http://d:9699", 1, 0)
+
+	dirs := 3000
+	moved := 0
+
+	for d := 0; d < dirs; d++ {
+
+		dir := fmt.Sprintf("/var/storage/test/%d", d)
+
+		bnode := ProxyNodes(before, dir)[0]
+		anode := ProxyNodes(after, dir)[0]
+
+		if bnode == anode {
+			continue
+		}
+
+		if anode != "http://d:9699" {
+			t.Errorf("dir %s moved from %s to old node %s after adding of new node", dir, bnode, anode)
+		}
+
+		moved++
+
+	}
+
+	// About a quarter of directories moves to the fourth node
+
+	if moved == 0 || moved > dirs*2/5 {
+		t.Errorf("moved %d of %d directories after adding of fourth node", moved, dirs)
+	}
+
+}
+
+func TestProxyMerge(t *testing.T) {
+
+	keys := []KeysSearch{
+		{Key: "b.txt", Size: 30, Date: 100, Type: 0},
+		{Key: "a.txt", Size: 10, Date: 300, Type: 1},
+		{Key: "c.txt", Size: 20, Date: 200, Type: 1},
+		{Key: "a.txt", Size: 10, Date: 300, Type: 1},
+		{Key: "a.txt", Size: 50, Date: 50, Type: 0},
+		{Key: "c.txt", Size: 20, Date: 200, Type: 1},
+	}
+
+	names := func(ks []KeysSearch) []string {
+
+		var out []string
+
+		for _, k := range ks {
+			out = append(out, fmt.Sprintf("%s/%d", k.Key, k.Type))
+		}
+
+		return out
+
+	}
+
+	tests := []struct {
+		name   string
+		sortby string
+		desc   bool
+		offset int
+		limit  int
+		want   []string
+	}{
+		{"dedup by key", "", false, -1, -1, []string{"a.txt/1", "a.txt/0", "b.txt/0", "c.txt/1"}},
+		{"key desc", "key", true, -1, -1, []string{"c.txt/1", "b.txt/0", "a.txt/1", "a.txt/0"}},
+		{"size", "size", false, -1, -1, []string{"a.txt/1", "c.txt/1", "b.txt/0", "a.txt/0"}},
+		{"size desc", "size", true, -1, -1, []string{"a.txt/0", "b.txt/0", "c.txt/1", "a.txt/1"}},
+		{"date", "date", false, -1, -1, []string{"a.txt/0", "b.txt/0", "c.txt/1", "a.txt/1"}},
+		{"first page", "size", false, 0, 2, []string{"a.txt/1", "c.txt/1"}},
+		{"second page", "size", false, 2, 2, []string{"b.txt/0", "a.txt/0"}},
+		{"last page", "size", false, 3, 2, []string{"a.txt/0"}},
+		{"after end", "size", false, 4, 2, nil},
+	}
+
+	for _, tt := range tests {
+
+		got := names(ProxyMerge(keys, tt.sortby, tt.desc, tt.offset, tt.limit))
+
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: keys %v, want %v", tt.name, got, tt.want)
+		}
+
+	}
+
+}
+
+func TestProxyCount(t *testing.T) {
+
+	tests := []struct {
+		name string
+		keys []KeysSearch
+		want int
+	}{
+		{"no keys", nil, 0},
+		{"all copies", []KeysSearch{{Key: "a"}, {Key: "b"}, {Key: "a"}, {Key: "b"}}, 2},
+		{"partial write", []KeysSearch{{Key: "a"}, {Key: "b"}, {Key: "c"}, {Key: "a"}, {Key: "c"}}, 3},
+		{"file and archive", []KeysSearch{{Key: "a", Type: 0}, {Key: "a", Type: 1}, {Key: "a", Type: 1}}, 2},
+	}
+
+	for _, tt := range tests {
+
+		if got := ProxyCount(tt.keys); got != tt.want {
+			t.Errorf("%s: count %d, want %d", tt.name, got, tt.want)
+		}
+
+	}
+
+}
+
+// ProxyTestNode : in memory backend node, which stores bodies of files by path and answers keys requests of directory
+type ProxyTestNode struct {
+	sync.Mutex
+	Files  map[string]string
+	Limits []string
+	Server *httptest.Server
+}
+
+// ProxyTestNodeNew : start in memory backend node
+func ProxyTestNodeNew() *ProxyTestNode {
+
+	node := &ProxyTestNode{Files: make(map[string]string)}
+
+	node.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		node.Lock()
+		defer node.Unlock()
+
+		path := r.URL.Path
+
+		switch {
+		case r.Method == "PUT":
+
+			body, _ := ioutil.ReadAll(r.Body)
+			node.Files[path] = string(body)
+
+		case r.Method == "DELETE":
+
+			if _, ok := node.Files[path]; !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+
+			delete(node.Files, path)
+
+		case r.Header.Get("Sea") == "1":
+
+			if r.Header.Get("KeysCount") != "" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			node.Limits = append(node.Limits, r.Header.Get("Limit"))
+
+			keys := []Keys{}
+
+			for file := range node.Files {
+
+				if filepath.Dir(file) == path {
+					keys = append(keys, Keys{Key: filepath.Base(file)})
+				}
+
+			}
+
+			if len(keys) == 0 {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+
+			_ = json.NewEncoder(w).Encode(map[string][]Keys{"keys": keys})
+
+		default:
+
+			body, ok := node.Files[path]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+
+			_, _ = w.Write([]byte(body))
+
+		}
+
+	}))
+
+	return node
+
+}
+
+// ProxyTestHas : backend node has file with body
+func (node *ProxyTestNode) ProxyTestHas(path string, body string) bool {
+
+	node.Lock()
+	defer node.Unlock()
+
+	fbody, ok := node.Files[path]
+
+	return ok && fbody == body
+
+}
+
+func TestProxyForward(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "wzd-proxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	logdir = dir
+	logmode = 0640
+
+	var nodes []*ProxyTestNode
+	var urls []string
+
+	for i := 0; i < 3; i++ {
+
+		node := ProxyTestNodeNew()
+		defer node.Server.Close()
+
+		nodes = append(nodes, node)
+		urls = append(urls, node.Server.URL)
+
+	}
+
+	byurl := make(map[string]*ProxyTestNode)
+	for _, node := range nodes {
+		byurl[node.Server.URL] = node
+	}
+
+	vhost := "127.0.0.1"
+
+	config.Server = map[string]server{vhost: {HOST: vhost, MODE: "proxy", LOG4XX: true}}
+
+	local := []Allow{{Vhost: vhost, CIDR: []strCIDR{{Addr: "127.0.0.0/8"}}}}
+
+	allowmu.Lock()
+	getallow, putallow, delallow = local, local, local
+	allowmu.Unlock()
+
+	ring := ProxyRingNew(vhost, strings.Join(urls, ","), 2, 5)
+	phosts = map[string]*ProxyRing{vhost: ring}
+
+	var wg sync.WaitGroup
+
+	app := iris.New()
+	app.Logger().SetLevel("disable")
+	app.Any("/{directory:path}", ZDProxy(&wg))
+
+	err = app.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(app)
+	defer srv.Close()
+
+	send := func(method string, path string, body string, header map[string]string) (int, string) {
+
+		req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for name, value := range header {
+			req.Header.Set(name, value)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		rbody, _ := ioutil.ReadAll(resp.Body)
+
+		return resp.StatusCode, string(rbody)
+
+	}
+
+	// Every file is written on both nodes of its directory and only on them
+
+	files := []string{"/a/1.txt", "/a/2.txt", "/a/3.txt", "/b/1.txt", "/c/1.txt", "/d/1.txt"}
+
+	for _, file := range files {
+
+		status, _ := send("PUT", file, "body of "+file, nil)
+		if status != http.StatusOK {
+			t.Fatalf("put %s: status %d, want %d", file, status, http.StatusOK)
+		}
+
+		owners := ProxyNodes(ring, filepath.Dir(file))
+
+		for _, node := range nodes {
+
+			owner := node.Server.URL == owners[0] || node.Server.URL == owners[1]
+
+			if node.ProxyTestHas(file, "body of "+file) != owner {
+				t.Errorf("put %s: node %s has file %v, owner %v", file, node.Server.URL, !owner, owner)
+			}
+
+		}
+
+	}
+
+	// Read is answered by next node of directory when first node has no copy
+
+	first := byurl[ProxyNodes(ring, "/a")[0]]
+
+	first.Lock()
+	delete(first.Files, "/a/2.txt")
+	first.Unlock()
+
+	for _, file := range files {
+
+		status, body := send("GET", file, "", nil)
+		if status != http.StatusOK || body != "body of "+file {
+			t.Errorf("get %s: status %d body %q, want %d %q", file, status, body, http.StatusOK, "body of "+file)
+		}
+
+	}
+
+	status, _ := send("GET", "/a/none.txt", "", nil)
+	if status != http.StatusNotFound {
+		t.Errorf("get missing file: status %d, want %d", status, http.StatusNotFound)
+	}
+
+	// Search is sent to all nodes, keys of copies are merged once and page is cut after merge
+
+	status, body := send("GET", "/a", "", map[string]string{"Sea": "1", "Keys": "1", "JSON": "1", "Offset": "1", "Limit": "1"})
+
+	var rkeys struct {
+		Keys []Keys `json:"keys"`
+	}
+
+	err = json.Unmarshal([]byte(body), &rkeys)
+	if status != http.StatusOK || err != nil {
+		t.Fatalf("search: status %d body %q error %v", status, body, err)
+	}
+
+	if !reflect.DeepEqual(rkeys.Keys, []Keys{{Key: "2.txt"}}) {
+		t.Errorf("search: keys %v, want %v", rkeys.Keys, []Keys{{Key: "2.txt"}})
+	}
+
+	for _, node := range nodes {
+
+		node.Lock()
+		limits := node.Limits
+		node.Limits = nil
+		node.Unlock()
+
+		if !reflect.DeepEqual(limits, []string{"2"}) {
+			t.Errorf("search: node %s limits %v, want %v", node.Server.URL, limits, []string{"2"})
+		}
+
+	}
+
+	// Count is taken from keys of all nodes, so file written only on one copy is counted once
+
+	status, body = send("GET", "/a", "", map[string]string{"Sea": "1", "KeysCount": "1", "JSON": "1"})
+
+	var rcount struct {
+		Count int `json:"count"`
+	}
+
+	err = json.Unmarshal([]byte(body), &rcount)
+	if status != http.StatusOK || err != nil || rcount.Count != 3 {
+		t.Errorf("count: status %d body %q error %v, want count 3", status, body, err)
+	}
+
+	// Delete is sent to both nodes of directory
+
+	status, _ = send("DELETE", "/b/1.txt", "", nil)
+	if status != http.StatusOK {
+		t.Errorf("delete: status %d, want %d", status, http.StatusOK)
+	}
+
+	for _, node := range nodes {
+
+		if node.ProxyTestHas("/b/1.txt", "body of /b/1.txt") {
+			t.Errorf("delete: node %s still has file", node.Server.URL)
+		}
+
+	}
+
+	status, _ = send("GET", "/b/1.txt", "", nil)
+	if status != http.StatusNotFound {
+		t.Errorf("get deleted file: status %d, want %d", status, http.StatusNotFound)
+	}
+
+	wg.Wait()
+
+}
@@ -0,0 +1,593 @@
+/*
+
+Copyright © 2020 Andrey Kuvshinov. Contacts: <syslinux@protonmail.com>
+Copyright © 2020 Eltaline OU. Contacts: <eltaline.ou@gmail.com>
+All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+The wZD project contains unmodified/modified libraries imports too with
+separate copyright notices and license terms. Your use of the source code
+this libraries is subject to the terms and conditions of licenses these libraries.
+
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/eltaline/nutsdb"
+	"github.com/pieterclaerhout/go-waitgroup"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Query Helpers
+
+// QueryCompile : validate JSON search query tree, compile regular expressions, tokenize full-text queries and encode JSON field lookups of virtual host
+func QueryCompile(node *QueryNode, level int, vhost string) error {
+
+	if node == nil {
+		return nil
+	}
+
+	if level > 32 {
+		return errors.New("query nesting level is too deep")
+	}
+
+	if node.Regex != "" {
+
+		expression := node.Regex
+
+		if node.ICase {
+			expression = "(?i)" + expression
+		}
+
+		rgx, err := regexp.Compile(expression)
+		if err != nil {
+			return err
+		}
+
+		node.rgx = rgx
+
+	}
+
+	if node.Glob != "" {
+
+		literal, rest, err := GlobRegex(node.Glob)
+		if err != nil {
+			return err
+		}
+
+		expression := "^" + regexp.QuoteMeta(literal) + strings.TrimPrefix(rest, "^")
+
+		if node.ICase {
+			expression = "(?i)" + expression
+		} else {
+			node.glit = literal
+		}
+
+		node.grx, err = regexp.Compile(expression)
+		if err != nil {
+			return err
+		}
+
+	}
+
+	if node.Text != "" {
+
+		ftopts := FTHost(vhost)
+
+		if ftopts == nil {
+			return errors.New("full-text search is disabled")
+		}
+
+		node.match = &KeysMatch{Text: FTQuery(node.Text, ftopts)}
+
+		if len(node.match.Text) == 0 {
+			return fmt.Errorf("query text %q has no searchable words", node.Text)
+		}
+
+	}
+
+	if node.Field != nil {
+
+		err := JXCompile(node.Field, JXHost(vhost))
+		if err != nil {
+			return err
+		}
+
+		if node.match == nil {
+			node.match = &KeysMatch{}
+		}
+
+		node.match.Field = node.Field
+
+	}
+
+	switch node.Type {
+	case "":
+		node.ktype = -1
+	case "file":
+		node.ktype = 0
+	case "archive":
+		node.ktype = 1
+	case "dir":
+		node.ktype = 2
+	default:
+		return fmt.Errorf("unknown query type %q", node.Type)
+	}
+
+	for _, sub := range node.And {
+
+		err := QueryCompile(sub, level+1, vhost)
+		if err != nil {
+			return err
+		}
+
+	}
+
+	for _, sub := range node.Or {
+
+		err := QueryCompile(sub, level+1, vhost)
+		if err != nil {
+			return err
+		}
+
+	}
+
+	return QueryCompile(node.Not, level+1, vhost)
+
+}
+
+// QueryMatch : evaluate compiled JSON search query tree for key name and metadata, full-text and JSON field nodes are matched by archive keys found in directory
+func QueryMatch(node *QueryNode, name string, ev RawKeysData, tsets map[*QueryNode]map[string]bool) bool {
+
+	if node == nil {
+		return true
+	}
+
+	switch {
+	case node.Prefix != "" && !node.ICase && !strings.HasPrefix(name, node.Prefix):
+		return false
+	case node.Prefix != "" && node.ICase && !strings.HasPrefix(strings.ToLower(name), strings.ToLower(node.Prefix)):
+		return false
+	case node.grx != nil && !node.grx.MatchString(name):
+		return false
+	case node.rgx != nil && !node.rgx.MatchString(name):
+		return false
+	case node.ktype >= 0 && int(ev.Type) != node.ktype:
+		return false
+	case node.Size != nil && node.Size.Min > 0 && ev.Size < node.Size.Min:
+		return false
+	case node.Size != nil && node.Size.Max > 0 && ev.Size > node.Size.Max:
+		return false
+	case node.Date != nil && node.Date.Min > 0 && ev.Date < node.Date.Min:
+		return false
+	case node.Date != nil && node.Date.Max > 0 && ev.Date > node.Date.Max:
+		return false
+	case node.match != nil && (ev.Type != 1 || !tsets[node][name]):
+		return false
+	}
+
+	for _, sub := range node.And {
+
+		if !QueryMatch(sub, name, ev, tsets) {
+			return false
+		}
+
+	}
+
+	if len(node.Or) > 0 {
+
+		or := false
+
+		for _, sub := range node.Or {
+
+			if QueryMatch(sub, name, ev, tsets) {
+				or = true
+				break
+			}
+
+		}
+
+		if !or {
+			return false
+		}
+
+	}
+
+	if node.Not != nil && QueryMatch(node.Not, name, ev, tsets) {
+		return false
+	}
+
+	return true
+
+}
+
+// QueryPrefix : literal prefix required by every key matched by JSON search query tree, pushed down into the prefix scan
+func QueryPrefix(node *QueryNode) string {
+
+	if node == nil {
+		return ""
+	}
+
+	prefix := ""
+
+	if !node.ICase {
+		prefix = node.Prefix
+	}
+
+	switch {
+	case strings.HasPrefix(node.glit, prefix):
+		prefix = node.glit
+	case !strings.HasPrefix(prefix, node.glit):
+		return prefix
+	}
+
+	for _, sub := range node.And {
+
+		sprefix := QueryPrefix(sub)
+
+		switch {
+		case strings.HasPrefix(sprefix, prefix):
+			prefix = sprefix
+		case !strings.HasPrefix(prefix, sprefix):
+			return prefix
+		}
+
+	}
+
+	if len(node.Or) > 0 {
+
+		oprefix := QueryPrefix(node.Or[0])
+
+		for _, sub := range node.Or[1:] {
+
+			sprefix := QueryPrefix(sub)
+
+			i := 0
+
+			for i < len(oprefix) && i < len(sprefix) && oprefix[i] == sprefix[i] {
+				i++
+			}
+
+			oprefix = oprefix[:i]
+
+		}
+
+		if strings.HasPrefix(oprefix, prefix) {
+			prefix = oprefix
+		}
+
+	}
+
+	return prefix
+
+}
+
+// QueryTypes : types of keys (file, archive, dir) which can be matched by JSON search query tree
+func QueryTypes(node *QueryNode) (types [3]bool) {
+
+	types = [3]bool{true, true, true}
+
+	if node == nil {
+		return types
+	}
+
+	if node.ktype >= 0 {
+		types = [3]bool{false, false, false}
+		types[node.ktype] = true
+	}
+
+	if node.match != nil {
+		types[0] = false
+		types[2] = false
+	}
+
+	for _, sub := range node.And {
+
+		stypes := QueryTypes(sub)
+
+		for i := range types {
+			types[i] = types[i] && stypes[i]
+		}
+
+	}
+
+	if len(node.Or) > 0 {
+
+		var otypes [3]bool
+
+		for _, sub := range node.Or {
+
+			stypes := QueryTypes(sub)
+
+			for i := range otypes {
+				otypes[i] = otypes[i] || stypes[i]
+			}
+
+		}
+
+		for i := range types {
+			types[i] = types[i] && otypes[i]
+		}
+
+	}
+
+	return types
+
+}
+
+// QueryMatches : full-text and JSON field nodes of JSON search query tree
+func QueryMatches(node *QueryNode) []*QueryNode {
+
+	if node == nil {
+		return nil
+	}
+
+	var tnodes []*QueryNode
+
+	if node.match != nil {
+		tnodes = append(tnodes, node)
+	}
+
+	for _, sub := range node.And {
+		tnodes = append(tnodes, QueryMatches(sub)...)
+	}
+
+	for _, sub := range node.Or {
+		tnodes = append(tnodes, QueryMatches(sub)...)
+	}
+
+	return append(tnodes, QueryMatches(node.Not)...)
+
+}
+
+// QueryKeys : search files, keys and directories through requested directories by JSON search query, total count of matched files and keys is returned before offset and limit
+func QueryKeys(filemode os.FileMode, timeout time.Duration, opentries int, freelist string, ndb *nutsdb.DB, base string, query *Query, withjoin map[string]int, url string, vmaxsize int64, searchthreads int, searchtimeout int, searchmaxdirs int, searchmaxresults int) ([]KeysSearch, int, error) {
+
+	var key sync.Mutex
+
+	var maxres bool = false
+
+	var ikeys []KeysSearch
+
+	var total int
+
+	imaxsize := uint64(vmaxsize)
+
+	top := &KeysTop{Desc: query.Sort == 1}
+
+	switch query.SortBy {
+	case "size":
+		top.By = 1
+	case "date":
+		top.By = 2
+	}
+
+	if query.Limit > 0 {
+
+		top.Size = query.Limit
+
+		if query.Offset > 0 {
+			top.Size += query.Offset
+		}
+
+	}
+
+	if query.StopFirst {
+		top.Size = 1
+	}
+
+	prefix := QueryPrefix(query.Where)
+	types := QueryTypes(query.Where)
+	tnodes := QueryMatches(query.Where)
+
+	tprefixes := []string{"f:", "b:", "d:"}
+
+	paths, err := RTree(base, withjoin, 0, searchmaxdirs)
+	if err != nil {
+		return ikeys, 0, err
+	}
+
+	pkeys := make([]string, 0, len(paths))
+	for pk := range paths {
+		pkeys = append(pkeys, pk)
+	}
+
+	sort.Strings(pkeys)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(searchtimeout)*time.Second)
+	defer cancel()
+
+	qwg, ctx := waitgroup.NewErrorGroup(ctx, searchthreads)
+
+Main:
+
+	for _, idirname := range pkeys {
+
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+			break Main
+		default:
+		}
+
+		qwait := make(chan bool)
+
+		qwg.Add(func() error {
+
+			dirname := idirname
+			dcrc := paths[dirname]
+
+			pbdbname := dirname + "/" + filepath.Base(dirname)
+
+			vdirname := strings.TrimPrefix(dirname, base) + "/"
+
+			nbucket := strconv.FormatUint(dcrc, 16)
+
+			qwait <- true
+
+			nerr := ndb.View(func(tx *nutsdb.Tx) error {
+
+				tsets := make(map[*QueryNode]map[string]bool)
+
+				for _, tnode := range tnodes {
+
+					tkeys, err := MatchKeys(tx, nbucket, tnode.match)
+					if err != nil {
+						return err
+					}
+
+					tsets[tnode] = tkeys
+
+				}
+
+				for t, tprefix := range tprefixes {
+
+					if !types[t] {
+						continue
+					}
+
+					entries, _, err := tx.PrefixScan(nbucket, []byte(tprefix+prefix), -1, -1)
+
+					if entries == nil {
+						continue
+					}
+
+					if err != nil {
+						return err
+					}
+
+					for _, entry := range entries {
+
+						select {
+						case <-ctx.Done():
+							return ctx.Err()
+						default:
+						}
+
+						var ik KeysSearch
+						var ev RawKeysData
+
+						kname := strings.TrimPrefix(string(entry.Key), tprefix)
+
+						err = binary.Read(bytes.NewReader(entry.Value), Endian, &ev)
+						if err != nil {
+							return err
+						}
+
+						if !QueryMatch(query.Where, kname, ev, tsets) {
+							continue
+						}
+
+						switch t {
+						case 1:
+
+							ik.dbn = pbdbname + ".bolt"
+							ik.buck = ev.Buck
+
+							if ev.Prnt > 0 {
+								ik.dbn = pbdbname + "_" + fmt.Sprintf("%08d%s", ev.Prnt, ".bolt")
+							}
+
+						case 0:
+							ik.dbn = dirname + "/" + kname
+						}
+
+						kname = strings.TrimPrefix(vdirname+kname, "/")
+
+						if query.WithUrl {
+							kname = url + "/" + kname
+						}
+
+						ik.Key = kname
+						ik.Type = t
+						ik.Size = ev.Size
+						ik.Date = ev.Date
+
+						key.Lock()
+
+						if searchmaxresults > 0 && top.Len() >= searchmaxresults {
+							maxres = true
+							key.Unlock()
+							return errMaxResults
+						}
+
+						total++
+						top.Add(ik)
+						key.Unlock()
+
+					}
+
+				}
+
+				return nil
+
+			})
+
+			if nerr != nil {
+				cancel()
+				return nerr
+			}
+
+			return nil
+
+		})
+
+		<-qwait
+
+	}
+
+	werr := qwg.Wait()
+
+	switch {
+	case maxres:
+		return nil, 0, errMaxResults
+	case err != nil:
+		return ikeys, total, err
+	case werr != nil:
+		return ikeys, total, werr
+	}
+
+	offset := query.Offset
+
+	if query.StopFirst {
+		offset = 0
+	}
+
+	ikeys = KeysTopCut(top.Sorted(), offset, query.Limit, query.StopFirst)
+
+	if query.WithValue {
+
+		err = KeysTopValues(ikeys, filemode, timeout, opentries, freelist, imaxsize)
+		if err != nil {
+			return nil, 0, err
+		}
+
+	}
+
+	return ikeys, total, nil
+
+}
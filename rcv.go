@@ -0,0 +1,383 @@
+/*
+
+Copyright © 2020 Andrey Kuvshinov. Contacts: <syslinux@protonmail.com>
+Copyright © 2020 Eltaline OU. Contacts: <eltaline.ou@gmail.com>
+All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+The wZD project contains unmodified/modified libraries imports too with
+separate copyright notices and license terms. Your use of the source code
+this libraries is subject to the terms and conditions of licenses these libraries.
+
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"github.com/eltaline/bolt"
+	"github.com/eltaline/nutsdb"
+	"hash/crc64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Recovery Helpers
+
+var rgxshard = regexp.MustCompile(`^(.+)_([0-9]{8})\.bolt$`)
+
+// RecoverRoots : root directories of all virtual hosts without nested roots
+func RecoverRoots() []string {
+
+	var roots []string
+
+	for _, Server := range config.Server {
+		roots = append(roots, filepath.Clean(Server.ROOT))
+	}
+
+	return TreeRoots(roots)
+
+}
+
+// RecoverShards : rename numbered archives of every directory to continuous sequence, so shard discovery of uploads does not stop at a hole
+func RecoverShards(roots []string) error {
+
+	// Loggers
+
+	appLogger, applogfile := AppLogger()
+	defer applogfile.Close()
+
+	renamed := 0
+
+	for _, root := range roots {
+
+		err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+
+			if err != nil {
+				return err
+			}
+
+			if !info.IsDir() {
+				return nil
+			}
+
+			if path == backupdir {
+				return filepath.SkipDir
+			}
+
+			files, err := ioutil.ReadDir(path)
+			if err != nil {
+				return err
+			}
+
+			shards := make(map[string][]int)
+
+			for _, file := range files {
+
+				if !file.Mode().IsRegular() {
+					continue
+				}
+
+				mch := rgxshard.FindStringSubmatch(file.Name())
+				if mch == nil {
+					continue
+				}
+
+				// Shard numbers start from 1, _00000000 is not a shard and is never renamed
+
+				n, err := strconv.Atoi(mch[2])
+				if err != nil || n < 1 {
+					continue
+				}
+
+				shards[mch[1]] = append(shards[mch[1]], n)
+
+			}
+
+			for dbn, nums := range shards {
+
+				sort.Ints(nums)
+
+				for i, n := range nums {
+
+					if n == i+1 {
+						continue
+					}
+
+					odbf := fmt.Sprintf("%s/%s_%08d.bolt", path, dbn, n)
+					ndbf := fmt.Sprintf("%s/%s_%08d.bolt", path, dbn, i+1)
+
+					_, err = os.Lstat(ndbf)
+					if err == nil {
+						return fmt.Errorf("can't rename shard %s, file %s already exists", odbf, ndbf)
+					}
+
+					if !os.IsNotExist(err) {
+						return err
+					}
+
+					err = os.Rename(odbf, ndbf)
+					if err != nil {
+						return err
+					}
+
+					appLogger.Warnf("| Recovery | Shard renamed to close the gap | DB [%s] | New DB [%s]", odbf, ndbf)
+
+					renamed++
+
+				}
+
+			}
+
+			return nil
+
+		})
+
+		if err != nil {
+			appLogger.Errorf("| Recovery | Can`t repair shard names error | Path [%s] | %v", root, err)
+			return err
+		}
+
+	}
+
+	appLogger.Warnf("| Recovery | Shard names repaired | Renamed [%d]", renamed)
+
+	return nil
+
+}
+
+// RecoverSearch : wipe search dbs, so search dbs and search tree are initialized again from root directories during open
+func RecoverSearch() error {
+
+	// Loggers
+
+	appLogger, applogfile := AppLogger()
+	defer applogfile.Close()
+
+	for _, sdb := range sdbs {
+
+		err := os.RemoveAll(sdb.Dir)
+		if err != nil {
+			appLogger.Errorf("| Recovery | Can`t wipe search db error | DB Directory [%s] | %v", sdb.Dir, err)
+			return err
+		}
+
+		appLogger.Warnf("| Recovery | Search db wiped for initialization from root directories | DB Directory [%s]", sdb.Dir)
+
+	}
+
+	return nil
+
+}
+
+// RecoverValues : index values of archive keys in full-text and JSON field indexes of virtual hosts, which are not restored by initialization of search db
+func RecoverValues() {
+
+	// Loggers
+
+	appLogger, applogfile := AppLogger()
+	defer applogfile.Close()
+
+	timeout := time.Duration(60) * time.Second
+
+	for _, Server := range config.Server {
+
+		ndb, search := SearchHost(Server.HOST)
+		if !search {
+			continue
+		}
+
+		ftopts := FTHost(Server.HOST)
+		jxfields := JXHost(Server.HOST)
+
+		if ftopts == nil && jxfields == nil {
+			continue
+		}
+
+		keys := 0
+
+		err := filepath.Walk(filepath.Clean(Server.ROOT), func(path string, info os.FileInfo, err error) error {
+
+			if err != nil {
+				return err
+			}
+
+			if shutdown {
+				return errors.New("shutdown in progress")
+			}
+
+			if info.IsDir() && path == backupdir {
+				return filepath.SkipDir
+			}
+
+			if !info.Mode().IsRegular() || !rgxbolt.MatchString(path) {
+				return nil
+			}
+
+			nbucket := strconv.FormatUint(crc64.Checksum([]byte(filepath.Dir(path)), ctbl64), 16)
+
+			db, err := BoltOpenRead(path, os.FileMode(0640), timeout, 30, freelist)
+			if err != nil {
+				return err
+			}
+
+			defer db.Close()
+
+			return db.View(func(tx *bolt.Tx) error {
+
+				ib := tx.Bucket([]byte("index"))
+				if ib == nil {
+					return nil
+				}
+
+				return ib.ForEach(func(k, v []byte) error {
+
+					b := tx.Bucket(v)
+					if b == nil {
+						return nil
+					}
+
+					val := b.Get(k)
+					if len(val) < 36 {
+						return nil
+					}
+
+					if ftopts != nil {
+
+						err := FTIndex(ndb, nbucket, string(k), val[36:], ftopts)
+						if err != nil {
+							return err
+						}
+
+					}
+
+					if jxfields != nil {
+
+						err := JXIndex(ndb, nbucket, string(k), val[36:], jxfields)
+						if err != nil {
+							return err
+						}
+
+					}
+
+					keys++
+
+					return nil
+
+				})
+
+			})
+
+		})
+
+		if err != nil {
+			appLogger.Errorf("| Recovery | Host [%s] | Can`t index values of archives error | Path [%s] | %v", Server.HOST, Server.ROOT, err)
+			continue
+		}
+
+		appLogger.Warnf("| Recovery | Host [%s] | Values of archives indexed | Keys [%d]", Server.HOST, keys)
+
+	}
+
+}
+
+// RecoverCompact : queue archives with free pages to compaction db with modification time of archive, so scheduler compacts them after cmptime days without changes
+func RecoverCompact(cdb *nutsdb.DB, roots []string) {
+
+	// Loggers
+
+	appLogger, applogfile := AppLogger()
+	defer applogfile.Close()
+
+	timeout := time.Duration(60) * time.Second
+
+	queued := 0
+
+	for _, root := range roots {
+
+		err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+
+			if err != nil {
+				return err
+			}
+
+			if shutdown {
+				return errors.New("shutdown in progress")
+			}
+
+			if info.IsDir() && path == backupdir {
+				return filepath.SkipDir
+			}
+
+			if !info.Mode().IsRegular() || !rgxbolt.MatchString(path) {
+				return nil
+			}
+
+			db, err := BoltOpenRead(path, os.FileMode(0640), timeout, 30, freelist)
+			if err != nil {
+				return err
+			}
+
+			st := db.Stats()
+			free := int64(st.FreePageN+st.PendingPageN) * int64(db.Info().PageSize)
+
+			db.Close()
+
+			if free*10 < info.Size() {
+				return nil
+			}
+
+			bdbf := make([]byte, 8)
+			Endian.PutUint64(bdbf, crc64.Checksum([]byte(path), ctbl64))
+
+			bval := new(bytes.Buffer)
+
+			sdts := &Compact{
+				Path: path,
+				Time: info.ModTime(),
+			}
+
+			err = gob.NewEncoder(bval).Encode(sdts)
+			if err != nil {
+				return err
+			}
+
+			err = NDBInsert(cdb, cmpbucket, bdbf, bval.Bytes(), 0)
+			if err != nil {
+				return err
+			}
+
+			queued++
+
+			return nil
+
+		})
+
+		if err != nil {
+			appLogger.Errorf("| Recovery | Can`t queue archives to compaction db error | Path [%s] | %v", root, err)
+		}
+
+	}
+
+	appLogger.Warnf("| Recovery | Archives queued to compaction db | Archives [%d]", queued)
+
+}
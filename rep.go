@@ -0,0 +1,477 @@
+/*
+
+Copyright © 2020 Andrey Kuvshinov. Contacts: <syslinux@protonmail.com>
+Copyright © 2020 Eltaline OU. Contacts: <eltaline.ou@gmail.com>
+All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+The wZD project contains unmodified/modified libraries imports too with
+separate copyright notices and license terms. Your use of the source code
+this libraries is subject to the terms and conditions of licenses these libraries.
+
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"github.com/eltaline/nutsdb"
+	"hash/crc64"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Replication Handlers
+
+const repmindelay = 1 * time.Second
+const repmaxdelay = 5 * time.Minute
+
+// RepOpen : replicas of all virtual hosts with enabled change feed and their replicated positions from change feed db
+func RepOpen(fdb *nutsdb.DB) {
+
+	for _, Server := range config.Server {
+
+		if !Server.FEED {
+			continue
+		}
+
+		for _, Replica := range Server.REPLICA {
+
+			rhost := Replica.HOST
+			if rhost == "" {
+				rhost = Server.HOST
+			}
+
+			timeout := 300
+			if Replica.TIMEOUT > 0 {
+				timeout = Replica.TIMEOUT
+			}
+
+			rep := &RepTarget{
+				DB:        fdb,
+				ID:        strconv.FormatUint(crc64.Checksum([]byte(Server.HOST+" "+Replica.URL+" "+rhost), ctbl64), 16),
+				Host:      Server.HOST,
+				Base:      filepath.Clean(Server.ROOT),
+				URL:       strings.TrimSuffix(Replica.URL, "/"),
+				RHost:     rhost,
+				Rate:      Replica.RATE,
+				Timeout:   time.Duration(timeout) * time.Second,
+				LockWait:  time.Duration(Server.LOCKTIMEOUT) * time.Second,
+				OpenTries: Server.OPENTRIES,
+			}
+
+			_ = fdb.View(func(tx *nutsdb.Tx) error {
+
+				entry, err := tx.Get(repbucket, []byte("p:"+rep.ID))
+				if err == nil && len(entry.Value) == 8 {
+					rep.Pos = binary.BigEndian.Uint64(entry.Value)
+				}
+
+				return nil
+
+			})
+
+			rhosts[Server.HOST] = append(rhosts[Server.HOST], rep)
+
+		}
+
+	}
+
+}
+
+// RepSave : save replicated position of replica to change feed db
+func RepSave(rep *RepTarget, pos uint64) error {
+
+	err := NDBInsert(rep.DB, repbucket, []byte("p:"+rep.ID), FeedKey(pos), 0)
+	if err != nil {
+		return err
+	}
+
+	rep.Lock()
+	rep.Pos = pos
+	rep.Unlock()
+
+	return nil
+
+}
+
+// RepStatus : replication position, lag and counters of all replicas of virtual host
+func RepStatus(vhost string) []RepStats {
+
+	rstats := []RepStats{}
+
+	now := uint64(time.Now().Unix())
+
+	for _, rep := range rhosts[vhost] {
+
+		rep.Lock()
+
+		rstat := rep.Stats
+
+		rstat.URL = rep.URL
+		rstat.Host = rep.RHost
+		rstat.Position = rep.Pos
+
+		if rstat.Last > rep.Pos {
+			rstat.Lag = rstat.Last - rep.Pos
+		}
+
+		if rstat.Lag > 0 && rep.Pending > 0 && now > rep.Pending {
+			rstat.LagTime = now - rep.Pending
+		}
+
+		rep.Unlock()
+
+		rstats = append(rstats, rstat)
+
+	}
+
+	return rstats
+
+}
+
+// RepSleep : wait for delay, interrupted by shutdown
+func RepSleep(delay time.Duration) {
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for !shutdown {
+
+		select {
+		case <-timer.C:
+			return
+		case <-ticker.C:
+		}
+
+	}
+
+}
+
+// RepURL : replica URL of file or key with escaped path, so names with ?, #, % or spaces are sent as is
+func RepURL(rep *RepTarget, path string) string {
+	return rep.URL + (&url.URL{Path: path}).EscapedPath()
+}
+
+// RepDead : change feed db bucket of events rejected by replica
+func RepDead(rep *RepTarget) string {
+	return "rd:" + rep.ID
+}
+
+// RepReject : save event rejected by replica to dead-letter bucket of replica
+func RepReject(rep *RepTarget, ev FeedEvent, rerr error) error {
+
+	tval, err := json.Marshal(RepTask{Event: ev, Error: rerr.Error()})
+	if err != nil {
+		return err
+	}
+
+	return NDBInsert(rep.DB, RepDead(rep), FeedKey(ev.Seq), tval, 0)
+
+}
+
+// RepApply : apply change feed event to replica through its PUT and DELETE methods, returns whether failed event should be retried
+func RepApply(client *http.Client, rep *RepTarget, ev FeedEvent) (bool, error) {
+
+	var req *http.Request
+	var err error
+
+	switch ev.Op {
+	case "delete":
+
+		req, err = http.NewRequest("DELETE", RepURL(rep, ev.Path), nil)
+		if err != nil {
+			return false, err
+		}
+
+		switch ev.Type {
+		case 0:
+			req.Header.Set("FromFile", "1")
+		default:
+			req.Header.Set("FromArchive", "1")
+		}
+
+	default:
+
+		var body io.Reader
+		var size int64
+
+		switch ev.Type {
+		case 0:
+
+			abs := filepath.Clean(rep.Base + ev.Path)
+
+			infile, err := os.Stat(abs)
+			if err != nil || !infile.Mode().IsRegular() {
+				// Deleted or replaced later, next events of change feed are applied to replica
+				return false, nil
+			}
+
+			rfile, err := os.Open(abs)
+			if err != nil {
+				return true, err
+			}
+			defer rfile.Close()
+
+			body = rfile
+			size = infile.Size()
+
+		default:
+
+			dbf := filepath.Clean(rep.Base + ev.Archive)
+
+			if !FileExists(dbf) {
+				return false, nil
+			}
+
+			db, err := BoltOpenRead(dbf, os.FileMode(0640), rep.LockWait, rep.OpenTries, freelist)
+			if err != nil {
+				return true, err
+			}
+
+			file := filepath.Base(ev.Path)
+
+			bucket, err := KeyExists(db, "index", file)
+			if err != nil || bucket == "" {
+				db.Close()
+				return false, nil
+			}
+
+			value, err := DBGetVal(db, bucket, []byte(file))
+			db.Close()
+
+			if err != nil {
+				return true, err
+			}
+
+			if value == nil {
+				return false, nil
+			}
+
+			body = bytes.NewReader(value)
+			size = int64(len(value))
+
+		}
+
+		req, err = http.NewRequest("PUT", RepURL(rep, ev.Path), body)
+		if err != nil {
+			return false, err
+		}
+
+		req.ContentLength = size
+		req.Header.Set("Content-Type", "application/octet-stream")
+
+		switch ev.Type {
+		case 0:
+			req.Header.Set("File", "1")
+		default:
+			req.Header.Set("Archive", "1")
+		}
+
+	}
+
+	req.Host = rep.RHost
+	req.Header.Set("User-Agent", "wZD")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return true, err
+	}
+
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode <= 299:
+		return false, nil
+	case ev.Op == "delete" && resp.StatusCode == http.StatusNotFound:
+		return false, nil
+	case resp.StatusCode == http.StatusRequestTimeout || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, fmt.Errorf("replica response status %d", resp.StatusCode)
+	}
+
+	return false, fmt.Errorf("replica response status %d", resp.StatusCode)
+
+}
+
+// RepLoop : replicate change feed events of virtual host to replica in order from replicated position, failed events are retried with exponential backoff
+func RepLoop(rep *RepTarget, wg *sync.WaitGroup) {
+
+	// Loggers
+
+	appLogger, applogfile := AppLogger()
+	defer applogfile.Close()
+
+	flog := FeedHost(rep.Host)
+	if flog == nil {
+		return
+	}
+
+	client := &http.Client{Timeout: rep.Timeout}
+
+	var pause time.Duration
+
+	if rep.Rate > 0 {
+		pause = time.Second / time.Duration(rep.Rate)
+	}
+
+	for !shutdown {
+
+		rep.Lock()
+		pos := rep.Pos
+		rep.Unlock()
+
+		flist, err := FeedWait(flog, pos+1, feedbatch, feedwait, nil)
+
+		if err == errFeedGone {
+
+			appLogger.Errorf("| Host [%s] | Replica [%s] | Change feed events are removed by retention before replication, replica must be synchronized | From [%d] | To [%d]", rep.Host, rep.URL, pos+1, flist.First-1)
+
+			err = RepSave(rep, flist.First-1)
+			if err != nil {
+				appLogger.Errorf("| Host [%s] | Replica [%s] | Can`t save replicated position error | Position [%d] | %v", rep.Host, rep.URL, flist.First-1, err)
+				RepSleep(repmaxdelay)
+				continue
+			}
+
+			rep.Lock()
+			rep.Stats.Lost += flist.First - 1 - pos
+			rep.Unlock()
+
+			continue
+
+		}
+
+		if err != nil {
+			appLogger.Errorf("| Host [%s] | Replica [%s] | Can`t read change feed error | Position [%d] | %v", rep.Host, rep.URL, pos, err)
+			RepSleep(repmaxdelay)
+			continue
+		}
+
+		rep.Lock()
+
+		rep.Stats.Last = flist.Last
+		rep.Pending = 0
+
+		if len(flist.Events) > 0 {
+			rep.Pending = flist.Events[0].Date
+		}
+
+		rep.Unlock()
+
+		for i, ev := range flist.Events {
+
+			applied := ev.Op == "compact"
+
+			for tries := 0; !applied && !shutdown; tries++ {
+
+				wg.Add(1)
+				retry, err := RepApply(client, rep, ev)
+				wg.Done()
+
+				if err == nil {
+
+					rep.Lock()
+					rep.Stats.Sent++
+					rep.Stats.Error = ""
+					rep.Unlock()
+
+					applied = true
+					break
+
+				}
+
+				rep.Lock()
+				rep.Stats.Errors++
+				rep.Stats.Error = err.Error()
+				rep.Unlock()
+
+				if !retry {
+
+					appLogger.Errorf("| Host [%s] | Replica [%s] | Replication event is rejected by replica and moved to dead-letter bucket | Seq [%d] | Op [%s] | Path [%s] | %v", rep.Host, rep.URL, ev.Seq, ev.Op, ev.Path, err)
+
+					derr := RepReject(rep, ev, err)
+					if derr != nil {
+						appLogger.Errorf("| Host [%s] | Replica [%s] | Can`t move event to dead-letter bucket error | Seq [%d] | %v", rep.Host, rep.URL, ev.Seq, derr)
+					}
+
+					rep.Lock()
+					rep.Stats.Skipped++
+					rep.Unlock()
+
+					applied = true
+					break
+
+				}
+
+				appLogger.Errorf("| Host [%s] | Replica [%s] | Replication error | Seq [%d] | Op [%s] | Path [%s] | Tries [%d] | %v", rep.Host, rep.URL, ev.Seq, ev.Op, ev.Path, tries+1, err)
+
+				delay := repmaxdelay
+
+				if tries < 20 {
+
+					delay = repmindelay << uint(tries)
+					if delay > repmaxdelay {
+						delay = repmaxdelay
+					}
+
+				}
+
+				RepSleep(delay)
+
+			}
+
+			if !applied {
+				break
+			}
+
+			err = RepSave(rep, ev.Seq)
+			if err != nil {
+				appLogger.Errorf("| Host [%s] | Replica [%s] | Can`t save replicated position error | Position [%d] | %v", rep.Host, rep.URL, ev.Seq, err)
+				break
+			}
+
+			rep.Lock()
+
+			rep.Pending = 0
+
+			if i+1 < len(flist.Events) {
+				rep.Pending = flist.Events[i+1].Date
+			}
+
+			rep.Unlock()
+
+			if pause > 0 {
+				time.Sleep(pause)
+			}
+
+		}
+
+	}
+
+}
@@ -0,0 +1,316 @@
+/*
+
+Copyright © 2020 Andrey Kuvshinov. Contacts: <syslinux@protonmail.com>
+Copyright © 2020 Eltaline OU. Contacts: <eltaline.ou@gmail.com>
+All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+The wZD project contains unmodified/modified libraries imports too with
+separate copyright notices and license terms. Your use of the source code
+this libraries is subject to the terms and conditions of licenses these libraries.
+
+*/
+
+package main
+
+import (
+	"fmt"
+	"github.com/coocood/freecache"
+	"github.com/eltaline/nutsdb"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Search DB Helpers
+
+// SearchRegistry : search db of each virtual host, virtual hosts without own search directory use shared search db
+func SearchRegistry() {
+
+	// Loggers
+
+	appLogger, applogfile := AppLogger()
+	defer applogfile.Close()
+
+	mdbs := make(map[string]*SearchDB)
+
+	var hosts []string
+
+	for _, Server := range config.Server {
+		hosts = append(hosts, Server.HOST)
+	}
+
+	sort.Strings(hosts)
+
+	for _, host := range hosts {
+
+		for _, Server := range config.Server {
+
+			if Server.HOST != host {
+				continue
+			}
+
+			dir := searchdir
+			index := strings.ToLower(searchindex)
+
+			if Server.SEARCHDIR != "" {
+				dir = filepath.Clean(Server.SEARCHDIR)
+			}
+
+			if Server.SEARCHINDEX != "" {
+				index = strings.ToLower(Server.SEARCHINDEX)
+			}
+
+			sdb, ok := mdbs[dir]
+			if !ok {
+				sdb = &SearchDB{Dir: dir, Index: index}
+				mdbs[dir] = sdb
+				sdbs = append(sdbs, sdb)
+			}
+
+			if sdb.Index != index {
+				appLogger.Errorf("| Host [%s] | Search db directory is used with other searchindex | DB Directory [%s] | Search Index [%s] | Other Search Index [%s]", host, dir, index, sdb.Index)
+				fmt.Printf("Search db directory is used with other searchindex | Host [%s] | DB Directory [%s] | Search Index [%s] | Other Search Index [%s]\n", host, dir, index, sdb.Index)
+				os.Exit(1)
+			}
+
+			sdb.Hosts = append(sdb.Hosts, host)
+			sdb.Roots = append(sdb.Roots, filepath.Clean(Server.ROOT))
+
+			shosts[host] = sdb
+
+			break
+
+		}
+
+	}
+
+	for _, sdb := range sdbs {
+		sdb.Roots = TreeRoots(sdb.Roots)
+	}
+
+}
+
+// SearchHost : search db of virtual host and search state, search is disabled for virtual host with broken search db
+func SearchHost(vhost string) (*nutsdb.DB, bool) {
+
+	sdb, ok := shosts[vhost]
+	if !ok || sdb.Broken || sdb.DB == nil {
+		return nil, false
+	}
+
+	return sdb.DB, search
+
+}
+
+// SearchDirs : working search dbs with root directories including directory
+func SearchDirs(dir string) []*SearchDB {
+
+	var dirs []*SearchDB
+
+	for _, sdb := range sdbs {
+
+		if sdb.Broken || sdb.DB == nil {
+			continue
+		}
+
+		for _, root := range sdb.Roots {
+
+			if dir == root || strings.HasPrefix(dir, root+"/") {
+				dirs = append(dirs, sdb)
+				break
+			}
+
+		}
+
+	}
+
+	return dirs
+
+}
+
+// SearchOptions : search db options by search directory and index mode
+func SearchOptions(dir string, index string) nutsdb.Options {
+
+	nopt := nutsdb.DefaultOptions
+	nopt.Dir = dir
+
+	switch {
+	case index == "ram":
+		nopt.EntryIdxMode = nutsdb.HintKeyValAndRAMIdxMode
+	case index == "disk":
+		nopt.EntryIdxMode = nutsdb.HintKeyAndRAMIdxMode
+	case index == "bpt":
+		nopt.EntryIdxMode = nutsdb.HintBPTSparseIdxMode
+	}
+
+	nopt.SegmentSize = 67108864
+	nopt.NodeNum = 1
+	nopt.StartFileLoadingMode = nutsdb.MMap
+
+	return nopt
+
+}
+
+// SearchOpen : open search db, empty search db is initialized from root directories, failed initialization is wiped for the next start
+func SearchOpen(cache *freecache.Cache, sdb *SearchDB, wg *sync.WaitGroup) error {
+
+	// Loggers
+
+	appLogger, applogfile := AppLogger()
+	defer applogfile.Close()
+
+	if !DirExists(sdb.Dir) {
+
+		err := os.MkdirAll(sdb.Dir, 0700)
+		if err != nil {
+			return err
+		}
+
+	}
+
+	nopt := SearchOptions(sdb.Dir, sdb.Index)
+
+	nempty, err := IsEmptyDir(sdb.Dir)
+	if err != nil {
+		return err
+	}
+
+	if nempty && search {
+
+		nopt.RWMode = nutsdb.MMap
+		nopt.SyncEnable = false
+
+		err = TreeInit(sdb.Roots)
+		if err == nil {
+			err = SearchInit(nopt, sdb.Roots)
+		}
+
+		if err != nil {
+
+			werr := os.RemoveAll(sdb.Dir)
+			if werr == nil {
+				werr = os.MkdirAll(sdb.Dir, 0700)
+			}
+
+			if werr != nil {
+				appLogger.Errorf("| Can`t wipe failed search db initialization | DB Directory [%s] | %v", sdb.Dir, werr)
+			}
+
+			return err
+
+		}
+
+	}
+
+	nopt.RWMode = nutsdb.FileIO
+	nopt.SyncEnable = true
+
+	ndb, err := nutsdb.Open(nopt)
+	if err != nil {
+		return err
+	}
+
+	sdb.DB = ndb
+
+	if !search {
+		return nil
+	}
+
+	// Search Tree
+
+	tcount := 0
+
+	if !nempty {
+
+		tcount, err = TreeLoad(ndb)
+		if err != nil {
+			appLogger.Errorf("| Can`t load search tree from search db, full walk is used | DB Directory [%s] | %v", sdb.Dir, err)
+		}
+
+	}
+
+	switch {
+	case tcount > 0:
+
+		appLogger.Warnf("| Search tree loaded from search db | DB Directory [%s] | Directories [%d]", sdb.Dir, tcount)
+
+		wg.Add(1)
+
+		go func() {
+			TreeVerify(cache, ndb, sdb.Roots)
+			wg.Done()
+		}()
+
+	default:
+
+		if !nempty {
+
+			err = TreeInit(sdb.Roots)
+			if err != nil {
+				return err
+			}
+
+		}
+
+		err = TreeSave(ndb, sdb.Roots)
+		if err != nil {
+			appLogger.Errorf("| Can`t save search tree to search db | DB Directory [%s] | %v", sdb.Dir, err)
+		}
+
+	}
+
+	return nil
+
+}
+
+// SearchMerge : merge all working search dbs except search dbs with bpt index
+func SearchMerge() {
+
+	// Loggers
+
+	appLogger, applogfile := AppLogger()
+	defer applogfile.Close()
+
+	for _, sdb := range sdbs {
+
+		if sdb.DB == nil || sdb.Index == "bpt" {
+			continue
+		}
+
+		appLogger.Warnf("Merging search db | DB Directory [%s]", sdb.Dir)
+
+		err := NDBMerge(sdb.DB, sdb.Dir)
+		if err != nil {
+			appLogger.Errorf("Merge search db error | DB Directory [%s] | %v", sdb.Dir, err)
+		}
+
+	}
+
+}
+
+// SearchClose : close all opened search dbs
+func SearchClose() {
+
+	for _, sdb := range sdbs {
+
+		if sdb.DB != nil {
+			sdb.DB.Close()
+		}
+
+	}
+
+}
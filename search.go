@@ -29,8 +29,10 @@ import (
 	"context"
 	"encoding/binary"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"github.com/eltaline/nutsdb"
+	"github.com/hashicorp/go-immutable-radix"
 	"github.com/pieterclaerhout/go-waitgroup"
 	"hash/crc64"
 	"io/ioutil"
@@ -45,6 +47,11 @@ import (
 
 // Search Handlers
 
+var (
+	errMaxDirs    = errors.New("search directories limit exceeded")
+	errMaxResults = errors.New("search results limit exceeded")
+)
+
 // Sort Handlers
 
 // Sort Ascending Handlers
@@ -144,10 +151,17 @@ func (e KeysSearchListDsc) Swap(i, j int) {
 // Names/Count Helpers
 
 // FileKeys : search file names through requested directory
-func FileKeys(ndb *nutsdb.DB, base string, dirpath string, msort uint8, offset int, limit int, prefix string, expression string, recursive int, stopfirst uint8, minsize uint64, maxsize uint64, minstmp uint64, maxstmp uint64, withurl bool, url string, withjoin map[string]int, searchthreads int, searchtimeout int) ([]Keys, int, int, error) {
+func FileKeys(ndb *nutsdb.DB, base string, dirpath string, msort uint8, offset int, limit int, prefix string, expression string, recursive int, stopfirst uint8, minsize uint64, maxsize uint64, minstmp uint64, maxstmp uint64, withurl bool, url string, withjoin map[string]int, match *KeysMatch, searchthreads int, searchtimeout int, searchmaxdirs int, searchmaxresults int) ([]Keys, int, int, error) {
+
+	if match != nil {
+		skeys, err := SortKeys(0, 0, 0, freelist, ndb, base, dirpath, msort, 0, offset, limit, prefix, expression, recursive, stopfirst, minsize, maxsize, minstmp, maxstmp, withurl, url, withjoin, true, false, match, false, 0, searchthreads, searchtimeout, searchmaxdirs, searchmaxresults)
+		return PlainKeys(skeys), offset, limit, err
+	}
 
 	var key sync.Mutex
 
+	var maxres bool = false
+
 	var ikeys []Keys
 	var skeys []Keys
 
@@ -162,7 +176,7 @@ func FileKeys(ndb *nutsdb.DB, base string, dirpath string, msort uint8, offset i
 	dirpath = filepath.Clean(dirpath)
 	// url = strings.TrimSuffix(url, "/")
 
-	paths, err := RTree(dirpath, withjoin, recursive)
+	paths, err := RTree(dirpath, withjoin, recursive, searchmaxdirs)
 	if err != nil {
 		return ikeys, offset, limit, err
 	}
@@ -296,6 +310,13 @@ Main:
 					ik.Type = 0
 
 					key.Lock()
+
+					if searchmaxresults > 0 && len(ikeys) >= searchmaxresults {
+						maxres = true
+						key.Unlock()
+						return errMaxResults
+					}
+
 					ikeys = append(ikeys, ik)
 					key.Unlock()
 
@@ -328,11 +349,14 @@ Main:
 
 	}
 
-	if err != nil {
-		return ikeys, offset, limit, err
-	}
+	werr := qwg.Wait()
 
-	if werr := qwg.Wait(); werr != nil {
+	switch {
+	case maxres:
+		return nil, offset, limit, errMaxResults
+	case err != nil:
+		return ikeys, offset, limit, err
+	case werr != nil:
 		return ikeys, offset, limit, werr
 	}
 
@@ -372,10 +396,17 @@ Main:
 }
 
 // FileKeysInfo : search file names with info through requested directory
-func FileKeysInfo(ndb *nutsdb.DB, base string, dirpath string, msort uint8, offset int, limit int, prefix string, expression string, recursive int, stopfirst uint8, minsize uint64, maxsize uint64, minstmp uint64, maxstmp uint64, withurl bool, url string, withjoin map[string]int, searchthreads int, searchtimeout int) ([]KeysInfo, int, int, error) {
+func FileKeysInfo(ndb *nutsdb.DB, base string, dirpath string, msort uint8, msortby uint8, offset int, limit int, prefix string, expression string, recursive int, stopfirst uint8, minsize uint64, maxsize uint64, minstmp uint64, maxstmp uint64, withurl bool, url string, withjoin map[string]int, match *KeysMatch, searchthreads int, searchtimeout int, searchmaxdirs int, searchmaxresults int) ([]KeysInfo, int, int, error) {
+
+	if msortby > 0 || match != nil {
+		skeys, err := SortKeys(0, 0, 0, freelist, ndb, base, dirpath, msort, msortby, offset, limit, prefix, expression, recursive, stopfirst, minsize, maxsize, minstmp, maxstmp, withurl, url, withjoin, true, false, match, false, 0, searchthreads, searchtimeout, searchmaxdirs, searchmaxresults)
+		return InfoKeys(skeys), offset, limit, err
+	}
 
 	var key sync.Mutex
 
+	var maxres bool = false
+
 	var ikeys []KeysInfo
 	var skeys []KeysInfo
 
@@ -390,7 +421,7 @@ func FileKeysInfo(ndb *nutsdb.DB, base string, dirpath string, msort uint8, offs
 	dirpath = filepath.Clean(dirpath)
 	// url = strings.TrimSuffix(url, "/")
 
-	paths, err := RTree(dirpath, withjoin, recursive)
+	paths, err := RTree(dirpath, withjoin, recursive, searchmaxdirs)
 	if err != nil {
 		return ikeys, offset, limit, err
 	}
@@ -526,6 +557,13 @@ Main:
 					ik.Date = ev.Date
 
 					key.Lock()
+
+					if searchmaxresults > 0 && len(ikeys) >= searchmaxresults {
+						maxres = true
+						key.Unlock()
+						return errMaxResults
+					}
+
 					ikeys = append(ikeys, ik)
 					key.Unlock()
 
@@ -558,11 +596,14 @@ Main:
 
 	}
 
-	if err != nil {
-		return ikeys, offset, limit, err
-	}
+	werr := qwg.Wait()
 
-	if werr := qwg.Wait(); werr != nil {
+	switch {
+	case maxres:
+		return nil, offset, limit, errMaxResults
+	case err != nil:
+		return ikeys, offset, limit, err
+	case werr != nil:
 		return ikeys, offset, limit, werr
 	}
 
@@ -602,10 +643,17 @@ Main:
 }
 
 // FileKeysSearch : search file names/names with values through requested directory
-func FileKeysSearch(ndb *nutsdb.DB, base string, dirpath string, msort uint8, offset int, limit int, prefix string, expression string, recursive int, stopfirst uint8, minsize uint64, maxsize uint64, minstmp uint64, maxstmp uint64, withurl bool, url string, withjoin map[string]int, withvalue bool, vmaxsize int64, searchthreads int, searchtimeout int) ([]KeysSearch, int, int, error) {
+func FileKeysSearch(ndb *nutsdb.DB, base string, dirpath string, msort uint8, msortby uint8, offset int, limit int, prefix string, expression string, recursive int, stopfirst uint8, minsize uint64, maxsize uint64, minstmp uint64, maxstmp uint64, withurl bool, url string, withjoin map[string]int, match *KeysMatch, withvalue bool, vmaxsize int64, searchthreads int, searchtimeout int, searchmaxdirs int, searchmaxresults int) ([]KeysSearch, int, int, error) {
+
+	if msortby > 0 || match != nil {
+		skeys, err := SortKeys(0, 0, 0, freelist, ndb, base, dirpath, msort, msortby, offset, limit, prefix, expression, recursive, stopfirst, minsize, maxsize, minstmp, maxstmp, withurl, url, withjoin, true, false, match, withvalue, vmaxsize, searchthreads, searchtimeout, searchmaxdirs, searchmaxresults)
+		return skeys, offset, limit, err
+	}
 
 	var key sync.Mutex
 
+	var maxres bool = false
+
 	var ikeys []KeysSearch
 	var skeys []KeysSearch
 
@@ -622,7 +670,7 @@ func FileKeysSearch(ndb *nutsdb.DB, base string, dirpath string, msort uint8, of
 	dirpath = filepath.Clean(dirpath)
 	// url = strings.TrimSuffix(url, "/")
 
-	paths, err := RTree(dirpath, withjoin, recursive)
+	paths, err := RTree(dirpath, withjoin, recursive, searchmaxdirs)
 	if err != nil {
 		return ikeys, offset, limit, err
 	}
@@ -777,6 +825,13 @@ Main:
 					ik.Date = ev.Date
 
 					key.Lock()
+
+					if searchmaxresults > 0 && len(ikeys) >= searchmaxresults {
+						maxres = true
+						key.Unlock()
+						return errMaxResults
+					}
+
 					ikeys = append(ikeys, ik)
 					key.Unlock()
 
@@ -809,11 +864,14 @@ Main:
 
 	}
 
-	if err != nil {
-		return ikeys, offset, limit, err
-	}
+	werr := qwg.Wait()
 
-	if werr := qwg.Wait(); werr != nil {
+	switch {
+	case maxres:
+		return nil, offset, limit, errMaxResults
+	case err != nil:
+		return ikeys, offset, limit, err
+	case werr != nil:
 		return ikeys, offset, limit, werr
 	}
 
@@ -853,10 +911,17 @@ Main:
 }
 
 // DBKeys : search key names through requested directory
-func DBKeys(ndb *nutsdb.DB, base string, dirpath string, msort uint8, offset int, limit int, prefix string, expression string, recursive int, stopfirst uint8, minsize uint64, maxsize uint64, minstmp uint64, maxstmp uint64, withurl bool, url string, withjoin map[string]int, searchthreads int, searchtimeout int) ([]Keys, error) {
+func DBKeys(ndb *nutsdb.DB, base string, dirpath string, msort uint8, offset int, limit int, prefix string, expression string, recursive int, stopfirst uint8, minsize uint64, maxsize uint64, minstmp uint64, maxstmp uint64, withurl bool, url string, withjoin map[string]int, match *KeysMatch, searchthreads int, searchtimeout int, searchmaxdirs int, searchmaxresults int) ([]Keys, error) {
+
+	if match != nil {
+		skeys, err := SortKeys(0, 0, 0, freelist, ndb, base, dirpath, msort, 0, offset, limit, prefix, expression, recursive, stopfirst, minsize, maxsize, minstmp, maxstmp, withurl, url, withjoin, false, true, match, false, 0, searchthreads, searchtimeout, searchmaxdirs, searchmaxresults)
+		return PlainKeys(skeys), err
+	}
 
 	var key sync.Mutex
 
+	var maxres bool = false
+
 	var ikeys []Keys
 	var skeys []Keys
 
@@ -871,7 +936,7 @@ func DBKeys(ndb *nutsdb.DB, base string, dirpath string, msort uint8, offset int
 	dirpath = filepath.Clean(dirpath)
 	// url = strings.TrimSuffix(url, "/")
 
-	paths, err := RTree(dirpath, withjoin, recursive)
+	paths, err := RTree(dirpath, withjoin, recursive, searchmaxdirs)
 	if err != nil {
 		return ikeys, err
 	}
@@ -1005,6 +1070,13 @@ Main:
 					ik.Type = 1
 
 					key.Lock()
+
+					if searchmaxresults > 0 && len(ikeys) >= searchmaxresults {
+						maxres = true
+						key.Unlock()
+						return errMaxResults
+					}
+
 					ikeys = append(ikeys, ik)
 					key.Unlock()
 
@@ -1037,11 +1109,14 @@ Main:
 
 	}
 
-	if err != nil {
-		return ikeys, err
-	}
+	werr := qwg.Wait()
 
-	if werr := qwg.Wait(); werr != nil {
+	switch {
+	case maxres:
+		return nil, errMaxResults
+	case err != nil:
+		return ikeys, err
+	case werr != nil:
 		return ikeys, werr
 	}
 
@@ -1081,10 +1156,17 @@ Main:
 }
 
 // DBKeysInfo : search key names with info through requested directory
-func DBKeysInfo(ndb *nutsdb.DB, base string, dirpath string, msort uint8, offset int, limit int, prefix string, expression string, recursive int, stopfirst uint8, minsize uint64, maxsize uint64, minstmp uint64, maxstmp uint64, withurl bool, url string, withjoin map[string]int, searchthreads int, searchtimeout int) ([]KeysInfo, error) {
+func DBKeysInfo(ndb *nutsdb.DB, base string, dirpath string, msort uint8, msortby uint8, offset int, limit int, prefix string, expression string, recursive int, stopfirst uint8, minsize uint64, maxsize uint64, minstmp uint64, maxstmp uint64, withurl bool, url string, withjoin map[string]int, match *KeysMatch, searchthreads int, searchtimeout int, searchmaxdirs int, searchmaxresults int) ([]KeysInfo, error) {
+
+	if msortby > 0 || match != nil {
+		skeys, err := SortKeys(0, 0, 0, freelist, ndb, base, dirpath, msort, msortby, offset, limit, prefix, expression, recursive, stopfirst, minsize, maxsize, minstmp, maxstmp, withurl, url, withjoin, false, true, match, false, 0, searchthreads, searchtimeout, searchmaxdirs, searchmaxresults)
+		return InfoKeys(skeys), err
+	}
 
 	var key sync.Mutex
 
+	var maxres bool = false
+
 	var ikeys []KeysInfo
 	var skeys []KeysInfo
 
@@ -1099,7 +1181,7 @@ func DBKeysInfo(ndb *nutsdb.DB, base string, dirpath string, msort uint8, offset
 	dirpath = filepath.Clean(dirpath)
 	// url = strings.TrimSuffix(url, "/")
 
-	paths, err := RTree(dirpath, withjoin, recursive)
+	paths, err := RTree(dirpath, withjoin, recursive, searchmaxdirs)
 	if err != nil {
 		return ikeys, err
 	}
@@ -1235,6 +1317,13 @@ Main:
 					ik.Date = ev.Date
 
 					key.Lock()
+
+					if searchmaxresults > 0 && len(ikeys) >= searchmaxresults {
+						maxres = true
+						key.Unlock()
+						return errMaxResults
+					}
+
 					ikeys = append(ikeys, ik)
 					key.Unlock()
 
@@ -1267,11 +1356,14 @@ Main:
 
 	}
 
-	if err != nil {
-		return ikeys, err
-	}
+	werr := qwg.Wait()
 
-	if werr := qwg.Wait(); werr != nil {
+	switch {
+	case maxres:
+		return nil, errMaxResults
+	case err != nil:
+		return ikeys, err
+	case werr != nil:
 		return ikeys, werr
 	}
 
@@ -1311,10 +1403,16 @@ Main:
 }
 
 // DBKeysSearch : search key names/names with values through requested directory
-func DBKeysSearch(filemode os.FileMode, timeout time.Duration, opentries int, freelist string, ndb *nutsdb.DB, base string, dirpath string, msort uint8, offset int, limit int, prefix string, expression string, recursive int, stopfirst uint8, minsize uint64, maxsize uint64, minstmp uint64, maxstmp uint64, withurl bool, url string, withjoin map[string]int, withvalue bool, vmaxsize int64, searchthreads int, searchtimeout int) ([]KeysSearch, error) {
+func DBKeysSearch(filemode os.FileMode, timeout time.Duration, opentries int, freelist string, ndb *nutsdb.DB, base string, dirpath string, msort uint8, msortby uint8, offset int, limit int, prefix string, expression string, recursive int, stopfirst uint8, minsize uint64, maxsize uint64, minstmp uint64, maxstmp uint64, withurl bool, url string, withjoin map[string]int, match *KeysMatch, withvalue bool, vmaxsize int64, searchthreads int, searchtimeout int, searchmaxdirs int, searchmaxresults int) ([]KeysSearch, error) {
+
+	if msortby > 0 || match != nil {
+		return SortKeys(filemode, timeout, opentries, freelist, ndb, base, dirpath, msort, msortby, offset, limit, prefix, expression, recursive, stopfirst, minsize, maxsize, minstmp, maxstmp, withurl, url, withjoin, false, true, match, withvalue, vmaxsize, searchthreads, searchtimeout, searchmaxdirs, searchmaxresults)
+	}
 
 	var key sync.Mutex
 
+	var maxres bool = false
+
 	var ikeys []KeysSearch
 	var skeys []KeysSearch
 
@@ -1331,7 +1429,7 @@ func DBKeysSearch(filemode os.FileMode, timeout time.Duration, opentries int, fr
 	dirpath = filepath.Clean(dirpath)
 	// url = strings.TrimSuffix(url, "/")
 
-	paths, err := RTree(dirpath, withjoin, recursive)
+	paths, err := RTree(dirpath, withjoin, recursive, searchmaxdirs)
 	if err != nil {
 		return ikeys, err
 	}
@@ -1501,6 +1599,13 @@ Main:
 					ik.Date = ev.Date
 
 					key.Lock()
+
+					if searchmaxresults > 0 && len(ikeys) >= searchmaxresults {
+						maxres = true
+						key.Unlock()
+						return errMaxResults
+					}
+
 					ikeys = append(ikeys, ik)
 					key.Unlock()
 
@@ -1533,11 +1638,14 @@ Main:
 
 	}
 
-	if err != nil {
-		return ikeys, err
-	}
+	werr := qwg.Wait()
 
-	if werr := qwg.Wait(); werr != nil {
+	switch {
+	case maxres:
+		return nil, errMaxResults
+	case err != nil:
+		return ikeys, err
+	case werr != nil:
 		return ikeys, werr
 	}
 
@@ -1577,11 +1685,16 @@ Main:
 }
 
 // AllKeys : search summary file and key names through requested directory
-func AllKeys(ndb *nutsdb.DB, base string, dirpath string, msort uint8, offset int, limit int, prefix string, expression string, recursive int, stopfirst uint8, minsize uint64, maxsize uint64, minstmp uint64, maxstmp uint64, withurl bool, url string, withjoin map[string]int, searchthreads int, searchtimeout int) ([]Keys, error) {
+func AllKeys(ndb *nutsdb.DB, base string, dirpath string, msort uint8, offset int, limit int, prefix string, expression string, recursive int, stopfirst uint8, minsize uint64, maxsize uint64, minstmp uint64, maxstmp uint64, withurl bool, url string, withjoin map[string]int, match *KeysMatch, searchthreads int, searchtimeout int, searchmaxdirs int, searchmaxresults int) ([]Keys, error) {
+
+	if match != nil {
+		skeys, err := SortKeys(0, 0, 0, freelist, ndb, base, dirpath, msort, 0, offset, limit, prefix, expression, recursive, stopfirst, minsize, maxsize, minstmp, maxstmp, withurl, url, withjoin, true, true, match, false, 0, searchthreads, searchtimeout, searchmaxdirs, searchmaxresults)
+		return PlainKeys(skeys), err
+	}
 
 	var ikeys []Keys
 
-	fskeys, co, cl, err := FileKeys(ndb, base, dirpath, msort, offset, limit, prefix, expression, recursive, stopfirst, minsize, maxsize, minstmp, maxstmp, withurl, url, withjoin, searchthreads, searchtimeout)
+	fskeys, co, cl, err := FileKeys(ndb, base, dirpath, msort, offset, limit, prefix, expression, recursive, stopfirst, minsize, maxsize, minstmp, maxstmp, withurl, url, withjoin, nil, searchthreads, searchtimeout, searchmaxdirs, searchmaxresults)
 	if err != nil {
 		return ikeys, err
 	}
@@ -1600,23 +1713,32 @@ func AllKeys(ndb *nutsdb.DB, base string, dirpath string, msort uint8, offset in
 
 	offset = co
 
-	dbkeys, err := DBKeys(ndb, base, dirpath, msort, offset, limit, prefix, expression, recursive, stopfirst, minsize, maxsize, minstmp, maxstmp, withurl, url, withjoin, searchthreads, searchtimeout)
+	dbkeys, err := DBKeys(ndb, base, dirpath, msort, offset, limit, prefix, expression, recursive, stopfirst, minsize, maxsize, minstmp, maxstmp, withurl, url, withjoin, nil, searchthreads, searchtimeout, searchmaxdirs, searchmaxresults)
 	if err != nil {
 		return ikeys, err
 	}
 
 	ikeys = append(ikeys, append(fskeys, dbkeys...)...)
 
+	if searchmaxresults > 0 && len(ikeys) > searchmaxresults {
+		return nil, errMaxResults
+	}
+
 	return ikeys, nil
 
 }
 
 // AllKeysInfo : search summary file and key names with info through requested directory
-func AllKeysInfo(ndb *nutsdb.DB, base string, dirpath string, msort uint8, offset int, limit int, prefix string, expression string, recursive int, stopfirst uint8, minsize uint64, maxsize uint64, minstmp uint64, maxstmp uint64, withurl bool, url string, withjoin map[string]int, searchthreads int, searchtimeout int) ([]KeysInfo, error) {
+func AllKeysInfo(ndb *nutsdb.DB, base string, dirpath string, msort uint8, msortby uint8, offset int, limit int, prefix string, expression string, recursive int, stopfirst uint8, minsize uint64, maxsize uint64, minstmp uint64, maxstmp uint64, withurl bool, url string, withjoin map[string]int, match *KeysMatch, searchthreads int, searchtimeout int, searchmaxdirs int, searchmaxresults int) ([]KeysInfo, error) {
+
+	if msortby > 0 || match != nil {
+		skeys, err := SortKeys(0, 0, 0, freelist, ndb, base, dirpath, msort, msortby, offset, limit, prefix, expression, recursive, stopfirst, minsize, maxsize, minstmp, maxstmp, withurl, url, withjoin, true, true, match, false, 0, searchthreads, searchtimeout, searchmaxdirs, searchmaxresults)
+		return InfoKeys(skeys), err
+	}
 
 	var ikeys []KeysInfo
 
-	fskeys, co, cl, err := FileKeysInfo(ndb, base, dirpath, msort, offset, limit, prefix, expression, recursive, stopfirst, minsize, maxsize, minstmp, maxstmp, withurl, url, withjoin, searchthreads, searchtimeout)
+	fskeys, co, cl, err := FileKeysInfo(ndb, base, dirpath, msort, msortby, offset, limit, prefix, expression, recursive, stopfirst, minsize, maxsize, minstmp, maxstmp, withurl, url, withjoin, nil, searchthreads, searchtimeout, searchmaxdirs, searchmaxresults)
 	if err != nil {
 		return ikeys, err
 	}
@@ -1635,23 +1757,31 @@ func AllKeysInfo(ndb *nutsdb.DB, base string, dirpath string, msort uint8, offse
 
 	offset = co
 
-	dbkeys, err := DBKeysInfo(ndb, base, dirpath, msort, offset, limit, prefix, expression, recursive, stopfirst, minsize, maxsize, minstmp, maxstmp, withurl, url, withjoin, searchthreads, searchtimeout)
+	dbkeys, err := DBKeysInfo(ndb, base, dirpath, msort, msortby, offset, limit, prefix, expression, recursive, stopfirst, minsize, maxsize, minstmp, maxstmp, withurl, url, withjoin, nil, searchthreads, searchtimeout, searchmaxdirs, searchmaxresults)
 	if err != nil {
 		return ikeys, err
 	}
 
 	ikeys = append(ikeys, append(fskeys, dbkeys...)...)
 
+	if searchmaxresults > 0 && len(ikeys) > searchmaxresults {
+		return nil, errMaxResults
+	}
+
 	return ikeys, nil
 
 }
 
 // AllKeysSearch : search summary file and key names/names with values through requested directory
-func AllKeysSearch(filemode os.FileMode, timeout time.Duration, opentries int, freelist string, ndb *nutsdb.DB, base string, dirpath string, msort uint8, offset int, limit int, prefix string, expression string, recursive int, stopfirst uint8, minsize uint64, maxsize uint64, minstmp uint64, maxstmp uint64, withurl bool, url string, withjoin map[string]int, withvalue bool, vmaxsize int64, searchthreads int, searchtimeout int) ([]KeysSearch, error) {
+func AllKeysSearch(filemode os.FileMode, timeout time.Duration, opentries int, freelist string, ndb *nutsdb.DB, base string, dirpath string, msort uint8, msortby uint8, offset int, limit int, prefix string, expression string, recursive int, stopfirst uint8, minsize uint64, maxsize uint64, minstmp uint64, maxstmp uint64, withurl bool, url string, withjoin map[string]int, match *KeysMatch, withvalue bool, vmaxsize int64, searchthreads int, searchtimeout int, searchmaxdirs int, searchmaxresults int) ([]KeysSearch, error) {
+
+	if msortby > 0 || match != nil {
+		return SortKeys(filemode, timeout, opentries, freelist, ndb, base, dirpath, msort, msortby, offset, limit, prefix, expression, recursive, stopfirst, minsize, maxsize, minstmp, maxstmp, withurl, url, withjoin, true, true, match, withvalue, vmaxsize, searchthreads, searchtimeout, searchmaxdirs, searchmaxresults)
+	}
 
 	var ikeys []KeysSearch
 
-	fskeys, co, cl, err := FileKeysSearch(ndb, base, dirpath, msort, offset, limit, prefix, expression, recursive, stopfirst, minsize, maxsize, minstmp, maxstmp, withurl, url, withjoin, withvalue, vmaxsize, searchthreads, searchtimeout)
+	fskeys, co, cl, err := FileKeysSearch(ndb, base, dirpath, msort, msortby, offset, limit, prefix, expression, recursive, stopfirst, minsize, maxsize, minstmp, maxstmp, withurl, url, withjoin, nil, withvalue, vmaxsize, searchthreads, searchtimeout, searchmaxdirs, searchmaxresults)
 	if err != nil {
 		return ikeys, err
 	}
@@ -1670,26 +1800,26 @@ func AllKeysSearch(filemode os.FileMode, timeout time.Duration, opentries int, f
 
 	offset = co
 
-	dbkeys, err := DBKeysSearch(filemode, timeout, opentries, freelist, ndb, base, dirpath, msort, offset, limit, prefix, expression, recursive, stopfirst, minsize, maxsize, minstmp, maxstmp, withurl, url, withjoin, withvalue, vmaxsize, searchthreads, searchtimeout)
+	dbkeys, err := DBKeysSearch(filemode, timeout, opentries, freelist, ndb, base, dirpath, msort, msortby, offset, limit, prefix, expression, recursive, stopfirst, minsize, maxsize, minstmp, maxstmp, withurl, url, withjoin, nil, withvalue, vmaxsize, searchthreads, searchtimeout, searchmaxdirs, searchmaxresults)
 	if err != nil {
 		return ikeys, err
 	}
 
 	ikeys = append(ikeys, append(fskeys, dbkeys...)...)
 
+	if searchmaxresults > 0 && len(ikeys) > searchmaxresults {
+		return nil, errMaxResults
+	}
+
 	return ikeys, nil
 
 }
 
-// RTree : search directories names through requested directory with maximum available recursion level = 3
-func RTree(dirpath string, withjoin map[string]int, recursive int) (paths map[string]uint64, err error) {
+// RTree : search directories names through requested directory with requested recursion level, negative recursion level is unlimited
+func RTree(dirpath string, withjoin map[string]int, recursive int, searchmaxdirs int) (paths map[string]uint64, err error) {
 
 	paths = make(map[string]uint64)
 
-	if recursive > 3 || recursive < 0 {
-		recursive = 3
-	}
-
 	switch {
 
 	case len(withjoin) == 0 && recursive == 0:
@@ -1702,29 +1832,60 @@ func RTree(dirpath string, withjoin map[string]int, recursive int) (paths map[st
 	radix.RLock()
 	root := tree.Root()
 
+	// Iterator is created again for every seek, directories of other branches and too deep subtrees are skipped without walking
+
+	seek := func(key []byte) *iradix.Iterator {
+		it := root.Iterator()
+		it.SeekLowerBound(key)
+		return it
+	}
+
+Main:
+
 	for edir, erec := range withjoin {
 
+		bdir := []byte(edir)
 		spc := strings.Count(edir, "/")
 
-		recursive = erec
+		it := seek(bdir)
 
-		walk := func(bdir []byte, dcrc interface{}) bool {
+		for {
 
-			fpc := bytes.Count(bdir, bslash) - recursive
+			sdir, dcrc, ok := it.Next()
+			if !ok || !bytes.HasPrefix(sdir, bdir) {
+				break
+			}
+
+			// Skip sibling directories with the same name prefix, /a/bc is not a subdirectory of /a/b
+
+			if len(sdir) > len(bdir) && sdir[len(bdir)] != '/' {
+
+				if sdir[len(bdir)] > '/' {
+					break
+				}
+
+				it = seek(append(append([]byte{}, bdir...), '/'))
+				continue
 
-			if spc >= fpc {
-				paths[string(bdir)] = dcrc.(uint64)
 			}
 
-			return false
+			if erec >= 0 && bytes.Count(sdir, bslash)-spc > erec {
+				it = seek(PrefixEnd(append(append([]byte{}, sdir...), '/')))
+				continue
+			}
 
-		}
+			paths[string(sdir)] = dcrc.(uint64)
 
-		root.WalkPrefix([]byte(edir), walk)
+			if searchmaxdirs > 0 && len(paths) > searchmaxdirs {
+				err = errMaxDirs
+				break Main
+			}
+
+		}
 
 	}
 	radix.RUnlock()
 
-	return paths, nil
+	return paths, err
 
 }
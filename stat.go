@@ -0,0 +1,354 @@
+/*
+
+Copyright © 2020 Andrey Kuvshinov. Contacts: <syslinux@protonmail.com>
+Copyright © 2020 Eltaline OU. Contacts: <eltaline.ou@gmail.com>
+All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+The wZD project contains unmodified/modified libraries imports too with
+separate copyright notices and license terms. Your use of the source code
+this libraries is subject to the terms and conditions of licenses these libraries.
+
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"github.com/eltaline/nutsdb"
+	"github.com/pieterclaerhout/go-waitgroup"
+	"math/bits"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Stats Handlers
+
+// StatKeys : aggregate sizes, counts and histograms of files and/or keys through requested directory grouped by subdirectories
+func StatKeys(ndb *nutsdb.DB, base string, dirpath string, prefix string, expression string, recursive int, minsize uint64, maxsize uint64, minstmp uint64, maxstmp uint64, withjoin map[string]int, files bool, archives bool, groupdepth int, histdate uint64, histsize uint64, histlog bool, searchthreads int, searchtimeout int, searchmaxdirs int) ([]KeysStats, error) {
+
+	var key sync.Mutex
+
+	var istats []KeysStats
+
+	groups := make(map[string]*KeysStats)
+
+	var tprefixes []string
+
+	if files {
+		tprefixes = append(tprefixes, "f:")
+	}
+
+	if archives {
+		tprefixes = append(tprefixes, "b:")
+	}
+
+	dirpath = filepath.Clean(dirpath)
+
+	paths, err := RTree(dirpath, withjoin, recursive, searchmaxdirs)
+	if err != nil {
+		return istats, err
+	}
+
+	pkeys := make([]string, 0, len(paths))
+	for pk := range paths {
+		pkeys = append(pkeys, pk)
+	}
+
+	sort.Strings(pkeys)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(searchtimeout)*time.Second)
+	defer cancel()
+
+	qwg, ctx := waitgroup.NewErrorGroup(ctx, searchthreads)
+
+Main:
+
+	for _, idirname := range pkeys {
+
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+			break Main
+		default:
+		}
+
+		qwait := make(chan bool)
+
+		qwg.Add(func() error {
+
+			dirname := idirname
+			dcrc := paths[dirname]
+
+			gdir := StatGroup(dirpath, dirname, groupdepth)
+
+			nbucket := strconv.FormatUint(dcrc, 16)
+
+			qwait <- true
+
+			lstats := &KeysStats{hdate: make(map[uint64]uint64), hsize: make(map[uint64]uint64)}
+
+			nerr := ndb.View(func(tx *nutsdb.Tx) error {
+
+				var err error
+
+				for _, tprefix := range tprefixes {
+
+					var entries nutsdb.Entries
+
+					bprefix := []byte(tprefix + prefix)
+
+					switch {
+					case expression != "(.+)":
+						entries, _, err = tx.PrefixSearchScan(nbucket, bprefix, expression, -1, -1)
+					default:
+						entries, _, err = tx.PrefixScan(nbucket, bprefix, -1, -1)
+					}
+
+					if entries == nil {
+						continue
+					}
+
+					if err != nil {
+						return err
+					}
+
+					for _, entry := range entries {
+
+						select {
+						case <-ctx.Done():
+							return ctx.Err()
+						default:
+						}
+
+						var ev RawKeysData
+
+						err = binary.Read(bytes.NewReader(entry.Value), Endian, &ev)
+						if err != nil {
+							return err
+						}
+
+						switch {
+						case ev.Size < minsize && minsize > 0:
+							continue
+						case ev.Size > maxsize && maxsize > 0:
+							continue
+						case ev.Date < minstmp && minstmp > 0:
+							continue
+						case ev.Date > maxstmp && maxstmp > 0:
+							continue
+						}
+
+						StatAdd(lstats, ev.Size, ev.Date, tprefix == "b:", histdate, histsize, histlog)
+
+					}
+
+				}
+
+				return nil
+
+			})
+
+			if nerr != nil {
+				cancel()
+				return nerr
+			}
+
+			key.Lock()
+
+			gstats, ok := groups[gdir]
+			if !ok {
+				gstats = &KeysStats{Path: gdir, hdate: make(map[uint64]uint64), hsize: make(map[uint64]uint64)}
+				groups[gdir] = gstats
+			}
+
+			StatMerge(gstats, lstats)
+
+			key.Unlock()
+
+			return nil
+
+		})
+
+		<-qwait
+
+	}
+
+	werr := qwg.Wait()
+
+	switch {
+	case err != nil:
+		return istats, err
+	case werr != nil:
+		return istats, werr
+	}
+
+	gkeys := make([]string, 0, len(groups))
+	for gk := range groups {
+		gkeys = append(gkeys, gk)
+	}
+
+	sort.Strings(gkeys)
+
+	for _, gk := range gkeys {
+
+		gstats := groups[gk]
+
+		if cnt := gstats.Files + gstats.Archives; cnt > 0 {
+			gstats.AvgSize = gstats.Bytes / cnt
+		}
+
+		gstats.HistDate = StatHist(gstats.hdate)
+		gstats.HistSize = StatHist(gstats.hsize)
+
+		gstats.Path = strings.TrimPrefix(gstats.Path, base)
+
+		if gstats.Path == "" {
+			gstats.Path = "/"
+		}
+
+		istats = append(istats, *gstats)
+
+	}
+
+	return istats, nil
+
+}
+
+// StatGroup : directory of aggregation group for directory, limited by group depth below requested directory, negative depth is unlimited
+func StatGroup(dirpath string, dirname string, groupdepth int) string {
+
+	rel := strings.Trim(strings.TrimPrefix(dirname, dirpath), "/")
+
+	if rel == "" || groupdepth < 0 {
+		return dirname
+	}
+
+	if groupdepth == 0 {
+		return dirpath
+	}
+
+	parts := strings.Split(rel, "/")
+
+	if len(parts) > groupdepth {
+		parts = parts[:groupdepth]
+	}
+
+	return dirpath + "/" + strings.Join(parts, "/")
+
+}
+
+// StatAdd : add size and date of one file or key to aggregated statistics
+func StatAdd(st *KeysStats, size uint64, date uint64, archive bool, histdate uint64, histsize uint64, histlog bool) {
+
+	if st.Files+st.Archives == 0 || size < st.MinSize {
+		st.MinSize = size
+	}
+
+	if size > st.MaxSize {
+		st.MaxSize = size
+	}
+
+	if st.Files+st.Archives == 0 || date < st.MinDate {
+		st.MinDate = date
+	}
+
+	if date > st.MaxDate {
+		st.MaxDate = date
+	}
+
+	st.Bytes += size
+
+	if archive {
+		st.Archives++
+	} else {
+		st.Files++
+	}
+
+	if histdate > 0 {
+		st.hdate[date-date%histdate]++
+	}
+
+	switch {
+	case histlog && size > 0:
+		st.hsize[uint64(1)<<uint(bits.Len64(size)-1)]++
+	case histlog:
+		st.hsize[0]++
+	case histsize > 0:
+		st.hsize[size-size%histsize]++
+	}
+
+}
+
+// StatMerge : merge aggregated statistics of one directory into aggregated statistics of group
+func StatMerge(dst *KeysStats, src *KeysStats) {
+
+	if src.Files+src.Archives == 0 {
+		return
+	}
+
+	if dst.Files+dst.Archives == 0 || src.MinSize < dst.MinSize {
+		dst.MinSize = src.MinSize
+	}
+
+	if src.MaxSize > dst.MaxSize {
+		dst.MaxSize = src.MaxSize
+	}
+
+	if dst.Files+dst.Archives == 0 || src.MinDate < dst.MinDate {
+		dst.MinDate = src.MinDate
+	}
+
+	if src.MaxDate > dst.MaxDate {
+		dst.MaxDate = src.MaxDate
+	}
+
+	dst.Bytes += src.Bytes
+	dst.Files += src.Files
+	dst.Archives += src.Archives
+
+	for hk, hv := range src.hdate {
+		dst.hdate[hk] += hv
+	}
+
+	for hk, hv := range src.hsize {
+		dst.hsize[hk] += hv
+	}
+
+}
+
+// StatHist : sorted histogram buckets from histogram map
+func StatHist(hist map[uint64]uint64) []KeysHist {
+
+	if len(hist) == 0 {
+		return nil
+	}
+
+	khist := make([]KeysHist, 0, len(hist))
+
+	for hk, hv := range hist {
+		khist = append(khist, KeysHist{From: hk, Count: hv})
+	}
+
+	sort.Slice(khist, func(i, j int) bool { return khist[i].From < khist[j].From })
+
+	return khist
+
+}
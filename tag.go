@@ -0,0 +1,207 @@
+/*
+
+Copyright © 2020 Andrey Kuvshinov. Contacts: <syslinux@protonmail.com>
+Copyright © 2020 Eltaline OU. Contacts: <eltaline.ou@gmail.com>
+All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+The wZD project contains unmodified/modified libraries imports too with
+separate copyright notices and license terms. Your use of the source code
+this libraries is subject to the terms and conditions of licenses these libraries.
+
+*/
+
+package main
+
+import (
+	"github.com/coocood/freecache"
+	"hash/crc64"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Cache Tags Helpers
+
+const (
+	ctagsrecent = 1024
+	ctagsprune  = 4096
+)
+
+// CacheSeq : current sequence number of directories invalidations, must be taken before search
+func CacheSeq() uint64 {
+
+	ctags.Lock()
+	defer ctags.Unlock()
+
+	return ctags.Seq
+
+}
+
+// CacheCovers : check that directory is covered by search through root directory with recursion depth, negative depth is unlimited
+func CacheCovers(root string, depth int, dir string) bool {
+
+	if dir == root {
+		return true
+	}
+
+	if !strings.HasPrefix(dir, root+"/") {
+		return false
+	}
+
+	return depth < 0 || strings.Count(strings.TrimPrefix(dir, root), "/") <= depth
+
+}
+
+// CacheSet : write search result to cache and tag it by searched root directories, result is not cached if directories were changed during search
+func CacheSet(cache *freecache.Cache, key []byte, value []byte, expire int, roots map[string]int, seq uint64) error {
+
+	ctags.Lock()
+	defer ctags.Unlock()
+
+	if seq != ctags.Seq {
+
+		if ctags.Seq-seq > uint64(len(ctags.Recent)) {
+			return nil
+		}
+
+		for _, inval := range ctags.Recent {
+
+			if inval.Seq <= seq {
+				continue
+			}
+
+			for root, depth := range roots {
+
+				if CacheCovers(root, depth, inval.Dir) {
+					return nil
+				}
+
+			}
+
+		}
+
+	}
+
+	err := cache.Set(key, value, expire)
+	if err != nil {
+		return err
+	}
+
+	var exp int64 = 0
+
+	if expire > 0 {
+		exp = time.Now().Unix() + int64(expire)
+	}
+
+	for root, depth := range roots {
+
+		rcrc := crc64.Checksum([]byte(root), ctbl64)
+
+		rtags, ok := ctags.Tags[rcrc]
+		if !ok {
+			rtags = make(map[string]CacheTag)
+			ctags.Tags[rcrc] = rtags
+		}
+
+		rtags[string(key)] = CacheTag{Depth: depth, Expire: exp}
+
+	}
+
+	ctags.Adds++
+
+	if ctags.Adds >= ctagsprune {
+		CachePrune(cache)
+		ctags.Adds = 0
+	}
+
+	return nil
+
+}
+
+// CacheInvalidate : delete from cache all search results which include changed directory, directory ancestors are checked by recursion depth
+func CacheInvalidate(cache *freecache.Cache, dir string) {
+
+	dir = filepath.Clean(dir)
+
+	ctags.Lock()
+	defer ctags.Unlock()
+
+	ctags.Seq++
+
+	ctags.Recent = append(ctags.Recent, CacheInval{Seq: ctags.Seq, Dir: dir})
+
+	if len(ctags.Recent) > ctagsrecent {
+		ctags.Recent = ctags.Recent[len(ctags.Recent)-ctagsrecent:]
+	}
+
+	root := dir
+
+	for {
+
+		rcrc := crc64.Checksum([]byte(root), ctbl64)
+
+		for key, tag := range ctags.Tags[rcrc] {
+
+			if CacheCovers(root, tag.Depth, dir) {
+				cache.Del([]byte(key))
+				delete(ctags.Tags[rcrc], key)
+			}
+
+		}
+
+		if len(ctags.Tags[rcrc]) == 0 {
+			delete(ctags.Tags, rcrc)
+		}
+
+		if root == "/" || root == "." {
+			break
+		}
+
+		root = filepath.Dir(root)
+
+	}
+
+}
+
+// CachePrune : delete tags of expired search results and of search results evicted from cache, must be called with locked tags
+func CachePrune(cache *freecache.Cache) {
+
+	now := time.Now().Unix()
+
+	for rcrc, rtags := range ctags.Tags {
+
+		for key, tag := range rtags {
+
+			if tag.Expire > 0 && tag.Expire < now {
+				delete(rtags, key)
+				continue
+			}
+
+			// Lookup of TTL does not copy the cached value
+
+			_, err := cache.TTL([]byte(key))
+			if err != nil {
+				delete(rtags, key)
+			}
+
+		}
+
+		if len(rtags) == 0 {
+			delete(ctags.Tags, rcrc)
+		}
+
+	}
+
+}
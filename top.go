@@ -0,0 +1,587 @@
+/*
+
+Copyright © 2020 Andrey Kuvshinov. Contacts: <syslinux@protonmail.com>
+Copyright © 2020 Eltaline OU. Contacts: <eltaline.ou@gmail.com>
+All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+The wZD project contains unmodified/modified libraries imports too with
+separate copyright notices and license terms. Your use of the source code
+this libraries is subject to the terms and conditions of licenses these libraries.
+
+*/
+
+package main
+
+import (
+	"bytes"
+	"container/heap"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"github.com/eltaline/nutsdb"
+	"github.com/pieterclaerhout/go-waitgroup"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Top Handlers
+
+// KeysSearchLess : order of two files and/or keys by key, size or date, equal sizes or dates are ordered by key
+func KeysSearchLess(a KeysSearch, b KeysSearch, by uint8, desc bool) bool {
+
+	switch {
+	case by == 1 && a.Size != b.Size:
+		return (a.Size < b.Size) != desc
+	case by == 2 && a.Date != b.Date:
+		return (a.Date < b.Date) != desc
+	case by == 0 && a.Key != b.Key:
+		return (a.Key < b.Key) != desc
+	case a.Key != b.Key:
+		return a.Key < b.Key
+	}
+
+	return a.Type < b.Type
+
+}
+
+// Len : len heap
+func (t *KeysTop) Len() int {
+	return len(t.Keys)
+}
+
+// Less : less heap, the last file or key of requested order is on top of heap
+func (t *KeysTop) Less(i, j int) bool {
+	return KeysSearchLess(t.Keys[j], t.Keys[i], t.By, t.Desc)
+}
+
+// Swap : swap heap
+func (t *KeysTop) Swap(i, j int) {
+	t.Keys[i], t.Keys[j] = t.Keys[j], t.Keys[i]
+}
+
+// Push : push heap
+func (t *KeysTop) Push(x interface{}) {
+	t.Keys = append(t.Keys, x.(KeysSearch))
+}
+
+// Pop : pop heap
+func (t *KeysTop) Pop() interface{} {
+
+	n := len(t.Keys)
+	x := t.Keys[n-1]
+	t.Keys = t.Keys[:n-1]
+
+	return x
+
+}
+
+// Add : add file or key to heap, keeps only first Size files or keys of requested order, Size <= 0 keeps all
+func (t *KeysTop) Add(ik KeysSearch) {
+
+	switch {
+	case t.Size <= 0:
+		t.Keys = append(t.Keys, ik)
+	case len(t.Keys) < t.Size:
+		heap.Push(t, ik)
+	case KeysSearchLess(ik, t.Keys[0], t.By, t.Desc):
+		t.Keys[0] = ik
+		heap.Fix(t, 0)
+	}
+
+}
+
+// Sorted : files and/or keys from heap in requested order
+func (t *KeysTop) Sorted() []KeysSearch {
+
+	sort.Slice(t.Keys, func(i, j int) bool { return KeysSearchLess(t.Keys[i], t.Keys[j], t.By, t.Desc) })
+
+	return t.Keys
+
+}
+
+// KeysTopCut : apply offset, stop first and limit to sorted files and/or keys
+func KeysTopCut(ikeys []KeysSearch, offset int, limit int, stopfirst bool) []KeysSearch {
+
+	if offset > 0 {
+
+		if offset >= len(ikeys) {
+			return nil
+		}
+
+		ikeys = ikeys[offset:]
+
+	}
+
+	if stopfirst && len(ikeys) > 1 {
+		ikeys = ikeys[:1]
+	}
+
+	if limit > 0 && len(ikeys) > limit {
+		ikeys = ikeys[:limit]
+	}
+
+	return ikeys
+
+}
+
+// InfoKeys : convert files and/or keys for search to files and/or keys with info
+func InfoKeys(skeys []KeysSearch) []KeysInfo {
+
+	var ikeys []KeysInfo
+
+	for _, sk := range skeys {
+		ikeys = append(ikeys, KeysInfo{Key: sk.Key, Size: sk.Size, Date: sk.Date, Type: sk.Type})
+	}
+
+	return ikeys
+
+}
+
+// PlainKeys : convert files and/or keys for search to files and/or keys
+func PlainKeys(skeys []KeysSearch) []Keys {
+
+	var ikeys []Keys
+
+	for _, sk := range skeys {
+		ikeys = append(ikeys, Keys{Key: sk.Key, Type: sk.Type})
+	}
+
+	return ikeys
+
+}
+
+// KeysTopValues : read values of selected keys from files and archives
+func KeysTopValues(ikeys []KeysSearch, filemode os.FileMode, timeout time.Duration, opentries int, freelist string, imaxsize uint64) error {
+
+	for i := range ikeys {
+
+		if ikeys[i].Size > imaxsize || !FileExists(ikeys[i].dbn) {
+			continue
+		}
+
+		if ikeys[i].Type == 0 {
+
+			value, err := ioutil.ReadFile(ikeys[i].dbn)
+			if err != nil {
+				return err
+			}
+
+			if value != nil {
+				ikeys[i].Value = hex.EncodeToString(value)
+			}
+
+			continue
+
+		}
+
+		db, err := BoltOpenRead(ikeys[i].dbn, filemode, timeout, opentries, freelist)
+		if err != nil {
+			return err
+		}
+
+		value, err := DBGetVal(db, fmt.Sprintf("wzd%d", ikeys[i].buck), []byte(filepath.Base(ikeys[i].Key)))
+		if err != nil {
+			db.Close()
+			return err
+		}
+
+		if value != nil {
+			ikeys[i].Value = hex.EncodeToString(value)
+		}
+
+		db.Close()
+
+	}
+
+	return nil
+
+}
+
+// MatchKeys : archive keys of directory found by full-text and/or JSON field indexes
+func MatchKeys(tx *nutsdb.Tx, nbucket string, match *KeysMatch) (map[string]bool, error) {
+
+	var keys map[string]bool
+	var err error
+
+	if len(match.Text) > 0 {
+
+		keys, err = FTSearch(tx, nbucket, match.Text)
+		if err != nil || len(keys) == 0 {
+			return keys, err
+		}
+
+	}
+
+	if match.Field != nil {
+
+		fkeys, err := JXSearch(tx, nbucket, match.Field)
+		if err != nil {
+			return nil, err
+		}
+
+		if keys == nil {
+			return fkeys, nil
+		}
+
+		for key := range keys {
+
+			if !fkeys[key] {
+				delete(keys, key)
+			}
+
+		}
+
+	}
+
+	if keys == nil {
+		keys = make(map[string]bool)
+	}
+
+	return keys, nil
+
+}
+
+// MatchIndexed : check that search is limited to archive keys found by full-text and/or JSON field indexes
+func MatchIndexed(match *KeysMatch) bool {
+	return match != nil && (len(match.Text) > 0 || match.Field != nil)
+}
+
+// MatchRanged : check that search is limited to range of key names
+func MatchRanged(match *KeysMatch) bool {
+	return match != nil && (match.StartKey != "" || match.EndKey != "")
+}
+
+// MatchRange : start and end of search db range scan by range of key names and prefix
+func MatchRange(match *KeysMatch, tprefix string, prefix string) (start []byte, end []byte) {
+
+	skey := match.StartKey
+
+	if prefix > skey {
+		skey = prefix
+	}
+
+	end = PrefixEnd([]byte(tprefix + prefix))
+
+	if match.EndKey != "" && tprefix+match.EndKey < string(end) {
+		end = []byte(tprefix + match.EndKey)
+	}
+
+	return []byte(tprefix + skey), end
+
+}
+
+// PrefixEnd : successor of prefix, the first key after all keys with prefix, used as end of range scan
+func PrefixEnd(prefix []byte) []byte {
+
+	end := append([]byte(nil), prefix...)
+
+	for i := len(end) - 1; i >= 0; i-- {
+
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+
+	}
+
+	return []byte("\xff")
+
+}
+
+// MatchInRange : check that key name is inside range of key names and matches prefix and regular expression
+func MatchInRange(match *KeysMatch, kname string, prefix string, rgx *regexp.Regexp) bool {
+
+	switch {
+	case kname < match.StartKey:
+		return false
+	case match.EndKey != "" && kname >= match.EndKey:
+		return false
+	case !strings.HasPrefix(kname, prefix):
+		return false
+	case rgx != nil && !rgx.MatchString(strings.TrimPrefix(kname, prefix)):
+		return false
+	}
+
+	return true
+
+}
+
+// SortKeys : search files and/or keys through requested directory sorted by size or date with top N heap, match limits search to range of key names and/or archive keys found by full-text and JSON field indexes
+func SortKeys(filemode os.FileMode, timeout time.Duration, opentries int, freelist string, ndb *nutsdb.DB, base string, dirpath string, msort uint8, msortby uint8, offset int, limit int, prefix string, expression string, recursive int, stopfirst uint8, minsize uint64, maxsize uint64, minstmp uint64, maxstmp uint64, withurl bool, url string, withjoin map[string]int, files bool, archives bool, match *KeysMatch, withvalue bool, vmaxsize int64, searchthreads int, searchtimeout int, searchmaxdirs int, searchmaxresults int) ([]KeysSearch, error) {
+
+	var key sync.Mutex
+
+	var maxres bool = false
+
+	imaxsize := uint64(vmaxsize)
+
+	top := &KeysTop{By: msortby, Desc: msort == 1}
+
+	if limit > 0 {
+
+		top.Size = limit
+
+		if offset > 0 {
+			top.Size += offset
+		}
+
+	}
+
+	if stopfirst == 1 {
+		top.Size = 1
+		offset = 0
+	}
+
+	var tprefixes []string
+
+	if files {
+		tprefixes = append(tprefixes, "f:")
+	}
+
+	if archives {
+		tprefixes = append(tprefixes, "b:")
+	}
+
+	if MatchIndexed(match) {
+		tprefixes = []string{"b:"}
+	}
+
+	var rgx *regexp.Regexp
+	var err error
+
+	if MatchRanged(match) && expression != "(.+)" {
+
+		rgx, err = regexp.Compile(expression)
+		if err != nil {
+			return nil, err
+		}
+
+	}
+
+	dirpath = filepath.Clean(dirpath)
+
+	paths, err := RTree(dirpath, withjoin, recursive, searchmaxdirs)
+	if err != nil {
+		return nil, err
+	}
+
+	pkeys := make([]string, 0, len(paths))
+	for pk := range paths {
+		pkeys = append(pkeys, pk)
+	}
+
+	sort.Strings(pkeys)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(searchtimeout)*time.Second)
+	defer cancel()
+
+	qwg, ctx := waitgroup.NewErrorGroup(ctx, searchthreads)
+
+Main:
+
+	for _, idirname := range pkeys {
+
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+			break Main
+		default:
+		}
+
+		qwait := make(chan bool)
+
+		qwg.Add(func() error {
+
+			dirname := idirname
+			dcrc := paths[dirname]
+
+			pbdbname := dirname + "/" + filepath.Base(dirname)
+
+			vdirname := strings.TrimPrefix(dirname, base) + "/"
+
+			nbucket := strconv.FormatUint(dcrc, 16)
+
+			qwait <- true
+
+			nerr := ndb.View(func(tx *nutsdb.Tx) error {
+
+				var err error
+
+				var tkeys map[string]bool
+
+				if MatchIndexed(match) {
+
+					tkeys, err = MatchKeys(tx, nbucket, match)
+					if err != nil {
+						return err
+					}
+
+					if len(tkeys) == 0 {
+						return nil
+					}
+
+				}
+
+				for _, tprefix := range tprefixes {
+
+					var entries nutsdb.Entries
+
+					bprefix := []byte(tprefix + prefix)
+
+					switch {
+					case MatchRanged(match):
+						start, end := MatchRange(match, tprefix, prefix)
+						entries, err = tx.RangeScan(nbucket, start, end)
+					case expression != "(.+)":
+						entries, _, err = tx.PrefixSearchScan(nbucket, bprefix, expression, -1, -1)
+					default:
+						entries, _, err = tx.PrefixScan(nbucket, bprefix, -1, -1)
+					}
+
+					if entries == nil {
+						continue
+					}
+
+					if err != nil {
+						return err
+					}
+
+					for _, entry := range entries {
+
+						select {
+						case <-ctx.Done():
+							return ctx.Err()
+						default:
+						}
+
+						var ik KeysSearch
+						var ev RawKeysData
+
+						kname := strings.TrimPrefix(string(entry.Key), tprefix)
+
+						if tkeys != nil && !tkeys[kname] {
+							continue
+						}
+
+						if MatchRanged(match) && !MatchInRange(match, kname, prefix, rgx) {
+							continue
+						}
+
+						err = binary.Read(bytes.NewReader(entry.Value), Endian, &ev)
+						if err != nil {
+							return err
+						}
+
+						switch {
+						case ev.Size < minsize && minsize > 0:
+							continue
+						case ev.Size > maxsize && maxsize > 0:
+							continue
+						case ev.Date < minstmp && minstmp > 0:
+							continue
+						case ev.Date > maxstmp && maxstmp > 0:
+							continue
+						}
+
+						switch tprefix {
+						case "b:":
+
+							ik.Type = 1
+							ik.dbn = pbdbname + ".bolt"
+							ik.buck = ev.Buck
+
+							if ev.Prnt > 0 {
+								ik.dbn = pbdbname + "_" + fmt.Sprintf("%08d%s", ev.Prnt, ".bolt")
+							}
+
+						case "f:":
+							ik.dbn = dirname + "/" + kname
+						}
+
+						kname = strings.TrimPrefix(vdirname+kname, "/")
+
+						if withurl {
+							kname = url + "/" + kname
+						}
+
+						ik.Key = kname
+						ik.Size = ev.Size
+						ik.Date = ev.Date
+
+						key.Lock()
+
+						if searchmaxresults > 0 && top.Len() >= searchmaxresults {
+							maxres = true
+							key.Unlock()
+							return errMaxResults
+						}
+
+						top.Add(ik)
+						key.Unlock()
+
+					}
+
+				}
+
+				return nil
+
+			})
+
+			if nerr != nil {
+				cancel()
+				return nerr
+			}
+
+			return nil
+
+		})
+
+		<-qwait
+
+	}
+
+	werr := qwg.Wait()
+
+	switch {
+	case maxres:
+		return nil, errMaxResults
+	case err != nil:
+		return nil, err
+	case werr != nil:
+		return nil, werr
+	}
+
+	ikeys := KeysTopCut(top.Sorted(), offset, limit, stopfirst == 1)
+
+	if withvalue {
+
+		err = KeysTopValues(ikeys, filemode, timeout, opentries, freelist, imaxsize)
+		if err != nil {
+			return nil, err
+		}
+
+	}
+
+	return ikeys, nil
+
+}
@@ -25,28 +25,122 @@ this libraries is subject to the terms and conditions of licenses these librarie
 package main
 
 import (
+	"bytes"
 	"context"
-	"fmt"
+	"encoding/binary"
+	"github.com/coocood/freecache"
 	"github.com/eltaline/cwalk"
+	"github.com/eltaline/nutsdb"
 	"github.com/pieterclaerhout/go-waitgroup"
 	"hash/crc64"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"sync/atomic"
 )
 
-// TreeInit : Tree Database Initialization
-func TreeInit() {
+// Tree Handlers
+
+const treebatch = 1024
+
+// TreeInit : Tree Database Initialization of root directories
+func TreeInit(roots []string) error {
 
 	var err error
 
-	// Variables
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
-	mdir := make(map[string]bool)
+	qwg, ctx := waitgroup.NewErrorGroup(ctx, searchinit)
+
+Main:
+
+	for _, idirname := range TreeRoots(roots) {
+
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+			break Main
+		default:
+		}
+
+		qwait := make(chan bool)
+
+		qwg.Add(func() error {
+
+			dirname := idirname
+
+			qwait <- true
+
+			err := cwalk.Walk(dirname, func(partpath string, ln os.FileInfo, err error) error {
+
+				if err != nil {
+					return err
+				}
+
+				if ln.IsDir() {
+
+					fullpath := []byte(filepath.Clean(dirname + "/" + partpath))
+
+					radix.Lock()
+					tree, _, _ = tree.Insert(fullpath, crc64.Checksum(fullpath, ctbl64))
+					radix.Unlock()
+
+				}
+
+				return nil
+
+			})
+
+			if err != nil {
+				cancel()
+				return err
+			}
+
+			return nil
+
+		})
+
+		<-qwait
+
+	}
+
+	werr := qwg.Wait()
+
+	switch {
+	case err != nil:
+		return err
+	case werr != nil:
+		return werr
+	}
+
+	return nil
+
+}
+
+// ServerRoots : root directories of all virtual hosts
+func ServerRoots() []string {
+
+	var roots []string
 
 	for _, Server := range config.Server {
+		roots = append(roots, filepath.Clean(Server.ROOT))
+	}
+
+	return roots
+
+}
+
+// TreeRoots : root directories without nested root directories
+func TreeRoots(sroots []string) []string {
+
+	mdir := make(map[string]bool)
 
-		root := filepath.Clean(Server.ROOT)
+	for _, sroot := range sroots {
+
+		root := filepath.Clean(sroot)
 		uroot := root
 
 		_, found := mdir[root]
@@ -83,68 +177,270 @@ func TreeInit() {
 
 	}
 
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	var roots []string
 
-	qwg, ctx := waitgroup.NewErrorGroup(ctx, searchinit)
+	for root := range mdir {
+		roots = append(roots, root)
+	}
 
-Main:
+	sort.Strings(roots)
 
-	for idirname := range mdir {
+	return roots
 
-		select {
-		case <-ctx.Done():
-			err = ctx.Err()
-			break Main
-		default:
+}
+
+// TreeInsert : insert directory to radix tree, new directory is saved to search db
+func TreeInsert(ndb *nutsdb.DB, dir string, dcrc uint64) error {
+
+	radix.Lock()
+	ntree, _, updated := tree.Insert([]byte(dir), dcrc)
+	tree = ntree
+	radix.Unlock()
+
+	if updated {
+		return nil
+	}
+
+	bcrc := make([]byte, 8)
+	Endian.PutUint64(bcrc, dcrc)
+
+	return NDBInsert(ndb, treebucket, []byte(dir), bcrc, 0)
+
+}
+
+// TreeMkdir : insert created directory to radix tree and to search db bucket of parent directory
+func TreeMkdir(ndb *nutsdb.DB, dir string) error {
+
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+
+	dcrc := crc64.Checksum([]byte(dir), ctbl64)
+
+	err = TreeInsert(ndb, dir, dcrc)
+	if err != nil {
+		return err
+	}
+
+	var nval RawKeysData
+
+	nval.Size = uint64(info.Size())
+	nval.Date = uint64(info.ModTime().Unix())
+	nval.Type = uint16(2)
+
+	nbuffer := new(bytes.Buffer)
+
+	err = binary.Write(nbuffer, Endian, nval)
+	if err != nil {
+		return err
+	}
+
+	pbucket := strconv.FormatUint(crc64.Checksum([]byte(filepath.Dir(dir)), ctbl64), 16)
+
+	return NDBInsert(ndb, pbucket, []byte("d:"+filepath.Base(dir)), nbuffer.Bytes(), 0)
+
+}
+
+// TreeDelete : delete directory from radix tree and search db
+func TreeDelete(ndb *nutsdb.DB, dir string) error {
+
+	radix.Lock()
+	tree, _, _ = tree.Delete([]byte(dir))
+	radix.Unlock()
+
+	return NDBDelete(ndb, treebucket, []byte(dir))
+
+}
+
+// TreeLoad : load radix tree from search db, returns count of loaded directories
+func TreeLoad(ndb *nutsdb.DB) (int, error) {
+
+	count := 0
+
+	err := ndb.View(func(tx *nutsdb.Tx) error {
+
+		entries, err := tx.GetAll(treebucket)
+
+		if entries == nil {
+			return nil
 		}
 
-		qwait := make(chan bool)
+		if err != nil {
+			return err
+		}
 
-		qwg.Add(func() error {
+		radix.Lock()
+		defer radix.Unlock()
 
-			dirname := idirname
+		txn := tree.Txn()
 
-			qwait <- true
+		for _, entry := range entries {
 
-			err := cwalk.Walk(dirname, func(partpath string, ln os.FileInfo, err error) error {
+			dir := []byte(string(entry.Key))
+
+			dcrc := crc64.Checksum(dir, ctbl64)
+
+			if len(entry.Value) == 8 {
+				dcrc = Endian.Uint64(entry.Value)
+			}
+
+			txn.Insert(dir, dcrc)
+
+		}
 
+		tree = txn.Commit()
+
+		count = len(entries)
+
+		return nil
+
+	})
+
+	return count, err
+
+}
+
+// TreeSave : save radix tree of root directories to search db
+func TreeSave(ndb *nutsdb.DB, roots []string) error {
+
+	var err error
+
+	var dirs [][]byte
+	var crcs []uint64
+
+	flush := func() error {
+
+		nerr := ndb.Update(func(tx *nutsdb.Tx) error {
+
+			for i, dir := range dirs {
+
+				bcrc := make([]byte, 8)
+				Endian.PutUint64(bcrc, crcs[i])
+
+				err := tx.Put(treebucket, dir, bcrc, 0)
 				if err != nil {
 					return err
 				}
 
-				if ln.IsDir() {
+			}
 
-					fullpath := []byte(filepath.Clean(dirname + "/" + partpath))
+			return nil
 
-					radix.Lock()
-					tree, _, _ = tree.Insert(fullpath, crc64.Checksum(fullpath, ctbl64))
-					radix.Unlock()
+		})
 
-				}
+		dirs = dirs[:0]
+		crcs = crcs[:0]
+
+		return nerr
+
+	}
+
+	radix.RLock()
+	stree := tree
+	radix.RUnlock()
+
+	for _, root := range TreeRoots(roots) {
+
+		broot := []byte(root)
+
+		stree.Root().WalkPrefix(broot, func(dir []byte, dcrc interface{}) bool {
+
+			if len(dir) > len(broot) && dir[len(broot)] != '/' {
+				return false
+			}
+
+			dirs = append(dirs, dir)
+			crcs = append(crcs, dcrc.(uint64))
+
+			if len(dirs) >= treebatch {
+				err = flush()
+			}
+
+			return err != nil
+
+		})
+
+		if err != nil {
+			return err
+		}
+
+	}
+
+	if len(dirs) > 0 {
+		return flush()
+	}
+
+	return nil
+
+}
+
+// TreeVerify : background verification of loaded radix tree of root directories with real directories, stale directories are deleted and missing directories are inserted
+func TreeVerify(cache *freecache.Cache, ndb *nutsdb.DB, roots []string) {
+
+	// Loggers
+
+	appLogger, applogfile := AppLogger()
+	defer applogfile.Close()
+
+	var rs ReindexStats
+	var added uint64
 
+	appLogger.Warnf("| Search tree verification started")
+
+	for _, root := range TreeRoots(roots) {
+
+		err := ReindexStale(cache, ndb, root, -1, &rs)
+		if err == errShutdown {
+			return
+		}
+
+		err = cwalk.Walk(root, func(partpath string, ln os.FileInfo, err error) error {
+
+			if shutdown {
+				return errShutdown
+			}
+
+			if err != nil || !ln.IsDir() {
 				return nil
+			}
 
-			})
+			fullpath := filepath.Clean(root + "/" + partpath)
+
+			radix.RLock()
+			_, ok := tree.Get([]byte(fullpath))
+			radix.RUnlock()
+
+			if ok {
+				return nil
+			}
 
+			err = TreeInsert(ndb, fullpath, crc64.Checksum([]byte(fullpath), ctbl64))
 			if err != nil {
-				cancel()
-				return err
+				appLogger.Errorf("| Search tree verification insert directory error | Path [%s] | %v", fullpath, err)
+				return nil
+			}
+
+			atomic.AddUint64(&added, 1)
+
+			if watch || watchrescan > 0 {
+				WatchMark(fullpath)
 			}
 
 			return nil
 
 		})
 
-		<-qwait
-
-	}
+		if shutdown {
+			return
+		}
 
-	werr := qwg.Wait()
+		if err != nil {
+			appLogger.Errorf("| Search tree verification walk error | Path [%s] | %v", root, err)
+		}
 
-	if err != nil || werr != nil {
-		fmt.Printf("Tree initialization error | %v | %v\n", err, werr)
-		os.Exit(1)
 	}
 
+	appLogger.Warnf("| Search tree verification finished | Stale [%d] | Added [%d] | Errors [%d]", rs.Stale, atomic.LoadUint64(&added), rs.Errors)
+
 }
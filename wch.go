@@ -0,0 +1,253 @@
+/*
+
+Copyright © 2020 Andrey Kuvshinov. Contacts: <syslinux@protonmail.com>
+Copyright © 2020 Eltaline OU. Contacts: <eltaline.ou@gmail.com>
+All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+The wZD project contains unmodified/modified libraries imports too with
+separate copyright notices and license terms. Your use of the source code
+this libraries is subject to the terms and conditions of licenses these libraries.
+
+*/
+
+package main
+
+import (
+	"fmt"
+	"github.com/coocood/freecache"
+	"github.com/eltaline/mmutex"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Watch Helpers
+
+// WatchMark : mark changed directory for reindex
+func WatchMark(dir string) {
+
+	now := time.Now()
+
+	wdirs.Lock()
+	defer wdirs.Unlock()
+
+	wd, ok := wdirs.Dirs[dir]
+	if !ok {
+		wd.First = now
+	}
+
+	wd.Last = now
+
+	wdirs.Dirs[dir] = wd
+
+}
+
+// WatchRescan : request full rescan of virtual hosts root directories, used on lost notifications
+func WatchRescan() {
+
+	wdirs.Lock()
+	wdirs.Rescan = true
+	wdirs.Unlock()
+
+}
+
+// WatchServer : virtual host options by directory, the longest root directory is used
+func WatchServer(dir string) (base string, reindexrate int, filemode os.FileMode, timeout time.Duration, opentries int, ok bool) {
+
+	for _, Server := range config.Server {
+
+		root := filepath.Clean(Server.ROOT)
+
+		if dir != root && !strings.HasPrefix(dir, root+"/") {
+			continue
+		}
+
+		if ok && len(root) <= len(base) {
+			continue
+		}
+
+		ok = true
+
+		base = root
+		reindexrate = Server.REINDEXRATE
+		timeout = time.Duration(Server.LOCKTIMEOUT) * time.Second
+		opentries = Server.OPENTRIES
+
+		cfilemode, err := strconv.ParseUint(fmt.Sprintf("%d", Server.FILEMODE), 8, 32)
+		switch {
+		case err != nil || cfilemode == 0:
+			filemode = os.FileMode(0640)
+		default:
+			filemode = os.FileMode(cfilemode)
+		}
+
+	}
+
+	return base, reindexrate, filemode, timeout, opentries, ok
+
+}
+
+// WatchLoop : reindex changed directories after delay of last change, directories changed all the time are reindexed every ten delays, and full rescan of virtual hosts by schedule or request
+func WatchLoop(cache *freecache.Cache, keymutex *mmutex.Mutex, wg *sync.WaitGroup) {
+
+	tick := time.NewTicker(time.Second)
+	defer tick.Stop()
+
+	lastscan := time.Now()
+
+	for range tick.C {
+
+		if shutdown {
+			return
+		}
+
+		wdirs.Lock()
+		rescan := wdirs.Rescan
+		wdirs.Unlock()
+
+		if rescan || (watchrescan > 0 && time.Since(lastscan) >= watchrescan) {
+
+			wg.Add(1)
+
+			if WatchScan(cache, keymutex) {
+				lastscan = time.Now()
+			}
+
+			wg.Done()
+
+			continue
+
+		}
+
+		wg.Add(1)
+		WatchFlush(cache)
+		wg.Done()
+
+	}
+
+}
+
+// WatchFlush : reindex directories without changes during delay
+func WatchFlush(cache *freecache.Cache) {
+
+	now := time.Now()
+
+	var dirs []string
+
+	wdirs.Lock()
+
+	for dir, wd := range wdirs.Dirs {
+
+		if now.Sub(wd.Last) >= watchdelay || now.Sub(wd.First) >= 10*watchdelay {
+			dirs = append(dirs, dir)
+			delete(wdirs.Dirs, dir)
+		}
+
+	}
+
+	wdirs.Unlock()
+
+	if len(dirs) == 0 {
+		return
+	}
+
+	sort.Strings(dirs)
+
+	// Loggers
+
+	appLogger, applogfile := AppLogger()
+	defer applogfile.Close()
+
+	for _, dir := range dirs {
+
+		if shutdown {
+			return
+		}
+
+		_, _, filemode, timeout, opentries, ok := WatchServer(dir)
+		if !ok {
+			continue
+		}
+
+		for _, sdb := range SearchDirs(dir) {
+
+			var rs ReindexStats
+
+			if !DirExists(dir) {
+
+				err := ReindexStale(cache, sdb.DB, dir, -1, &rs)
+				if err != nil && err != errShutdown {
+					appLogger.Errorf("| Watch delete stale directory error | Path [%s] | DB Directory [%s] | %v", dir, sdb.Dir, err)
+				}
+
+				continue
+
+			}
+
+			changed, err := ReindexDir(sdb.DB, dir, filemode, timeout, opentries, &rs)
+			if err != nil {
+				appLogger.Errorf("| Watch reindex directory error | Path [%s] | DB Directory [%s] | %v", dir, sdb.Dir, err)
+				continue
+			}
+
+			if changed {
+				CacheInvalidate(cache, dir)
+			}
+
+		}
+
+	}
+
+}
+
+// WatchScan : full rescan of virtual hosts root directories, skipped if other reindex is in progress
+func WatchScan(cache *freecache.Cache, keymutex *mmutex.Mutex) bool {
+
+	if !keymutex.TryLock("reindex") {
+		return false
+	}
+
+	defer keymutex.UnLock("reindex")
+
+	wdirs.Lock()
+	wdirs.Rescan = false
+	wdirs.Unlock()
+
+	for _, sdb := range sdbs {
+
+		if sdb.Broken || sdb.DB == nil {
+			continue
+		}
+
+		for _, root := range sdb.Roots {
+
+			base, reindexrate, filemode, timeout, opentries, _ := WatchServer(root)
+
+			_, err := Reindex(cache, sdb.DB, base, root, -1, reindexrate, filemode, timeout, opentries)
+			if err == errShutdown {
+				return true
+			}
+
+		}
+
+	}
+
+	return true
+
+}
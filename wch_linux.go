@@ -0,0 +1,223 @@
+//go:build linux
+// +build linux
+
+/*
+
+Copyright © 2020 Andrey Kuvshinov. Contacts: <syslinux@protonmail.com>
+Copyright © 2020 Eltaline OU. Contacts: <eltaline.ou@gmail.com>
+All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+The wZD project contains unmodified/modified libraries imports too with
+separate copyright notices and license terms. Your use of the source code
+this libraries is subject to the terms and conditions of licenses these libraries.
+
+*/
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// Watch Linux Handlers
+
+const watchmask = syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MODIFY | syscall.IN_CLOSE_WRITE | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_DELETE_SELF | syscall.IN_MOVE_SELF
+
+// WatchNotify : type for inotify descriptor with watched directories
+type WatchNotify struct {
+	fd    int
+	wds   map[int32]string
+	paths map[string]int32
+	full  bool
+}
+
+// WatchInit : inotify watcher of virtual hosts root directories, changed directories are marked for reindex
+func WatchInit() error {
+
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC)
+	if err != nil {
+		return err
+	}
+
+	wn := &WatchNotify{fd: fd, wds: make(map[int32]string), paths: make(map[string]int32)}
+
+	for _, root := range TreeRoots(ServerRoots()) {
+		wn.Add(root, false)
+	}
+
+	go wn.Read()
+
+	return nil
+
+}
+
+// Add : add inotify watches for directory and subdirectories, new subdirectories are marked for reindex
+func (wn *WatchNotify) Add(dir string, mark bool) {
+
+	_ = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+
+		if err != nil || !info.IsDir() {
+			return nil
+		}
+
+		wd, err := syscall.InotifyAddWatch(wn.fd, path, watchmask)
+		if err != nil {
+
+			if !wn.full {
+
+				appLogger, applogfile := AppLogger()
+				appLogger.Errorf("| Can`t add search watch, changes are found by rescan only | Path [%s] | %v", path, err)
+				applogfile.Close()
+
+				wn.full = true
+
+			}
+
+			WatchRescan()
+
+			return filepath.SkipDir
+
+		}
+
+		wn.wds[int32(wd)] = path
+		wn.paths[path] = int32(wd)
+
+		if mark {
+			WatchMark(path)
+		}
+
+		return nil
+
+	})
+
+}
+
+// Remove : remove inotify watches for moved directory and subdirectories
+func (wn *WatchNotify) Remove(dir string) {
+
+	for path, wd := range wn.paths {
+
+		if path != dir && !strings.HasPrefix(path, dir+"/") {
+			continue
+		}
+
+		_, _ = syscall.InotifyRmWatch(wn.fd, uint32(wd))
+
+		delete(wn.wds, wd)
+		delete(wn.paths, path)
+
+	}
+
+}
+
+// Read : read inotify events and mark changed directories
+func (wn *WatchNotify) Read() {
+
+	buf := make([]byte, 4096*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+
+	for {
+
+		n, err := syscall.Read(wn.fd, buf)
+		if err != nil {
+
+			if err == syscall.EINTR {
+				continue
+			}
+
+			appLogger, applogfile := AppLogger()
+			appLogger.Errorf("| Can`t read search watch events, changes are found by rescan only | %v", err)
+			applogfile.Close()
+
+			return
+
+		}
+
+		if shutdown {
+			return
+		}
+
+		for off := 0; off+syscall.SizeofInotifyEvent <= n; {
+
+			ev := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[off]))
+
+			name := string(bytes.TrimRight(buf[off+syscall.SizeofInotifyEvent:off+syscall.SizeofInotifyEvent+int(ev.Len)], "\x00"))
+
+			off += syscall.SizeofInotifyEvent + int(ev.Len)
+
+			wn.Event(ev.Wd, ev.Mask, name)
+
+		}
+
+	}
+
+}
+
+// Event : handle one inotify event
+func (wn *WatchNotify) Event(wd int32, mask uint32, name string) {
+
+	if mask&syscall.IN_Q_OVERFLOW != 0 {
+		WatchRescan()
+		return
+	}
+
+	dir, ok := wn.wds[wd]
+	if !ok {
+		return
+	}
+
+	if mask&syscall.IN_IGNORED != 0 {
+		delete(wn.wds, wd)
+		delete(wn.paths, dir)
+		return
+	}
+
+	if name == "" {
+
+		if mask&(syscall.IN_DELETE_SELF|syscall.IN_MOVE_SELF) != 0 {
+			WatchMark(dir)
+		}
+
+		return
+
+	}
+
+	if rgxcrcbolt.MatchString(name) {
+		return
+	}
+
+	path := filepath.Join(dir, name)
+
+	if mask&syscall.IN_ISDIR != 0 {
+
+		switch {
+		case mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0:
+			wn.Add(path, true)
+		case mask&syscall.IN_MOVED_FROM != 0:
+			wn.Remove(path)
+			WatchMark(path)
+		case mask&syscall.IN_DELETE != 0:
+			WatchMark(path)
+		}
+
+	}
+
+	WatchMark(dir)
+
+}